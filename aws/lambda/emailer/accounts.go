@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// AccountDirectory tells whether an email belongs to a real, reachable account, so a
+// spoofed or stale summary is not emailed to whoever owns that address.
+type AccountDirectory interface {
+	// Known reports whether email has transactions and is not suppressed. Emails
+	// are compared case-insensitively.
+	Known(ctx context.Context, email string) (bool, error)
+}
+
+// accountDirectory is nil unless EMAIL_VERIFY_ACCOUNTS is enabled.
+var accountDirectory AccountDirectory
+
+// accountsTablePattern accepts a plain or schema-qualified SQL identifier; the table
+// name is interpolated into the query, so nothing else is allowed.
+var accountsTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// pgAccountDirectory looks emails up in the summarizer's transactions table and the
+// email_suppressions table.
+type pgAccountDirectory struct {
+	db    *sql.DB
+	table string
+}
+
+func (d *pgAccountDirectory) Known(ctx context.Context, email string) (bool, error) {
+	var known bool
+	err := d.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM `+d.table+` WHERE LOWER(email) = LOWER($1))
+			AND NOT EXISTS (SELECT 1 FROM email_suppressions WHERE LOWER(email) = LOWER($1))`, email).Scan(&known)
+	if err != nil {
+		return false, classify(ErrTransient, fmt.Errorf("error verifying account: %w", err))
+	}
+	return known, nil
+}
+
+// verifyRecipient reports whether email may be sent a summary under
+// EMAIL_VERIFY_ACCOUNTS; every recipient may when verification is disabled.
+func verifyRecipient(ctx context.Context, email string) (bool, error) {
+	if accountDirectory == nil {
+		return true, nil
+	}
+	return accountDirectory.Known(ctx, email)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeDirectory is an AccountDirectory knowing the emails in known, compared
+// case-insensitively, and failing with err when it is set.
+type fakeDirectory struct {
+	known []string
+	err   error
+}
+
+func (d *fakeDirectory) Known(ctx context.Context, email string) (bool, error) {
+	if d.err != nil {
+		return false, d.err
+	}
+	return slices.ContainsFunc(d.known, func(k string) bool { return strings.EqualFold(k, email) }), nil
+}
+
+func TestHandlerSkipsUnknownAccounts(t *testing.T) {
+	setVar[AccountDirectory](t, &accountDirectory, &fakeDirectory{known: []string{"jane@example.com"}})
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "Jane@Example.com"}, {Email: "stranger@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 1 || s.sent[0].To != "Jane@Example.com" {
+		t.Errorf("sent %+v, want only the known account", s.sent)
+	}
+	if !slices.Equal(result.Skipped, []string{"stranger@example.com"}) {
+		t.Errorf("skipped %v, want stranger@example.com", result.Skipped)
+	}
+}
+
+func TestHandlerKeepsEmailWhenVerificationFails(t *testing.T) {
+	setVar[AccountDirectory](t, &accountDirectory, &fakeDirectory{err: classify(ErrTransient, errors.New("connection refused"))})
+	s := &fakeSender{}
+	useSender(t, s)
+
+	result, err := handler(context.Background(), mustJSON(t, Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 0 || len(result.Failed) != 1 || !result.Failed[0].Retryable {
+		t.Errorf("sent %d, failed %+v, want the email held back as retryable", len(s.sent), result.Failed)
+	}
+}
+
+func TestPgAccountDirectoryKnownIgnoresCase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	d := &pgAccountDirectory{db: db, table: "transacciones"}
+	query := regexp.QuoteMeta("FROM transacciones WHERE LOWER(email) = LOWER($1)") + ".*" +
+		regexp.QuoteMeta("FROM email_suppressions WHERE LOWER(email) = LOWER($1)")
+
+	mock.ExpectQuery(query).WithArgs("Jane@Example.com").WillReturnRows(sqlmock.NewRows([]string{"known"}).AddRow(true))
+	if known, err := d.Known(context.Background(), "Jane@Example.com"); err != nil || !known {
+		t.Errorf("Known() = %v, %v, want true", known, err)
+	}
+
+	mock.ExpectQuery(query).WithArgs("stranger@example.com").WillReturnRows(sqlmock.NewRows([]string{"known"}).AddRow(false))
+	if known, err := d.Known(context.Background(), "stranger@example.com"); err != nil || known {
+		t.Errorf("Known() = %v, %v, want false", known, err)
+	}
+
+	mock.ExpectQuery(query).WillReturnError(errors.New("connection reset"))
+	if _, err := d.Known(context.Background(), "jane@example.com"); !errors.Is(err, ErrTransient) {
+		t.Errorf("Known() error = %v, want ErrTransient", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAccountsTablePattern(t *testing.T) {
+	for table, want := range map[string]bool{
+		"transacciones":        true,
+		"ledger.transacciones": true,
+		"_staging":             true,
+		"transacciones; --":    false,
+		"a.b.c":                false,
+		"1table":               false,
+		"":                     false,
+	} {
+		if got := accountsTablePattern.MatchString(table); got != want {
+			t.Errorf("accountsTablePattern.MatchString(%q) = %v, want %v", table, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestRecipientAllowed(t *testing.T) {
+	setVar(t, &allowedDomains, map[string]struct{}{"example.com": {}})
+	tests := map[string]bool{
+		"jane@example.com":      true,
+		"Jane@EXAMPLE.com":      true,
+		"jane@mail.example.com": false,
+		"jane@other.org":        false,
+		"not-an-email":          false,
+	}
+	for email, want := range tests {
+		if got := recipientAllowed(email); got != want {
+			t.Errorf("recipientAllowed(%q) = %t, want %t", email, got, want)
+		}
+	}
+}
+
+func TestRecipientAllowedWithoutAllowlist(t *testing.T) {
+	setVar(t, &allowedDomains, nil)
+	if !recipientAllowed("jane@other.org") {
+		t.Error("recipientAllowed() = false, want every recipient allowed by default")
+	}
+}
+
+func TestHandlerSkipsRecipientsOutsideAllowlist(t *testing.T) {
+	setVar(t, &allowedDomains, map[string]struct{}{"example.com": {}})
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}, {Email: "john@other.org"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if !slices.Equal(result.Sent, []string{"jane@example.com"}) || !slices.Equal(result.Skipped, []string{"john@other.org"}) {
+		t.Errorf("sent %v, skipped %v, want jane sent and john skipped", result.Sent, result.Skipped)
+	}
+	if len(s.sent) != 1 || s.sent[0].To != "jane@example.com" {
+		t.Errorf("sender got %+v, want only jane's email", s.sent)
+	}
+}
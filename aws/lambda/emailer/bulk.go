@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Statuses of a row in account_summaries.
+const (
+	summaryPending = "pending"
+	summarySent    = "sent"
+	summaryFailed  = "failed"
+	// summarySkipped marks a summary deliberately not emailed, e.g. below EMAIL_MIN_TRANSACTIONS.
+	summarySkipped = "skipped"
+)
+
+// StoredSummary is an account summary persisted by the summarizer for a period.
+type StoredSummary struct {
+	Period  string
+	Summary AccountSummary
+}
+
+// SummaryStore pages through persisted summaries and records their send status.
+type SummaryStore interface {
+	// PendingSummaries returns up to limit pending summaries of period with an
+	// email after the given one, ordered by email.
+	PendingSummaries(ctx context.Context, period, after string, limit int) ([]StoredSummary, error)
+	// SetStatus records the send status of a summary.
+	SetStatus(ctx context.Context, email, period, status string) error
+}
+
+// summaryStore is nil unless EMAIL_BULK_ENABLED is set.
+var summaryStore SummaryStore
+
+// pgSummaryStore reads and updates the account_summaries table.
+type pgSummaryStore struct {
+	db *sql.DB
+}
+
+func (s *pgSummaryStore) PendingSummaries(ctx context.Context, period, after string, limit int) ([]StoredSummary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT email, period, summary FROM account_summaries
+		WHERE status = $1 AND period = $2 AND email > $3
+		ORDER BY email
+		LIMIT $4`, summaryPending, period, after, limit)
+	if err != nil {
+		return nil, classify(ErrTransient, fmt.Errorf("error reading pending summaries: %w", err))
+	}
+	defer rows.Close()
+
+	var page []StoredSummary
+	for rows.Next() {
+		var email string
+		var stored StoredSummary
+		var data []byte
+		if err := rows.Scan(&email, &stored.Period, &data); err != nil {
+			return nil, classify(ErrFatal, fmt.Errorf("error scanning summary: %w", err))
+		}
+		if err := json.Unmarshal(data, &stored.Summary); err != nil {
+			return nil, classify(ErrFatal, fmt.Errorf("invalid stored summary for %s: %w", maskEmail(email), err))
+		}
+		stored.Summary.Email = email
+		page = append(page, stored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(ErrTransient, fmt.Errorf("error reading pending summaries: %w", err))
+	}
+	return page, nil
+}
+
+func (s *pgSummaryStore) SetStatus(ctx context.Context, email, period, status string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE account_summaries SET status = $1, updated_at = NOW()
+		WHERE email = $2 AND period = $3`, status, email, period)
+	if err != nil {
+		return classify(ErrTransient, fmt.Errorf("error updating summary status: %w", err))
+	}
+	return nil
+}
+
+// bulkSend emails every pending summary of the period, a page at a time and at most
+// bulkRate emails per second. Each summary is marked sent as soon as SES accepts it,
+// so a run that crashes or times out resumes where it stopped when invoked again.
+// Summaries that fail permanently are marked failed; transient failures stay pending
+// for the next run. The run stops early, leaving the rest pending, when the
+// invocation is about to time out.
+func bulkSend(ctx context.Context, period, from, subject string) (Result, error) {
+	var result Result
+	interval := time.Second / time.Duration(bulkRate)
+	throttle := time.NewTicker(interval)
+	defer throttle.Stop()
+
+	after := ""
+	for {
+		page, err := summaryStore.PendingSummaries(ctx, period, after, bulkPageSize)
+		if err != nil {
+			return result, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, stored := range page {
+			after = stored.Summary.Email
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < sendTimeout+interval {
+				log.Printf("Bulk send stopping before timeout; remaining summaries stay pending")
+				result.Remaining = true
+				return result, nil
+			}
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-throttle.C:
+			}
+
+			if belowMinTransactions(stored.Summary) {
+				result.Skipped = append(result.Skipped, stored.Summary.Email)
+				if err := summaryStore.SetStatus(ctx, stored.Summary.Email, stored.Period, summarySkipped); err != nil {
+					return result, err
+				}
+				continue
+			}
+
+			msg := EmailMessage{
+				From:    from,
+				To:      stored.Summary.Email,
+				Subject: subject,
+				HTML:    buildHTMLBody(stored.Summary),
+				Period:  stored.Period,
+			}
+			status := bulkDeliver(ctx, msg, &result)
+			if status == summaryPending {
+				continue
+			}
+			if err := summaryStore.SetStatus(ctx, msg.To, msg.Period, status); err != nil {
+				// Stop: further sends could not be recorded either, and would be re-sent on resume
+				return result, err
+			}
+		}
+	}
+
+	log.Printf("Bulk send for %s finished: %d sent, %d failed", period, len(result.Sent), len(result.Failed))
+	return result, nil
+}
+
+// bulkDeliver sends one bulk email, records the outcome in result and returns the
+// status the summary should be given.
+func bulkDeliver(ctx context.Context, msg EmailMessage, result *Result) string {
+	if !recipientAllowed(msg.To) {
+		log.Printf("Skipping email to %s: domain not in EMAIL_ALLOWED_DOMAINS", maskEmail(msg.To))
+		result.Skipped = append(result.Skipped, msg.To)
+		return summaryFailed
+	}
+	if ok, err := sandboxPreflight(ctx, msg.To); err != nil {
+		log.Printf("Warning: SES sandbox preflight failed for %s, sending anyway: %v", maskEmail(msg.To), err)
+	} else if !ok {
+		// Left pending, so it is sent once the recipient is verified or SES leaves the sandbox
+		log.Printf("Skipping email to %s: not a verified identity in the SES sandbox", maskEmail(msg.To))
+		result.Skipped = append(result.Skipped, msg.To)
+		return summaryPending
+	}
+	if ok, err := verifyRecipient(ctx, msg.To); err != nil {
+		// Left pending, so a later run verifies it again
+		log.Printf("Not sending email to %s: %v", maskEmail(msg.To), err)
+		result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error(), Retryable: true})
+		return summaryPending
+	} else if !ok {
+		log.Printf("Skipping email to %s: unknown or suppressed account", maskEmail(msg.To))
+		result.Skipped = append(result.Skipped, msg.To)
+		return summarySkipped
+	}
+
+	attempted, err := deliver(ctx, msg)
+	if errors.Is(err, errSendPending) {
+		// Left pending, so a run after EMAIL_SEND_MARKER_PENDING_TTL sends it
+		log.Printf("Skipping email to %s: a previous send may have gone out", maskEmail(msg.To))
+		result.AlreadySent = append(result.AlreadySent, msg.To)
+		return summaryPending
+	}
+	switch {
+	case !attempted && err == nil:
+		result.AlreadySent = append(result.AlreadySent, msg.To)
+		return summarySent
+	case err == nil:
+		log.Printf("Email successfully sent to %s", maskEmail(msg.To))
+		result.Sent = append(result.Sent, msg.To)
+		return summarySent
+	}
+
+	log.Printf("Failed to send email to %s (%s): %v", maskEmail(msg.To), errorKind(err), err)
+	retryable := errors.Is(err, ErrTransient)
+	result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error(), Retryable: retryable})
+	// An ambiguous send keeps its pending marker, which blocks resending until it expires
+	if retryable {
+		return summaryPending
+	}
+	return summaryFailed
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// memSummaryStore is an in-memory SummaryStore. SetStatus fails once failAfter
+// statuses have been recorded, when it is positive, to simulate a crash mid-run.
+type memSummaryStore struct {
+	mu        sync.Mutex
+	summaries map[string]StoredSummary
+	status    map[string]string
+	pages     int
+	updates   int
+	failAfter int
+}
+
+func newMemSummaryStore(period string, emails ...string) *memSummaryStore {
+	s := &memSummaryStore{summaries: make(map[string]StoredSummary), status: make(map[string]string)}
+	for _, email := range emails {
+		s.summaries[email] = StoredSummary{Period: period, Summary: AccountSummary{Email: email}}
+		s.status[email] = summaryPending
+	}
+	return s
+}
+
+func (s *memSummaryStore) PendingSummaries(ctx context.Context, period, after string, limit int) ([]StoredSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages++
+	var emails []string
+	for email, stored := range s.summaries {
+		if stored.Period == period && s.status[email] == summaryPending && email > after {
+			emails = append(emails, email)
+		}
+	}
+	slices.Sort(emails)
+	var page []StoredSummary
+	for _, email := range emails[:min(limit, len(emails))] {
+		page = append(page, s.summaries[email])
+	}
+	return page, nil
+}
+
+func (s *memSummaryStore) SetStatus(ctx context.Context, email, period, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failAfter > 0 && s.updates >= s.failAfter {
+		return classify(ErrTransient, errors.New("connection lost"))
+	}
+	s.updates++
+	s.status[email] = status
+	return nil
+}
+
+// useSummaryStore replaces the SummaryStore for the duration of the test.
+func useSummaryStore(t *testing.T, s SummaryStore) {
+	t.Helper()
+	setVar(t, &summaryStore, s)
+}
+
+func TestBulkSendPagesAndMarksSent(t *testing.T) {
+	setVar(t, &bulkPageSize, 2)
+	setVar(t, &bulkRate, 1000)
+	var emails []string
+	for i := range 5 {
+		emails = append(emails, fmt.Sprintf("user%d@example.com", i))
+	}
+	store := newMemSummaryStore("2024-03", emails...)
+	store.summaries["other@example.com"] = StoredSummary{Period: "2024-02", Summary: AccountSummary{Email: "other@example.com"}}
+	store.status["other@example.com"] = summaryPending
+	useSummaryStore(t, store)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	result, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Sent, emails) {
+		t.Errorf("sent %v, want %v", result.Sent, emails)
+	}
+	// Three pages of summaries and the empty page that ends the run
+	if store.pages != 4 {
+		t.Errorf("read %d pages, want 4", store.pages)
+	}
+	for _, email := range emails {
+		if store.status[email] != summarySent {
+			t.Errorf("%s status = %s, want sent", email, store.status[email])
+		}
+	}
+	if store.status["other@example.com"] != summaryPending {
+		t.Error("summary of another period was sent")
+	}
+}
+
+func TestBulkSendResumesAfterCrashWithoutResending(t *testing.T) {
+	setVar(t, &bulkPageSize, 2)
+	setVar(t, &bulkRate, 1000)
+	emails := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com"}
+	store := newMemSummaryStore("2024-03", emails...)
+	store.failAfter = 2
+	useSummaryStore(t, store)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	if _, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary"); !errors.Is(err, ErrTransient) {
+		t.Fatalf("bulkSend() = %v, want the status update failure", err)
+	}
+	// The third email went out but could not be marked; the run stopped there
+	if len(s.sent) != 3 {
+		t.Fatalf("first run sent %d emails, want 3", len(s.sent))
+	}
+
+	store.failAfter = 0
+	s.sent = nil
+	result, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c@example.com", "d@example.com"}; !slices.Equal(result.Sent, want) {
+		t.Errorf("resumed run sent %v, want only %v", result.Sent, want)
+	}
+	for _, email := range emails {
+		if store.status[email] != summarySent {
+			t.Errorf("%s status = %s, want sent", email, store.status[email])
+		}
+	}
+}
+
+func TestBulkSendResumeSkipsSendMarkedBeforeCrash(t *testing.T) {
+	setVar(t, &bulkPageSize, 10)
+	setVar(t, &bulkRate, 1000)
+	setVar[SendMarkers](t, &sendMarkers, newFakeMarkers())
+	store := newMemSummaryStore("2024-03", "a@example.com", "b@example.com")
+	store.failAfter = 1
+	useSummaryStore(t, store)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	if _, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary"); err == nil {
+		t.Fatal("bulkSend() = nil, want the status update failure")
+	}
+	store.failAfter = 0
+	result, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b was sent before the crash; its send marker keeps the resumed run from sending it again
+	if len(s.sent) != 2 || !slices.Equal(result.AlreadySent, []string{"b@example.com"}) {
+		t.Errorf("sent %d emails, already sent %v, want b@example.com sent once", len(s.sent), result.AlreadySent)
+	}
+	if store.status["b@example.com"] != summarySent {
+		t.Errorf("b@example.com status = %s, want sent", store.status["b@example.com"])
+	}
+}
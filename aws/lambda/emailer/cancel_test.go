@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestOutOfTime(t *testing.T) {
+	setVar(t, &sendTimeout, time.Second)
+	if err := outOfTime(context.Background()); err != nil {
+		t.Errorf("outOfTime() without a deadline = %v, want nil", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := outOfTime(ctx); err != nil {
+		t.Errorf("outOfTime() an hour from the deadline = %v, want nil", err)
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), time.Second/2)
+	defer cancelShort()
+	if err := outOfTime(short); err != context.DeadlineExceeded {
+		t.Errorf("outOfTime() with less than a send left = %v, want context.DeadlineExceeded", err)
+	}
+
+	cancel()
+	if err := outOfTime(ctx); err != context.Canceled {
+		t.Errorf("outOfTime() after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestHandlerStopsSendingNearDeadline(t *testing.T) {
+	// The first send leaves less than a send's worth of time before the deadline
+	setVar(t, &sendTimeout, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 1400*time.Millisecond)
+	defer cancel()
+	s := &fakeSender{send: func(EmailMessage) error {
+		time.Sleep(600 * time.Millisecond)
+		return nil
+	}}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}}}
+	result, err := handler(ctx, mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 1 || !slices.Equal(result.Sent, []string{"a@example.com"}) {
+		t.Errorf("sent %v, want only the email started with time to finish", result.Sent)
+	}
+	var failed []string
+	for _, f := range result.Failed {
+		if !f.Retryable {
+			t.Errorf("failure %+v is not retryable", f)
+		}
+		failed = append(failed, f.Email)
+	}
+	if !slices.Equal(failed, []string{"b@example.com", "c@example.com"}) {
+		t.Errorf("failed %v, want the emails not attempted reported for retry", failed)
+	}
+}
+
+func TestHandleRetryQueueLeavesUnreachedMessages(t *testing.T) {
+	useRetryQueue(t)
+	s := &fakeSender{}
+	useSender(t, s)
+	setVar(t, &sendTimeout, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	first, second := retryMessage(t, 1), retryMessage(t, 1)
+	second.MessageId = "msg-2"
+	result, err := handleRetryQueue(ctx, events.SQSEvent{Records: []events.SQSMessage{first, second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sent) != 0 {
+		t.Errorf("sent %d emails, want none started this close to the deadline", len(s.sent))
+	}
+	var ids []string
+	for _, f := range result.BatchItemFailures {
+		ids = append(ids, f.ItemIdentifier)
+	}
+	if !slices.Equal(ids, []string{"msg-1", "msg-2"}) {
+		t.Errorf("batch item failures = %v, want both messages redelivered", ids)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Supported values for EMAIL_MODE.
+const (
+	emailModePerAccount = "per-account"
+	emailModeDigest     = "digest"
+)
+
+// Supported values for EMAIL_TRANSPORT.
+const (
+	emailTransportSES  = "ses"
+	emailTransportSMTP = "smtp"
+)
+
+var (
+	// sendTimeout bounds how long a single email send may take before it is abandoned.
+	sendTimeout time.Duration
+	// logPII disables masking of email addresses in log output.
+	logPII bool
+	// emailMode selects between one email per account and a single operator digest.
+	emailMode string
+	// digestEmail is the recipient of the digest email in digest mode.
+	digestEmail string
+
+	// logoURL, brandName and brandColor white-label the email template.
+	logoURL    string
+	brandName  string
+	brandColor string
+	// tierTemplates maps account tiers (lower case) to email template names.
+	tierTemplates map[string]string
+	// fromName is the display name shown with the From address; empty sends it bare.
+	fromName string
+
+	// sesSourceARN and sesReturnPathARN identify SES identities in another account
+	// for cross-account sending; both are omitted from requests when unset.
+	sesSourceARN     string
+	sesReturnPathARN string
+
+	// retryQueueURL is the SQS queue that receives emails SES could not accept.
+	retryQueueURL string
+	// retryDLQURL receives retry queue messages that fail permanently or too often.
+	retryDLQURL string
+	// retryMaxAttempts is how many times a queued email is received before it is dead-lettered.
+	retryMaxAttempts int
+	// retryBackoff is how long a failed retry stays hidden; it doubles with each receive.
+	retryBackoff time.Duration
+	// maxSendsPerInvocation caps the emails an event invocation sends; the rest are put
+	// on the retry queue for a later run. 0 means no cap.
+	maxSendsPerInvocation int
+
+	// maxMonths caps the months listed in an email, keeping the most recent; 0 means no cap.
+	maxMonths int
+	// statementURL is linked from the note shown when months are left out.
+	statementURL string
+	// absentAmountLabel is shown instead of an average when a month has no credits or debits.
+	absentAmountLabel string
+	// creditLabel and debitLabel name incoming and outgoing amounts in the monthly
+	// breakdown, e.g. "deposit"/"withdrawal" or "income"/"expense".
+	creditLabel string
+	debitLabel  string
+	// roundingNote is a footnote explaining that displayed amounts are rounded; empty omits it.
+	roundingNote string
+	// minTransactions is the fewest transactions an account needs to be emailed; 0 emails every account.
+	minTransactions int
+	// verifyAccounts skips summaries whose email has no transactions in accountsTable
+	// or is in email_suppressions.
+	verifyAccounts bool
+	accountsTable  string
+
+	// sendRetries is how many times a transiently failed send is retried in-process.
+	sendRetries int
+	// sendRetryBackoff is the initial delay between send retries; it doubles on each attempt.
+	sendRetryBackoff time.Duration
+	// useSendMarkers records a marker per (recipient, period) in Postgres so that an
+	// email is never sent twice, even after an ambiguous timeout.
+	useSendMarkers bool
+	// sendMarkerPendingTTL is how long a pending marker blocks resending after an
+	// ambiguous send; once older it can be claimed again. 0 keeps it forever.
+	sendMarkerPendingTTL time.Duration
+	// bulkEnabled allows bulk runs, which send the summaries persisted in Postgres.
+	bulkEnabled bool
+	// bulkPageSize is how many pending summaries a bulk run reads at a time.
+	bulkPageSize int
+	// bulkRate caps bulk sends per second, to stay under the SES sending rate.
+	bulkRate int
+
+	// databaseURL, when set, replaces the DB_* variables with a full connection string.
+	databaseURL string
+	// dbSSLMode and dbSSLRootCert configure TLS for the send markers database.
+	dbSSLMode     string
+	dbSSLRootCert string
+
+	// sesSandbox skips recipients that are not verified SES identities before sending.
+	sesSandbox bool
+
+	// emailTransport selects the EmailSender: Amazon SES or an SMTP relay.
+	emailTransport string
+	// smtpHost, smtpPort, smtpUsername and smtpPassword configure the SMTP relay.
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
+	// sesIdentityCacheTTL is how long an identity's verification status is cached.
+	sesIdentityCacheTTL time.Duration
+
+	// allowedDomains restricts recipients to these lower-cased domains; empty allows all.
+	allowedDomains map[string]struct{}
+)
+
+// requiredEnv lists the environment variables the emailer cannot start without
+// under the current configuration.
+func requiredEnv() []string {
+	var keys []string
+	if os.Getenv("EMAIL_MODE") == emailModeDigest {
+		keys = append(keys, "DIGEST_EMAIL")
+	}
+	if os.Getenv("EMAIL_TRANSPORT") == emailTransportSMTP {
+		keys = append(keys, "SMTP_HOST")
+	}
+	markers, _ := strconv.ParseBool(os.Getenv("EMAIL_SEND_MARKERS"))
+	bulk, _ := strconv.ParseBool(os.Getenv("EMAIL_BULK_ENABLED"))
+	verify, _ := strconv.ParseBool(os.Getenv("EMAIL_VERIFY_ACCOUNTS"))
+	if (markers || bulk || verify) && os.Getenv("DATABASE_URL") == "" {
+		keys = append(keys, "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME")
+	}
+	return keys
+}
+
+// loadConfig validates required settings and reads optional ones from the environment,
+// applying defaults. It terminates execution if anything is missing or malformed.
+func loadConfig() {
+	if err := checkRequiredEnv(requiredEnv()...); err != nil {
+		log.Fatal(err)
+	}
+
+	sendTimeout = envDuration("SES_SEND_TIMEOUT", 10*time.Second)
+	if sendTimeout <= 0 {
+		log.Fatalf("Invalid value for SES_SEND_TIMEOUT: must be positive, got %s", sendTimeout)
+	}
+	logPII = envBool("LOG_PII", false)
+	sendRetries = envInt("SES_SEND_RETRIES", 0)
+	sendRetryBackoff = envDuration("SES_SEND_RETRY_BACKOFF", 500*time.Millisecond)
+	useSendMarkers = envBool("EMAIL_SEND_MARKERS", false)
+	sendMarkerPendingTTL = envDuration("EMAIL_SEND_MARKER_PENDING_TTL", 24*time.Hour)
+	if sendMarkerPendingTTL < 0 {
+		log.Fatalf("Invalid value for EMAIL_SEND_MARKER_PENDING_TTL: must not be negative, got %s", sendMarkerPendingTTL)
+	}
+	bulkEnabled = envBool("EMAIL_BULK_ENABLED", false)
+	bulkPageSize = envInt("EMAIL_BULK_PAGE_SIZE", 100)
+	bulkRate = envInt("EMAIL_BULK_RATE", 10)
+	if bulkPageSize < 1 || bulkRate < 1 {
+		log.Fatalf("Invalid value for EMAIL_BULK_PAGE_SIZE or EMAIL_BULK_RATE: both must be at least 1")
+	}
+	databaseURL = os.Getenv("DATABASE_URL")
+	if databaseURL != "" {
+		// Parses the URL without connecting
+		if _, err := pq.NewConnector(databaseURL); err != nil {
+			log.Fatalf("Invalid value for DATABASE_URL: %v", err)
+		}
+	}
+	dbSSLMode = envString("DB_SSLMODE", "require")
+	switch dbSSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		log.Fatalf("Invalid value for DB_SSLMODE: %q", dbSSLMode)
+	}
+	dbSSLRootCert = os.Getenv("DB_SSLROOTCERT")
+
+	logoURL = envString("EMAIL_LOGO_URL", "https://www.storicard.com/_next/static/media/storis_savvi_color.7e286ddd.svg")
+	brandName = envString("EMAIL_BRAND_NAME", "Stori")
+	brandColor = os.Getenv("EMAIL_BRAND_COLOR")
+	fromName = os.Getenv("EMAIL_FROM_NAME")
+	tierTemplates = envTierTemplates("EMAIL_TIER_TEMPLATES")
+
+	sesSourceARN = os.Getenv("SES_SOURCE_ARN")
+	sesReturnPathARN = os.Getenv("SES_RETURN_PATH_ARN")
+
+	retryQueueURL = os.Getenv("EMAIL_RETRY_QUEUE_URL")
+	retryDLQURL = os.Getenv("EMAIL_RETRY_DLQ_URL")
+	retryMaxAttempts = envInt("EMAIL_RETRY_MAX_ATTEMPTS", 5)
+	retryBackoff = envDuration("EMAIL_RETRY_BACKOFF", time.Minute)
+	maxSendsPerInvocation = envInt("EMAIL_MAX_SENDS_PER_INVOCATION", 0)
+	if maxSendsPerInvocation > 0 && retryQueueURL == "" {
+		log.Fatalf("EMAIL_MAX_SENDS_PER_INVOCATION requires EMAIL_RETRY_QUEUE_URL for the overflow")
+	}
+	maxMonths = envInt("EMAIL_MAX_MONTHS", 0)
+	statementURL = os.Getenv("EMAIL_STATEMENT_URL")
+	absentAmountLabel = envString("EMAIL_ABSENT_AMOUNT_LABEL", "n/a")
+	creditLabel = envString("EMAIL_CREDIT_LABEL", "credit")
+	debitLabel = envString("EMAIL_DEBIT_LABEL", "debit")
+	roundingNote = os.Getenv("EMAIL_ROUNDING_NOTE")
+	verifyAccounts = envBool("EMAIL_VERIFY_ACCOUNTS", false)
+	accountsTable = envString("EMAIL_ACCOUNTS_TABLE", "transacciones")
+	if !accountsTablePattern.MatchString(accountsTable) {
+		log.Fatalf("Invalid value for EMAIL_ACCOUNTS_TABLE: %q", accountsTable)
+	}
+	minTransactions = envInt("EMAIL_MIN_TRANSACTIONS", 0)
+	if minTransactions < 0 {
+		log.Fatalf("Invalid value for EMAIL_MIN_TRANSACTIONS: must not be negative, got %d", minTransactions)
+	}
+	allowedDomains = envSet("EMAIL_ALLOWED_DOMAINS")
+	sesSandbox = envBool("SES_SANDBOX", false)
+	emailTransport = envString("EMAIL_TRANSPORT", emailTransportSES)
+	if emailTransport != emailTransportSES && emailTransport != emailTransportSMTP {
+		log.Fatalf("Invalid value for EMAIL_TRANSPORT: %q", emailTransport)
+	}
+	if emailTransport == emailTransportSMTP && sesSandbox {
+		log.Fatal("Invalid value for SES_SANDBOX: only supported with EMAIL_TRANSPORT=ses")
+	}
+	smtpHost = os.Getenv("SMTP_HOST")
+	smtpPort = envInt("SMTP_PORT", 587)
+	if smtpPort < 1 || smtpPort > 65535 {
+		log.Fatalf("Invalid value for SMTP_PORT: %d", smtpPort)
+	}
+	smtpUsername = os.Getenv("SMTP_USERNAME")
+	smtpPassword = os.Getenv("SMTP_PASSWORD")
+	sesIdentityCacheTTL = envDuration("SES_IDENTITY_CACHE_TTL", 10*time.Minute)
+
+	emailMode = envString("EMAIL_MODE", emailModePerAccount)
+	digestEmail = os.Getenv("DIGEST_EMAIL")
+	switch emailMode {
+	case emailModePerAccount, emailModeDigest:
+	default:
+		log.Fatalf("Invalid value for EMAIL_MODE: %q", emailMode)
+	}
+}
+
+// envString returns the value of the environment variable key, or def if it is unset or empty.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration returns the duration value (e.g. "5s") of the environment variable key, or def if it is unset.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return d
+}
+
+// envBool returns the boolean value of the environment variable key, or def if it is unset.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return b
+}
+
+// checkRequiredEnv returns an error naming every variable in keys that is unset or empty,
+// so a misconfigured deployment reports all of its problems at once.
+func checkRequiredEnv(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// envInt returns the integer value of the environment variable key, or def if it is unset.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return n
+}
+
+// envSet returns the comma-separated values of the environment variable key as a
+// lower-cased set, or nil if it is unset or empty.
+func envSet(key string) map[string]struct{} {
+	var set map[string]struct{}
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]struct{})
+		}
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// envTierTemplates parses a comma-separated tier=template list, e.g.
+// "premium=premium,gold=premium". Tiers are matched case-insensitively.
+func envTierTemplates(key string) map[string]string {
+	var m map[string]string
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tier, name, ok := strings.Cut(pair, "=")
+		tier = strings.ToLower(strings.TrimSpace(tier))
+		name = strings.TrimSpace(name)
+		if _, known := emailTemplates[name]; !ok || tier == "" || !known {
+			log.Fatalf("Invalid value for %s: %q", key, pair)
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[tier] = name
+	}
+	return m
+}
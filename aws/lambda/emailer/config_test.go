@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckRequiredEnvListsEveryMissingVariable(t *testing.T) {
+	t.Setenv("REQ_A", "")
+	err := checkRequiredEnv("REQ_A", "REQ_B")
+	if err == nil {
+		t.Fatal("checkRequiredEnv() = nil, want an error")
+	}
+	if want := "missing required environment variables: REQ_A, REQ_B"; err.Error() != want {
+		t.Errorf("checkRequiredEnv() = %q, want %q", err, want)
+	}
+}
+
+func TestRequiredEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"defaults", nil, nil},
+		{"digest", map[string]string{"EMAIL_MODE": emailModeDigest}, []string{"DIGEST_EMAIL"}},
+		{"smtp", map[string]string{"EMAIL_TRANSPORT": emailTransportSMTP}, []string{"SMTP_HOST"}},
+		{"send markers", map[string]string{"EMAIL_SEND_MARKERS": "true"}, []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"}},
+		{"send markers with URL", map[string]string{"EMAIL_SEND_MARKERS": "true", "DATABASE_URL": "postgres://db/app"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"EMAIL_MODE", "EMAIL_TRANSPORT", "EMAIL_SEND_MARKERS", "EMAIL_BULK_ENABLED", "EMAIL_VERIFY_ACCOUNTS", "DATABASE_URL"} {
+				t.Setenv(key, tt.env[key])
+			}
+			if got := requiredEnv(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadConfigHelperProcess only runs in the subprocess started by loadConfigError,
+// where TestMain has already loaded the configuration from its environment.
+func TestLoadConfigHelperProcess(t *testing.T) {
+	if os.Getenv("LOAD_CONFIG_HELPER_PROCESS") != "1" {
+		t.Skip("helper process for loadConfigError")
+	}
+	loadConfig()
+}
+
+// loadConfigError loads the configuration with env in a subprocess, since invalid
+// values are fatal, and returns its output. It fails the test if loading succeeds.
+func loadConfigError(t *testing.T, env map[string]string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadConfigHelperProcess$")
+	cmd.Env = append(os.Environ(), "LOAD_CONFIG_HELPER_PROCESS=1")
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("loadConfig() with %v succeeded, want it to exit", env)
+	}
+	return string(out)
+}
+
+func TestLoadConfigRejectsNonPositiveSendTimeout(t *testing.T) {
+	for _, timeout := range []string{"0s", "-1s"} {
+		if out := loadConfigError(t, map[string]string{"SES_SEND_TIMEOUT": timeout}); !strings.Contains(out, "Invalid value for SES_SEND_TIMEOUT") {
+			t.Errorf("loadConfig() output = %q, want SES_SEND_TIMEOUT=%s rejected", out, timeout)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDigestModeSendsOneEmailWithEveryAccount(t *testing.T) {
+	setVar(t, &emailMode, emailModeDigest)
+	setVar(t, &digestEmail, "ops@example.com")
+	s := &fakeSender{}
+	useSender(t, s)
+
+	accounts := []string{"jane@example.com", "john@example.com", "ana@example.com"}
+	event := Event{}
+	for i, email := range accounts {
+		event.Summaries = append(event.Summaries, AccountSummary{Email: email, TotalBalance: float64(i + 1)})
+	}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(s.sent))
+	}
+	msg := s.sent[0]
+	if msg.To != "ops@example.com" {
+		t.Errorf("To = %q, want the digest address", msg.To)
+	}
+	if !strings.Contains(msg.HTML, "3 accounts processed") {
+		t.Errorf("digest does not report the account count:\n%s", msg.HTML)
+	}
+	for _, email := range accounts {
+		if !strings.Contains(msg.HTML, email) {
+			t.Errorf("digest is missing %s", email)
+		}
+	}
+	if len(result.Sent) != 1 || result.Sent[0] != "ops@example.com" {
+		t.Errorf("result.Sent = %v, want the digest address", result.Sent)
+	}
+}
+
+func TestPerAccountModeSendsOneEmailPerAccount(t *testing.T) {
+	setVar(t, &emailMode, emailModePerAccount)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}}}
+	if _, err := handler(context.Background(), mustJSON(t, event)); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sent) != 2 || s.sent[0].To != "jane@example.com" || s.sent[1].To != "john@example.com" {
+		t.Errorf("sent = %+v, want one email to each account", s.sent)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+var (
+	// ErrValidation marks failures caused by bad input, such as a rejected recipient address.
+	ErrValidation = errors.New("validation error")
+	// ErrTransient marks infrastructure failures that may succeed if retried.
+	ErrTransient = errors.New("transient error")
+	// ErrFatal marks infrastructure failures that will not succeed if retried.
+	ErrFatal = errors.New("fatal error")
+)
+
+// classifiedError attaches one of the sentinel kinds to an underlying error
+// without altering its message.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// classify tags err with kind so that errors.Is(err, kind) reports true.
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind: kind, err: err}
+}
+
+// errorKind returns a short label for the kind of a classified error, for logging.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	case errors.Is(err, ErrTransient):
+		return "transient"
+	default:
+		return "fatal"
+	}
+}
+
+// classifySendError tags an SES send error: rejected messages and unverified
+// identities are validation errors, retryable errors are transient, the rest fatal.
+// Errors already classified are kept, and SMTP errors go through classifySMTPError.
+func classifySendError(err error) error {
+	if errors.Is(err, ErrValidation) || errors.Is(err, ErrTransient) || errors.Is(err, ErrFatal) {
+		return err
+	}
+	if classified, ok := classifySMTPError(err); ok {
+		return classified
+	}
+	var rejected *types.MessageRejected
+	var unverified *types.MailFromDomainNotVerifiedException
+	switch {
+	case errors.As(err, &rejected), errors.As(err, &unverified):
+		return classify(ErrValidation, err)
+	case retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary:
+		return classify(ErrTransient, err)
+	default:
+		return classify(ErrFatal, err)
+	}
+}
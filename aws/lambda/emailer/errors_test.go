@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestClassifySendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"rejected", &types.MessageRejected{Message: new(string)}, ErrValidation},
+		{"unverified domain", &types.MailFromDomainNotVerifiedException{}, ErrValidation},
+		{"throttled", &smithy.GenericAPIError{Code: "Throttling"}, ErrTransient},
+		{"access denied", &smithy.GenericAPIError{Code: "AccessDenied"}, ErrFatal},
+		{"already classified", classify(ErrTransient, errors.New("timeout")), ErrTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := classifySendError(tt.err); !errors.Is(err, tt.want) {
+				t.Errorf("classifySendError() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := map[error]string{
+		ErrValidation: "validation",
+		ErrTransient:  "transient",
+		ErrFatal:      "fatal",
+	}
+	for kind, want := range tests {
+		if got := errorKind(classify(kind, errors.New("x"))); got != want {
+			t.Errorf("errorKind(%v) = %q, want %q", kind, got, want)
+		}
+	}
+}
@@ -1,52 +1,191 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"math"
+	"net/mail"
+	"net/smtp"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
-	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
 )
 
 // MonthlySummary represents a summary of transactions for a given month
 type MonthlySummary struct {
-	Month            string  `json:"month"`
-	TransactionCount int     `json:"transaction_count"`
-	AverageCredit    float64 `json:"average_credit"`
-	AverageDebit     float64 `json:"average_debit"`
+	Month            string   `json:"month"`
+	TransactionCount int      `json:"transaction_count"`
+	AverageCredit    *float64 `json:"average_credit"`
+	AverageDebit     *float64 `json:"average_debit"`
+	MaxCredit        *float64 `json:"max_credit"`
+	MaxDebit         *float64 `json:"max_debit"`
+	Net              *float64 `json:"net"`
+	PrevMonthNet     *float64 `json:"prev_month_net"`
+	ChangePercent    *float64 `json:"change_percent"`
+	// HasLargeTransaction marks a month with a transaction above the summarizer's
+	// LARGE_TRANSACTION_THRESHOLD.
+	HasLargeTransaction bool `json:"has_large_transaction,omitempty"`
 }
 
-// AccountSummary represents the total and monthly transaction summary for a user
+// AccountSummary represents the total and monthly transaction summary for a user.
+// Currencies is set when the account's transactions are broken down by currency,
+// and Transactions when the summarizer itemized a small account. Template, or else
+// the template EMAIL_TIER_TEMPLATES maps Tier to, selects the email design.
 type AccountSummary struct {
-	Email            string           `json:"email"`
+	Email            string              `json:"email"`
+	Name             string              `json:"name,omitempty"`
+	Tier             string              `json:"tier,omitempty"`
+	Template         string              `json:"template,omitempty"`
+	TotalBalance     float64             `json:"total_balance"`
+	MonthlySummaries []MonthlySummary    `json:"monthly_summaries"`
+	Currencies       []CurrencyBreakdown `json:"currencies,omitempty"`
+	Transactions     []Transaction       `json:"transactions,omitempty"`
+}
+
+// Transaction is one itemized transaction of an account
+type Transaction struct {
+	Date     string  `json:"date"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"`
+	// Large is set by the summarizer for amounts above LARGE_TRANSACTION_THRESHOLD.
+	Large bool `json:"large,omitempty"`
+}
+
+// CurrencyBreakdown is the balance and monthly summary of an account in one currency
+type CurrencyBreakdown struct {
+	Currency         string           `json:"currency"`
 	TotalBalance     float64          `json:"total_balance"`
 	MonthlySummaries []MonthlySummary `json:"monthly_summaries"`
 }
 
 // Event is the structure expected as input to the Lambda
+// With payload_encoding "gzip", summaries arrive gzipped and base64 encoded in data.
+//
+// Period (YYYY-MM, default: the current month) is the period the emails are sent for,
+// which keys the send markers. Mode "bulk" ignores the summaries and instead sends the
+// pending summaries persisted for Period in the account_summaries table.
 type Event struct {
-	Summaries []AccountSummary `json:"summaries"`
+	Mode            string           `json:"mode,omitempty"`
+	Period          string           `json:"period,omitempty"`
+	SchemaVersion   int              `json:"schema_version"`
+	PayloadEncoding string           `json:"payload_encoding,omitempty"`
+	Summaries       []AccountSummary `json:"summaries"`
+	Data            []byte           `json:"data,omitempty"`
 }
 
-var sesClient *ses.Client
+// eventModeBulk selects the bulk send from the account_summaries table.
+const eventModeBulk = "bulk"
+
+// currentSchemaVersion is the newest notifier payload schema this Lambda understands.
+// Payloads without a schema_version predate versioning and are read as version 1.
+const currentSchemaVersion = 1
+
+// Result reports the outcome of an invocation per recipient.
+type Result struct {
+	Sent   []string  `json:"sent"`
+	Failed []Failure `json:"failed,omitempty"`
+	// Queued counts emails that failed transiently and were put in the retry outbox.
+	Queued int `json:"queued,omitempty"`
+	// Deferred counts emails over EMAIL_MAX_SENDS_PER_INVOCATION put in the outbox unsent.
+	Deferred int `json:"deferred,omitempty"`
+	// Skipped lists recipients outside EMAIL_ALLOWED_DOMAINS, unverified in the SES
+	// sandbox, below EMAIL_MIN_TRANSACTIONS or unknown under EMAIL_VERIFY_ACCOUNTS.
+	Skipped []string `json:"skipped,omitempty"`
+	// AlreadySent lists recipients whose send marker for the period already existed.
+	AlreadySent []string `json:"already_sent,omitempty"`
+	// Remaining reports that a bulk run stopped early and should be invoked again.
+	Remaining bool `json:"remaining,omitempty"`
+	// BatchItemFailures lists retry queue messages to redeliver (SQS partial batch response).
+	BatchItemFailures []events.SQSBatchItemFailure `json:"batchItemFailures,omitempty"`
+}
+
+// Failure describes an email that could not be sent.
+type Failure struct {
+	Email     string `json:"email"`
+	Error     string `json:"error"`
+	Retryable bool   `json:"retryable"`
+}
+
+var (
+	// clock returns the current time; it determines the period of per-account emails.
+	clock  = time.Now
+	sender EmailSender
+	// outbox is nil unless EMAIL_RETRY_QUEUE_URL is configured.
+	outbox Outbox
+)
 
 // Initialize AWS SES client with region
-func init() {
+func initClients() {
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-east-1"))
 	if err != nil {
 		log.Fatalf("Failed to load AWS config: %v", err)
 	}
-	sesClient = ses.NewFromConfig(cfg)
+	sesClient := ses.NewFromConfig(cfg)
+	if emailTransport == emailTransportSMTP {
+		s := &smtpSender{host: smtpHost, port: smtpPort}
+		if smtpUsername != "" {
+			s.auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+		}
+		sender = s
+	} else {
+		sender = &sesSender{
+			client:        sesClient,
+			sourceARN:     sesSourceARN,
+			returnPathARN: sesReturnPathARN,
+		}
+	}
+	if sesSandbox {
+		identityClient = sesClient
+	}
+	if retryQueueURL != "" {
+		client := sqs.NewFromConfig(cfg)
+		outbox = &sqsOutbox{client: client, queueURL: retryQueueURL}
+		retryQueueClient = client
+	}
+	if useSendMarkers || bulkEnabled || verifyAccounts {
+		db, err := openDB()
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		if useSendMarkers {
+			sendMarkers = &pgSendMarkers{db: db, pendingTTL: sendMarkerPendingTTL}
+		}
+		if bulkEnabled {
+			summaryStore = &pgSummaryStore{db: db}
+		}
+		if verifyAccounts {
+			accountDirectory = &pgAccountDirectory{db: db, table: accountsTable}
+		}
+	}
 }
 
-// Format a float with 2 decimal places
+// Format a float rounded to 2 decimal places, printing -0.00 as 0.00.
 func formatFloat(f float64) string {
-	return fmt.Sprintf("%.2f", f)
+	s := fmt.Sprintf("%.2f", f)
+	if s == "-0.00" {
+		return "0.00"
+	}
+	return s
+}
+
+// Format an optional average, showing the configured label when it is absent or not finite
+func formatAverage(f *float64) string {
+	if f == nil || math.IsNaN(*f) || math.IsInf(*f, 0) {
+		return html.EscapeString(absentAmountLabel)
+	}
+	return formatFloat(*f)
 }
 
 // Convert integer to string
@@ -54,75 +193,522 @@ func itoa(i int) string {
 	return strconv.Itoa(i)
 }
 
-// Builds the HTML body of the email
+// Renders the configured brand logo, escaping the URL for use in an attribute
+func logoHTML() string {
+	return `<img src="` + html.EscapeString(logoURL) + `" alt="` + html.EscapeString(brandName+" Logo") + `" style="width:150px;margin-bottom:20px;" />`
+}
+
+// Renders the main heading, in the brand color when one is configured
+func headingHTML(text string) string {
+	if brandColor == "" {
+		return `<h1>` + html.EscapeString(text) + `</h1>`
+	}
+	return `<h1 style="color:` + html.EscapeString(brandColor) + `;">` + html.EscapeString(text) + `</h1>`
+}
+
+// Email templates selectable per account
+const (
+	templateStandard = "standard"
+	templatePremium  = "premium"
+)
+
+// emailTemplates renders the HTML body of a per-account email for each template name
+var emailTemplates = map[string]func(AccountSummary) string{
+	templateStandard: buildStandardHTMLBody,
+	templatePremium:  buildPremiumHTMLBody,
+}
+
+// templateFor returns the template of an account: its explicit template, else the one
+// mapped to its tier, else the standard template. Unknown names fall back to standard.
+func templateFor(summary AccountSummary) string {
+	name := summary.Template
+	if name == "" {
+		name = tierTemplates[strings.ToLower(strings.TrimSpace(summary.Tier))]
+	}
+	if _, ok := emailTemplates[name]; !ok {
+		return templateStandard
+	}
+	return name
+}
+
+// Builds the HTML body of the email with the account's template
 func buildHTMLBody(summary AccountSummary) string {
+	return emailTemplates[templateFor(summary)](summary)
+}
+
+// Builds the HTML body of the standard email
+func buildStandardHTMLBody(summary AccountSummary) string {
 	body := `<html><body>`
 
-	// Add Stori logo (public link)
-	body += `<img src="https://www.storicard.com/_next/static/media/storis_savvi_color.7e286ddd.svg" alt="Stori Logo" style="width:150px;margin-bottom:20px;" />`
+	// Add brand logo (public link)
+	body += logoHTML()
 
 	// Summary info
-	body += `<h1>Transaction Summary</h1>`
-	body += `<p><strong>Total Balance:</strong> ` + formatFloat(summary.TotalBalance) + `</p>`
+	body += headingHTML("Transaction Summary")
+	body += greetingHTML(summary)
+	body += buildSummaryHTML(summary)
+	body += buildTransactionsHTML(summary.Transactions)
+	body += roundingNoteHTML()
 
-	// Monthly breakdown
+	body += `</body></html>`
+	return body
+}
+
+// Builds the HTML body of the premium email: a framed layout in the brand color that
+// always lists the itemized transactions when the summarizer sent them
+func buildPremiumHTMLBody(summary AccountSummary) string {
+	accent := brandColor
+	if accent == "" {
+		accent = "#1a1a2e"
+	}
+	body := `<html><body style="background:#f4f4f7;">`
+	body += `<div style="max-width:640px;margin:0 auto;padding:24px;background:#ffffff;border-top:6px solid ` + html.EscapeString(accent) + `;">`
+
+	// Add brand logo (public link)
+	body += logoHTML()
+
+	body += headingHTML("Your Premium Account Summary")
+	body += greetingHTML(summary)
+	body += `<p>Thank you for being a premium ` + html.EscapeString(brandName) + ` customer. Here is your activity at a glance.</p>`
+	body += buildSummaryHTML(summary)
+	body += buildTransactionsHTML(summary.Transactions)
+	body += roundingNoteHTML()
+
+	body += `</div></body></html>`
+	return body
+}
+
+// Renders "Hi <name>," using the account's name, or the local part of its email when it has none
+func greetingHTML(summary AccountSummary) string {
+	name := strings.TrimSpace(summary.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(summary.Email, "@")
+	}
+	return `<p>Hi ` + html.EscapeString(name) + `,</p>`
+}
+
+// Builds the HTML body of a digest email listing every account in the event
+func buildDigestHTMLBody(summaries []AccountSummary) string {
+	body := `<html><body>`
+
+	// Add brand logo (public link)
+	body += logoHTML()
+
+	body += headingHTML("Transaction Digest")
+	body += `<p>` + itoa(len(summaries)) + ` accounts processed.</p>`
+	for _, summary := range summaries {
+		body += `<hr /><h2>` + html.EscapeString(summary.Email) + `</h2>`
+		body += buildSummaryHTML(summary)
+	}
+	body += roundingNoteHTML()
+
+	body += `</body></html>`
+	return body
+}
+
+// Builds the balance and monthly breakdown section for one account,
+// with one section per currency when the summary is broken down by currency
+func buildSummaryHTML(summary AccountSummary) string {
+	if len(summary.Currencies) == 0 {
+		return buildBalanceHTML(summary.TotalBalance, summary.MonthlySummaries)
+	}
+
+	body := ``
+	for _, c := range summary.Currencies {
+		body += `<h2>` + html.EscapeString(c.Currency) + `</h2>`
+		body += buildBalanceHTML(c.TotalBalance, c.MonthlySummaries)
+	}
+	return body
+}
+
+// Builds the total balance and monthly breakdown for one set of months
+func buildBalanceHTML(totalBalance float64, monthlySummaries []MonthlySummary) string {
+	body := `<p><strong>Total Balance:</strong> ` + formatFloat(totalBalance) + `</p>`
+
+	// Monthly breakdown, limited to the most recent months when configured
+	months := monthlySummaries
+	if maxMonths > 0 && len(months) > maxMonths {
+		months = months[len(months)-maxMonths:]
+	}
 	body += `<h2>Monthly Breakdown:</h2><ul>`
-	for _, m := range summary.MonthlySummaries {
+	for _, m := range months {
 		body += `<li><strong>` + m.Month + `</strong>: `
 		body += itoa(m.TransactionCount) + ` transactions, `
-		body += `Average credit amount: ` + formatFloat(m.AverageCredit) + `, `
-		body += `Average debit amount: ` + formatFloat(m.AverageDebit) + `</li>`
+		body += `Average ` + html.EscapeString(creditLabel) + ` amount: ` + formatAverage(m.AverageCredit) + `, `
+		body += `Average ` + html.EscapeString(debitLabel) + ` amount: ` + formatAverage(m.AverageDebit) + `, `
+		body += `Largest ` + html.EscapeString(creditLabel) + `: ` + formatAverage(m.MaxCredit) + `, `
+		body += `Largest ` + html.EscapeString(debitLabel) + `: ` + formatAverage(m.MaxDebit)
+		body += formatMonthOverMonth(m)
+		if m.HasLargeTransaction {
+			body += ` <strong style="color:#c0392b;">(large transaction)</strong>`
+		}
+		body += `</li>`
 	}
 	body += `</ul>`
-
-	body += `</body></html>`
+	if len(months) < len(monthlySummaries) {
+		body += truncationNoteHTML(len(months), len(monthlySummaries))
+	}
 	return body
 }
 
-// Main handler function
-func handler(ctx context.Context, event Event) (string, error) {
-	from := "devsysluis@gmail.com"
+// Renders the itemized transaction list as a table; accounts that were not itemized render nothing
+func buildTransactionsHTML(transactions []Transaction) string {
+	if len(transactions) == 0 {
+		return ``
+	}
+	body := `<h2>Transactions:</h2><table><tr><th align="left">Date</th><th align="right">Amount</th></tr>`
+	for _, t := range transactions {
+		amount := formatFloat(t.Amount)
+		if t.Currency != "" {
+			amount += ` ` + html.EscapeString(t.Currency)
+		}
+		row := `<tr>`
+		if t.Large {
+			row = `<tr style="background:#fdecea;font-weight:bold;">`
+		}
+		body += row + `<td>` + html.EscapeString(t.Date) + `</td><td align="right">` + amount + `</td></tr>`
+	}
+	return body + `</table>`
+}
+
+// Renders the month's net and its change versus the previous month; payloads from
+// older summarizers without a net render nothing
+func formatMonthOverMonth(m MonthlySummary) string {
+	if m.Net == nil {
+		return ``
+	}
+	text := `, Net: ` + formatFloat(*m.Net)
+	if m.PrevMonthNet == nil {
+		return text
+	}
+	text += ` (` + fmt.Sprintf("%+.2f", *m.Net-*m.PrevMonthNet)
+	if m.ChangePercent != nil && !math.IsNaN(*m.ChangePercent) && !math.IsInf(*m.ChangePercent, 0) {
+		text += `, ` + fmt.Sprintf("%+.1f%%", *m.ChangePercent)
+	}
+	return text + ` vs previous month)`
+}
+
+// Renders the note shown when older months were left out of the email
+func truncationNoteHTML(shown, total int) string {
+	note := `<p><em>Showing the most recent ` + itoa(shown) + ` of ` + itoa(total) + ` months. `
+	if statementURL != "" {
+		note += `<a href="` + html.EscapeString(statementURL) + `">View full statement</a>`
+	} else {
+		note += `View your full statement for earlier activity.`
+	}
+	return note + `</em></p>`
+}
+
+// Renders the EMAIL_ROUNDING_NOTE footnote explaining that displayed amounts are rounded;
+// nothing when it is not configured
+func roundingNoteHTML() string {
+	if roundingNote == "" {
+		return ``
+	}
+	return `<p style="font-size:12px;color:#666666;"><em>` + html.EscapeString(roundingNote) + `</em></p>`
+}
+
+// buildMessages renders the emails for an event: one per account, or a single
+// digest addressed to digestEmail when EMAIL_MODE is digest.
+func buildMessages(summaries []AccountSummary, from, subject, period string) []EmailMessage {
+	if emailMode == emailModeDigest {
+		return []EmailMessage{{
+			From:    from,
+			To:      digestEmail,
+			Subject: "Transaction Digest",
+			HTML:    buildDigestHTMLBody(summaries),
+		}}
+	}
+
+	messages := make([]EmailMessage, 0, len(summaries))
+	for _, summary := range summaries {
+		messages = append(messages, EmailMessage{
+			From:    from,
+			To:      summary.Email,
+			Subject: subject,
+			HTML:    buildHTMLBody(summary),
+			Period:  period,
+		})
+	}
+	return messages
+}
+
+// outOfTime returns an error once ctx is done or has less time left than one send
+// could take, so loops stop before starting work the deadline would cut short.
+func outOfTime(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < sendTimeout {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// transactionCount returns the number of transactions in a summary, across its
+// currencies when it is broken down by currency.
+func transactionCount(summary AccountSummary) int {
+	months := summary.MonthlySummaries
+	if len(summary.Currencies) > 0 {
+		months = nil
+		for _, c := range summary.Currencies {
+			months = append(months, c.MonthlySummaries...)
+		}
+	}
+	count := 0
+	for _, m := range months {
+		count += m.TransactionCount
+	}
+	return count
+}
+
+// belowMinTransactions reports, and logs, whether an account has fewer transactions
+// than EMAIL_MIN_TRANSACTIONS and should not be emailed.
+func belowMinTransactions(summary AccountSummary) bool {
+	if minTransactions <= 0 {
+		return false
+	}
+	count := transactionCount(summary)
+	if count >= minTransactions {
+		return false
+	}
+	log.Printf("Skipping email to %s: %d transactions, below EMAIL_MIN_TRANSACTIONS (%d)", maskEmail(summary.Email), count, minTransactions)
+	return true
+}
+
+// recipientAllowed reports whether email's domain is in EMAIL_ALLOWED_DOMAINS.
+// Every recipient is allowed when no allowlist is configured.
+func recipientAllowed(email string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	_, ok := allowedDomains[strings.ToLower(strings.TrimSpace(email[at+1:]))]
+	return ok
+}
+
+// fromHeader returns the From header for address, with EMAIL_FROM_NAME as its display
+// name when set. The name is quoted when needed and RFC 2047-encoded when not ASCII.
+func fromHeader(address string) string {
+	if fromName == "" {
+		return address
+	}
+	return (&mail.Address{Name: fromName, Address: address}).String()
+}
+
+// checkSchemaVersion returns a validation error if the payload version is not supported:
+// every version up to currentSchemaVersion is, 0 being a payload without one.
+func checkSchemaVersion(version int) error {
+	if version >= 0 && version <= currentSchemaVersion {
+		return nil
+	}
+	return classify(ErrValidation, fmt.Errorf("unsupported schema_version %d (max %d)", version, currentSchemaVersion))
+}
+
+// queueForRetry puts a transiently failed email in the outbox, if one is configured,
+// and reports whether it was queued. Permanent failures are never queued.
+func queueForRetry(ctx context.Context, msg EmailMessage, sendErr error) bool {
+	if outbox == nil || !errors.Is(sendErr, ErrTransient) {
+		return false
+	}
+	if err := outbox.Enqueue(ctx, msg); err != nil {
+		log.Printf("Failed to queue email to %s for retry: %v", maskEmail(msg.To), err)
+		return false
+	}
+	log.Printf("Queued email to %s for retry", maskEmail(msg.To))
+	return true
+}
+
+// decodeSummaries fills event.Summaries from event.Data according to its payload encoding.
+func decodeSummaries(event *Event) error {
+	switch event.PayloadEncoding {
+	case "", "json":
+		return nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(event.Data))
+		if err != nil {
+			return classify(ErrValidation, fmt.Errorf("invalid gzip payload: %w", err))
+		}
+		defer zr.Close()
+		if err := json.NewDecoder(zr).Decode(&event.Summaries); err != nil {
+			return classify(ErrValidation, fmt.Errorf("invalid compressed summaries: %w", err))
+		}
+		return nil
+	default:
+		return classify(ErrValidation, fmt.Errorf("unsupported payload_encoding %q", event.PayloadEncoding))
+	}
+}
+
+// handler is the Lambda entry point: it consumes the email retry queue when invoked by
+// SQS and otherwise handles a summaries Event.
+func handler(ctx context.Context, payload json.RawMessage) (Result, error) {
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return Result{}, classify(ErrValidation, fmt.Errorf("invalid event payload: %w", err))
+	}
+	if len(probe.Records) > 0 && probe.Records[0].EventSource == "aws:sqs" {
+		if retryQueueClient == nil {
+			return Result{}, classify(ErrValidation, errors.New("SQS events require EMAIL_RETRY_QUEUE_URL"))
+		}
+		var sqsEvent events.SQSEvent
+		if err := json.Unmarshal(payload, &sqsEvent); err != nil {
+			return Result{}, classify(ErrValidation, fmt.Errorf("invalid SQS event: %w", err))
+		}
+		return handleRetryQueue(ctx, sqsEvent)
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Result{}, classify(ErrValidation, fmt.Errorf("invalid event: %w", err))
+	}
+	return handleEvent(ctx, event)
+}
+
+// handleEvent sends the emails for a summaries Event.
+func handleEvent(ctx context.Context, event Event) (Result, error) {
+	from := fromHeader("devsysluis@gmail.com")
 	subject := "Your Monthly Transaction Summary"
 
+	// Reruns for the same period find the markers of the emails already sent
+	period := event.Period
+	if period == "" {
+		period = clock().Format("2006-01")
+	} else if _, err := time.Parse("2006-01", period); err != nil {
+		log.Printf("Rejecting event: invalid period %q", period)
+		return Result{}, classify(ErrValidation, fmt.Errorf("invalid period %q: want YYYY-MM", period))
+	}
+
+	if event.Mode == eventModeBulk {
+		if summaryStore == nil {
+			return Result{}, classify(ErrValidation, errors.New("bulk mode requires EMAIL_BULK_ENABLED"))
+		}
+		return bulkSend(ctx, period, from, subject)
+	}
+
+	// Reject payloads written for a schema we don't know how to read
+	if err := checkSchemaVersion(event.SchemaVersion); err != nil {
+		log.Printf("Rejecting event: %v", err)
+		return Result{}, err
+	}
+
+	// Unpack compressed summaries
+	if err := decodeSummaries(&event); err != nil {
+		log.Printf("Rejecting event: %v", err)
+		return Result{}, err
+	}
+
 	// Check if there are any summaries to process
 	if len(event.Summaries) == 0 {
 		log.Println("No summaries received to send.")
-		return "No summaries to send", nil
+		return Result{}, nil
 	}
 
-	// Process each summary and send email
+	// Accounts with too little activity are not worth an email
+	var result Result
+	summaries := make([]AccountSummary, 0, len(event.Summaries))
 	for _, summary := range event.Summaries {
-		body := buildHTMLBody(summary)
-
-		input := &ses.SendEmailInput{
-			Source: aws.String(from),
-			Destination: &types.Destination{
-				ToAddresses: []string{summary.Email},
-			},
-			Message: &types.Message{
-				Subject: &types.Content{
-					Data: aws.String(subject),
-				},
-				Body: &types.Body{
-					Html: &types.Content{
-						Data: aws.String(body),
-					},
-				},
-			},
-		}
-
-		// Attempt to send email via SES
-		_, err := sesClient.SendEmail(ctx, input)
+		if belowMinTransactions(summary) {
+			result.Skipped = append(result.Skipped, summary.Email)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+
+	// Render and send each email
+	attempts := 0
+	messages := buildMessages(summaries, from, subject, period)
+	for i, msg := range messages {
+		// Near the deadline the remaining emails are reported as retryable, not half-sent
+		if err := outOfTime(ctx); err != nil {
+			log.Printf("Stopping before %d remaining emails: %v", len(messages)-i, err)
+			for _, rest := range messages[i:] {
+				result.Failed = append(result.Failed, Failure{Email: rest.To, Error: fmt.Sprintf("not attempted: %v", err), Retryable: true})
+			}
+			break
+		}
+
+		// Keep test environments from emailing domains outside the allowlist
+		if !recipientAllowed(msg.To) {
+			log.Printf("Skipping email to %s: domain not in EMAIL_ALLOWED_DOMAINS", maskEmail(msg.To))
+			result.Skipped = append(result.Skipped, msg.To)
+			continue
+		}
+
+		// In the SES sandbox, unverified recipients would be rejected by SES anyway
+		if ok, err := sandboxPreflight(ctx, msg.To); err != nil {
+			log.Printf("Warning: SES sandbox preflight failed for %s, sending anyway: %v", maskEmail(msg.To), err)
+		} else if !ok {
+			log.Printf("Skipping email to %s: not a verified identity in the SES sandbox", maskEmail(msg.To))
+			result.Skipped = append(result.Skipped, msg.To)
+			continue
+		}
+
+		// The payload is not trusted to name real accounts; digests go to the operator
+		if emailMode == emailModePerAccount {
+			if ok, err := verifyRecipient(ctx, msg.To); err != nil {
+				log.Printf("Not sending email to %s: %v", maskEmail(msg.To), err)
+				result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error(), Retryable: true})
+				continue
+			} else if !ok {
+				log.Printf("Skipping email to %s: unknown or suppressed account", maskEmail(msg.To))
+				result.Skipped = append(result.Skipped, msg.To)
+				continue
+			}
+		}
+
+		// Past the per-invocation cap, leave the email for the retry queue consumer
+		if maxSendsPerInvocation > 0 && attempts >= maxSendsPerInvocation {
+			if err := outbox.Enqueue(ctx, msg); err != nil {
+				log.Printf("Failed to defer email to %s: %v", maskEmail(msg.To), err)
+				result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error(), Retryable: true})
+				continue
+			}
+			result.Deferred++
+			continue
+		}
+
+		// Attempt to send email, at most once per recipient and period when send markers are enabled
+		attempted, err := deliver(ctx, msg)
+		if attempted {
+			attempts++
+		}
+		if !attempted && (err == nil || errors.Is(err, errSendPending)) {
+			log.Printf("Skipping email to %s: already sent (or possibly sent) for %s", maskEmail(msg.To), msg.Period)
+			result.AlreadySent = append(result.AlreadySent, msg.To)
+			continue
+		}
 		if err != nil {
-			log.Printf("Failed to send email to %s: %v", summary.Email, err)
+			log.Printf("Failed to send email to %s (%s): %v", maskEmail(msg.To), errorKind(err), err)
+			if queueForRetry(ctx, msg, err) {
+				result.Queued++
+				continue
+			}
+			result.Failed = append(result.Failed, Failure{
+				Email:     msg.To,
+				Error:     err.Error(),
+				Retryable: errors.Is(err, ErrTransient),
+			})
 			continue
 		}
-		log.Printf("Email successfully sent to %s", summary.Email)
+		log.Printf("Email successfully sent to %s", maskEmail(msg.To))
+		result.Sent = append(result.Sent, msg.To)
 	}
 
-	return "Emails sent", nil
+	if result.Deferred > 0 {
+		log.Printf("Send cap of %d reached: deferred %d emails to the retry queue", maxSendsPerInvocation, result.Deferred)
+	}
+	return result, nil
 }
 
 func main() {
+	loadConfig()
+	initClients()
 	lambda.Start(handler)
 }
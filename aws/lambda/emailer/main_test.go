@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	loadConfig()
+	os.Exit(m.Run())
+}
+
+// fakeSender is an EmailSender that records the messages it is given and answers
+// with send, or succeeds when send is nil.
+type fakeSender struct {
+	mu   sync.Mutex
+	send func(EmailMessage) error
+	sent []EmailMessage
+}
+
+func (s *fakeSender) Send(ctx context.Context, msg EmailMessage) error {
+	s.mu.Lock()
+	s.sent = append(s.sent, msg)
+	s.mu.Unlock()
+	if s.send == nil {
+		return nil
+	}
+	return s.send(msg)
+}
+
+// useSender replaces the EmailSender for the duration of the test.
+func useSender(t *testing.T, s EmailSender) {
+	t.Helper()
+	prev := sender
+	sender = s
+	t.Cleanup(func() { sender = prev })
+}
+
+// setVar sets *p to v for the duration of the test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// mustJSON encodes v, failing the test on error.
+func mustJSON(t *testing.T, v any) json.RawMessage {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	for v := 0; v <= currentSchemaVersion; v++ {
+		if err := checkSchemaVersion(v); err != nil {
+			t.Errorf("checkSchemaVersion(%d) = %v, want nil", v, err)
+		}
+	}
+	for _, v := range []int{-1, currentSchemaVersion + 1} {
+		if err := checkSchemaVersion(v); !errors.Is(err, ErrValidation) {
+			t.Errorf("checkSchemaVersion(%d) = %v, want ErrValidation", v, err)
+		}
+	}
+}
+
+func TestHandlerRejectsUnknownSchemaVersion(t *testing.T) {
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{SchemaVersion: currentSchemaVersion + 1, Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	_, err := handler(context.Background(), mustJSON(t, event))
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("handler() error = %v, want ErrValidation", err)
+	}
+	if len(s.sent) != 0 {
+		t.Errorf("sent %d emails, want none", len(s.sent))
+	}
+}
+
+func TestHandlerReadsUnversionedPayloadAsVersion1(t *testing.T) {
+	s := &fakeSender{}
+	useSender(t, s)
+
+	payload := json.RawMessage(`{"summaries": [{"email": "jane@example.com", "total_balance": 10}]}`)
+	result, err := handler(context.Background(), payload)
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(result.Sent) != 1 || len(s.sent) != 1 {
+		t.Errorf("sent = %v (%d messages), want one email", result.Sent, len(s.sent))
+	}
+}
+
+func TestHandlerUsesClockForPeriod(t *testing.T) {
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC) })
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	if _, err := handler(context.Background(), mustJSON(t, event)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 1 || s.sent[0].Period != "2024-03" {
+		t.Errorf("sent %+v, want one email for 2024-03", s.sent)
+	}
+
+	event.Period = "2024-02"
+	s.sent = nil
+	if _, err := handler(context.Background(), mustJSON(t, event)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 1 || s.sent[0].Period != "2024-02" {
+		t.Errorf("sent %+v, want the event's period 2024-02 over the clock", s.sent)
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "reports@example.com"},
+		{"Stori Statements", `"Stori Statements" <reports@example.com>`},
+		{"Stori, Inc.", `"Stori, Inc." <reports@example.com>`},
+		{"Estados de Cuenta Stori ñ", "=?utf-8?q?Estados_de_Cuenta_Stori_=C3=B1?= <reports@example.com>"},
+	}
+	for _, tt := range tests {
+		setVar(t, &fromName, tt.name)
+		if got := fromHeader("reports@example.com"); got != tt.want {
+			t.Errorf("fromHeader() with name %q = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerSendsFromDisplayName(t *testing.T) {
+	setVar(t, &fromName, "Stori Statements")
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	if _, err := handler(context.Background(), mustJSON(t, event)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 1 || s.sent[0].From != `"Stori Statements" <devsysluis@gmail.com>` {
+		t.Errorf("sent %+v, want one email from \"Stori Statements\" <devsysluis@gmail.com>", s.sent)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// SendMarkers records which (email, period) pairs have been sent, or may have been.
+// A marker is claimed before the first send attempt and kept when SES may have
+// accepted the email, so no later retry sends it again.
+type SendMarkers interface {
+	// Claim creates a pending marker and reports false if the email was already
+	// sent. A pending marker that has not expired yields errSendPending; an
+	// expired one is claimed again.
+	Claim(ctx context.Context, email, period string) (bool, error)
+	// MarkSent records that the email was accepted by SES.
+	MarkSent(ctx context.Context, email, period string) error
+	// Release removes the marker after a failure that certainly sent nothing.
+	Release(ctx context.Context, email, period string) error
+}
+
+// sendMarkers is nil unless EMAIL_SEND_MARKERS is enabled.
+var sendMarkers SendMarkers
+
+// errSendPending reports a marker left pending by an ambiguous send that has not
+// expired yet, so the email may have gone out and is not sent again for now.
+var errSendPending = classify(ErrTransient, errors.New("send marker pending: email may already have been sent"))
+
+// openDB opens the Postgres pool described by DATABASE_URL, or else by the DB_*
+// environment variables. sql.Open does not connect, so an unreachable database
+// surfaces on first use.
+func openDB() (*sql.DB, error) {
+	if databaseURL != "" {
+		return sql.Open("postgres", databaseURL)
+	}
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), dbSSLMode)
+	if dbSSLRootCert != "" {
+		connStr += " sslrootcert=" + quoteConnValue(dbSSLRootCert)
+	}
+	return sql.Open("postgres", connStr)
+}
+
+// quoteConnValue single-quotes a connection string value, escaping quotes and
+// backslashes, so paths with spaces are passed through intact.
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// pgSendMarkers stores markers in the email_send_markers table. Pending markers
+// older than pendingTTL are reclaimed; 0 never reclaims them.
+type pgSendMarkers struct {
+	db         *sql.DB
+	pendingTTL time.Duration
+}
+
+func (m *pgSendMarkers) Claim(ctx context.Context, email, period string) (bool, error) {
+	var claimed string
+	err := m.db.QueryRowContext(ctx, `
+		INSERT INTO email_send_markers (email, period)
+		VALUES ($1, $2)
+		ON CONFLICT (email, period) DO UPDATE SET updated_at = NOW()
+		WHERE email_send_markers.status = 'pending'
+		  AND $3::float8 > 0
+		  AND email_send_markers.updated_at < NOW() - make_interval(secs => $3::float8)
+		RETURNING email`, email, period, m.pendingTTL.Seconds()).Scan(&claimed)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, classify(ErrTransient, fmt.Errorf("error claiming send marker: %w", err))
+	}
+
+	var status string
+	err = m.db.QueryRowContext(ctx, `
+		SELECT status FROM email_send_markers WHERE email = $1 AND period = $2`, email, period).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released since the insert; a retry can claim it
+		return false, classify(ErrTransient, errors.New("send marker released while claiming"))
+	}
+	if err != nil {
+		return false, classify(ErrTransient, fmt.Errorf("error reading send marker: %w", err))
+	}
+	if status == "pending" {
+		return false, errSendPending
+	}
+	return false, nil
+}
+
+func (m *pgSendMarkers) MarkSent(ctx context.Context, email, period string) error {
+	_, err := m.db.ExecContext(ctx, `
+		UPDATE email_send_markers SET status = 'sent', updated_at = NOW()
+		WHERE email = $1 AND period = $2`, email, period)
+	if err != nil {
+		return fmt.Errorf("error marking email sent: %w", err)
+	}
+	return nil
+}
+
+func (m *pgSendMarkers) Release(ctx context.Context, email, period string) error {
+	_, err := m.db.ExecContext(ctx, `
+		DELETE FROM email_send_markers WHERE email = $1 AND period = $2`, email, period)
+	if err != nil {
+		return fmt.Errorf("error releasing send marker: %w", err)
+	}
+	return nil
+}
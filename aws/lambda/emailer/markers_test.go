@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// fakeMarkers is an in-memory SendMarkers whose pending markers never expire.
+type fakeMarkers struct {
+	mu       sync.Mutex
+	status   map[string]string
+	released int
+}
+
+func newFakeMarkers() *fakeMarkers {
+	return &fakeMarkers{status: make(map[string]string)}
+}
+
+func (m *fakeMarkers) Claim(ctx context.Context, email, period string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.status[email+"|"+period] {
+	case "":
+		m.status[email+"|"+period] = "pending"
+		return true, nil
+	case "pending":
+		return false, errSendPending
+	}
+	return false, nil
+}
+
+func (m *fakeMarkers) MarkSent(ctx context.Context, email, period string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[email+"|"+period] = "sent"
+	return nil
+}
+
+func (m *fakeMarkers) Release(ctx context.Context, email, period string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.status, email+"|"+period)
+	m.released++
+	return nil
+}
+
+func TestDeliverDoesNotResendAfterAmbiguousTimeout(t *testing.T) {
+	markers := newFakeMarkers()
+	setVar[SendMarkers](t, &sendMarkers, markers)
+	setVar(t, &sendTimeout, 20*time.Millisecond)
+	setVar(t, &sendRetries, 2)
+	release := make(chan struct{})
+	defer close(release)
+	s := &fakeSender{send: func(EmailMessage) error {
+		<-release // SES accepted the email but the answer never arrives in time
+		return nil
+	}}
+	useSender(t, s)
+	msg := EmailMessage{To: "jane@example.com", Period: "2024-03"}
+
+	attempted, err := deliver(context.Background(), msg)
+	if !attempted || !errors.Is(err, errAmbiguousSend) {
+		t.Fatalf("deliver() = %v, %v, want an attempted ambiguous send", attempted, err)
+	}
+	attempted, err = deliver(context.Background(), msg)
+	if attempted || !errors.Is(err, errSendPending) {
+		t.Errorf("deliver() retry = %v, %v, want it blocked by the pending marker", attempted, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sent) != 1 {
+		t.Errorf("sent %d times, want 1 with neither an in-process nor a later retry", len(s.sent))
+	}
+}
+
+func TestDeliverReleasesMarkerWhenNothingWasSent(t *testing.T) {
+	markers := newFakeMarkers()
+	setVar[SendMarkers](t, &sendMarkers, markers)
+	rejected := true
+	s := &fakeSender{send: func(EmailMessage) error {
+		if rejected {
+			return classify(ErrFatal, errors.New("message rejected"))
+		}
+		return nil
+	}}
+	useSender(t, s)
+	msg := EmailMessage{To: "jane@example.com", Period: "2024-03"}
+
+	if _, err := deliver(context.Background(), msg); !errors.Is(err, ErrFatal) {
+		t.Fatalf("deliver() = %v, want the rejection", err)
+	}
+	if markers.released != 1 {
+		t.Fatalf("released %d markers, want 1", markers.released)
+	}
+
+	rejected = false
+	if attempted, err := deliver(context.Background(), msg); !attempted || err != nil {
+		t.Fatalf("deliver() retry = %v, %v, want it sent", attempted, err)
+	}
+	if attempted, err := deliver(context.Background(), msg); attempted || err != nil {
+		t.Errorf("deliver() after sent = %v, %v, want it skipped as already sent", attempted, err)
+	}
+	if len(s.sent) != 2 {
+		t.Errorf("sent %d times, want the rejected attempt and one send", len(s.sent))
+	}
+}
+
+func TestSendWithRetriesRetriesTransientFailures(t *testing.T) {
+	setVar(t, &sendRetries, 2)
+	setVar(t, &sendRetryBackoff, time.Millisecond)
+	calls := 0
+	useSender(t, &fakeSender{send: func(EmailMessage) error {
+		calls++
+		if calls < 3 {
+			return classify(ErrTransient, errors.New("throttled"))
+		}
+		return nil
+	}})
+
+	if err := sendWithRetries(context.Background(), EmailMessage{To: "jane@example.com"}); err != nil {
+		t.Fatalf("sendWithRetries() = %v, want success on the third attempt", err)
+	}
+	if calls != 3 {
+		t.Errorf("sent %d times, want 3", calls)
+	}
+}
+
+func TestPgSendMarkersClaim(t *testing.T) {
+	claim := regexp.QuoteMeta("INSERT INTO email_send_markers")
+	status := regexp.QuoteMeta("SELECT status FROM email_send_markers")
+	tests := []struct {
+		name    string
+		expect  func(sqlmock.Sqlmock)
+		claimed bool
+		wantErr error
+	}{
+		{"new marker", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(claim).WithArgs("jane@example.com", "2024-03", time.Hour.Seconds()).
+				WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("jane@example.com"))
+		}, true, nil},
+		{"unexpired pending marker", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(claim).WillReturnRows(sqlmock.NewRows([]string{"email"}))
+			mock.ExpectQuery(status).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		}, false, errSendPending},
+		{"already sent", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(claim).WillReturnRows(sqlmock.NewRows([]string{"email"}))
+			mock.ExpectQuery(status).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("sent"))
+		}, false, nil},
+		{"database down", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(claim).WillReturnError(errors.New("connection refused"))
+		}, false, ErrTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.expect(mock)
+
+			m := &pgSendMarkers{db: db, pendingTTL: time.Hour}
+			claimed, err := m.Claim(context.Background(), "jane@example.com", "2024-03")
+			if claimed != tt.claimed || !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Claim() = %v, %v, want %v, %v", claimed, err, tt.claimed, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHandlerRerunEmailsOnlyNewAccounts(t *testing.T) {
+	setVar[SendMarkers](t, &sendMarkers, newFakeMarkers())
+	s := &fakeSender{}
+	useSender(t, s)
+	ctx := context.Background()
+
+	first := Event{Period: "2024-03", Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	if _, err := handler(ctx, mustJSON(t, first)); err != nil {
+		t.Fatalf("first run error = %v", err)
+	}
+	rerun := Event{Period: "2024-03", Summaries: []AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}}}
+	result, err := handler(ctx, mustJSON(t, rerun))
+	if err != nil {
+		t.Fatalf("rerun error = %v", err)
+	}
+	if len(result.Sent) != 1 || result.Sent[0] != "john@example.com" {
+		t.Errorf("rerun sent %v, want only the new john@example.com", result.Sent)
+	}
+
+	// The markers are per period, so the next month emails everyone again
+	next := Event{Period: "2024-04", Summaries: rerun.Summaries}
+	if result, err = handler(ctx, mustJSON(t, next)); err != nil {
+		t.Fatalf("next period error = %v", err)
+	}
+	if len(result.Sent) != 2 {
+		t.Errorf("next period sent %v, want both accounts", result.Sent)
+	}
+	if len(s.sent) != 4 {
+		t.Errorf("sent %d emails, want 4 across the three runs", len(s.sent))
+	}
+}
+
+func TestHandlerRejectsInvalidPeriod(t *testing.T) {
+	useSender(t, &fakeSender{})
+	for _, period := range []string{"2024-3", "March 2024", "2024-03-01"} {
+		event := Event{Period: period, Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+		if _, err := handler(context.Background(), mustJSON(t, event)); !errors.Is(err, ErrValidation) {
+			t.Errorf("handler() with period %q error = %v, want ErrValidation", period, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+// accountWith returns a summary for email with the given transaction counts per month.
+func accountWith(email string, counts ...int) AccountSummary {
+	summary := AccountSummary{Email: email}
+	for _, n := range counts {
+		summary.MonthlySummaries = append(summary.MonthlySummaries, MonthlySummary{Month: "January", TransactionCount: n})
+	}
+	return summary
+}
+
+func TestTransactionCountAcrossCurrencies(t *testing.T) {
+	summary := AccountSummary{Currencies: []CurrencyBreakdown{
+		{Currency: "EUR", MonthlySummaries: []MonthlySummary{{TransactionCount: 2}, {TransactionCount: 1}}},
+		{Currency: "USD", MonthlySummaries: []MonthlySummary{{TransactionCount: 4}}},
+	}}
+	if got := transactionCount(summary); got != 7 {
+		t.Errorf("transactionCount() = %d, want 7", got)
+	}
+	if got := transactionCount(accountWith("jane@example.com", 2, 3)); got != 5 {
+		t.Errorf("transactionCount() = %d, want 5", got)
+	}
+}
+
+func TestHandlerSkipsAccountsBelowMinTransactions(t *testing.T) {
+	setVar(t, &minTransactions, 3)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{
+		accountWith("below@example.com", 1, 1),
+		accountWith("at@example.com", 2, 1),
+		accountWith("above@example.com", 5),
+		accountWith("empty@example.com"),
+	}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if want := []string{"at@example.com", "above@example.com"}; !slices.Equal(result.Sent, want) {
+		t.Errorf("sent %v, want %v", result.Sent, want)
+	}
+	if want := []string{"below@example.com", "empty@example.com"}; !slices.Equal(result.Skipped, want) {
+		t.Errorf("skipped %v, want %v", result.Skipped, want)
+	}
+	if len(s.sent) != 2 {
+		t.Errorf("sent %d emails, want 2", len(s.sent))
+	}
+}
+
+func TestHandlerSendsEveryAccountByDefault(t *testing.T) {
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{accountWith("quiet@example.com"), accountWith("busy@example.com", 9)}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(result.Sent) != 2 || len(result.Skipped) != 0 {
+		t.Errorf("sent %v, skipped %v, want every account sent", result.Sent, result.Skipped)
+	}
+}
+
+func TestBulkSendSkipsAccountsBelowMinTransactions(t *testing.T) {
+	setVar(t, &minTransactions, 2)
+	setVar(t, &bulkRate, 1000)
+	store := newMemSummaryStore("2024-03")
+	for _, summary := range []AccountSummary{accountWith("below@example.com", 1), accountWith("at@example.com", 2)} {
+		store.summaries[summary.Email] = StoredSummary{Period: "2024-03", Summary: summary}
+		store.status[summary.Email] = summaryPending
+	}
+	useSummaryStore(t, store)
+	useSender(t, &fakeSender{})
+
+	result, err := bulkSend(context.Background(), "2024-03", "reports@example.com", "Summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Sent, []string{"at@example.com"}) || !slices.Equal(result.Skipped, []string{"below@example.com"}) {
+		t.Errorf("sent %v, skipped %v, want at@ sent and below@ skipped", result.Sent, result.Skipped)
+	}
+	if store.status["below@example.com"] != summarySkipped || store.status["at@example.com"] != summarySent {
+		t.Errorf("statuses = %v, want below@ skipped and at@ sent", store.status)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Outbox holds emails that could not be delivered so they can be retried later.
+type Outbox interface {
+	Enqueue(ctx context.Context, msg EmailMessage) error
+}
+
+// sqsSendAPI is the subset of the SQS client used by sqsOutbox.
+type sqsSendAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// sqsOutbox queues undeliverable emails as JSON messages on an SQS queue.
+type sqsOutbox struct {
+	client   sqsSendAPI
+	queueURL string
+}
+
+func (o *sqsOutbox) Enqueue(ctx context.Context, msg EmailMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error serializing queued email: %w", err)
+	}
+	_, err = o.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(o.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("error queueing email on %s: %w", o.queueURL, err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+)
+
+// fakeOutbox is an Outbox that records the emails queued on it.
+type fakeOutbox struct {
+	queued []EmailMessage
+	err    error
+}
+
+func (o *fakeOutbox) Enqueue(ctx context.Context, msg EmailMessage) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.queued = append(o.queued, msg)
+	return nil
+}
+
+// useOutbox replaces the outbox for the duration of the test.
+func useOutbox(t *testing.T, o Outbox) {
+	t.Helper()
+	setVar(t, &outbox, o)
+}
+
+// errSESThrottled is an SES error the SDK's default retryer treats as transient.
+var errSESThrottled = &smithy.GenericAPIError{Code: "Throttling", Message: "Maximum sending rate exceeded"}
+
+func TestHandlerQueuesEmailsWhenSESIsUnavailable(t *testing.T) {
+	useSender(t, &fakeSender{send: func(EmailMessage) error { return errSESThrottled }})
+	o := &fakeOutbox{}
+	useOutbox(t, o)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if result.Queued != 2 || len(result.Failed) != 0 {
+		t.Errorf("result = %+v, want both emails queued", result)
+	}
+	if len(o.queued) != 2 || o.queued[0].To != "jane@example.com" || o.queued[1].To != "john@example.com" {
+		t.Errorf("queued %v, want the emails of jane and john", o.queued)
+	}
+}
+
+func TestHandlerDoesNotQueuePermanentFailures(t *testing.T) {
+	useSender(t, &fakeSender{send: func(EmailMessage) error {
+		return &types.MessageRejected{Message: aws.String("Email address is not verified")}
+	}})
+	o := &fakeOutbox{}
+	useOutbox(t, o)
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if result.Queued != 0 || len(result.Failed) != 1 || result.Failed[0].Retryable {
+		t.Errorf("result = %+v, want one permanent failure", result)
+	}
+	if len(o.queued) != 0 {
+		t.Errorf("queued %d emails, want none", len(o.queued))
+	}
+}
+
+func TestHandlerReportsFailureWhenOutboxIsUnavailable(t *testing.T) {
+	useSender(t, &fakeSender{send: func(EmailMessage) error { return errSESThrottled }})
+	useOutbox(t, &fakeOutbox{err: errors.New("queue unavailable")})
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if result.Queued != 0 || len(result.Failed) != 1 || !result.Failed[0].Retryable {
+		t.Errorf("result = %+v, want one retryable failure", result)
+	}
+}
+
+// fakeSQS is an sqsSendAPI that records the messages it sends.
+type fakeSQS struct {
+	inputs []*sqs.SendMessageInput
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func TestSQSOutboxQueuesMessageAsJSON(t *testing.T) {
+	client := &fakeSQS{}
+	o := &sqsOutbox{client: client, queueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/outbox"}
+	msg := EmailMessage{From: "reports@example.com", To: "jane@example.com", Subject: "Summary", HTML: "<p>hi</p>", Period: "2024-01"}
+
+	if err := o.Enqueue(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.inputs) != 1 || aws.ToString(client.inputs[0].QueueUrl) != o.queueURL {
+		t.Fatalf("SendMessage inputs = %v, want one message on %s", client.inputs, o.queueURL)
+	}
+	var got EmailMessage
+	if err := json.Unmarshal([]byte(aws.ToString(client.inputs[0].MessageBody)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != msg {
+		t.Errorf("queued %+v, want %+v", got, msg)
+	}
+}
+
+func TestHandlerDefersEmailsOverSendCap(t *testing.T) {
+	setVar(t, &maxSendsPerInvocation, 2)
+	s := &fakeSender{}
+	useSender(t, s)
+	o := &fakeOutbox{}
+	useOutbox(t, o)
+
+	event := Event{Period: "2024-03", Summaries: []AccountSummary{
+		{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}, {Email: "d@example.com"}, {Email: "e@example.com"},
+	}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(s.sent) != 2 || len(result.Sent) != 2 {
+		t.Errorf("sent %d emails (%v), want the cap of 2", len(s.sent), result.Sent)
+	}
+	if result.Deferred != 3 || len(o.queued) != 3 {
+		t.Fatalf("deferred %d, queued %d, want 3", result.Deferred, len(o.queued))
+	}
+	for i, want := range []string{"c@example.com", "d@example.com", "e@example.com"} {
+		if msg := o.queued[i]; msg.To != want || msg.Period != "2024-03" || msg.HTML == "" {
+			t.Errorf("queued[%d] = %+v, want the rendered email to %s for 2024-03", i, msg, want)
+		}
+	}
+}
+
+func TestHandlerCountsOnlyAttemptedSendsTowardsCap(t *testing.T) {
+	setVar(t, &maxSendsPerInvocation, 1)
+	markers := newFakeMarkers()
+	markers.status["a@example.com|2024-03"] = "sent"
+	setVar[SendMarkers](t, &sendMarkers, markers)
+	s := &fakeSender{}
+	useSender(t, s)
+	o := &fakeOutbox{}
+	useOutbox(t, o)
+
+	event := Event{Period: "2024-03", Summaries: []AccountSummary{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(result.AlreadySent) != 1 || len(result.Sent) != 1 || result.Sent[0] != "b@example.com" || result.Deferred != 1 {
+		t.Errorf("result = %+v, want a already sent, b sent and c deferred", result)
+	}
+}
+
+func TestHandlerReportsDeferFailure(t *testing.T) {
+	setVar(t, &maxSendsPerInvocation, 1)
+	useSender(t, &fakeSender{})
+	useOutbox(t, &fakeOutbox{err: errors.New("queue unavailable")})
+
+	event := Event{Summaries: []AccountSummary{{Email: "a@example.com"}, {Email: "b@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if result.Deferred != 0 || len(result.Failed) != 1 || result.Failed[0].Email != "b@example.com" || !result.Failed[0].Retryable {
+		t.Errorf("result = %+v, want b@example.com as a retryable failure", result)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// gzipJSON returns the gzipped JSON encoding of v, as the summarizer compresses summaries.
+func gzipJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeSummariesDecompressesGzipPayload(t *testing.T) {
+	net := 42.5
+	want := []AccountSummary{
+		{Email: "jane@example.com", TotalBalance: 42.5, MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 2, Net: &net}}},
+		{Email: "john@example.com", TotalBalance: -3},
+	}
+
+	// Sent as JSON, the data travels base64 encoded
+	var event Event
+	if err := json.Unmarshal(mustJSON(t, Event{PayloadEncoding: "gzip", Data: gzipJSON(t, want)}), &event); err != nil {
+		t.Fatal(err)
+	}
+	if err := decodeSummaries(&event); err != nil {
+		t.Fatalf("decodeSummaries() error = %v", err)
+	}
+	if !reflect.DeepEqual(event.Summaries, want) {
+		t.Errorf("summaries = %+v, want %+v", event.Summaries, want)
+	}
+}
+
+func TestHandlerSendsCompressedSummaries(t *testing.T) {
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{PayloadEncoding: "gzip", Data: gzipJSON(t, []AccountSummary{{Email: "jane@example.com"}})}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if len(result.Sent) != 1 || result.Sent[0] != "jane@example.com" {
+		t.Errorf("sent = %v, want jane@example.com", result.Sent)
+	}
+}
+
+func TestDecodeSummariesRejectsBadPayloads(t *testing.T) {
+	for _, event := range []Event{
+		{PayloadEncoding: "gzip", Data: []byte("not gzip")},
+		{PayloadEncoding: "gzip", Data: gzipJSON(t, "not summaries")},
+		{PayloadEncoding: "brotli"},
+	} {
+		if err := decodeSummaries(&event); !errors.Is(err, ErrValidation) {
+			t.Errorf("decodeSummaries(%s) = %v, want ErrValidation", event.PayloadEncoding, err)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "strings"
+
+// maskEmail partially redacts an email address for logging, keeping the first
+// character of the local part and the domain (j***@example.com).
+// The address is returned unchanged when logPII is enabled.
+func maskEmail(email string) string {
+	if logPII || email == "" {
+		return email
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
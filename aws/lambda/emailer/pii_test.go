@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestMaskEmail(t *testing.T) {
+	tests := map[string]string{
+		"jane@example.com":      "j***@example.com",
+		"j@example.com":         "j***@example.com",
+		"first.last@mail.co.uk": "f***@mail.co.uk",
+		`"a@b"@example.com`:     `"***@example.com`,
+		"not-an-email":          "***",
+		"@example.com":          "***",
+		"":                      "",
+	}
+	for email, want := range tests {
+		if got := maskEmail(email); got != want {
+			t.Errorf("maskEmail(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
+
+func TestMaskEmailWithLogPII(t *testing.T) {
+	setVar(t, &logPII, true)
+	if got := maskEmail("jane@example.com"); got != "jane@example.com" {
+		t.Errorf("maskEmail() = %q, want the address unchanged", got)
+	}
+}
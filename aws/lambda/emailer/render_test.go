@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildHTMLBodyUsesConfiguredBranding(t *testing.T) {
+	setVar(t, &logoURL, "https://cdn.example.com/logo.svg?a=1&b=2")
+	setVar(t, &brandName, "Acme")
+	setVar(t, &brandColor, "#ff0000")
+
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", TotalBalance: 10})
+	for _, want := range []string{
+		`src="https://cdn.example.com/logo.svg?a=1&amp;b=2"`,
+		`alt="Acme Logo"`,
+		`<h1 style="color:#ff0000;">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestLogoHTMLEscapesURL(t *testing.T) {
+	setVar(t, &logoURL, `https://example.com/"><script>alert(1)</script>`)
+	got := logoHTML()
+	if strings.Contains(got, "<script>") || !strings.Contains(got, "&#34;&gt;&lt;script&gt;") {
+		t.Errorf("logoHTML() = %s, want the URL escaped", got)
+	}
+}
+
+func TestHeadingHTMLWithoutBrandColor(t *testing.T) {
+	setVar(t, &brandColor, "")
+	if got := headingHTML("Transaction Summary"); got != "<h1>Transaction Summary</h1>" {
+		t.Errorf("headingHTML() = %s, want an unstyled heading", got)
+	}
+}
+
+// monthsOf returns n monthly summaries for consecutive months starting at January 2024.
+func monthsOf(n int) []MonthlySummary {
+	months := make([]MonthlySummary, n)
+	for i := range months {
+		months[i] = MonthlySummary{Month: fmt.Sprintf("2024-%02d", i+1), TransactionCount: 1}
+	}
+	return months
+}
+
+func TestBuildHTMLBodyShowsMostRecentMonths(t *testing.T) {
+	setVar(t, &maxMonths, 2)
+	setVar(t, &statementURL, "")
+
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: monthsOf(4)})
+	for _, month := range []string{"2024-01", "2024-02"} {
+		if strings.Contains(body, month) {
+			t.Errorf("body lists %s, want only the 2 most recent months", month)
+		}
+	}
+	for _, want := range []string{"2024-03", "2024-04", "Showing the most recent 2 of 4 months.", "View your full statement"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q", want)
+		}
+	}
+}
+
+func TestBuildHTMLBodyLinksFullStatement(t *testing.T) {
+	setVar(t, &maxMonths, 1)
+	setVar(t, &statementURL, "https://example.com/statement?a=1&b=2")
+
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: monthsOf(2)})
+	if want := `<a href="https://example.com/statement?a=1&amp;b=2">View full statement</a>`; !strings.Contains(body, want) {
+		t.Errorf("body is missing %s", want)
+	}
+}
+
+func TestBuildHTMLBodyWithinMonthCapHasNoNote(t *testing.T) {
+	setVar(t, &maxMonths, 4)
+
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: monthsOf(4)})
+	if strings.Contains(body, "Showing the most recent") || !strings.Contains(body, "2024-01") {
+		t.Errorf("body truncated %d months under a cap of %d", 4, maxMonths)
+	}
+}
+
+func TestBuildHTMLBodyMarksAbsentAverages(t *testing.T) {
+	setVar(t, &absentAmountLabel, "n/a")
+	zero, debit := 0.0, -15.0
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: []MonthlySummary{
+		{Month: "January", TransactionCount: 2, AverageDebit: &debit},
+		{Month: "February", TransactionCount: 1, AverageCredit: &zero},
+	}})
+	for _, want := range []string{
+		"Average " + creditLabel + " amount: n/a, Average " + debitLabel + " amount: -15.00",
+		"Average " + creditLabel + " amount: 0.00, Average " + debitLabel + " amount: n/a",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestBuildHTMLBodyShowsSectionPerCurrency(t *testing.T) {
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", Currencies: []CurrencyBreakdown{
+		{Currency: "EUR", TotalBalance: 70, MonthlySummaries: monthsOf(2)},
+		{Currency: "USD", TotalBalance: 20.5, MonthlySummaries: monthsOf(1)},
+	}})
+	eur := strings.Index(body, "<h2>EUR</h2><p><strong>Total Balance:</strong> 70.00</p>")
+	usd := strings.Index(body, "<h2>USD</h2><p><strong>Total Balance:</strong> 20.50</p>")
+	if eur < 0 || usd < eur {
+		t.Errorf("body does not show EUR then USD with their own balances:\n%s", body)
+	}
+}
+
+func TestFormatMonthOverMonth(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		m    MonthlySummary
+		want string
+	}{
+		{"older payload", MonthlySummary{}, ""},
+		{"first month", MonthlySummary{Net: f(100)}, ", Net: 100.00"},
+		{"increase", MonthlySummary{Net: f(150), PrevMonthNet: f(100), ChangePercent: f(50)}, ", Net: 150.00 (+50.00, +50.0% vs previous month)"},
+		{"decrease", MonthlySummary{Net: f(-20), PrevMonthNet: f(150), ChangePercent: f(-113.33)}, ", Net: -20.00 (-170.00, -113.3% vs previous month)"},
+		{"from zero", MonthlySummary{Net: f(40), PrevMonthNet: f(0)}, ", Net: 40.00 (+40.00 vs previous month)"},
+	}
+	for _, tt := range tests {
+		if got := formatMonthOverMonth(tt.m); got != tt.want {
+			t.Errorf("%s: formatMonthOverMonth() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGreetingHTML(t *testing.T) {
+	tests := []struct {
+		summary AccountSummary
+		want    string
+	}{
+		{AccountSummary{Email: "jane@example.com", Name: "Jane Doe"}, "<p>Hi Jane Doe,</p>"},
+		{AccountSummary{Email: "john.smith@example.com", Name: "  "}, "<p>Hi john.smith,</p>"},
+		{AccountSummary{Email: "eve@example.com", Name: "<b>Eve</b> & co"}, "<p>Hi &lt;b&gt;Eve&lt;/b&gt; &amp; co,</p>"},
+	}
+	for _, tt := range tests {
+		if got := greetingHTML(tt.summary); got != tt.want {
+			t.Errorf("greetingHTML(%q, %q) = %s, want %s", tt.summary.Email, tt.summary.Name, got, tt.want)
+		}
+	}
+	if body := buildHTMLBody(AccountSummary{Email: "jane@example.com", Name: "Jane"}); !strings.Contains(body, "<p>Hi Jane,</p>") {
+		t.Errorf("body is missing the greeting:\n%s", body)
+	}
+}
+
+func TestBuildHTMLBodyShowsMonthlyExtremes(t *testing.T) {
+	credit, debit := 250.5, -99.25
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: []MonthlySummary{
+		{Month: "January", TransactionCount: 5, MaxCredit: &credit, MaxDebit: &debit},
+		{Month: "February", TransactionCount: 1, MaxCredit: &credit},
+	}})
+	for _, want := range []string{"Largest credit: 250.50, Largest debit: -99.25", "Largest credit: 250.50, Largest debit: " + absentAmountLabel} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestBuildHTMLBodyItemizesTransactions(t *testing.T) {
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", Transactions: []Transaction{
+		{Date: "2024-01-05", Amount: 10},
+		{Date: "2024-01-09", Amount: -5.5, Currency: "EUR"},
+	}})
+	for _, want := range []string{
+		`<h2>Transactions:</h2>`,
+		`<tr><td>2024-01-05</td><td align="right">10.00</td></tr>`,
+		`<tr><td>2024-01-09</td><td align="right">-5.50 EUR</td></tr>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %s:\n%s", want, body)
+		}
+	}
+
+	if body := buildHTMLBody(AccountSummary{Email: "jane@example.com"}); strings.Contains(body, "Transactions:") {
+		t.Errorf("body of an aggregate-only summary has a transaction table:\n%s", body)
+	}
+}
+
+func TestTemplateFor(t *testing.T) {
+	setVar(t, &tierTemplates, map[string]string{"premium": templatePremium, "gold": templatePremium, "basic": templateStandard})
+	tests := []struct {
+		summary AccountSummary
+		want    string
+	}{
+		{AccountSummary{}, templateStandard},
+		{AccountSummary{Tier: "Premium "}, templatePremium},
+		{AccountSummary{Tier: "gold"}, templatePremium},
+		{AccountSummary{Tier: "basic"}, templateStandard},
+		{AccountSummary{Tier: "unknown"}, templateStandard},
+		{AccountSummary{Template: templatePremium}, templatePremium},
+		{AccountSummary{Template: templateStandard, Tier: "premium"}, templateStandard},
+		{AccountSummary{Template: "holiday"}, templateStandard},
+	}
+	for _, tt := range tests {
+		if got := templateFor(tt.summary); got != tt.want {
+			t.Errorf("templateFor(tier %q, template %q) = %q, want %q", tt.summary.Tier, tt.summary.Template, got, tt.want)
+		}
+	}
+}
+
+func TestBuildHTMLBodyRendersPremiumTemplate(t *testing.T) {
+	setVar(t, &tierTemplates, map[string]string{"premium": templatePremium})
+	setVar(t, &brandName, "Acme")
+	setVar(t, &brandColor, "#c9a227")
+	summary := AccountSummary{Email: "jane@example.com", Tier: "premium", TotalBalance: 10, Transactions: []Transaction{{Date: "2024-01-05", Amount: 10}}}
+
+	body := buildHTMLBody(summary)
+	for _, want := range []string{
+		`border-top:6px solid #c9a227;`,
+		`Your Premium Account Summary`,
+		`Thank you for being a premium Acme customer.`,
+		`<tr><td>2024-01-05</td><td align="right">10.00</td></tr>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("premium body is missing %s:\n%s", want, body)
+		}
+	}
+
+	summary.Tier = "basic"
+	if body := buildHTMLBody(summary); strings.Contains(body, "Premium") || body != buildStandardHTMLBody(summary) {
+		t.Errorf("basic tier body is not the standard template:\n%s", body)
+	}
+}
+
+func TestEnvTierTemplates(t *testing.T) {
+	t.Setenv("TEST_TIER_TEMPLATES", " Premium=premium, gold = premium ,,basic=standard")
+	want := map[string]string{"premium": templatePremium, "gold": templatePremium, "basic": templateStandard}
+	if got := envTierTemplates("TEST_TIER_TEMPLATES"); !reflect.DeepEqual(got, want) {
+		t.Errorf("envTierTemplates() = %v, want %v", got, want)
+	}
+	t.Setenv("TEST_TIER_TEMPLATES", "")
+	if got := envTierTemplates("TEST_TIER_TEMPLATES"); got != nil {
+		t.Errorf("envTierTemplates() = %v, want nil when unset", got)
+	}
+}
+
+func TestBuildHTMLBodyUsesConfiguredAmountLabels(t *testing.T) {
+	setVar(t, &creditLabel, "deposit")
+	setVar(t, &debitLabel, "withdrawal & fees")
+
+	credit, debit := 10.0, -5.0
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", MonthlySummaries: []MonthlySummary{
+		{Month: "January", TransactionCount: 2, AverageCredit: &credit, AverageDebit: &debit, MaxCredit: &credit, MaxDebit: &debit},
+	}})
+	for _, want := range []string{
+		"Average deposit amount: 10.00",
+		"Average withdrawal &amp; fees amount: -5.00",
+		"Largest deposit: 10.00",
+		"Largest withdrawal &amp; fees: -5.00",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("body is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Average credit") || strings.Contains(body, "Average debit") {
+		t.Errorf("body still uses the default labels:\n%s", body)
+	}
+}
+
+func TestBuildHTMLBodyHighlightsLargeTransactions(t *testing.T) {
+	body := buildHTMLBody(AccountSummary{
+		Email: "jane@example.com",
+		MonthlySummaries: []MonthlySummary{
+			{Month: "January", TransactionCount: 1, HasLargeTransaction: true},
+			{Month: "February", TransactionCount: 1},
+		},
+		Transactions: []Transaction{
+			{Date: "2024-01-05", Amount: 1500, Large: true},
+			{Date: "2024-02-01", Amount: -20},
+		},
+	})
+	if strings.Count(body, "(large transaction)") != 1 || !strings.Contains(body, `Largest debit: n/a <strong style="color:#c0392b;">(large transaction)</strong></li><li><strong>February`) {
+		t.Errorf("body does not flag exactly the January month:\n%s", body)
+	}
+	if !strings.Contains(body, `<tr style="background:#fdecea;font-weight:bold;"><td>2024-01-05</td>`) {
+		t.Errorf("body does not highlight the large transaction row:\n%s", body)
+	}
+	if !strings.Contains(body, `<tr><td>2024-02-01</td>`) {
+		t.Errorf("body highlights an ordinary transaction row:\n%s", body)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{10.004, "10.00"},
+		{30.012, "30.01"},
+		{-2.5, "-2.50"},
+		{-0.004, "0.00"},
+		{0, "0.00"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.in); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBuildHTMLBodyShowsRoundedTotalOfUnroundedAmounts(t *testing.T) {
+	net := 10.004
+	months := []MonthlySummary{
+		{Month: "January", TransactionCount: 1, Net: &net},
+		{Month: "February", TransactionCount: 1, Net: &net},
+		{Month: "March", TransactionCount: 1, Net: &net},
+	}
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com", TotalBalance: 3 * net, MonthlySummaries: months})
+	if strings.Count(body, "Net: 10.00") != 3 {
+		t.Errorf("body does not show each month's net as 10.00:\n%s", body)
+	}
+	// The rounded months add up to 30.00; the true total rounds to 30.01
+	if !strings.Contains(body, "<strong>Total Balance:</strong> 30.01") {
+		t.Errorf("body does not show the rounded true total 30.01:\n%s", body)
+	}
+}
+
+func TestBuildHTMLBodyRoundingNote(t *testing.T) {
+	setVar(t, &roundingNote, "Amounts are rounded & may not add up.")
+	body := buildHTMLBody(AccountSummary{Email: "jane@example.com"})
+	if want := `<p style="font-size:12px;color:#666666;"><em>Amounts are rounded &amp; may not add up.</em></p>`; !strings.Contains(body, want) {
+		t.Errorf("body is missing the rounding note %s:\n%s", want, body)
+	}
+
+	roundingNote = ""
+	if body := buildHTMLBody(AccountSummary{Email: "jane@example.com"}); strings.Contains(body, "font-size:12px") {
+		t.Errorf("body has a rounding note when none is configured:\n%s", body)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// retryQueueAPI is the subset of the SQS client used to consume the retry queue.
+type retryQueueAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// retryQueueClient is nil unless EMAIL_RETRY_QUEUE_URL is configured.
+var retryQueueClient retryQueueAPI
+
+// maxVisibilityTimeout is the longest visibility timeout SQS allows (12 hours).
+const maxVisibilityTimeout = 12 * time.Hour
+
+// handleRetryQueue resends the emails queued on the retry queue. A message that
+// fails transiently is reported as a batch item failure and hidden for an
+// exponentially growing backoff; one that fails permanently, cannot be decoded or
+// has been received more than retryMaxAttempts times is moved to the dead-letter
+// queue, so poison messages never loop forever.
+func handleRetryQueue(ctx context.Context, event events.SQSEvent) (Result, error) {
+	var result Result
+	for i, record := range event.Records {
+		// Messages not reached before the deadline are redelivered untouched
+		if err := outOfTime(ctx); err != nil {
+			log.Printf("Stopping before %d remaining retry messages: %v", len(event.Records)-i, err)
+			for _, rest := range event.Records[i:] {
+				result.BatchItemFailures = append(result.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: rest.MessageId})
+			}
+			break
+		}
+		receives, _ := strconv.Atoi(record.Attributes["ApproximateReceiveCount"])
+
+		var msg EmailMessage
+		if err := json.Unmarshal([]byte(record.Body), &msg); err != nil {
+			deadLetter(ctx, record, fmt.Sprintf("undecodable message: %v", err), &result)
+			continue
+		}
+		if receives > retryMaxAttempts {
+			deadLetter(ctx, record, fmt.Sprintf("gave up after %d attempts", retryMaxAttempts), &result)
+			continue
+		}
+
+		attempted, err := deliver(ctx, msg)
+		switch {
+		case !attempted && err == nil:
+			log.Printf("Retry of email to %s skipped: already sent (or possibly sent) for %s", maskEmail(msg.To), msg.Period)
+			result.AlreadySent = append(result.AlreadySent, msg.To)
+		case err == nil:
+			log.Printf("Retried email successfully sent to %s", maskEmail(msg.To))
+			result.Sent = append(result.Sent, msg.To)
+		case errors.Is(err, ErrTransient) && !errors.Is(err, errAmbiguousSend):
+			log.Printf("Retry %d of email to %s failed, will retry: %v", receives, maskEmail(msg.To), err)
+			delayRetry(ctx, record, receives)
+			result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error(), Retryable: true})
+			result.BatchItemFailures = append(result.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		default:
+			// Permanent, or possibly already sent: retrying cannot help
+			deadLetter(ctx, record, err.Error(), &result)
+			result.Failed = append(result.Failed, Failure{Email: msg.To, Error: err.Error()})
+		}
+	}
+	return result, nil
+}
+
+// delayRetry hides a failed message for retryBackoff doubled per receive, capped at
+// the SQS maximum. On failure the queue's own visibility timeout applies.
+func delayRetry(ctx context.Context, record events.SQSMessage, receives int) {
+	delay := retryBackoff << max(receives-1, 0)
+	if delay <= 0 || delay > maxVisibilityTimeout {
+		delay = maxVisibilityTimeout
+	}
+	_, err := retryQueueClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(retryQueueURL),
+		ReceiptHandle:     aws.String(record.ReceiptHandle),
+		VisibilityTimeout: int32(delay / time.Second),
+	})
+	if err != nil {
+		log.Printf("Warning: could not delay retry of message %s: %v", record.MessageId, err)
+	}
+}
+
+// deadLetter moves a message to the dead-letter queue. Without one configured the
+// message is dropped with an error log. If the move fails, the message is reported as
+// a batch item failure so it stays on the retry queue instead of being lost.
+func deadLetter(ctx context.Context, record events.SQSMessage, reason string, result *Result) {
+	if retryDLQURL == "" {
+		log.Printf("ERROR: dropping retry message %s (%s); no EMAIL_RETRY_DLQ_URL configured", record.MessageId, reason)
+		return
+	}
+	_, err := retryQueueClient.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(retryDLQURL),
+		MessageBody: aws.String(record.Body),
+		MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+			"reason": {DataType: aws.String("String"), StringValue: aws.String(reason)},
+		},
+	})
+	if err != nil {
+		log.Printf("Error dead-lettering message %s: %v", record.MessageId, err)
+		result.BatchItemFailures = append(result.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		return
+	}
+	log.Printf("Moved retry message %s to the dead-letter queue: %s", record.MessageId, reason)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// fakeRetryQueue is a retryQueueAPI that records dead-lettered messages and
+// visibility changes. SendMessage fails with sendErr when it is set.
+type fakeRetryQueue struct {
+	sent       []*sqs.SendMessageInput
+	visibility []*sqs.ChangeMessageVisibilityInput
+	sendErr    error
+}
+
+func (f *fakeRetryQueue) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, in)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeRetryQueue) ChangeMessageVisibility(ctx context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.visibility = append(f.visibility, in)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// useRetryQueue configures the retry queue and its dead-letter queue for the test.
+func useRetryQueue(t *testing.T) *fakeRetryQueue {
+	t.Helper()
+	q := &fakeRetryQueue{}
+	setVar[retryQueueAPI](t, &retryQueueClient, q)
+	setVar(t, &retryQueueURL, "https://sqs.us-east-1.amazonaws.com/123456789012/retry")
+	setVar(t, &retryDLQURL, "https://sqs.us-east-1.amazonaws.com/123456789012/retry-dlq")
+	return q
+}
+
+// retryMessage returns a retry queue message for jane@example.com received receives times.
+func retryMessage(t *testing.T, receives int) events.SQSMessage {
+	t.Helper()
+	return events.SQSMessage{
+		MessageId:     "msg-1",
+		ReceiptHandle: "receipt-1",
+		Body:          string(mustJSON(t, EmailMessage{To: "jane@example.com", Subject: "Summary", Period: "2024-03"})),
+		Attributes:    map[string]string{"ApproximateReceiveCount": strconv.Itoa(receives)},
+	}
+}
+
+func TestHandleRetryQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	setVar(t, &retryMaxAttempts, 3)
+	q := useRetryQueue(t)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	result, err := handleRetryQueue(context.Background(), events.SQSEvent{Records: []events.SQSMessage{retryMessage(t, 4)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.sent) != 0 {
+		t.Errorf("sent %d emails, want none after the attempts are exhausted", len(s.sent))
+	}
+	if len(q.sent) != 1 || aws.ToString(q.sent[0].QueueUrl) != retryDLQURL {
+		t.Fatalf("SendMessage inputs = %v, want the message on the dead-letter queue", q.sent)
+	}
+	if reason := aws.ToString(q.sent[0].MessageAttributes["reason"].StringValue); reason != "gave up after 3 attempts" {
+		t.Errorf("reason = %q", reason)
+	}
+	if len(result.BatchItemFailures) != 0 {
+		t.Errorf("batch item failures = %v, want the message removed from the retry queue", result.BatchItemFailures)
+	}
+}
+
+func TestHandleRetryQueueDelaysTransientFailure(t *testing.T) {
+	setVar(t, &retryMaxAttempts, 5)
+	setVar(t, &retryBackoff, time.Minute)
+	q := useRetryQueue(t)
+	useSender(t, &fakeSender{send: func(EmailMessage) error { return classify(ErrTransient, errors.New("throttled")) }})
+
+	result, err := handleRetryQueue(context.Background(), events.SQSEvent{Records: []events.SQSMessage{retryMessage(t, 3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BatchItemFailures) != 1 || result.BatchItemFailures[0].ItemIdentifier != "msg-1" {
+		t.Errorf("batch item failures = %v, want msg-1 left on the queue", result.BatchItemFailures)
+	}
+	if len(q.visibility) != 1 || q.visibility[0].VisibilityTimeout != 240 || aws.ToString(q.visibility[0].ReceiptHandle) != "receipt-1" {
+		t.Errorf("visibility changes = %v, want msg-1 hidden for 4 minutes", q.visibility)
+	}
+	if len(q.sent) != 0 {
+		t.Errorf("dead-lettered %d messages, want none", len(q.sent))
+	}
+}
+
+func TestHandleRetryQueueDeadLettersPermanentFailure(t *testing.T) {
+	q := useRetryQueue(t)
+	useSender(t, &fakeSender{send: func(EmailMessage) error { return classify(ErrFatal, errors.New("message rejected")) }})
+
+	result, err := handleRetryQueue(context.Background(), events.SQSEvent{Records: []events.SQSMessage{retryMessage(t, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.sent) != 1 || len(result.BatchItemFailures) != 0 || len(result.Failed) != 1 {
+		t.Errorf("dead-lettered %d, result = %+v, want the rejected message dead-lettered", len(q.sent), result)
+	}
+}
+
+func TestHandleRetryQueueKeepsMessageWhenDeadLetteringFails(t *testing.T) {
+	setVar(t, &retryMaxAttempts, 1)
+	q := useRetryQueue(t)
+	q.sendErr = errors.New("access denied")
+	useSender(t, &fakeSender{})
+
+	result, err := handleRetryQueue(context.Background(), events.SQSEvent{Records: []events.SQSMessage{retryMessage(t, 2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.BatchItemFailures) != 1 {
+		t.Errorf("batch item failures = %v, want the message kept on the retry queue", result.BatchItemFailures)
+	}
+}
+
+func TestHandleRetryQueueSendsQueuedEmail(t *testing.T) {
+	useRetryQueue(t)
+	s := &fakeSender{}
+	useSender(t, s)
+
+	result, err := handleRetryQueue(context.Background(), events.SQSEvent{Records: []events.SQSMessage{retryMessage(t, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sent) != 1 || len(s.sent) != 1 || s.sent[0].To != "jane@example.com" {
+		t.Errorf("result = %+v, want the queued email sent", result)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// sesIdentityAPI is the subset of the SES client used by the sandbox preflight.
+type sesIdentityAPI interface {
+	GetIdentityVerificationAttributes(ctx context.Context, params *ses.GetIdentityVerificationAttributesInput, optFns ...func(*ses.Options)) (*ses.GetIdentityVerificationAttributesOutput, error)
+}
+
+// identityClient is nil unless SES_SANDBOX is enabled.
+var identityClient sesIdentityAPI
+
+// verifiedIdentities caches whether each SES identity (address or domain) is verified.
+var verifiedIdentities = struct {
+	sync.Mutex
+	verified  map[string]bool
+	checkedAt map[string]time.Time
+}{verified: make(map[string]bool), checkedAt: make(map[string]time.Time)}
+
+// sandboxPreflight reports whether SES will accept email to recipient. Outside the
+// sandbox every recipient is accepted; in the sandbox the address, or its domain,
+// must be a verified identity, since SES otherwise rejects the send.
+func sandboxPreflight(ctx context.Context, recipient string) (bool, error) {
+	if identityClient == nil {
+		return true, nil
+	}
+
+	identities := []string{strings.ToLower(recipient)}
+	if at := strings.LastIndex(recipient, "@"); at >= 0 {
+		identities = append(identities, strings.ToLower(recipient[at+1:]))
+	}
+	if err := refreshIdentities(ctx, identities); err != nil {
+		return false, err
+	}
+
+	verifiedIdentities.Lock()
+	defer verifiedIdentities.Unlock()
+	for _, id := range identities {
+		if verifiedIdentities.verified[id] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// refreshIdentities looks up the identities whose cached status is missing or older
+// than sesIdentityCacheTTL.
+func refreshIdentities(ctx context.Context, identities []string) error {
+	now := clock()
+	var stale []string
+	verifiedIdentities.Lock()
+	for _, id := range identities {
+		if t, ok := verifiedIdentities.checkedAt[id]; !ok || now.Sub(t) > sesIdentityCacheTTL {
+			stale = append(stale, id)
+		}
+	}
+	verifiedIdentities.Unlock()
+	if len(stale) == 0 {
+		return nil
+	}
+
+	out, err := identityClient.GetIdentityVerificationAttributes(ctx, &ses.GetIdentityVerificationAttributesInput{
+		Identities: stale,
+	})
+	if err != nil {
+		return classifySendError(fmt.Errorf("error checking SES identities: %w", err))
+	}
+
+	verifiedIdentities.Lock()
+	defer verifiedIdentities.Unlock()
+	for _, id := range stale {
+		attrs, ok := out.VerificationAttributes[id]
+		verifiedIdentities.verified[id] = ok && attrs.VerificationStatus == types.VerificationStatusSuccess
+		verifiedIdentities.checkedAt[id] = now
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// fakeIdentities is an sesIdentityAPI that reports the identities in verified as
+// verified and records the identities it is asked about.
+type fakeIdentities struct {
+	verified  map[string]bool
+	requested [][]string
+}
+
+func (f *fakeIdentities) GetIdentityVerificationAttributes(ctx context.Context, in *ses.GetIdentityVerificationAttributesInput, _ ...func(*ses.Options)) (*ses.GetIdentityVerificationAttributesOutput, error) {
+	f.requested = append(f.requested, in.Identities)
+	attrs := make(map[string]types.IdentityVerificationAttributes)
+	for _, id := range in.Identities {
+		status := types.VerificationStatusPending
+		if f.verified[id] {
+			status = types.VerificationStatusSuccess
+		}
+		attrs[id] = types.IdentityVerificationAttributes{VerificationStatus: status}
+	}
+	return &ses.GetIdentityVerificationAttributesOutput{VerificationAttributes: attrs}, nil
+}
+
+// useIdentities enables the sandbox preflight with client and an empty cache.
+func useIdentities(t *testing.T, client sesIdentityAPI) {
+	t.Helper()
+	setVar(t, &identityClient, client)
+	reset := func() {
+		verifiedIdentities.Lock()
+		verifiedIdentities.verified = make(map[string]bool)
+		verifiedIdentities.checkedAt = make(map[string]time.Time)
+		verifiedIdentities.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestHandlerSkipsUnverifiedRecipientsInSandbox(t *testing.T) {
+	useIdentities(t, &fakeIdentities{verified: map[string]bool{"jane@example.com": true, "verified.org": true}})
+	s := &fakeSender{}
+	useSender(t, s)
+
+	event := Event{Summaries: []AccountSummary{
+		{Email: "jane@example.com"},
+		{Email: "Bob@Verified.org"},
+		{Email: "john@example.com"},
+	}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"jane@example.com", "Bob@Verified.org"}; !slices.Equal(result.Sent, want) {
+		t.Errorf("sent %v, want %v", result.Sent, want)
+	}
+	if !slices.Equal(result.Skipped, []string{"john@example.com"}) || len(s.sent) != 2 {
+		t.Errorf("skipped %v after %d sends, want john@example.com skipped before sending", result.Skipped, len(s.sent))
+	}
+}
+
+func TestSandboxPreflightCachesIdentities(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	setVar(t, &clock, func() time.Time { return now })
+	setVar(t, &sesIdentityCacheTTL, 10*time.Minute)
+	client := &fakeIdentities{verified: map[string]bool{"example.com": true}}
+	useIdentities(t, client)
+
+	for _, email := range []string{"jane@example.com", "john@example.com"} {
+		if ok, err := sandboxPreflight(context.Background(), email); !ok || err != nil {
+			t.Fatalf("sandboxPreflight(%s) = %v, %v, want verified by its domain", email, ok, err)
+		}
+	}
+	// The domain is cached; only john's address was looked up the second time
+	if len(client.requested) != 2 || !slices.Equal(client.requested[1], []string{"john@example.com"}) {
+		t.Errorf("requested %v, want the cached domain not looked up again", client.requested)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if _, err := sandboxPreflight(context.Background(), "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.requested) != 3 || len(client.requested[2]) != 2 {
+		t.Errorf("requested %v, want both identities refreshed after the TTL", client.requested)
+	}
+}
+
+func TestSandboxPreflightOutsideSandbox(t *testing.T) {
+	setVar(t, &identityClient, nil)
+	if ok, err := sandboxPreflight(context.Background(), "anyone@example.com"); !ok || err != nil {
+		t.Errorf("sandboxPreflight() = %v, %v, want every recipient accepted", ok, err)
+	}
+}
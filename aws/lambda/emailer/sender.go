@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// EmailMessage is a rendered email ready to be delivered.
+type EmailMessage struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	// Period is the month ("2006-01") a per-account summary email covers; it keys
+	// the send marker. Digest emails have no period and are not marked.
+	Period string `json:"period,omitempty"`
+}
+
+// EmailSender delivers a rendered email.
+type EmailSender interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// sesSendAPI is the subset of the SES client used by sesSender.
+type sesSendAPI interface {
+	SendEmail(ctx context.Context, params *ses.SendEmailInput, optFns ...func(*ses.Options)) (*ses.SendEmailOutput, error)
+}
+
+// sesSender delivers email through Amazon SES. The optional ARNs authorize
+// sending through identities owned by another account.
+type sesSender struct {
+	client        sesSendAPI
+	sourceARN     string
+	returnPathARN string
+}
+
+// Send builds an SES SendEmailInput from msg and sends it.
+func (s *sesSender) Send(ctx context.Context, msg EmailMessage) error {
+	input := &ses.SendEmailInput{
+		Source: aws.String(msg.From),
+		Destination: &types.Destination{
+			ToAddresses: []string{msg.To},
+		},
+		Message: &types.Message{
+			Subject: &types.Content{
+				Data: aws.String(msg.Subject),
+			},
+			Body: &types.Body{
+				Html: &types.Content{
+					Data: aws.String(msg.HTML),
+				},
+			},
+		},
+	}
+	if s.sourceARN != "" {
+		input.SourceArn = aws.String(s.sourceARN)
+	}
+	if s.returnPathARN != "" {
+		input.ReturnPathArn = aws.String(s.returnPathARN)
+	}
+	_, err := s.client.SendEmail(ctx, input)
+	return err
+}
+
+// sendWithTimeout sends msg, abandoning the attempt once sendTimeout elapses even if the
+// sender does not honor cancellation. The returned error is classified; a timeout is transient.
+func sendWithTimeout(ctx context.Context, msg EmailMessage) error {
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- sender.Send(sendCtx, msg) }()
+
+	select {
+	case err := <-done:
+		if err != nil && sendCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return classify(ErrTransient, fmt.Errorf("send timed out after %s: %w: %w", sendTimeout, errAmbiguousSend, err))
+		}
+		if err != nil {
+			return classifySendError(err)
+		}
+		return nil
+	case <-sendCtx.Done():
+		if ctx.Err() == nil {
+			return classify(ErrTransient, fmt.Errorf("send abandoned after %s: %w: %w", sendTimeout, errAmbiguousSend, sendCtx.Err()))
+		}
+		return classify(ErrTransient, fmt.Errorf("%w: %w", errAmbiguousSend, ctx.Err()))
+	}
+}
+
+// errAmbiguousSend marks a send that was abandoned before SES answered, so the
+// email may or may not have been accepted. Such sends are never retried in-process.
+var errAmbiguousSend = errors.New("send outcome unknown")
+
+// sendWithRetries sends msg, retrying transient failures up to sendRetries times.
+// A send whose outcome is unknown is not retried, since SES may already have it.
+func sendWithRetries(ctx context.Context, msg EmailMessage) error {
+	backoff := sendRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := sendWithTimeout(ctx, msg)
+		if err == nil || attempt >= sendRetries || !errors.Is(err, ErrTransient) || errors.Is(err, errAmbiguousSend) {
+			return err
+		}
+		log.Printf("Send to %s failed (attempt %d/%d), retrying in %s: %v", maskEmail(msg.To), attempt+1, sendRetries+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// deliver sends msg at most once per (recipient, period) when send markers are
+// enabled. It reports false, without error, when a marker shows the email was
+// already sent, and errSendPending while an ambiguous send's marker has not
+// expired. The marker is released only when the failure certainly sent nothing,
+// so a later retry can try again; otherwise it stays pending until
+// EMAIL_SEND_MARKER_PENDING_TTL passes.
+func deliver(ctx context.Context, msg EmailMessage) (bool, error) {
+	if sendMarkers == nil || msg.Period == "" {
+		return true, sendWithRetries(ctx, msg)
+	}
+
+	claimed, err := sendMarkers.Claim(ctx, msg.To, msg.Period)
+	if err != nil {
+		return false, err
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	if err := sendWithRetries(ctx, msg); err != nil {
+		if !errors.Is(err, errAmbiguousSend) {
+			if relErr := sendMarkers.Release(ctx, msg.To, msg.Period); relErr != nil {
+				log.Printf("Warning: %v", relErr)
+			}
+		}
+		return true, err
+	}
+	if err := sendMarkers.MarkSent(ctx, msg.To, msg.Period); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+func TestSendWithTimeoutAbandonsBlockedSend(t *testing.T) {
+	setVar(t, &sendTimeout, 20*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	useSender(t, &fakeSender{send: func(EmailMessage) error {
+		<-release // ignores cancellation, like a hung connection
+		return nil
+	}})
+
+	start := time.Now()
+	err := sendWithTimeout(context.Background(), EmailMessage{To: "jane@example.com"})
+	if !errors.Is(err, ErrTransient) || !errors.Is(err, errAmbiguousSend) {
+		t.Fatalf("sendWithTimeout() = %v, want a transient ambiguous send", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sendWithTimeout() took %s, want about %s", elapsed, sendTimeout)
+	}
+}
+
+func TestHandlerRecordsTimedOutSendAsFailure(t *testing.T) {
+	setVar(t, &sendTimeout, 20*time.Millisecond)
+	useSender(t, &fakeSender{send: func(EmailMessage) error {
+		time.Sleep(time.Second)
+		return nil
+	}})
+
+	event := Event{Summaries: []AccountSummary{{Email: "jane@example.com"}}}
+	result, err := handler(context.Background(), mustJSON(t, event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sent) != 0 || len(result.Failed) != 1 {
+		t.Fatalf("result = %+v, want one failure", result)
+	}
+	if f := result.Failed[0]; f.Email != "jane@example.com" || !f.Retryable {
+		t.Errorf("failure = %+v, want a retryable failure for jane@example.com", f)
+	}
+}
+
+func TestSendWithTimeoutPassesDeadlineToSender(t *testing.T) {
+	setVar(t, &sendTimeout, time.Minute)
+	var deadline time.Time
+	useSender(t, senderFunc(func(ctx context.Context, msg EmailMessage) error {
+		deadline, _ = ctx.Deadline()
+		return nil
+	}))
+
+	if err := sendWithTimeout(context.Background(), EmailMessage{To: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(deadline) <= 0 || time.Until(deadline) > time.Minute {
+		t.Errorf("send deadline = %s, want within SES_SEND_TIMEOUT", deadline)
+	}
+}
+
+// senderFunc adapts a function to an EmailSender.
+type senderFunc func(ctx context.Context, msg EmailMessage) error
+
+func (f senderFunc) Send(ctx context.Context, msg EmailMessage) error { return f(ctx, msg) }
+
+// fakeSES is an sesSendAPI that records the emails it sends.
+type fakeSES struct {
+	inputs []*ses.SendEmailInput
+}
+
+func (f *fakeSES) SendEmail(ctx context.Context, in *ses.SendEmailInput, _ ...func(*ses.Options)) (*ses.SendEmailOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &ses.SendEmailOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+func TestSESSenderSetsCrossAccountARNs(t *testing.T) {
+	msg := EmailMessage{From: "reports@example.com", To: "jane@example.com", Subject: "Summary", HTML: "<p>hi</p>"}
+	const (
+		sourceARN     = "arn:aws:ses:us-east-1:123456789012:identity/example.com"
+		returnPathARN = "arn:aws:ses:us-east-1:123456789012:identity/bounces.example.com"
+	)
+
+	client := &fakeSES{}
+	s := &sesSender{client: client, sourceARN: sourceARN, returnPathARN: returnPathARN}
+	if err := s.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	in := client.inputs[0]
+	if aws.ToString(in.SourceArn) != sourceARN || aws.ToString(in.ReturnPathArn) != returnPathARN {
+		t.Errorf("SourceArn = %v, ReturnPathArn = %v, want the configured ARNs", aws.ToString(in.SourceArn), aws.ToString(in.ReturnPathArn))
+	}
+	if aws.ToString(in.Source) != msg.From || in.Destination.ToAddresses[0] != msg.To {
+		t.Errorf("Source = %v, To = %v, want %s to %s", aws.ToString(in.Source), in.Destination.ToAddresses, msg.From, msg.To)
+	}
+
+	client = &fakeSES{}
+	s = &sesSender{client: client}
+	if err := s.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if in := client.inputs[0]; in.SourceArn != nil || in.ReturnPathArn != nil {
+		t.Errorf("SourceArn = %v, ReturnPathArn = %v, want both omitted by default", in.SourceArn, in.ReturnPathArn)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// smtpSender delivers email through an SMTP relay, for deployments outside AWS.
+// STARTTLS is used whenever the server offers it; auth is nil when no username is set.
+type smtpSender struct {
+	host string
+	port int
+	auth smtp.Auth
+}
+
+// Send delivers msg as a single-part HTML message, the same shape SES builds.
+func (s *smtpSender) Send(ctx context.Context, msg EmailMessage) error {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return classify(ErrValidation, fmt.Errorf("invalid sender %q: %w", msg.From, err))
+	}
+	data, err := buildMIMEMessage(msg, clock())
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := client.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMIMEMessage renders msg with its headers and a quoted-printable HTML body.
+func buildMIMEMessage(msg EmailMessage, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(msg.HTML)); err != nil {
+		return nil, classify(ErrFatal, fmt.Errorf("error encoding message body: %w", err))
+	}
+	if err := qp.Close(); err != nil {
+		return nil, classify(ErrFatal, fmt.Errorf("error encoding message body: %w", err))
+	}
+	return buf.Bytes(), nil
+}
+
+// classifySMTPError classifies an SMTP reply by its code: a rejected mailbox or
+// address (550-553) is a validation error, other 5xx replies such as failed auth are
+// fatal, and 4xx replies and network failures may succeed if retried. It reports
+// false for errors that did not come from the SMTP conversation.
+func classifySMTPError(err error) (error, bool) {
+	var reply *textproto.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &reply) && reply.Code >= 550 && reply.Code <= 553:
+		return classify(ErrValidation, err), true
+	case errors.As(err, &reply) && reply.Code >= 500:
+		return classify(ErrFatal, err), true
+	case errors.As(err, &reply):
+		return classify(ErrTransient, err), true
+	case errors.As(err, &netErr):
+		return classify(ErrTransient, err), true
+	default:
+		return err, false
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal SMTP server on a loopback port that accepts every message
+// and records the envelope and the DATA it was given.
+type smtpServer struct {
+	ln       net.Listener
+	from     string
+	rcpt     []string
+	data     string
+	received chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, received: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, line)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			tp.PrintfLine("250 OK")
+			close(s.received)
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestSMTPSenderDeliversMessage(t *testing.T) {
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) })
+	srv := newSMTPServer(t)
+	s := &smtpSender{host: "127.0.0.1", port: srv.port()}
+
+	msg := EmailMessage{
+		From:    `"Stori Statements" <reports@example.com>`,
+		To:      "jane@example.com",
+		Subject: "Resumen de transacciones ñ",
+		HTML:    "<h1>Transaction Summary</h1><p>Total balance: 10.00</p>",
+	}
+	if err := s.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	select {
+	case <-srv.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server never received the message")
+	}
+
+	if srv.from != "MAIL FROM:<reports@example.com>" {
+		t.Errorf("envelope sender = %q, want reports@example.com", srv.from)
+	}
+	if len(srv.rcpt) != 1 || srv.rcpt[0] != "RCPT TO:<jane@example.com>" {
+		t.Errorf("envelope recipients = %q, want jane@example.com", srv.rcpt)
+	}
+	parsed, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(srv.data)))
+	if err != nil {
+		t.Fatalf("delivered message does not parse: %v\n%s", err, srv.data)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":         msg.From,
+		"To":           msg.To,
+		"Date":         "Fri, 01 Mar 2024 12:00:00 +0000",
+		"Content-Type": "text/html; charset=UTF-8",
+	} {
+		if got := parsed.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if subject != msg.Subject {
+		t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+	}
+	if !strings.Contains(srv.data, "<p>Total balance: 10.00</p>") {
+		t.Errorf("body is missing the HTML summary:\n%s", srv.data)
+	}
+}
+
+func TestSMTPSenderRejectsInvalidSender(t *testing.T) {
+	s := &smtpSender{host: "127.0.0.1", port: 1}
+	err := s.Send(context.Background(), EmailMessage{From: "not an address", To: "jane@example.com"})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("Send() error = %v, want ErrValidation", err)
+	}
+}
+
+func TestClassifySMTPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&textproto.Error{Code: 550, Msg: "mailbox unavailable"}, ErrValidation},
+		{&textproto.Error{Code: 535, Msg: "authentication failed"}, ErrFatal},
+		{&textproto.Error{Code: 421, Msg: "service not available"}, ErrTransient},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrTransient},
+	}
+	for _, tt := range tests {
+		got, ok := classifySMTPError(tt.err)
+		if !ok || !errors.Is(got, tt.want) {
+			t.Errorf("classifySMTPError(%v) = %v, %v, want %v", tt.err, got, ok, tt.want)
+		}
+	}
+	if _, ok := classifySMTPError(errors.New("boom")); ok {
+		t.Error("classifySMTPError() reported a plain error as an SMTP error")
+	}
+}
+
+func TestBuildMIMEMessageEncodesLongLines(t *testing.T) {
+	msg := EmailMessage{From: "reports@example.com", To: "jane@example.com", HTML: strings.Repeat("x", 200)}
+	data, err := buildMIMEMessage(msg, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 76 {
+			t.Fatalf("line of %d bytes exceeds the quoted-printable limit", len(line))
+		}
+	}
+}
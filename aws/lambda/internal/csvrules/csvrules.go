@@ -0,0 +1,167 @@
+// Package csvrules holds the per-row checks of the transactions CSV, shared by the
+// summarizer, which applies them at ingest, and the uploader's /validate route, so
+// both agree on which rows are valid.
+package csvrules
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules configure the checks of a row; the zero value is the summarizer's defaults
+// except for the key type.
+type Rules struct {
+	// IntKey requires the id to be an integer (KEY_TYPE=int); otherwise it must not be blank.
+	IntKey bool
+	// StrictAmounts accepts only plain signed decimals (AMOUNT_FORMAT=strict).
+	StrictAmounts bool
+	// RejectFutureDates rejects dates after Now (REJECT_FUTURE_DATES).
+	RejectFutureDates bool
+	Now               func() time.Time
+}
+
+// Problem is a column of a row that fails the rules.
+type Problem struct {
+	Column  string
+	Message string
+}
+
+// Check returns a Problem for each invalid column of a row. field returns the value of
+// a column and whether the feed has that column at all.
+func (r Rules) Check(field func(column string) (string, bool)) []Problem {
+	var problems []Problem
+	fail := func(column, message string) {
+		problems = append(problems, Problem{Column: column, Message: message})
+	}
+
+	id, _ := field("id")
+	if _, err := ParseKey(id, r.IntKey); err != nil {
+		if r.IntKey {
+			fail("id", "not an integer")
+		} else {
+			fail("id", "empty")
+		}
+	}
+	value, _ := field("date")
+	if date, err := ParseDate(value); err != nil {
+		fail("date", "not a date in YYYY-MM-DD format, optionally with a time of day")
+	} else if r.RejectFutureDates && date.After(r.Now()) {
+		fail("date", "in the future")
+	}
+	value, _ = field("transaction")
+	if amount := strings.TrimSpace(value); r.StrictAmounts && !StrictAmount.MatchString(amount) {
+		fail("transaction", "not a plain signed decimal amount (digits with an optional sign and decimal point)")
+	} else if f, err := strconv.ParseFloat(amount, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		fail("transaction", "not a signed decimal amount")
+	}
+	value, _ = field("email")
+	if email := strings.TrimSpace(value); email != "" && !strings.Contains(email, "@") {
+		fail("email", "not an email address")
+	}
+	if value, ok := field("currency"); ok {
+		if code := strings.TrimSpace(value); code != "" && !isCurrencyCode(code) {
+			fail("currency", "not a three-letter currency code")
+		}
+	}
+	return problems
+}
+
+// ParseKey converts the "id" field of a row into the value stored in the key column:
+// an integer when intKey is set, the trimmed text otherwise.
+func ParseKey(value string, intKey bool) (interface{}, error) {
+	if intKey {
+		return strconv.Atoi(value)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("empty key")
+	}
+	return value, nil
+}
+
+// StrictAmount matches an amount under AMOUNT_FORMAT=strict: at most one leading sign,
+// digits and an optional fractional part. Exponents, hex, inner whitespace and
+// thousands separators, all of which ParseFloat or Postgres might read differently,
+// are rejected.
+var StrictAmount = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+// NormalizeAmount rewrites an amount written with the decimal and thousands separators
+// of the feed (e.g. "+1.234,56") into the canonical form stored in the database
+// ("+1234.56"). Thousands groups must be well formed (three digits after the first
+// group); an amount that does not fit the format is returned unchanged, so validation
+// rejects it instead of storing a misread value.
+func NormalizeAmount(amount, decimalSeparator, thousandsSeparator string) string {
+	amount = strings.TrimSpace(amount)
+	if decimalSeparator == "." && thousandsSeparator == "" {
+		return amount
+	}
+
+	sign := ""
+	digits := amount
+	if strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		sign, digits = digits[:1], digits[1:]
+	}
+	intPart, fracPart, hasFrac := strings.Cut(digits, decimalSeparator)
+	if hasFrac && strings.Contains(fracPart, decimalSeparator) {
+		return amount
+	}
+
+	if thousandsSeparator != "" && strings.Contains(intPart, thousandsSeparator) {
+		groups := strings.Split(intPart, thousandsSeparator)
+		if len(groups[0]) < 1 || len(groups[0]) > 3 {
+			return amount
+		}
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return amount
+			}
+		}
+		intPart = strings.Join(groups, "")
+	}
+	if thousandsSeparator != "" && strings.Contains(fracPart, thousandsSeparator) {
+		return amount
+	}
+
+	if !hasFrac {
+		return sign + intPart
+	}
+	return sign + intPart + "." + fracPart
+}
+
+// dateLayouts are the accepted formats of the date column, most precise first.
+// Values without a zone are read as UTC.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseDate parses the date column, keeping the time of day when the feed has one.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", value)
+}
+
+// isCurrencyCode reports whether code looks like an ISO 4217 code (three ASCII letters).
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package csvrules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fields returns a lookup over a row of the default id,date,transaction,email columns.
+func fields(values ...string) func(string) (string, bool) {
+	columns := []string{"id", "date", "transaction", "email"}
+	return func(column string) (string, bool) {
+		for i, c := range columns {
+			if c == column {
+				return values[i], true
+			}
+		}
+		return "", false
+	}
+}
+
+func TestCheckReportsEveryInvalidColumn(t *testing.T) {
+	rules := Rules{IntKey: true}
+	if got := rules.Check(fields("1", "2024-01-05", "+60.5", "jane@example.com")); len(got) != 0 {
+		t.Errorf("Check(valid row) = %+v, want no problems", got)
+	}
+
+	got := rules.Check(fields("x", "2024-13-01", "ten", "jane.example.com"))
+	want := []Problem{
+		{Column: "id", Message: "not an integer"},
+		{Column: "date", Message: "not a date in YYYY-MM-DD format, optionally with a time of day"},
+		{Column: "transaction", Message: "not a signed decimal amount"},
+		{Column: "email", Message: "not an email address"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCheckAppliesConfiguredRules(t *testing.T) {
+	now := func() time.Time { return time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC) }
+	rules := Rules{StrictAmounts: true, RejectFutureDates: true, Now: now}
+	got := rules.Check(fields(" ", "2024-03-11", "1e3", ""))
+	want := []Problem{
+		{Column: "id", Message: "empty"},
+		{Column: "date", Message: "in the future"},
+		{Column: "transaction", Message: "not a plain signed decimal amount (digits with an optional sign and decimal point)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() = %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseDateKeepsTimeOfDay(t *testing.T) {
+	tests := map[string]time.Time{
+		"2024-01-05":                 time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC),
+		"2024-01-05 14:30:15":        time.Date(2024, 1, 5, 14, 30, 15, 0, time.UTC),
+		"2024-01-05T14:30:15":        time.Date(2024, 1, 5, 14, 30, 15, 0, time.UTC),
+		"2024-01-05T14:30:15.250Z":   time.Date(2024, 1, 5, 14, 30, 15, 250e6, time.UTC),
+		" 2024-01-05 14:30:15+02:00": time.Date(2024, 1, 5, 12, 30, 15, 0, time.UTC),
+	}
+	for value, want := range tests {
+		got, err := ParseDate(value)
+		if err != nil {
+			t.Errorf("ParseDate(%q) error = %v", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseDate(%q) = %v, want %v", value, got, want)
+		}
+	}
+	if _, err := ParseDate("05/01/2024"); err == nil {
+		t.Error("ParseDate(05/01/2024) succeeded, want an error")
+	}
+}
+
+func TestStrictAmount(t *testing.T) {
+	for _, valid := range []string{"+60.5", "-3", "42", "0.01"} {
+		if !StrictAmount.MatchString(valid) {
+			t.Errorf("StrictAmount rejects %q", valid)
+		}
+	}
+	for _, invalid := range []string{"+-5", "--5", "1e3", "1 000", "- 5", "0x10", "1,000", ".5", "5.", "", "+"} {
+		if StrictAmount.MatchString(invalid) {
+			t.Errorf("StrictAmount accepts %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+
+// normalizeAmount rewrites an amount written with AMOUNT_DECIMAL_SEPARATOR and
+// AMOUNT_THOUSANDS_SEPARATOR into the canonical form stored in the database.
+func normalizeAmount(amount string) string {
+	return csvrules.NormalizeAmount(amount, amountDecimalSeparator, amountThousandsSeparator)
+}
+
+// normalizeAmounts applies normalizeAmount to the transaction column of every row.
+func normalizeAmounts(rows []csvRow) {
+	i := schema.index["transaction"]
+	for _, row := range rows {
+		row.Fields[i] = normalizeAmount(row.Fields[i])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAmount(t *testing.T) {
+	tests := []struct {
+		decimal, thousands string
+		amount, want       string
+	}{
+		{".", "", "+1234.56", "+1234.56"},
+		{".", "", " -12.5 ", "-12.5"},
+		{".", ",", "+1,234.56", "+1234.56"},
+		{".", ",", "-1,234,567", "-1234567"},
+		{",", ".", "+1.234,56", "+1234.56"},
+		{",", ".", "-0,5", "-0.5"},
+		{",", "", "+1234,56", "+1234.56"},
+		{",", " ", "+1 234,56", "+1234.56"},
+		{".", "'", "+1'234.56", "+1234.56"},
+		// Amounts outside the configured format are left for validation to reject
+		{".", ",", "+12,34.56", "+12,34.56"},
+		{".", ",", "+1234,567.5", "+1234,567.5"},
+		{",", ".", "+1,234,56", "+1,234,56"},
+		{",", ".", "+1.234,5.6", "+1.234,5.6"},
+	}
+	for _, tt := range tests {
+		setVar(t, &amountDecimalSeparator, tt.decimal)
+		setVar(t, &amountThousandsSeparator, tt.thousands)
+		if got := normalizeAmount(tt.amount); got != tt.want {
+			t.Errorf("normalizeAmount(%q) with decimal %q, thousands %q = %q, want %q", tt.amount, tt.decimal, tt.thousands, got, tt.want)
+		}
+	}
+}
+
+func TestProcessFileStoresSameAmountsForUSAndEuropeanFormats(t *testing.T) {
+	stored := make(map[string][]string)
+	for _, tt := range []struct {
+		name, decimal, thousands, body string
+	}{
+		{"US", ".", ",", "id,date,transaction,email\n1,2024-01-05,\"+1,234.56\",jane@example.com\n2,2024-01-06,-0.5,jane@example.com\n"},
+		{"European", ",", ".", "id,date,transaction,email\n1,2024-01-05,\"+1.234,56\",jane@example.com\n2,2024-01-06,\"-0,5\",jane@example.com\n"},
+	} {
+		setVar(t, &amountDecimalSeparator, tt.decimal)
+		setVar(t, &amountThousandsSeparator, tt.thousands)
+		useS3(t, newFakeS3(map[string]string{"bucket/file.csv": tt.body}))
+		repo := newMemRepository()
+		useRepository(t, repo)
+		db, mock := newMockDB(t)
+		expectNewFile(mock, "bucket", "file.csv")
+		expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+		summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+		if err != nil {
+			t.Fatalf("%s: processFile() error = %v", tt.name, err)
+		}
+		if len(summaries) != 1 || summaries[0].TotalBalance != 1234.06 {
+			t.Errorf("%s: summaries = %+v, want a balance of 1234.06", tt.name, summaries)
+		}
+		for _, row := range repo.tables[defaultTransactionsTable] {
+			stored[tt.name] = append(stored[tt.name], strings.TrimSpace(schema.field(row.Fields, "transaction")))
+		}
+	}
+	if want := []string{"+1234.56", "-0.5"}; !reflect.DeepEqual(stored["US"], want) || !reflect.DeepEqual(stored["European"], want) {
+		t.Errorf("stored amounts = %v, want %v for both formats", stored, want)
+	}
+}
+
+func TestLoadConfigRejectsInvalidAmountSeparators(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"AMOUNT_DECIMAL_SEPARATOR": ";"}, "Invalid value for AMOUNT_DECIMAL_SEPARATOR"},
+		{map[string]string{"AMOUNT_THOUSANDS_SEPARATOR": "_"}, "Invalid value for AMOUNT_THOUSANDS_SEPARATOR"},
+		{map[string]string{"AMOUNT_DECIMAL_SEPARATOR": ",", "AMOUNT_THOUSANDS_SEPARATOR": ","}, "same as AMOUNT_DECIMAL_SEPARATOR"},
+	}
+	for _, tt := range tests {
+		if out := loadConfigError(t, tt.env); !strings.Contains(out, tt.want) {
+			t.Errorf("loadConfig() with %v output = %q, want %q", tt.env, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// summaryArtifactKey returns the S3 key for this run's summaries artifact:
+// <prefix>/<yyyy>/<mm>/<dd>/<run id>.json, where the run id is the Lambda request ID
+// (or a timestamp when running outside Lambda).
+func summaryArtifactKey(ctx context.Context) string {
+	now := clock().UTC()
+	runID := strconv.FormatInt(now.UnixNano(), 10)
+	if lc, ok := lambdacontext.FromContext(ctx); ok && lc.AwsRequestID != "" {
+		runID = lc.AwsRequestID
+	}
+	return path.Join(summaryArtifactPrefix, now.Format("2006/01/02"), runID+".json")
+}
+
+// writeSummaryArtifact stores the run's summaries as a JSON array in S3 when
+// SUMMARY_S3_BUCKET is configured. It is independent of the notification step.
+func writeSummaryArtifact(ctx context.Context, summaries []*AccountSummary) error {
+	if summaryArtifactBucket == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(summariesForOutput(summaries))
+	if err != nil {
+		return classify(ErrFatal, fmt.Errorf("error serializing summaries: %w", err))
+	}
+
+	key := summaryArtifactKey(ctx)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(summaryArtifactBucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return classifyAWSError(fmt.Errorf("error writing summaries to s3://%s/%s: %w", summaryArtifactBucket, key, err))
+	}
+
+	log.Printf("Summaries written to s3://%s/%s", summaryArtifactBucket, key)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestSummaryArtifactKeyUsesClock(t *testing.T) {
+	now := time.Date(2024, 3, 7, 23, 30, 0, 0, time.FixedZone("UTC-5", -5*3600))
+	setVar(t, &clock, func() time.Time { return now })
+
+	want := "summaries/2024/03/08/1709872200000000000.json"
+	if got := summaryArtifactKey(context.Background()); got != want {
+		t.Errorf("summaryArtifactKey() = %q, want %q", got, want)
+	}
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+	if got := summaryArtifactKey(ctx); got != "summaries/2024/03/08/req-1.json" {
+		t.Errorf("summaryArtifactKey() = %q, want the request ID under the UTC date", got)
+	}
+}
+
+func TestWriteSummaryArtifactStoresSummaries(t *testing.T) {
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC) })
+	setVar(t, &summaryArtifactBucket, "artifacts")
+	f := newFakeS3(nil)
+	useS3(t, f)
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+	if err := writeSummaryArtifact(ctx, []*AccountSummary{{Email: "jane@example.com", TotalBalance: 10}}); err != nil {
+		t.Fatalf("writeSummaryArtifact() error = %v", err)
+	}
+	data, ok := f.object("artifacts", "summaries/2024/03/07/req-1.json")
+	if !ok {
+		t.Fatalf("artifact not stored; objects = %v", f.objects)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0]["email"] != "jane@example.com" {
+		t.Errorf("artifact = %s, want jane@example.com's summary", data)
+	}
+}
+
+func TestSummaryArtifactDecodesToSummaries(t *testing.T) {
+	setVar(t, &summaryArtifactBucket, "artifacts")
+	f := newFakeS3(nil)
+	useS3(t, f)
+	credit, net := 30.25, 20.25
+	summaries := []*AccountSummary{
+		{Email: "jane@example.com", TotalBalance: 20.25, MonthlySummaries: []MonthlySummary{
+			{Month: "January", TransactionCount: 2, AverageCredit: &credit, Net: &net},
+		}},
+		{Email: "john@example.com", TotalBalance: -3},
+	}
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+	if err := writeSummaryArtifact(ctx, summaries); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := f.object("artifacts", summaryArtifactKey(ctx))
+	var got []*AccountSummary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, summaries) {
+		t.Errorf("artifact decodes to %s, want the summaries written", data)
+	}
+}
+
+func TestHandleS3EventNotifiesWhenArtifactFails(t *testing.T) {
+	setVar(t, &summaryArtifactBucket, "artifacts")
+	f := newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"})
+	f.put = func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		return nil, errors.New("access denied")
+	}
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "file.csv")
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", "file.csv")); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if got := n.emails(); len(got) != 1 {
+		t.Errorf("notified %v, want jane@example.com despite the artifact failure", got)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/lib/pq"
+)
+
+// ingestAuditKey is the context key of the file's ingestAudit.
+type ingestAuditKey struct{}
+
+// ingestAudit is the INGEST_AUDIT record of one file: who uploaded it and, through
+// Status, what came of it. It is written in the insert transaction when the file is
+// stored atomically, otherwise (failures, partitioned inserts) after the fact, and
+// either way is brought up to date once the event's summaries are delivered or not.
+type ingestAudit struct {
+	Principal string
+	Status    *IngestStatus
+	// id is the row committed with the file's data; 0 until then.
+	id int64
+}
+
+// withIngestAudit attaches a to ctx; a nil a stops inserts from writing the audit row.
+func withIngestAudit(ctx context.Context, a *ingestAudit) context.Context {
+	return context.WithValue(ctx, ingestAuditKey{}, a)
+}
+
+// ingestAuditFrom returns the file's audit record, or nil when INGEST_AUDIT is disabled.
+func ingestAuditFrom(ctx context.Context) *ingestAudit {
+	a, _ := ctx.Value(ingestAuditKey{}).(*ingestAudit)
+	return a
+}
+
+// sqlExecer is satisfied by *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlQueryer is satisfied by *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertAudit writes an audit row for status with the given outcome and inserted
+// row count, returning its id.
+func insertAudit(ctx context.Context, db sqlQueryer, principal string, status *IngestStatus, outcome string, inserted int) (int64, error) {
+	requestID := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = lc.AwsRequestID
+	}
+	var id int64
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO ingest_audit (bucket, object_key, principal, request_id, outcome, rows_read, rows_rejected, rows_inserted, errors)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9::text[], '{}'))
+		RETURNING id`,
+		status.Bucket, status.Key, principal, requestID, outcome,
+		status.RowsRead, status.RejectedRows, inserted, pq.Array(status.Errors)).Scan(&id)
+	return id, err
+}
+
+// writeIngestAudit records the file's final outcome, updating the row its insert
+// transaction wrote or inserting one when none did. A processed file whose
+// summaries were not delivered (undelivered is non-nil) is audited as failed, or
+// as retrying when the event will be redelivered. It is best effort, and runs even
+// if the invocation is being cancelled.
+func writeIngestAudit(ctx context.Context, db *sql.DB, a *ingestAudit, undelivered error) {
+	if a.Status == nil {
+		return
+	}
+	final := *a.Status
+	final.Errors = slices.Clone(final.Errors)
+	if undelivered != nil && final.Status == ingestProcessed {
+		final.fail(fmt.Errorf("summaries not delivered: %w", undelivered))
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	var err error
+	if a.id == 0 {
+		_, err = insertAudit(ctx, db, a.Principal, &final, final.Status, final.RowsInserted)
+	} else {
+		_, err = db.ExecContext(ctx, `
+			UPDATE ingest_audit
+			SET outcome = $2, rows_read = $3, rows_rejected = $4, rows_inserted = $5,
+			    errors = COALESCE($6::text[], '{}'), recorded_at = NOW()
+			WHERE id = $1`,
+			a.id, final.Status, final.RowsRead, final.RejectedRows, final.RowsInserted, pq.Array(final.Errors))
+	}
+	if err != nil {
+		log.Printf("Error writing ingest audit for s3://%s/%s: %v", final.Bucket, final.Key, err)
+	}
+}
+
+// writeIngestAudits records the final outcome of every audited file of the event.
+func writeIngestAudits(ctx context.Context, db *sql.DB, audits []*ingestAudit, undelivered error) {
+	for _, a := range audits {
+		writeIngestAudit(ctx, db, a, undelivered)
+	}
+}
+
+// manualReprocessPrincipal is audited for files reprocessed on request, whose
+// synthetic records carry no uploader.
+const manualReprocessPrincipal = "manual-reprocess"
+
+// uploaderPrincipal returns the principal that wrote the record's object.
+func uploaderPrincipal(record events.S3EventRecord) string {
+	if record.EventName == reprocessEventName {
+		return manualReprocessPrincipal
+	}
+	return record.PrincipalID.PrincipalID
+}
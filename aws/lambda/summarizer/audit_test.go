@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// auditRows are the rows of a file whose audit is under test.
+var auditRows = []csvRow{
+	{Line: 2, Fields: []string{"1", "2024-01-05", "+10", "jane@example.com"}},
+	{Line: 3, Fields: []string{"2", "2024-01-06", "-4", "jane@example.com"}},
+}
+
+// expectAuditRows expects auditRows to be inserted in a transaction.
+func expectAuditRows(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare("INSERT INTO transacciones")
+	prep.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WillReturnResult(sqlmock.NewResult(2, 1))
+}
+
+func TestInsertInTransactionWritesAuditWithData(t *testing.T) {
+	db, mock := newMockDB(t)
+	audit := &ingestAudit{Principal: "AWS:uploader", Status: &IngestStatus{Bucket: "bucket", Key: "file.csv", RowsRead: 3, RejectedRows: 1}}
+	expectAuditRows(mock)
+	mock.ExpectQuery("INSERT INTO ingest_audit").
+		WithArgs("bucket", "file.csv", "AWS:uploader", "", ingestProcessed, 3, 1, 2, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectCommit()
+
+	if _, err := insertInTransaction(withIngestAudit(context.Background(), audit), db, "transacciones", auditRows, ""); err != nil {
+		t.Fatalf("insertInTransaction() error = %v", err)
+	}
+	if audit.id != 7 {
+		t.Errorf("audit id = %d, want the committed row 7", audit.id)
+	}
+}
+
+func TestInsertInTransactionRollsBackDataWhenAuditFails(t *testing.T) {
+	db, mock := newMockDB(t)
+	audit := &ingestAudit{Principal: "AWS:uploader", Status: &IngestStatus{Bucket: "bucket", Key: "file.csv", RowsRead: 2}}
+	expectAuditRows(mock)
+	mock.ExpectQuery("INSERT INTO ingest_audit").WillReturnError(errors.New("relation \"ingest_audit\" does not exist"))
+	mock.ExpectRollback()
+
+	if _, err := insertInTransaction(withIngestAudit(context.Background(), audit), db, "transacciones", auditRows, ""); err == nil {
+		t.Fatal("insertInTransaction() succeeded, want the audit failure")
+	}
+	if audit.id != 0 {
+		t.Errorf("audit id = %d, want none after the rollback", audit.id)
+	}
+}
+
+func TestWriteIngestAuditRecordsFailureOutOfBand(t *testing.T) {
+	db, mock := newMockDB(t)
+	status := &IngestStatus{Bucket: "bucket", Key: "file.csv", RowsRead: 2}
+	status.fail(classify(ErrValidation, errors.New("invalid CSV header")))
+	mock.ExpectQuery("INSERT INTO ingest_audit").
+		WithArgs("bucket", "file.csv", "AWS:uploader", "", ingestFailed, 2, 0, 0, containsArg("invalid CSV header")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+
+	writeIngestAudit(context.Background(), db, &ingestAudit{Principal: "AWS:uploader", Status: status}, nil)
+}
+
+func TestWriteIngestAuditUpdatesCommittedRowWithDeliveryOutcome(t *testing.T) {
+	db, mock := newMockDB(t)
+	status := &IngestStatus{Bucket: "bucket", Key: "file.csv", Status: ingestProcessed, RowsRead: 2, RowsInserted: 2}
+	mock.ExpectExec("UPDATE ingest_audit").
+		WithArgs(int64(7), ingestRetrying, 2, 0, 2, containsArg("summaries not delivered: throttled")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	a := &ingestAudit{Status: status, id: 7}
+	writeIngestAudit(context.Background(), db, a, classify(ErrTransient, errors.New("throttled")))
+	if status.Status != ingestProcessed || len(status.Errors) != 0 {
+		t.Errorf("status = %+v, want the file's own status left as processed", status)
+	}
+}
+
+func TestHandleS3EventAuditsAfterDeliveryOutcome(t *testing.T) {
+	setVar(t, &ingestAuditEnabled, true)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"}))
+	useRepository(t, newMemRepository())
+	useNotifier(t, &fakeNotifier{err: classify(ErrTransient, errors.New("throttled"))})
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+	// The rows are stored, but the summaries never reached the emailer
+	mock.ExpectQuery("INSERT INTO ingest_audit").
+		WithArgs("bucket", "file.csv", sqlmock.AnyArg(), "", ingestRetrying, 1, 0, 1, containsArg("summaries not delivered")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", "file.csv")); !shouldRetry(err) {
+		t.Fatalf("handleS3Event() error = %v, want the retryable notify failure", err)
+	}
+}
+
+func TestUploaderPrincipal(t *testing.T) {
+	record := s3Event("bucket", "file.csv").Records[0]
+	record.PrincipalID.PrincipalID = "AWS:AIDAEXAMPLE"
+	if got := uploaderPrincipal(record); got != "AWS:AIDAEXAMPLE" {
+		t.Errorf("uploaderPrincipal() = %q, want the S3 principal", got)
+	}
+	record.EventName = reprocessEventName
+	if got := uploaderPrincipal(record); got != manualReprocessPrincipal {
+		t.Errorf("uploaderPrincipal() = %q, want %q for a reprocess", got, manualReprocessPrincipal)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// cancelOnRead is an object body that cancels the invocation's context on its first read.
+type cancelOnRead struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnRead) Read(p []byte) (int, error) {
+	c.cancel()
+	return c.r.Read(p)
+}
+
+func TestProcessCSVFileStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := "id,date,transaction,email\n1,2024-01-05,+1,jane@example.com\n2,2024-01-06,+2,jane@example.com\n"
+	f := newFakeS3(nil)
+	f.get = func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+		return &s3.GetObjectOutput{Body: io.NopCloser(&cancelOnRead{r: strings.NewReader(body), cancel: cancel})}, nil
+	}
+	useS3(t, f)
+
+	rows, err := processCSVFile(ctx, "bucket", "file.csv")
+	if !shouldRetry(err) || !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "stopped reading s3://bucket/file.csv") {
+		t.Fatalf("processCSVFile() error = %v, want a retryable stop", err)
+	}
+	if rows != nil {
+		t.Errorf("rows = %v, want none from an abandoned read", rows)
+	}
+}
+
+// cancellingRepository is a memRepository whose first summary ends the invocation.
+type cancellingRepository struct {
+	*memRepository
+	cancel context.CancelFunc
+}
+
+func (r cancellingRepository) SummaryByEmail(ctx context.Context, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	defer r.cancel()
+	return r.memRepository.SummaryByEmail(ctx, table, email, since)
+}
+
+func TestSummarizeEmailsKeepsSummariesBuiltBeforeCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := cancellingRepository{memRepository: newMemRepository(), cancel: cancel}
+	status := &IngestStatus{Bucket: "bucket", Key: "file.csv"}
+
+	emails := []string{"a@example.com", "b@example.com", "c@example.com"}
+	summaries := summarizeEmails(ctx, repo, tableRoute{Table: "transacciones"}, emails, sql.NullInt64{}, status)
+	if len(summaries) != 1 || summaries[0].Email != "a@example.com" {
+		t.Errorf("summaries = %+v, want only a@example.com", summaries)
+	}
+	if len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "stopped summarizing after 1 of 3 accounts") {
+		t.Errorf("status errors = %v, want the stop recorded", status.Errors)
+	}
+}
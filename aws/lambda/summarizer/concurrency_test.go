@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// concurrentFiles returns n objects in bucket, each with one transaction of its own
+// account, with their keys and sorted emails.
+func concurrentFiles(n int) (objects map[string]string, keys, emails []string) {
+	objects = make(map[string]string)
+	for i := range n {
+		key := fmt.Sprintf("file%d.csv", i)
+		email := fmt.Sprintf("user%d@example.com", i)
+		objects["bucket/"+key] = fmt.Sprintf("id,date,transaction,email\n%d,2024-01-0%d,+10.5,%s\n", i+1, i+1, email)
+		keys, emails = append(keys, key), append(emails, email)
+	}
+	return objects, keys, emails
+}
+
+func TestHandleS3EventProcessesRecordsConcurrently(t *testing.T) {
+	setVar(t, &recordConcurrency, 2)
+	objects, keys, want := concurrentFiles(5)
+
+	// Every download lingers so that files processed at the same time overlap
+	f := newFakeS3(objects)
+	var (
+		mu                sync.Mutex
+		inFlight, maxSeen int
+	)
+	f.get = func(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+		mu.Lock()
+		inFlight++
+		maxSeen = max(maxSeen, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		data, _ := f.object(*in.Bucket, *in.Key)
+		return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+	}
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	mock.MatchExpectationsInOrder(false)
+	for _, key := range keys {
+		expectNewFile(mock, "bucket", key)
+		expectLedgerWrite(mock, "bucket", key, ingestProcessed)
+		expectNotified(mock, "bucket", key)
+	}
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", keys...)); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if got := repo.rowCount("transacciones"); got != len(keys) {
+		t.Errorf("stored %d rows, want %d", got, len(keys))
+	}
+	if got := n.emails(); !slices.Equal(got, want) {
+		t.Errorf("notified %v, want %v", got, want)
+	}
+	if maxSeen > recordConcurrency {
+		t.Errorf("%d files processed at once, want at most %d", maxSeen, recordConcurrency)
+	}
+	if maxSeen < 2 {
+		t.Errorf("files were processed one at a time, want up to %d at once", recordConcurrency)
+	}
+}
+
+func TestHandleS3EventCollectsFileErrors(t *testing.T) {
+	setVar(t, &recordConcurrency, 3)
+	objects, keys, _ := concurrentFiles(3)
+	useS3(t, newFakeS3(objects))
+	repo := newMemRepository()
+	repo.fail = map[string]error{
+		"s3://bucket/file0.csv": classify(ErrTransient, errors.New("file0 insert failed")),
+		"s3://bucket/file2.csv": classify(ErrTransient, errors.New("file2 insert failed")),
+	}
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	mock.MatchExpectationsInOrder(false)
+	for i, key := range keys {
+		expectNewFile(mock, "bucket", key)
+		status := ingestRetrying
+		if i == 1 {
+			status = ingestProcessed
+		}
+		expectLedgerWrite(mock, "bucket", key, status)
+	}
+
+	err := handleS3Event(context.Background(), s3Event("bucket", keys...))
+	if err == nil || !shouldRetry(err) {
+		t.Fatalf("handleS3Event() error = %v, want a retryable error", err)
+	}
+	for _, msg := range []string{"file0 insert failed", "file2 insert failed"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("error %q does not report %q", err, msg)
+		}
+	}
+	if got := repo.rowCount("transacciones"); got != 1 {
+		t.Errorf("stored %d rows, want the 1 of the file that succeeded", got)
+	}
+	if len(n.payloads) != 0 {
+		t.Errorf("notified %d payloads, want none until the event is retried", len(n.payloads))
+	}
+}
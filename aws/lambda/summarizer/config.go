@@ -0,0 +1,533 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // REPORT_TIMEZONE is validated without relying on the OS zone database
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/lib/pq"
+)
+
+// Supported values for BLANK_EMAIL_POLICY.
+const (
+	// blankEmailExclude stores rows without an email but leaves them out of every summary.
+	blankEmailExclude = "exclude"
+	// blankEmailDefault attributes rows without an email to BLANK_EMAIL_ACCOUNT.
+	blankEmailDefault = "default"
+)
+
+// Supported values for AMOUNT_FORMAT.
+const (
+	amountFormatLenient = "lenient"
+	amountFormatStrict  = "strict"
+)
+
+// Supported values for STRICT_COLUMNS.
+const (
+	strictColumnsSkip = "skip"
+	strictColumnsFail = "fail"
+)
+
+var (
+	// notifySchemaVersion is the schema_version stamped on every notifier payload.
+	notifySchemaVersion int
+	// incremental restricts summaries to transactions ingested since the last successful run.
+	incremental bool
+	// logPII disables masking of email addresses in log output.
+	logPII bool
+	// csvHasHeader controls whether the first CSV line is a header to skip or a data row.
+	csvHasHeader bool
+	// schema is the column layout of ingested CSV files.
+	schema *csvSchema
+	// csvMaxLineBytes rejects files with a line longer than this; 0 disables the limit.
+	csvMaxLineBytes int
+	// blankEmailPolicy decides how rows with a blank email are summarized.
+	blankEmailPolicy string
+	// blankEmailAccount receives blank-email rows under the default policy.
+	blankEmailAccount string
+	// rejectFutureDates quarantines rows dated after the current time.
+	rejectFutureDates bool
+	// csvIgnoreTrailingBlanks drops blank records at the end of a file instead of
+	// reporting them as malformed rows.
+	csvIgnoreTrailingBlanks bool
+	// strictColumns decides whether a row with the wrong column count fails the file or is skipped.
+	strictColumns string
+	// strictFeed fails the whole file at its first invalid row instead of quarantining rows.
+	strictFeed bool
+	// ingestAuditEnabled records every processed file in the ingest_audit table.
+	ingestAuditEnabled bool
+	// columnTransforms are the COLUMN_TRANSFORMS rules applied to rows before validation.
+	columnTransforms []columnTransform
+	// amountDecimalSeparator and amountThousandsSeparator describe how the feed writes
+	// amounts; they are normalized to a "." decimal without grouping before storage.
+	amountDecimalSeparator   string
+	amountThousandsSeparator string
+	// summaryGranularity is the DATE_TRUNC unit summaries are bucketed by.
+	summaryGranularity string
+	// reportTimezone is the IANA zone transaction dates are bucketed and labeled in.
+	reportTimezone string
+	// processedAction is applied to an object once its rows are committed: none, archive
+	// (gzip under processedPrefix, deleting the original) or tag (with processedTag).
+	processedAction string
+	processedPrefix string
+	processedTag    string
+	// largeTransactionThreshold flags months and itemized transactions with an amount
+	// above it in absolute value; 0 disables it.
+	largeTransactionThreshold float64
+	// summaryAPIEnabled serves GET /summary for API Gateway requests; summaryAPIDefaultLimit
+	// and summaryAPIMaxLimit bound its pages of monthly summaries.
+	summaryAPIEnabled      bool
+	summaryAPIDefaultLimit int
+	summaryAPIMaxLimit     int
+	// summaryAPIEmailClaim is the authorizer claim holding the caller's own email.
+	summaryAPIEmailClaim string
+	// keyColumn is the database column the "id" field is stored in, and keyType
+	// whether it holds an integer or text (such as a UUID).
+	keyColumn string
+	keyType   string
+	// amountFormat decides whether transaction amounts must be plain signed decimals
+	// (strict) or anything ParseFloat accepts (lenient).
+	amountFormat string
+	// numericPrecision decides whether a balance that float64 cannot hold exactly fails
+	// the account's summary or, when explicitly allowed, is rounded with a warning.
+	numericPrecision string
+	// defaultCurrency is stored for rows whose currency column is blank.
+	defaultCurrency string
+	// recordConcurrency caps how many files of one event are processed at the same time.
+	recordConcurrency int
+	// insertConcurrency splits a file's rows into this many partitions inserted in parallel.
+	insertConcurrency int
+	// maxFileAge skips objects last modified longer ago than this; 0 disables the check.
+	maxFileAge time.Duration
+
+	// summaryTimeout bounds each account's summary queries, so one stuck query is
+	// abandoned instead of stalling the rest; 0 disables the limit.
+	summaryTimeout time.Duration
+	// eventDedupeWindow skips a repeat notification for the same object within this window; 0 disables it.
+	eventDedupeWindow time.Duration
+	// storeSourceKey records the originating s3://bucket/key on every inserted transaction.
+	storeSourceKey bool
+
+	// notifyChannel selects how summaries are delivered: lambda, sns, sqs or eventbridge.
+	notifyChannel string
+	// notifyTarget is the function name, topic ARN, queue URL or event bus for notifyChannel.
+	notifyTarget string
+
+	// eventBridgeBus, when set, also publishes every summary to this event bus.
+	eventBridgeBus string
+	// eventBridgeSource and eventBridgeDetailType label the published summary events.
+	eventBridgeSource     string
+	eventBridgeDetailType string
+	// notifyBatchSize caps the summaries per notifier payload; 0 sends them all in one.
+	notifyBatchSize int
+	// notifyMaxInFlight caps the notifier invocations sent concurrently for one run's batches.
+	notifyMaxInFlight int
+	// duplicateSummariesPolicy decides whether an email summarized from several files of
+	// one event is merged into one summary or notified once per file.
+	duplicateSummariesPolicy string
+	// flaggedNotifyTarget, when set, receives flagged summaries instead of notifyTarget.
+	flaggedNotifyTarget string
+	// txnCountThreshold flags accounts with more transactions than this, in total or in
+	// any month; 0 disables flagging.
+	txnCountThreshold int
+	// maxEmailsPerFile caps the distinct emails of one file; 0 disables the cap.
+	maxEmailsPerFile int
+	// maxEmailsPolicy decides whether an over-cap file is rejected or ingested without summaries.
+	maxEmailsPolicy string
+	// itemizeMaxTransactions lists every transaction in the summary of accounts with
+	// fewer transactions than this; 0 disables itemizing.
+	itemizeMaxTransactions int
+	// notifyDedupeTTL suppresses identical notifications within this window; 0 disables it.
+	notifyDedupeTTL time.Duration
+	// notifyPayloadEncoding is json, or gzip to compress the summaries in the payload.
+	notifyPayloadEncoding string
+	// notifySync invokes the notification Lambda synchronously and reports its send result.
+	notifySync bool
+
+	// summarySchema is the JSON schema (v1 or v2) of the S3 artifact and EventBridge events
+	// only; every other output keeps v1.
+	summarySchema string
+	// operatorEmail receives a statistics report after every run; empty disables it.
+	operatorEmail string
+	// operatorEmailFrom is the verified SES sender of the operator report.
+	operatorEmailFrom string
+	// healthBucket is probed with HeadBucket by health checks; empty skips the S3 check.
+	healthBucket string
+	// healthCheckTimeout bounds each dependency check of a health check invocation.
+	healthCheckTimeout time.Duration
+	// tableRoutes maps key prefixes to their own tables, longest prefix first.
+	tableRoutes []tableRoute
+	// persistSummaryRows stores every summary in account_summaries for the emailer's bulk mode.
+	persistSummaryRows bool
+
+	// summaryArtifactBucket and summaryArtifactPrefix locate the per-run summaries JSON;
+	// no artifact is written when the bucket is empty.
+	summaryArtifactBucket string
+	summaryArtifactPrefix string
+
+	// s3DownloadManager downloads CSV files with the S3 transfer manager instead of a single stream.
+	s3DownloadManager bool
+	// s3DownloadPartSize and s3DownloadConcurrency tune the transfer manager's ranged downloads.
+	s3DownloadPartSize    int64
+	s3DownloadConcurrency int
+	// s3ReadAttempts bounds how many times a file is fetched when its body fails mid-read.
+	s3ReadAttempts int
+
+	// metricsNamespace is the CloudWatch namespace for metrics emitted by the summarizer.
+	metricsNamespace string
+
+	// dbRetryAttempts bounds how many times a database operation is tried.
+	dbRetryAttempts int
+	// dbRetryBackoff is the initial delay between database retries; it doubles on each attempt.
+	dbRetryBackoff time.Duration
+	// dbSaturatedBackoff replaces dbRetryBackoff when Postgres reports too_many_connections.
+	dbSaturatedBackoff time.Duration
+	// retryBudgetReserve is kept free of retries at the end of each invocation.
+	retryBudgetReserve time.Duration
+	// databaseURL, when set, is the full connection string (URL or key=value form)
+	// and replaces the DB_* variables, e.g. to pass connect_timeout or application_name.
+	databaseURL string
+	// dbSSLMode is the Postgres sslmode; verify-ca and verify-full check the server certificate.
+	dbSSLMode string
+	// dbSSLRootCert is the path of the CA bundle used to verify the server certificate.
+	dbSSLRootCert string
+	// databaseReplicaURL and dbReplicaHost point the summary queries at a read replica;
+	// with neither set they run on the primary.
+	databaseReplicaURL string
+	dbReplicaHost      string
+	// replicaMaxWait is how long summaries wait for the replica to replay the file's
+	// inserts before falling back to the primary; 0 skips the check.
+	replicaMaxWait time.Duration
+	// dbMaxOpenConns caps the connections this container opens; 0 means unlimited.
+	dbMaxOpenConns int
+	// fileLock takes a Postgres advisory lock per object so concurrent containers
+	// never ingest the same file twice.
+	fileLock bool
+)
+
+// requiredEnv lists the environment variables the summarizer cannot start without
+// under the current configuration.
+func requiredEnv() []string {
+	var keys []string
+	if os.Getenv("DATABASE_URL") == "" {
+		keys = append(keys, "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME")
+	}
+	// A replica given by host borrows the other DB_* settings, even with DATABASE_URL
+	if os.Getenv("DATABASE_URL") != "" && os.Getenv("DATABASE_REPLICA_URL") == "" && os.Getenv("DB_REPLICA_HOST") != "" {
+		keys = append(keys, "DB_USER", "DB_PASSWORD", "DB_NAME")
+	}
+	if channel := os.Getenv("NOTIFY_CHANNEL"); channel != "" && channel != notifyChannelLambda {
+		keys = append(keys, "NOTIFY_TARGET")
+	}
+	if os.Getenv("BLANK_EMAIL_POLICY") == blankEmailDefault {
+		keys = append(keys, "BLANK_EMAIL_ACCOUNT")
+	}
+	return keys
+}
+
+// loadConfig validates required settings and reads optional ones from the environment,
+// applying defaults. It terminates execution if anything is missing or malformed.
+func loadConfig() {
+	if err := checkRequiredEnv(requiredEnv()...); err != nil {
+		log.Fatal(err)
+	}
+
+	notifySchemaVersion = envInt("NOTIFY_SCHEMA_VERSION", 1)
+	incremental = envBool("INCREMENTAL", false)
+	logPII = envBool("LOG_PII", false)
+	csvHasHeader = envBool("CSV_HAS_HEADER", true)
+	csvIgnoreTrailingBlanks = envBool("CSV_IGNORE_TRAILING_BLANKS", true)
+	var err error
+	schema, err = newCSVSchema(strings.Split(envString("CSV_COLUMNS", "id,date,transaction,email"), ","))
+	if err != nil {
+		log.Fatalf("Invalid value for CSV_COLUMNS: %v", err)
+	}
+	csvMaxLineBytes = envInt("CSV_MAX_LINE_BYTES", 1024*1024)
+	if csvMaxLineBytes < 0 {
+		log.Fatalf("Invalid value for CSV_MAX_LINE_BYTES: must not be negative, got %d", csvMaxLineBytes)
+	}
+	blankEmailPolicy = envString("BLANK_EMAIL_POLICY", blankEmailExclude)
+	if blankEmailPolicy != blankEmailExclude && blankEmailPolicy != blankEmailDefault {
+		log.Fatalf("Invalid value for BLANK_EMAIL_POLICY: %q", blankEmailPolicy)
+	}
+	blankEmailAccount = strings.TrimSpace(os.Getenv("BLANK_EMAIL_ACCOUNT"))
+	rejectFutureDates = envBool("REJECT_FUTURE_DATES", false)
+	strictColumns = envString("STRICT_COLUMNS", strictColumnsSkip)
+	if strictColumns != strictColumnsSkip && strictColumns != strictColumnsFail {
+		log.Fatalf("Invalid value for STRICT_COLUMNS: %q", strictColumns)
+	}
+	strictFeed = envBool("STRICT_FEED", false)
+	ingestAuditEnabled = envBool("INGEST_AUDIT", false)
+	if strictFeed {
+		// A strict feed has no partially ingested files, whatever STRICT_COLUMNS says
+		strictColumns = strictColumnsFail
+	}
+	if columnTransforms, err = parseColumnTransforms(os.Getenv("COLUMN_TRANSFORMS"), schema); err != nil {
+		log.Fatalf("Invalid value for COLUMN_TRANSFORMS: %v", err)
+	}
+	amountDecimalSeparator = envString("AMOUNT_DECIMAL_SEPARATOR", ".")
+	amountThousandsSeparator = os.Getenv("AMOUNT_THOUSANDS_SEPARATOR")
+	if amountDecimalSeparator != "." && amountDecimalSeparator != "," {
+		log.Fatalf("Invalid value for AMOUNT_DECIMAL_SEPARATOR: %q", amountDecimalSeparator)
+	}
+	switch amountThousandsSeparator {
+	case "", ".", ",", " ", "'":
+	default:
+		log.Fatalf("Invalid value for AMOUNT_THOUSANDS_SEPARATOR: %q", amountThousandsSeparator)
+	}
+	if amountThousandsSeparator == amountDecimalSeparator {
+		log.Fatalf("Invalid value for AMOUNT_THOUSANDS_SEPARATOR: same as AMOUNT_DECIMAL_SEPARATOR (%q)", amountDecimalSeparator)
+	}
+	summaryGranularity = envString("SUMMARY_GRANULARITY", granularityMonth)
+	if _, ok := periodLabels[summaryGranularity]; !ok {
+		log.Fatalf("Invalid value for SUMMARY_GRANULARITY: %q", summaryGranularity)
+	}
+	reportTimezone = envString("REPORT_TIMEZONE", "UTC")
+	if _, err := time.LoadLocation(reportTimezone); err != nil || reportTimezone == "Local" || strings.Contains(reportTimezone, "'") {
+		log.Fatalf("Invalid value for REPORT_TIMEZONE: %q", reportTimezone)
+	}
+	processedAction = envString("PROCESSED_ACTION", processedNone)
+	if processedAction != processedNone && processedAction != processedArchive && processedAction != processedTagged {
+		log.Fatalf("Invalid value for PROCESSED_ACTION: %q", processedAction)
+	}
+	processedPrefix = envString("PROCESSED_PREFIX", "processed/")
+	processedTag = envString("PROCESSED_TAG", "processed=true")
+	if name, _, ok := strings.Cut(processedTag, "="); !ok || name == "" {
+		log.Fatalf("Invalid value for PROCESSED_TAG: %q", processedTag)
+	}
+	largeTransactionThreshold = envFloat("LARGE_TRANSACTION_THRESHOLD", 0)
+	if largeTransactionThreshold < 0 {
+		log.Fatalf("Invalid value for LARGE_TRANSACTION_THRESHOLD: must not be negative, got %v", largeTransactionThreshold)
+	}
+	summaryAPIEnabled = envBool("SUMMARY_API_ENABLED", false)
+	summaryAPIMaxLimit = envInt("SUMMARY_API_MAX_LIMIT", 100)
+	summaryAPIDefaultLimit = envInt("SUMMARY_API_DEFAULT_LIMIT", 12)
+	summaryAPIEmailClaim = envString("SUMMARY_API_EMAIL_CLAIM", "email")
+	if summaryAPIMaxLimit < 1 {
+		log.Fatalf("Invalid value for SUMMARY_API_MAX_LIMIT: must be at least 1, got %d", summaryAPIMaxLimit)
+	}
+	if summaryAPIDefaultLimit < 1 || summaryAPIDefaultLimit > summaryAPIMaxLimit {
+		log.Fatalf("Invalid value for SUMMARY_API_DEFAULT_LIMIT: must be between 1 and SUMMARY_API_MAX_LIMIT (%d), got %d", summaryAPIMaxLimit, summaryAPIDefaultLimit)
+	}
+	keyColumn = envString("KEY_COLUMN", "external_id")
+	if !keyColumnPattern.MatchString(keyColumn) {
+		log.Fatalf("Invalid value for KEY_COLUMN: %q", keyColumn)
+	}
+	keyType = envString("KEY_TYPE", keyTypeInt)
+	if keyType != keyTypeInt && keyType != keyTypeText {
+		log.Fatalf("Invalid value for KEY_TYPE: %q", keyType)
+	}
+	amountFormat = envString("AMOUNT_FORMAT", amountFormatLenient)
+	if amountFormat != amountFormatLenient && amountFormat != amountFormatStrict {
+		log.Fatalf("Invalid value for AMOUNT_FORMAT: %q", amountFormat)
+	}
+	numericPrecision = envString("NUMERIC_PRECISION", numericPrecisionFail)
+	if numericPrecision != numericPrecisionRound && numericPrecision != numericPrecisionFail {
+		log.Fatalf("Invalid value for NUMERIC_PRECISION: %q", numericPrecision)
+	}
+	defaultCurrency = strings.ToUpper(envString("DEFAULT_CURRENCY", "USD"))
+	notifyChannel = envString("NOTIFY_CHANNEL", notifyChannelLambda)
+	notifyTarget = envString("NOTIFY_TARGET", "pongo_mail")
+	eventBridgeBus = os.Getenv("EVENTBRIDGE_BUS")
+	eventBridgeSource = envString("EVENTBRIDGE_SOURCE", "summarizer")
+	eventBridgeDetailType = envString("EVENTBRIDGE_DETAIL_TYPE", "AccountSummary")
+	flaggedNotifyTarget = os.Getenv("FLAGGED_NOTIFY_TARGET")
+	notifyBatchSize = envInt("NOTIFY_BATCH_SIZE", 0)
+	if notifyBatchSize < 0 {
+		log.Fatalf("Invalid value for NOTIFY_BATCH_SIZE: must not be negative, got %d", notifyBatchSize)
+	}
+	notifyMaxInFlight = envInt("NOTIFY_MAX_IN_FLIGHT", 1)
+	if notifyMaxInFlight < 1 {
+		log.Fatalf("Invalid value for NOTIFY_MAX_IN_FLIGHT: must be at least 1, got %d", notifyMaxInFlight)
+	}
+	duplicateSummariesPolicy = envString("DUPLICATE_SUMMARIES", duplicateSummariesMerge)
+	if duplicateSummariesPolicy != duplicateSummariesMerge && duplicateSummariesPolicy != duplicateSummariesKeep {
+		log.Fatalf("Invalid value for DUPLICATE_SUMMARIES: %q", duplicateSummariesPolicy)
+	}
+	txnCountThreshold = envInt("TRANSACTION_COUNT_THRESHOLD", 0)
+	if txnCountThreshold < 0 {
+		log.Fatalf("Invalid value for TRANSACTION_COUNT_THRESHOLD: must not be negative, got %d", txnCountThreshold)
+	}
+	maxEmailsPerFile = envInt("MAX_EMAILS_PER_FILE", 0)
+	if maxEmailsPerFile < 0 {
+		log.Fatalf("Invalid value for MAX_EMAILS_PER_FILE: must not be negative, got %d", maxEmailsPerFile)
+	}
+	maxEmailsPolicy = envString("MAX_EMAILS_POLICY", maxEmailsFail)
+	if maxEmailsPolicy != maxEmailsFail && maxEmailsPolicy != maxEmailsFlag {
+		log.Fatalf("Invalid value for MAX_EMAILS_POLICY: %q", maxEmailsPolicy)
+	}
+	itemizeMaxTransactions = envInt("ITEMIZE_MAX_TRANSACTIONS", 0)
+	if itemizeMaxTransactions < 0 {
+		log.Fatalf("Invalid value for ITEMIZE_MAX_TRANSACTIONS: must not be negative, got %d", itemizeMaxTransactions)
+	}
+	notifyDedupeTTL = envDuration("NOTIFY_DEDUPE_TTL", 0)
+	notifySync = envBool("NOTIFY_SYNC", false)
+	notifyPayloadEncoding = envString("NOTIFY_PAYLOAD_ENCODING", payloadEncodingJSON)
+	if notifyPayloadEncoding != payloadEncodingJSON && notifyPayloadEncoding != payloadEncodingGzip {
+		log.Fatalf("Invalid value for NOTIFY_PAYLOAD_ENCODING: %q", notifyPayloadEncoding)
+	}
+	summarySchema = envString("SUMMARY_SCHEMA", summarySchemaV1)
+	if summarySchema != summarySchemaV1 && summarySchema != summarySchemaV2 {
+		log.Fatalf("Invalid value for SUMMARY_SCHEMA: %q", summarySchema)
+	}
+	persistSummaryRows = envBool("PERSIST_SUMMARIES", false)
+	operatorEmail = os.Getenv("OPERATOR_EMAIL")
+	healthBucket = envString("HEALTH_S3_BUCKET", os.Getenv("SUMMARY_S3_BUCKET"))
+	healthCheckTimeout = envDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second)
+	operatorEmailFrom = envString("OPERATOR_EMAIL_FROM", "devsysluis@gmail.com")
+	if tableRoutes, err = parseTableRoutes(os.Getenv("TABLE_ROUTES")); err != nil {
+		log.Fatalf("Invalid value for TABLE_ROUTES: %v", err)
+	}
+	summaryArtifactBucket = os.Getenv("SUMMARY_S3_BUCKET")
+	summaryArtifactPrefix = envString("SUMMARY_S3_PREFIX", "summaries")
+	s3DownloadManager = envBool("S3_DOWNLOAD_MANAGER", false)
+	s3DownloadPartSize = int64(envInt("S3_DOWNLOAD_PART_SIZE", 16*1024*1024))
+	if s3DownloadPartSize < minDownloadPartSize {
+		log.Fatalf("Invalid value for S3_DOWNLOAD_PART_SIZE: must be at least %d bytes, got %d", minDownloadPartSize, s3DownloadPartSize)
+	}
+	s3DownloadConcurrency = envInt("S3_DOWNLOAD_CONCURRENCY", manager.DefaultDownloadConcurrency)
+	if s3DownloadConcurrency < 1 {
+		log.Fatalf("Invalid value for S3_DOWNLOAD_CONCURRENCY: must be at least 1, got %d", s3DownloadConcurrency)
+	}
+	s3ReadAttempts = envInt("S3_READ_ATTEMPTS", 3)
+	if s3ReadAttempts < 1 {
+		log.Fatalf("Invalid value for S3_READ_ATTEMPTS: must be at least 1, got %d", s3ReadAttempts)
+	}
+	metricsNamespace = envString("METRICS_NAMESPACE", "Summarizer")
+	storeSourceKey = envBool("STORE_SOURCE_KEY", false)
+	maxFileAge = envDuration("MAX_FILE_AGE", 0)
+	summaryTimeout = envDuration("SUMMARY_QUERY_TIMEOUT", 0)
+	eventDedupeWindow = envDuration("S3_EVENT_DEDUPE_WINDOW", 0)
+	recordConcurrency = envInt("RECORD_CONCURRENCY", 1)
+	if recordConcurrency < 1 {
+		log.Fatalf("Invalid value for RECORD_CONCURRENCY: must be at least 1, got %d", recordConcurrency)
+	}
+	insertConcurrency = envInt("INSERT_CONCURRENCY", 1)
+	if insertConcurrency < 1 {
+		log.Fatalf("Invalid value for INSERT_CONCURRENCY: must be at least 1, got %d", insertConcurrency)
+	}
+	dbRetryAttempts = envInt("DB_RETRY_ATTEMPTS", 3)
+	if dbRetryAttempts < 1 {
+		log.Fatalf("Invalid value for DB_RETRY_ATTEMPTS: must be at least 1, got %d", dbRetryAttempts)
+	}
+	dbRetryBackoff = envDuration("DB_RETRY_BACKOFF", 200*time.Millisecond)
+	dbSaturatedBackoff = envDuration("DB_TOO_MANY_CONNECTIONS_BACKOFF", 2*time.Second)
+	retryBudgetReserve = envDuration("RETRY_BUDGET_RESERVE", 5*time.Second)
+	databaseURL = os.Getenv("DATABASE_URL")
+	if databaseURL != "" {
+		// Parses the URL without connecting
+		if _, err := pq.NewConnector(databaseURL); err != nil {
+			log.Fatalf("Invalid value for DATABASE_URL: %v", err)
+		}
+	}
+	dbSSLMode = envSSLMode("DB_SSLMODE")
+	dbSSLRootCert = os.Getenv("DB_SSLROOTCERT")
+	databaseReplicaURL = os.Getenv("DATABASE_REPLICA_URL")
+	if databaseReplicaURL != "" {
+		if _, err := pq.NewConnector(databaseReplicaURL); err != nil {
+			log.Fatalf("Invalid value for DATABASE_REPLICA_URL: %v", err)
+		}
+	}
+	dbReplicaHost = os.Getenv("DB_REPLICA_HOST")
+	replicaMaxWait = envDuration("REPLICA_MAX_WAIT", 5*time.Second)
+	dbMaxOpenConns = envInt("DB_MAX_OPEN_CONNS", 0)
+	fileLock = envBool("FILE_LOCK", false)
+	// Every file in flight holds its lock connection while its inserts need more
+	if need := recordConcurrency * (insertConcurrency + 1); fileLock && dbMaxOpenConns > 0 && dbMaxOpenConns < need {
+		log.Fatalf("Invalid value for DB_MAX_OPEN_CONNS: FILE_LOCK needs at least %d connections (RECORD_CONCURRENCY × (INSERT_CONCURRENCY + 1)), got %d", need, dbMaxOpenConns)
+	}
+}
+
+// envString returns the value of the environment variable key, or def if it is unset or empty.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns the integer value of the environment variable key, or def if it is unset.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return n
+}
+
+// envFloat returns the numeric value of the environment variable key, or def if it is unset.
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		log.Fatalf("Invalid value for %s: %q", key, v)
+	}
+	return f
+}
+
+// envBool returns the boolean value of the environment variable key, or def if it is unset.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return b
+}
+
+// envDuration returns the duration value (e.g. "5s") of the environment variable key, or def if it is unset.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return d
+}
+
+// checkRequiredEnv returns an error naming every variable in keys that is unset or empty,
+// so a misconfigured deployment reports all of its problems at once.
+func checkRequiredEnv(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// envSSLMode returns the Postgres sslmode in the environment variable key, defaulting
+// to require, and terminates execution if it is not a mode Postgres accepts.
+func envSSLMode(key string) string {
+	mode := envString(key, "require")
+	switch mode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		return mode
+	default:
+		log.Fatalf("Invalid value for %s: %q", key, mode)
+		return ""
+	}
+}
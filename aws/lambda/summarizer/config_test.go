@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckRequiredEnvListsEveryMissingVariable(t *testing.T) {
+	t.Setenv("REQ_A", "set")
+	t.Setenv("REQ_B", "")
+	err := checkRequiredEnv("REQ_A", "REQ_B", "REQ_C")
+	if err == nil {
+		t.Fatal("checkRequiredEnv() = nil, want an error")
+	}
+	if want := "missing required environment variables: REQ_B, REQ_C"; err.Error() != want {
+		t.Errorf("checkRequiredEnv() = %q, want %q", err, want)
+	}
+	if err := checkRequiredEnv("REQ_A"); err != nil {
+		t.Errorf("checkRequiredEnv(set) = %v, want nil", err)
+	}
+}
+
+func TestRequiredEnv(t *testing.T) {
+	dbVars := []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"}
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"defaults", nil, dbVars},
+		{"database URL", map[string]string{"DATABASE_URL": "postgres://db/app"}, nil},
+		{"SNS channel", map[string]string{"DATABASE_URL": "postgres://db/app", "NOTIFY_CHANNEL": "sns"}, []string{"NOTIFY_TARGET"}},
+		{"default blank email", map[string]string{"DATABASE_URL": "postgres://db/app", "BLANK_EMAIL_POLICY": blankEmailDefault}, []string{"BLANK_EMAIL_ACCOUNT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"DATABASE_URL", "DATABASE_REPLICA_URL", "DB_REPLICA_HOST", "NOTIFY_CHANNEL", "BLANK_EMAIL_POLICY"} {
+				t.Setenv(key, tt.env[key])
+			}
+			if got := requiredEnv(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadConfigHelperProcess only runs in the subprocess started by loadConfigError,
+// where TestMain has already loaded the configuration from its environment.
+func TestLoadConfigHelperProcess(t *testing.T) {
+	if os.Getenv("LOAD_CONFIG_HELPER_PROCESS") != "1" {
+		t.Skip("helper process for loadConfigError")
+	}
+	loadConfig()
+}
+
+// loadConfigError loads the configuration with env in a subprocess, since invalid
+// values are fatal, and returns its output. It fails the test if loading succeeds.
+func loadConfigError(t *testing.T, env map[string]string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLoadConfigHelperProcess$")
+	cmd.Env = append(os.Environ(), "LOAD_CONFIG_HELPER_PROCESS=1")
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("loadConfig() with %v succeeded, want it to exit", env)
+	}
+	return string(out)
+}
+
+func TestConnectionStringUsesConfiguredSSL(t *testing.T) {
+	setVar(t, &databaseURL, "")
+	for k, v := range map[string]string{"DB_HOST": "db", "DB_PORT": "5432", "DB_USER": "app", "DB_PASSWORD": "secret", "DB_NAME": "ledger"} {
+		t.Setenv(k, v)
+	}
+
+	setVar(t, &dbSSLMode, "require")
+	setVar(t, &dbSSLRootCert, "")
+	if got, want := connectionString(), "host=db port=5432 user=app password=secret dbname=ledger sslmode=require"; got != want {
+		t.Errorf("connectionString() = %q, want %q", got, want)
+	}
+
+	setVar(t, &dbSSLMode, "verify-full")
+	setVar(t, &dbSSLRootCert, `/opt/certs/rds ca's.pem`)
+	want := `host=db port=5432 user=app password=secret dbname=ledger sslmode=verify-full sslrootcert='/opt/certs/rds ca\'s.pem'`
+	if got := connectionString(); got != want {
+		t.Errorf("connectionString() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigRejectsUnknownSSLMode(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"DB_SSLMODE": "verify"}); !strings.Contains(out, "Invalid value for DB_SSLMODE") {
+		t.Errorf("output = %s, want an invalid DB_SSLMODE error", out)
+	}
+}
+
+func TestLoadConfigRejectsUnknownBlankEmailPolicy(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"BLANK_EMAIL_POLICY": "drop"}); !strings.Contains(out, "Invalid value for BLANK_EMAIL_POLICY") {
+		t.Errorf("output = %s, want an invalid BLANK_EMAIL_POLICY error", out)
+	}
+}
+
+func TestConnectionStringUsesDatabaseURLVerbatim(t *testing.T) {
+	t.Setenv("DB_HOST", "ignored")
+	const url = "postgres://app:secret@db:5432/ledger?connect_timeout=5&application_name=summarizer&target_session_attrs=read-write"
+	setVar(t, &databaseURL, url)
+	if got := connectionString(); got != url {
+		t.Errorf("connectionString() = %q, want DATABASE_URL %q", got, url)
+	}
+}
+
+func TestLoadConfigRejectsUnparsableDatabaseURL(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"DATABASE_URL": "postgres://db:notaport/app"}); !strings.Contains(out, "Invalid value for DATABASE_URL") {
+		t.Errorf("output = %s, want an invalid DATABASE_URL error", out)
+	}
+}
+
+func TestLoadConfigRejectsDBRetryAttemptsBelowOne(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"DB_RETRY_ATTEMPTS": "0"}); !strings.Contains(out, "Invalid value for DB_RETRY_ATTEMPTS") {
+		t.Errorf("loadConfig() output = %q, want DB_RETRY_ATTEMPTS rejected", out)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestProcessCSVFileHeaderPresence(t *testing.T) {
+	tests := []struct {
+		name      string
+		hasHeader bool
+		body      string
+		wantLines []int
+	}{
+		{
+			name:      "headered",
+			hasHeader: true,
+			body:      "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-06,-3,jane@example.com\n",
+			wantLines: []int{2, 3},
+		},
+		{
+			name:      "headerless",
+			hasHeader: false,
+			body:      "1,2024-01-05,+10.5,jane@example.com\n2,2024-01-06,-3,jane@example.com\n",
+			wantLines: []int{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &csvHasHeader, tt.hasHeader)
+			useS3(t, newFakeS3(map[string]string{"bucket/file.csv": tt.body}))
+
+			rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{
+				{"1", "2024-01-05", "+10.5", "jane@example.com"},
+				{"2", "2024-01-06", "-3", "jane@example.com"},
+			}
+			if got := fields(rows); !reflect.DeepEqual(got, want) {
+				t.Errorf("rows = %v, want %v", got, want)
+			}
+			for i, row := range rows {
+				if row.Line != tt.wantLines[i] {
+					t.Errorf("row %d line = %d, want %d", i, row.Line, tt.wantLines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProcessCSVFileStripsBOM(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/excel.csv": "\xEF\xBB\xBFid,date,transaction,email\r\n1,2024-01-05,+10.5,jane@example.com\r\n"}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "excel.csv")
+	if err != nil {
+		t.Fatalf("processCSVFile() error = %v, want the header matched", err)
+	}
+	if got := fields(rows); !reflect.DeepEqual(got, [][]string{{"1", "2024-01-05", "+10.5", "jane@example.com"}}) {
+		t.Errorf("rows = %v", got)
+	}
+}
+
+func TestProcessCSVFileStripsBOMWithoutHeader(t *testing.T) {
+	setVar(t, &csvHasHeader, false)
+	useS3(t, newFakeS3(map[string]string{"bucket/excel.csv": "\xEF\xBB\xBF1,2024-01-05,+10.5,jane@example.com\n"}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "excel.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Fields[0] != "1" {
+		t.Errorf("rows = %v, want the id of the first row without the BOM", fields(rows))
+	}
+}
+
+// gzipMembers compresses each part as its own gzip member and concatenates them.
+func gzipMembers(t *testing.T, parts ...string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, part := range parts {
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestProcessCSVFileReadsEveryGzipMember(t *testing.T) {
+	body := gzipMembers(t,
+		"id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n",
+		"2,2024-01-06,-3,jane@example.com\n3,2024-02-01,+7,john@example.com\n",
+	)
+	useS3(t, newFakeS3(map[string]string{"bucket/export.csv": body}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "export.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"1", "2024-01-05", "+10.5", "jane@example.com"},
+		{"2", "2024-01-06", "-3", "jane@example.com"},
+		{"3", "2024-02-01", "+7", "john@example.com"},
+	}
+	if got := fields(rows); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want the rows of both members %v", got, want)
+	}
+}
+
+func TestProcessCSVFileRejectsTruncatedGzip(t *testing.T) {
+	body := gzipMembers(t, "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n")
+	useS3(t, newFakeS3(map[string]string{"bucket/export.csv": body[:len(body)-6]}))
+
+	if _, err := processCSVFile(context.Background(), "bucket", "export.csv"); !errors.Is(err, ErrValidation) {
+		t.Errorf("processCSVFile() = %v, want ErrValidation for a truncated gzip stream", err)
+	}
+}
+
+func TestIsBlankRecord(t *testing.T) {
+	tests := []struct {
+		record []string
+		want   bool
+	}{
+		{[]string{""}, true},
+		{[]string{"", "", "", ""}, true},
+		{[]string{"  ", "\t"}, true},
+		{[]string{"", "", "x", ""}, false},
+		{[]string{"1", "2024-01-05", "+1", "jane@example.com"}, false},
+	}
+	for _, tt := range tests {
+		if got := isBlankRecord(tt.record); got != tt.want {
+			t.Errorf("isBlankRecord(%q) = %v, want %v", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestProcessCSVFileIgnoresTrailingBlankRecords(t *testing.T) {
+	// Under STRICT_COLUMNS=fail any record counted as malformed fails the file
+	setVar(t, &strictColumns, strictColumnsFail)
+	body := "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n2,2024-01-06,-10,john@example.com\n,,,\n   \n,,\n\n"
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": body}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if err != nil {
+		t.Fatalf("processCSVFile() error = %v, want the trailing blank records ignored", err)
+	}
+	if len(rows) != 2 || rows[1].Line != 3 {
+		t.Errorf("rows = %+v, want the 2 data rows", rows)
+	}
+}
+
+func TestProcessCSVFileReportsInteriorBlankRecords(t *testing.T) {
+	setVar(t, &strictColumns, strictColumnsFail)
+	body := "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n,,\n2,2024-01-06,-10,john@example.com\n"
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": body}))
+
+	if _, err := processCSVFile(context.Background(), "bucket", "file.csv"); !errors.Is(err, ErrValidation) {
+		t.Errorf("processCSVFile() error = %v, want the blank line 3 rejected", err)
+	}
+}
+
+func TestProcessCSVFileReportsTrailingBlanksWhenDisabled(t *testing.T) {
+	setVar(t, &strictColumns, strictColumnsFail)
+	setVar(t, &csvIgnoreTrailingBlanks, false)
+	body := "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n,,\n"
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": body}))
+
+	if _, err := processCSVFile(context.Background(), "bucket", "file.csv"); !errors.Is(err, ErrValidation) {
+		t.Errorf("processCSVFile() error = %v, want the blank record rejected with CSV_IGNORE_TRAILING_BLANKS off", err)
+	}
+}
+
+// failingBody returns an object body that yields prefix and then fails as a dropped
+// connection would.
+func failingBody(prefix string) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{Body: io.NopCloser(io.MultiReader(strings.NewReader(prefix), iotest.ErrReader(errors.New("connection reset by peer"))))}
+}
+
+func TestProcessCSVFileRetriesBodyFailingMidway(t *testing.T) {
+	body := "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n2,2024-01-06,-10,john@example.com\n"
+	f := newFakeS3(nil)
+	f.get = func(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+		if f.gets == 1 {
+			return failingBody(body[:40]), nil
+		}
+		return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+	useS3(t, f)
+
+	rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if err != nil {
+		t.Fatalf("processCSVFile() error = %v, want the second read to succeed", err)
+	}
+	if len(rows) != 2 || rows[1].Fields[3] != "john@example.com" {
+		t.Errorf("rows = %+v, want both rows from the complete read", rows)
+	}
+	if f.gets != 2 {
+		t.Errorf("GetObject called %d times, want 2", f.gets)
+	}
+}
+
+func TestProcessCSVFileGivesUpAfterReadAttempts(t *testing.T) {
+	setVar(t, &s3ReadAttempts, 2)
+	f := newFakeS3(nil)
+	f.get = func(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+		return failingBody("id,date,transaction,email\n1,2024-01-05,+6"), nil
+	}
+	useS3(t, f)
+
+	_, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if !errors.Is(err, errObjectRead) || !shouldRetry(err) {
+		t.Errorf("processCSVFile() error = %v, want a retryable read error", err)
+	}
+	if f.gets != 2 {
+		t.Errorf("GetObject called %d times, want S3_READ_ATTEMPTS (2)", f.gets)
+	}
+}
+
+func TestProcessCSVFileDoesNotRetryMalformedFile(t *testing.T) {
+	// A truncated gzip stream is malformed, however often it is read
+	body := gzipMembers(t, "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n")
+	f := newFakeS3(map[string]string{"bucket/file.csv": body[:len(body)-6]})
+	useS3(t, f)
+
+	_, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if !errors.Is(err, ErrValidation) || errors.Is(err, errObjectRead) {
+		t.Errorf("processCSVFile() error = %v, want a validation error", err)
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want a malformed file read once", f.gets)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetTransactionSummarySeparatesCurrencies(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,currency")
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{currency: "EUR", month: "January", credits: []float64{100}, balance: "100", period: jan},
+		monthRow{currency: "EUR", month: "February", debits: []float64{30}, balance: "-30", prevBal: "100", period: feb},
+		monthRow{currency: "USD", month: "January", credits: []float64{20.5}, balance: "20.5", period: jan},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Currencies) != 2 {
+		t.Fatalf("currencies = %+v, want EUR and USD", summary.Currencies)
+	}
+	eur, usd := summary.Currencies[0], summary.Currencies[1]
+	if eur.Currency != "EUR" || eur.TotalBalance != 70 || len(eur.MonthlySummaries) != 2 {
+		t.Errorf("EUR = %+v, want a balance of 70 over 2 months", eur)
+	}
+	if usd.Currency != "USD" || usd.TotalBalance != 20.5 || len(usd.MonthlySummaries) != 1 {
+		t.Errorf("USD = %+v, want a balance of 20.5 over 1 month", usd)
+	}
+	if summary.TotalBalance != 0 || summary.MonthlySummaries != nil {
+		t.Errorf("top-level balance = %v, months = %v, want currencies never added together", summary.TotalBalance, summary.MonthlySummaries)
+	}
+}
+
+func TestGetTransactionSummaryFillsTotalsForSingleCurrency(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,currency")
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{currency: "EUR", month: "January", credits: []float64{100}, balance: "100", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.TotalBalance != 100 || len(summary.MonthlySummaries) != 1 || len(summary.Currencies) != 1 {
+		t.Errorf("summary = %+v, want the EUR totals at the top level too", summary)
+	}
+}
+
+func TestInsertTransactionsNormalizesCurrency(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,currency")
+	setVar(t, &defaultCurrency, "USD")
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email, currency) VALUES ($1, $2, $3, $4, $5)"))
+	prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+1", "jane@example.com", "EUR").WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs(int64(2), sqlmock.AnyArg(), "+2", "jane@example.com", "USD").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+1", "jane@example.com", " eur "}},
+		{Line: 3, Fields: []string{"2", "2024-01-06", "+2", "jane@example.com", ""}},
+	}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// notificationToken identifies a notification by the set of recipient emails and the
+// period (YYYY-MM) it covers, independent of summary order.
+func notificationToken(summaries []*AccountSummary, period string) string {
+	emails := make([]string, 0, len(summaries))
+	for _, s := range summaries {
+		emails = append(emails, s.Email)
+	}
+	sort.Strings(emails)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|", period)
+	for _, email := range emails {
+		fmt.Fprintf(h, "%s\n", email)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// claimNotification records token with the given TTL and reports whether this caller
+// claimed it. A token that is already present and not yet expired is a duplicate.
+func claimNotification(ctx context.Context, db *sql.DB, token string, ttl time.Duration) (bool, error) {
+	var claimed string
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO notification_dedupe (token, expires_at)
+		VALUES ($1, now() + $2 * INTERVAL '1 second')
+		ON CONFLICT (token) DO UPDATE SET expires_at = EXCLUDED.expires_at
+			WHERE notification_dedupe.expires_at < now()
+		RETURNING token
+	`, token, ttl.Seconds()).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, classifyDBError(fmt.Errorf("failed claiming notification token: %w", err))
+	}
+	return true, nil
+}
+
+// releaseNotification removes a claimed token so a failed notification can be retried.
+func releaseNotification(ctx context.Context, db *sql.DB, token string) {
+	if _, err := db.ExecContext(ctx, `DELETE FROM notification_dedupe WHERE token = $1`, token); err != nil {
+		log.Printf("Error releasing notification token: %v", err)
+	}
+}
+
+// notifyDedupeKey is the context key of the notifyDedupe of a notification.
+type notifyDedupeKey struct{}
+
+// notifyDedupe claims each delivery of a notification once: token identifies the
+// batch being sent, and every notifier it is delivered to claims it separately.
+type notifyDedupe struct {
+	db    *sql.DB
+	token string
+}
+
+// notifyOnce sends the summaries unless an identical notification was already sent within
+// NOTIFY_DEDUPE_TTL. Every batch, and the EVENTBRIDGE_BUS target next to the channel, is
+// claimed on its own, so a retry after a partial failure only sends what was not
+// delivered. If the dedupe table is unavailable it fails open and notifies anyway.
+func notifyOnce(ctx context.Context, db *sql.DB, summaries []*AccountSummary) error {
+	if notifyDedupeTTL > 0 {
+		ctx = context.WithValue(ctx, notifyDedupeKey{}, &notifyDedupe{db: db})
+	}
+	return notifySummaries(ctx, summaries)
+}
+
+// withBatchToken returns ctx claiming the batch of summaries when notifications are
+// deduplicated.
+func withBatchToken(ctx context.Context, batch []*AccountSummary) context.Context {
+	d, _ := ctx.Value(notifyDedupeKey{}).(*notifyDedupe)
+	if d == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, notifyDedupeKey{}, &notifyDedupe{db: d.db, token: notificationToken(batch, summaryPeriod(batch))})
+}
+
+// deliverOnce hands the payload to n unless the batch was already delivered to it;
+// target tells apart the notifiers of a multiNotifier. A failed delivery releases
+// its claim so it can be retried.
+func deliverOnce(ctx context.Context, n Notifier, target string, payload NotificationPayload) error {
+	d, _ := ctx.Value(notifyDedupeKey{}).(*notifyDedupe)
+	if d == nil || d.token == "" {
+		return n.Notify(ctx, payload)
+	}
+	if _, ok := n.(multiNotifier); ok {
+		return n.Notify(ctx, payload)
+	}
+
+	token := d.token + target
+	claimed, err := claimNotification(ctx, d.db, token, notifyDedupeTTL)
+	if err != nil {
+		log.Printf("Warning: notification dedupe unavailable, notifying anyway: %v", err)
+		return n.Notify(ctx, payload)
+	}
+	if !claimed {
+		log.Printf("Duplicate notification for %d summaries suppressed (token %s)", len(payload.Summaries), d.token[:12]+target)
+		return nil
+	}
+
+	if err := n.Notify(ctx, payload); err != nil {
+		releaseNotification(ctx, d.db, token)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectClaim expects a notification token to be claimed, answering whether it was.
+func expectClaim(mock sqlmock.Sqlmock, claimed bool) {
+	rows := sqlmock.NewRows([]string{"token"})
+	if claimed {
+		rows.AddRow("token")
+	}
+	mock.ExpectQuery("INSERT INTO notification_dedupe").WithArgs(sqlmock.AnyArg(), time.Hour.Seconds()).WillReturnRows(rows)
+}
+
+func TestNotificationTokenIgnoresOrderButNotPeriod(t *testing.T) {
+	a := []*AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}}
+	b := []*AccountSummary{{Email: "john@example.com"}, {Email: "jane@example.com"}}
+
+	if notificationToken(a, "2024-01") != notificationToken(b, "2024-01") {
+		t.Error("tokens differ for the same emails in the same month")
+	}
+	if notificationToken(a, "2024-01") == notificationToken(a, "2024-02") {
+		t.Error("tokens match across months")
+	}
+	if notificationToken(a, "2024-01") == notificationToken(a[:1], "2024-01") {
+		t.Error("tokens match for different emails")
+	}
+}
+
+func TestNotifyOnceSuppressesDuplicateWithinTTL(t *testing.T) {
+	setVar(t, &notifyDedupeTTL, time.Hour)
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC) })
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	db, mock := newMockDB(t)
+	expectClaim(mock, true)
+	expectClaim(mock, false)
+
+	summaries := []*AccountSummary{{Email: "jane@example.com"}}
+	for range 2 {
+		if err := notifyOnce(context.Background(), db, summaries); err != nil {
+			t.Fatalf("notifyOnce() error = %v", err)
+		}
+	}
+	if len(n.payloads) != 1 {
+		t.Errorf("notified %d times, want the duplicate suppressed", len(n.payloads))
+	}
+}
+
+func TestNotifyOnceReleasesClaimOfFailedNotification(t *testing.T) {
+	setVar(t, &notifyDedupeTTL, time.Hour)
+	useNotifier(t, &fakeNotifier{err: classify(ErrTransient, errors.New("throttled"))})
+	db, mock := newMockDB(t)
+	expectClaim(mock, true)
+	mock.ExpectExec("DELETE FROM notification_dedupe").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := notifyOnce(context.Background(), db, []*AccountSummary{{Email: "jane@example.com"}}); !shouldRetry(err) {
+		t.Errorf("notifyOnce() error = %v, want the retryable notification error", err)
+	}
+}
+
+func TestNotifyOnceFailsOpenWhenDedupeIsUnavailable(t *testing.T) {
+	setVar(t, &notifyDedupeTTL, time.Hour)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("INSERT INTO notification_dedupe").WillReturnError(errors.New("relation does not exist"))
+
+	if err := notifyOnce(context.Background(), db, []*AccountSummary{{Email: "jane@example.com"}}); err != nil {
+		t.Fatalf("notifyOnce() error = %v", err)
+	}
+	if len(n.payloads) != 1 {
+		t.Errorf("notified %d times, want 1", len(n.payloads))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3DownloadAPI is the subset of the transfer manager used by the summarizer,
+// so it can be replaced in tests.
+type s3DownloadAPI interface {
+	Download(ctx context.Context, w io.WriterAt, input *s3.GetObjectInput, options ...func(*manager.Downloader)) (int64, error)
+}
+
+// minDownloadPartSize is the smallest S3_DOWNLOAD_PART_SIZE. S3 serves ranged GETs of
+// any size (the 5 MiB minimum is for multipart uploads), but smaller parts turn a
+// large file into an unreasonable number of requests.
+const minDownloadPartSize = 1024 * 1024
+
+// downloader is nil unless S3_DOWNLOAD_MANAGER is enabled.
+var downloader s3DownloadAPI
+
+// newDownloader builds a transfer manager downloader with the configured part size and concurrency.
+func newDownloader(client manager.DownloadAPIClient) s3DownloadAPI {
+	return manager.NewDownloader(client, func(d *manager.Downloader) {
+		d.PartSize = s3DownloadPartSize
+		d.Concurrency = s3DownloadConcurrency
+	})
+}
+
+// openObject returns a reader over the object's content. With the transfer manager
+// enabled the object is first downloaded in parallel ranged parts to a temporary file,
+// which is removed when the reader is closed; otherwise the GetObject body is streamed.
+func openObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if downloader == nil {
+		obj, err := s3Client.GetObject(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		return obj.Body, nil
+	}
+
+	f, err := os.CreateTemp("", "summarizer-*.csv")
+	if err != nil {
+		return nil, classify(ErrTransient, fmt.Errorf("error creating temp file: %w", err))
+	}
+	n, err := downloader.Download(ctx, f, input)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	log.Printf("Downloaded %d bytes of s3://%s/%s with the transfer manager", n, bucket, key)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, classify(ErrFatal, fmt.Errorf("error rewinding temp file: %w", err))
+	}
+	return &tempFile{File: f}, nil
+}
+
+// tempFile deletes the underlying file when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	if rmErr := os.Remove(t.Name()); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeDownloader is an s3DownloadAPI that writes an object of its fakeS3 in parts of
+// partSize bytes, last part first, as a concurrent ranged download may.
+type fakeDownloader struct {
+	s3       *fakeS3
+	partSize int
+	files    []string
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, w io.WriterAt, in *s3.GetObjectInput, _ ...func(*manager.Downloader)) (int64, error) {
+	if f, ok := w.(*os.File); ok {
+		d.files = append(d.files, f.Name())
+	}
+	data, ok := d.s3.object(*in.Bucket, *in.Key)
+	if !ok {
+		return 0, s3NotFound(nil)
+	}
+	for off := (len(data) - 1) / d.partSize * d.partSize; off >= 0; off -= d.partSize {
+		if _, err := w.WriteAt(data[off:min(off+d.partSize, len(data))], int64(off)); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(data)), nil
+}
+
+func TestProcessCSVFileWithDownloadManagerMatchesStreaming(t *testing.T) {
+	var body strings.Builder
+	body.WriteString("id,date,transaction,email\n")
+	for i := range 200 {
+		body.WriteString(strings.Join([]string{strconv.Itoa(i + 1), "2024-01-05", "+10.5", "jane@example.com"}, ",") + "\n")
+	}
+	f := newFakeS3(map[string]string{"bucket/big.csv": body.String()})
+	useS3(t, f)
+
+	streamed, err := processCSVFile(context.Background(), "bucket", "big.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &fakeDownloader{s3: f, partSize: 97}
+	setVar[s3DownloadAPI](t, &downloader, d)
+	downloaded, err := processCSVFile(context.Background(), "bucket", "big.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downloaded) != 200 || !reflect.DeepEqual(downloaded, streamed) {
+		t.Errorf("download manager parsed %d rows, streaming %d; want the same rows", len(downloaded), len(streamed))
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want only the streaming read", f.gets)
+	}
+	for _, name := range d.files {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("temp file %s left behind", filepath.Base(name))
+		}
+	}
+	if len(d.files) != 1 {
+		t.Errorf("downloaded to %d temp files, want 1", len(d.files))
+	}
+}
+
+func TestLoadConfigRejectsSmallDownloadPartSize(t *testing.T) {
+	out := loadConfigError(t, map[string]string{"S3_DOWNLOAD_PART_SIZE": "1024"})
+	if !strings.Contains(out, "Invalid value for S3_DOWNLOAD_PART_SIZE") {
+		t.Errorf("output = %s, want the part size rejected", out)
+	}
+}
+
+func TestLoadConfigRejectsNegativeThresholds(t *testing.T) {
+	for _, key := range []string{"LARGE_TRANSACTION_THRESHOLD", "TRANSACTION_COUNT_THRESHOLD"} {
+		out := loadConfigError(t, map[string]string{key: "-1"})
+		if !strings.Contains(out, "Invalid value for "+key) {
+			t.Errorf("output = %s, want %s rejected", out, key)
+		}
+	}
+}
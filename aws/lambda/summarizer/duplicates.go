@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// Supported values for DUPLICATE_SUMMARIES.
+const (
+	// duplicateSummariesMerge sends one summary per email, combining the duplicates.
+	duplicateSummariesMerge = "merge"
+	// duplicateSummariesKeep sends every summary as built, one per file and email.
+	duplicateSummariesKeep = "keep"
+)
+
+// mergeDuplicateSummaries returns one summary per email when an email appears in
+// several files of the event, so the account is not emailed twice.
+//
+// Files routed to the same table produce summaries of the same account history; only
+// the one with the most transactions, built after the most rows were committed, is
+// kept. Summaries of different tables cover different transactions and are combined.
+func mergeDuplicateSummaries(summaries []*AccountSummary) []*AccountSummary {
+	if duplicateSummariesPolicy == duplicateSummariesKeep {
+		return summaries
+	}
+
+	type tableKey struct{ email, table string }
+	fullest := make(map[tableKey]*AccountSummary)
+	var order []tableKey
+	for _, s := range summaries {
+		k := tableKey{s.Email, s.summaryTable}
+		prev, ok := fullest[k]
+		if !ok {
+			order = append(order, k)
+		}
+		if !ok || summaryTransactionCount(s) > summaryTransactionCount(prev) {
+			fullest[k] = s
+		}
+	}
+
+	merged := make([]*AccountSummary, 0, len(order))
+	byEmail := make(map[string]int)
+	for _, k := range order {
+		s := fullest[k]
+		i, ok := byEmail[k.email]
+		if !ok {
+			byEmail[k.email] = len(merged)
+			merged = append(merged, s)
+			continue
+		}
+		merged[i] = combineSummaries(merged[i], s)
+	}
+
+	if dropped := len(summaries) - len(merged); dropped > 0 {
+		log.Printf("Merged %d duplicate summaries into %d accounts", dropped, len(merged))
+		emitMetric("MergedDuplicateSummaries", float64(dropped), nil, nil)
+	}
+	return merged
+}
+
+// combineSummaries returns the summary of one account's transactions in two tables.
+func combineSummaries(a, b *AccountSummary) *AccountSummary {
+	c := &AccountSummary{
+		Email:        a.Email,
+		Name:         a.Name,
+		Tier:         a.Tier,
+		Flagged:      a.Flagged || b.Flagged,
+		FlagReason:   a.FlagReason,
+		summaryTable: a.summaryTable,
+	}
+	if c.Name == "" {
+		c.Name = b.Name
+	}
+	if c.Tier == "" {
+		c.Tier = b.Tier
+	}
+	if c.FlagReason == "" {
+		c.FlagReason = b.FlagReason
+	}
+
+	if len(a.Currencies) == 0 && len(b.Currencies) == 0 {
+		c.TotalBalance = a.TotalBalance + b.TotalBalance
+		c.MonthlySummaries = mergeMonths(a.MonthlySummaries, b.MonthlySummaries)
+	} else {
+		c.Currencies = append([]CurrencyBreakdown(nil), a.Currencies...)
+		for _, bc := range b.Currencies {
+			i := 0
+			for i < len(c.Currencies) && c.Currencies[i].Currency != bc.Currency {
+				i++
+			}
+			if i == len(c.Currencies) {
+				c.Currencies = append(c.Currencies, bc)
+				continue
+			}
+			c.Currencies[i].TotalBalance += bc.TotalBalance
+			c.Currencies[i].MonthlySummaries = mergeMonths(c.Currencies[i].MonthlySummaries, bc.MonthlySummaries)
+		}
+		// As when summarizing, top-level figures only exist for a single currency
+		if len(c.Currencies) == 1 {
+			c.TotalBalance = c.Currencies[0].TotalBalance
+			c.MonthlySummaries = c.Currencies[0].MonthlySummaries
+		}
+	}
+
+	// An itemized list is only kept while the combined account is still small enough
+	if itemizeMaxTransactions > 0 && summaryTransactionCount(c) < itemizeMaxTransactions {
+		c.Transactions = append(append([]Transaction(nil), a.Transactions...), b.Transactions...)
+		sort.SliceStable(c.Transactions, func(i, j int) bool {
+			return c.Transactions[i].Date < c.Transactions[j].Date
+		})
+	}
+	return c
+}
+
+// mergeMonths combines two lists of periods, adding up the periods that start on the
+// same date, and returns them in chronological order.
+func mergeMonths(a, b []MonthlySummary) []MonthlySummary {
+	months := append([]MonthlySummary(nil), a...)
+	for _, m := range b {
+		i := 0
+		for i < len(months) && months[i].periodKey != m.periodKey {
+			i++
+		}
+		if i == len(months) {
+			months = append(months, m)
+			continue
+		}
+		months[i] = combineMonth(months[i], m)
+	}
+	sort.SliceStable(months, func(i, j int) bool { return months[i].periodKey < months[j].periodKey })
+	return months
+}
+
+// combineMonth adds up one period of two tables.
+func combineMonth(x, y MonthlySummary) MonthlySummary {
+	m := MonthlySummary{
+		Month:               x.Month,
+		TransactionCount:    x.TransactionCount + y.TransactionCount,
+		AverageCredit:       weightedAverage(x.AverageCredit, x.creditCount, y.AverageCredit, y.creditCount),
+		AverageDebit:        weightedAverage(x.AverageDebit, x.debitCount, y.AverageDebit, y.debitCount),
+		MaxCredit:           pickFloat(x.MaxCredit, y.MaxCredit, math.Max),
+		MaxDebit:            pickFloat(x.MaxDebit, y.MaxDebit, math.Min), // debits are negative
+		Net:                 pickFloat(x.Net, y.Net, sumFloat),
+		PrevMonthNet:        pickFloat(x.PrevMonthNet, y.PrevMonthNet, sumFloat),
+		HasLargeTransaction: x.HasLargeTransaction || y.HasLargeTransaction,
+		creditCount:         x.creditCount + y.creditCount,
+		debitCount:          x.debitCount + y.debitCount,
+		periodKey:           x.periodKey,
+	}
+	if m.Net != nil && m.PrevMonthNet != nil && *m.PrevMonthNet != 0 {
+		change := (*m.Net - *m.PrevMonthNet) / math.Abs(*m.PrevMonthNet) * 100
+		m.ChangePercent = &change
+	}
+	return m
+}
+
+// pickFloat combines two optional values with f, or returns whichever is set.
+func pickFloat(a, b *float64, f func(float64, float64) float64) *float64 {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	v := f(*a, *b)
+	return &v
+}
+
+func sumFloat(a, b float64) float64 { return a + b }
+
+// weightedAverage combines two optional averages of wa and wb amounts.
+func weightedAverage(a *float64, wa int, b *float64, wb int) *float64 {
+	if a == nil || b == nil {
+		return pickFloat(a, b, nil)
+	}
+	if wa+wb == 0 {
+		return a
+	}
+	v := (*a*float64(wa) + *b*float64(wb)) / float64(wa+wb)
+	return &v
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestMergeDuplicateSummariesKeepsFullestOfSameTable(t *testing.T) {
+	first := &AccountSummary{Email: "jane@example.com", TotalBalance: 10, summaryTable: "summaries",
+		MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 1, periodKey: "2024-01-01"}}}
+	second := &AccountSummary{Email: "jane@example.com", TotalBalance: 30, summaryTable: "summaries",
+		MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 3, periodKey: "2024-01-01"}}}
+	other := &AccountSummary{Email: "john@example.com", summaryTable: "summaries"}
+
+	merged := mergeDuplicateSummaries([]*AccountSummary{first, other, second})
+	if len(merged) != 2 || merged[0] != second || merged[1] != other {
+		t.Errorf("merged = %+v, want jane's fullest summary and john's, in first-seen order", merged)
+	}
+}
+
+func TestMergeDuplicateSummariesCombinesTablesChronologically(t *testing.T) {
+	cards := &AccountSummary{Email: "jane@example.com", TotalBalance: 50, summaryTable: "card_summaries",
+		MonthlySummaries: []MonthlySummary{
+			{Month: "February", TransactionCount: 1, AverageCredit: floatPtr(10), MaxCredit: floatPtr(10), Net: floatPtr(10), creditCount: 1, periodKey: "2024-02-01"},
+			{Month: "April", TransactionCount: 1, AverageCredit: floatPtr(40), MaxCredit: floatPtr(40), Net: floatPtr(40), creditCount: 1, periodKey: "2024-04-01"},
+		}}
+	loans := &AccountSummary{Email: "jane@example.com", TotalBalance: 110, summaryTable: "loan_summaries",
+		MonthlySummaries: []MonthlySummary{
+			{Month: "February", TransactionCount: 3, AverageCredit: floatPtr(40), MaxCredit: floatPtr(60), AverageDebit: floatPtr(-5), MaxDebit: floatPtr(-5), Net: floatPtr(115), creditCount: 3, debitCount: 1, periodKey: "2024-02-01"},
+			{Month: "March", TransactionCount: 1, Net: floatPtr(-5), debitCount: 1, periodKey: "2024-03-01"},
+		}}
+
+	merged := mergeDuplicateSummaries([]*AccountSummary{cards, loans})
+	if len(merged) != 1 {
+		t.Fatalf("merged = %+v, want one summary", merged)
+	}
+	got := merged[0]
+	if got.TotalBalance != 160 {
+		t.Errorf("TotalBalance = %v, want 160", got.TotalBalance)
+	}
+	var months []string
+	for _, m := range got.MonthlySummaries {
+		months = append(months, m.Month)
+	}
+	if want := []string{"February", "March", "April"}; !reflect.DeepEqual(months, want) {
+		t.Fatalf("months = %v, want %v in chronological order", months, want)
+	}
+
+	feb := got.MonthlySummaries[0]
+	if feb.TransactionCount != 4 || *feb.Net != 125 || *feb.MaxCredit != 60 || *feb.MaxDebit != -5 {
+		t.Errorf("February = %+v, want 4 transactions, net 125 and the larger maxima", feb)
+	}
+	// One credit averaging 10 and three averaging 40 average 32.5, not (10+40)/2
+	if *feb.AverageCredit != 32.5 {
+		t.Errorf("February average credit = %v, want 32.5 weighted by credit count", *feb.AverageCredit)
+	}
+	if *feb.AverageDebit != -5 || feb.creditCount != 4 || feb.debitCount != 1 {
+		t.Errorf("February = %+v, want the debit average kept and the counts added up", feb)
+	}
+}
+
+func TestMergeDuplicateSummariesCombinesCurrencies(t *testing.T) {
+	a := &AccountSummary{Email: "jane@example.com", summaryTable: "a", Currencies: []CurrencyBreakdown{
+		{Currency: "EUR", TotalBalance: 10, MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 1, periodKey: "2024-01-01"}}},
+	}}
+	b := &AccountSummary{Email: "jane@example.com", summaryTable: "b", Currencies: []CurrencyBreakdown{
+		{Currency: "EUR", TotalBalance: 5, MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 2, periodKey: "2024-01-01"}}},
+		{Currency: "USD", TotalBalance: 7, MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 1, periodKey: "2024-01-01"}}},
+	}}
+
+	merged := mergeDuplicateSummaries([]*AccountSummary{a, b})
+	if len(merged) != 1 || len(merged[0].Currencies) != 2 {
+		t.Fatalf("merged = %+v, want one summary in EUR and USD", merged)
+	}
+	eur, usd := merged[0].Currencies[0], merged[0].Currencies[1]
+	if eur.TotalBalance != 15 || eur.MonthlySummaries[0].TransactionCount != 3 || usd.TotalBalance != 7 {
+		t.Errorf("currencies = %+v, want EUR 15 over 3 transactions and USD 7", merged[0].Currencies)
+	}
+	if merged[0].TotalBalance != 0 || merged[0].MonthlySummaries != nil {
+		t.Errorf("top-level figures set for several currencies: %+v", merged[0])
+	}
+}
+
+func TestMergeDuplicateSummariesKeepPolicy(t *testing.T) {
+	setVar(t, &duplicateSummariesPolicy, duplicateSummariesKeep)
+	summaries := []*AccountSummary{{Email: "jane@example.com"}, {Email: "jane@example.com"}}
+	if got := mergeDuplicateSummaries(summaries); len(got) != 2 {
+		t.Errorf("merged %d summaries, want both kept", len(got))
+	}
+}
+
+func TestHandleS3EventNotifiesDuplicateEmailOnce(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{
+		"bucket/a.csv": "id,date,transaction,email\n1,2024-01-05,+10,jane@example.com\n",
+		"bucket/b.csv": "id,date,transaction,email\n2,2024-01-06,+20,jane@example.com\n",
+	}))
+	useRepository(t, newMemRepository())
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	mock.MatchExpectationsInOrder(false)
+	for _, key := range []string{"a.csv", "b.csv"} {
+		expectNewFile(mock, "bucket", key)
+		expectLedgerWrite(mock, "bucket", key, ingestProcessed)
+		expectNotified(mock, "bucket", key)
+	}
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", "a.csv", "b.csv")); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if got := n.emails(); !reflect.DeepEqual(got, []string{"jane@example.com"}) {
+		t.Errorf("notified %v, want jane@example.com once", got)
+	}
+	if got := n.payloads[0].Summaries[0].TotalBalance; got != 30 {
+		t.Errorf("notified balance = %v, want 30 from both files", got)
+	}
+}
+
+func TestLoadConfigRejectsUnknownDuplicateSummariesPolicy(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"DUPLICATE_SUMMARIES": "drop"}); !strings.Contains(out, "Invalid value for DUPLICATE_SUMMARIES") {
+		t.Errorf("loadConfig() output = %q, want DUPLICATE_SUMMARIES rejected", out)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Supported values for MAX_EMAILS_POLICY.
+const (
+	maxEmailsFail = "fail"
+	maxEmailsFlag = "flag"
+)
+
+// distinctEmails counts the accounts rows would be summarized for, applying the same
+// blank email handling as insertTransactions.
+func distinctEmails(rows []csvRow) int {
+	emails := make(map[string]struct{})
+	for _, row := range rows {
+		email := schema.field(row.Fields, "email")
+		if strings.TrimSpace(email) == "" {
+			if blankEmailPolicy != blankEmailDefault {
+				continue
+			}
+			email = blankEmailAccount
+		}
+		emails[email] = struct{}{}
+	}
+	return len(emails)
+}
+
+// checkEmailCap guards against a malformed file fanning out into thousands of summary
+// queries and emails. It returns a validation error when the file has more distinct
+// emails than MAX_EMAILS_PER_FILE under MAX_EMAILS_POLICY=fail, and suspicious=true
+// under flag, in which case the file is ingested but not summarized.
+func checkEmailCap(bucket, key string, rows []csvRow) (suspicious bool, err error) {
+	if maxEmailsPerFile <= 0 {
+		return false, nil
+	}
+	n := distinctEmails(rows)
+	if n <= maxEmailsPerFile {
+		return false, nil
+	}
+
+	emitMetric("SuspiciousFiles", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key, "Policy": maxEmailsPolicy})
+	if maxEmailsPolicy == maxEmailsFail {
+		return false, classify(ErrValidation, fmt.Errorf("s3://%s/%s has %d distinct emails, more than MAX_EMAILS_PER_FILE (%d)", bucket, key, n, maxEmailsPerFile))
+	}
+	log.Printf("Warning: s3://%s/%s has %d distinct emails, more than MAX_EMAILS_PER_FILE (%d); ingesting without summaries", bucket, key, n, maxEmailsPerFile)
+	return true, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// manyEmailsCSV returns a file with one row for each of n distinct emails.
+func manyEmailsCSV(n int) string {
+	var b strings.Builder
+	b.WriteString("id,date,transaction,email\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d,2024-01-05,+1,user%d@example.com\n", i, i)
+	}
+	return b.String()
+}
+
+func TestDistinctEmails(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 4, Fields: []string{"3", "2024-01-05", "+1", "john@example.com"}},
+		{Line: 5, Fields: []string{"4", "2024-01-05", "+1", " "}},
+	}
+	if got := distinctEmails(rows); got != 2 {
+		t.Errorf("distinctEmails() = %d, want 2 with blank emails excluded", got)
+	}
+
+	setVar(t, &blankEmailPolicy, blankEmailDefault)
+	setVar(t, &blankEmailAccount, "unassigned@example.com")
+	if got := distinctEmails(rows); got != 3 {
+		t.Errorf("distinctEmails() = %d, want 3 with blank emails under the default account", got)
+	}
+}
+
+func TestCheckEmailCap(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-01-05", "+1", "john@example.com"}},
+		{Line: 4, Fields: []string{"3", "2024-01-05", "+1", "ana@example.com"}},
+	}
+	tests := []struct {
+		name           string
+		max            int
+		policy         string
+		wantSuspicious bool
+		wantErr        bool
+	}{
+		{"disabled", 0, maxEmailsFail, false, false},
+		{"at cap", 3, maxEmailsFail, false, false},
+		{"over cap fails", 2, maxEmailsFail, false, true},
+		{"over cap flags", 2, maxEmailsFlag, true, false},
+	}
+	for _, tt := range tests {
+		setVar(t, &maxEmailsPerFile, tt.max)
+		setVar(t, &maxEmailsPolicy, tt.policy)
+		m := captureMetrics(t)
+		suspicious, err := checkEmailCap("bucket", "file.csv", rows)
+		if suspicious != tt.wantSuspicious || (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkEmailCap() = %v, %v, want suspicious %v, error %v", tt.name, suspicious, err, tt.wantSuspicious, tt.wantErr)
+		}
+		if err != nil && (!errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "has 3 distinct emails, more than MAX_EMAILS_PER_FILE (2)")) {
+			t.Errorf("%s: checkEmailCap() error = %v, want a validation error with the counts", tt.name, err)
+		}
+		wantMetrics := 0
+		if tt.wantSuspicious || tt.wantErr {
+			wantMetrics = 1
+		}
+		if got := len(m.records(t, "SuspiciousFiles")); got != wantMetrics {
+			t.Errorf("%s: emitted %d SuspiciousFiles records, want %d", tt.name, got, wantMetrics)
+		}
+	}
+}
+
+func TestProcessFileRejectsFileOverEmailCap(t *testing.T) {
+	setVar(t, &maxEmailsPerFile, 5)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": manyEmailsCSV(6)}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestFailed)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v, want the file rejected without a retry", err)
+	}
+	if len(summaries) != 0 || repo.rowCount("transacciones") != 0 {
+		t.Errorf("processFile() = %d summaries, %d rows stored, want the file rejected", len(summaries), repo.rowCount("transacciones"))
+	}
+}
+
+func TestProcessFileIngestsFlaggedFileWithoutSummaries(t *testing.T) {
+	setVar(t, &maxEmailsPerFile, 5)
+	setVar(t, &maxEmailsPolicy, maxEmailsFlag)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": manyEmailsCSV(6)}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if len(summaries) != 0 || repo.rowCount("transacciones") != 6 {
+		t.Errorf("processFile() = %d summaries, %d rows stored, want all 6 rows and no summaries", len(summaries), repo.rowCount("transacciones"))
+	}
+}
+
+func TestLoadConfigRejectsUnknownMaxEmailsPolicy(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"MAX_EMAILS_POLICY": "drop"}); !strings.Contains(out, "Invalid value for MAX_EMAILS_POLICY") {
+		t.Errorf("loadConfig() output = %q, want MAX_EMAILS_POLICY rejected", out)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrValidation marks failures caused by bad input data, such as a malformed CSV.
+	ErrValidation = errors.New("validation error")
+	// ErrTransient marks infrastructure failures that may succeed if retried.
+	ErrTransient = errors.New("transient error")
+	// ErrFatal marks infrastructure failures that will not succeed if retried.
+	ErrFatal = errors.New("fatal error")
+
+	// errObjectNotFound reports that an S3 object named in an event no longer exists.
+	errObjectNotFound = errors.New("S3 object not found")
+	// errObjectRead reports that an S3 object body failed partway through being read.
+	errObjectRead = errors.New("error reading S3 object")
+)
+
+// classifiedError attaches one of the sentinel kinds to an underlying error
+// without altering its message.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// classify tags err with kind so that errors.Is(err, kind) reports true.
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind: kind, err: err}
+}
+
+// shouldRetry reports whether the Lambda invocation should fail so that the event is retried.
+func shouldRetry(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// stopped returns the error for work abandoned because ctx ended, typically as the
+// Lambda approaches its deadline; what describes where it stopped. It is transient,
+// so the event is retried by an invocation with time to finish.
+func stopped(ctx context.Context, what string) error {
+	return classify(ErrTransient, fmt.Errorf("stopped %s: %w", what, ctx.Err()))
+}
+
+// isRetryable reports whether err is a transient AWS error worth retrying.
+func isRetryable(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// isNotFound reports whether err is S3 saying the object does not exist (NoSuchKey or a bare 404).
+func isNotFound(err error) bool {
+	var noSuchKey *s3types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// classifyAWSError tags an AWS SDK error as transient when it is retryable and fatal otherwise.
+func classifyAWSError(err error) error {
+	if isRetryable(err) {
+		return classify(ErrTransient, err)
+	}
+	return classify(ErrFatal, err)
+}
+
+// classifyDBError tags a database error by its SQLSTATE class: data and integrity
+// violations are validation errors, connection and resource problems are transient,
+// and everything else (e.g. a missing table) is fatal.
+func classifyDBError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		// Errors outside the server protocol are network or driver level problems.
+		return classify(ErrTransient, err)
+	}
+	switch pqErr.Code.Class() {
+	case "22", "23":
+		return classify(ErrValidation, err)
+	case "08", "40", "53", "57":
+		return classify(ErrTransient, err)
+	default:
+		return classify(ErrFatal, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/lib/pq"
+)
+
+func TestClassifyDBError(t *testing.T) {
+	tests := []struct {
+		code pq.ErrorCode
+		want error
+	}{
+		{"22003", ErrValidation}, // numeric_value_out_of_range
+		{"23505", ErrValidation}, // unique_violation
+		{"08006", ErrTransient},  // connection_failure
+		{"40001", ErrTransient},  // serialization_failure
+		{"53300", ErrTransient},  // too_many_connections
+		{"57014", ErrTransient},  // query_canceled
+		{"42P01", ErrFatal},      // undefined_table
+	}
+	for _, tt := range tests {
+		err := classifyDBError(&pq.Error{Code: tt.code})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("classifyDBError(%s) = %v, want %v", tt.code, err, tt.want)
+		}
+	}
+	if err := classifyDBError(errors.New("connection reset")); !errors.Is(err, ErrTransient) {
+		t.Errorf("classifyDBError(driver error) = %v, want ErrTransient", err)
+	}
+}
+
+func TestShouldRetryOnlyTransientErrors(t *testing.T) {
+	if !shouldRetry(classify(ErrTransient, errors.New("db down"))) {
+		t.Error("shouldRetry(transient) = false, want true")
+	}
+	for _, kind := range []error{ErrValidation, ErrFatal} {
+		if shouldRetry(classify(kind, errors.New("bad"))) {
+			t.Errorf("shouldRetry(%v) = true, want false", kind)
+		}
+	}
+}
+
+func TestClassifyAWSError(t *testing.T) {
+	if err := classifyAWSError(&smithy.GenericAPIError{Code: "RequestTimeout"}); !errors.Is(err, ErrTransient) {
+		t.Errorf("classifyAWSError(RequestTimeout) = %v, want ErrTransient", err)
+	}
+	if err := classifyAWSError(&smithy.GenericAPIError{Code: "AccessDenied"}); !errors.Is(err, ErrFatal) {
+		t.Errorf("classifyAWSError(AccessDenied) = %v, want ErrFatal", err)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(&s3types.NoSuchKey{}) {
+		t.Error("isNotFound(NoSuchKey) = false, want true")
+	}
+	notFound := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusNotFound}},
+		Err:      errors.New("not found"),
+	}}
+	if !isNotFound(notFound) {
+		t.Error("isNotFound(404 response) = false, want true")
+	}
+	if isNotFound(errors.New("other")) {
+		t.Error("isNotFound(other) = true, want false")
+	}
+}
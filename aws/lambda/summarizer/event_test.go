@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHandleS3EventSkipsNonCreateEvents(t *testing.T) {
+	f := newFakeS3(map[string]string{
+		"bucket/new.csv":     "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n",
+		"bucket/deleted.csv": "id,date,transaction,email\n2,2024-01-06,+1,john@example.com\n",
+	})
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "new.csv")
+	expectLedgerWrite(mock, "bucket", "new.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "new.csv")
+
+	event := s3Event("bucket", "new.csv", "deleted.csv")
+	event.Records[1].EventName = "ObjectRemoved:Delete"
+	if err := handleS3Event(context.Background(), event); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want 1 for the created object", f.gets)
+	}
+	if got := n.emails(); len(got) != 1 || got[0] != "jane@example.com" {
+		t.Errorf("notified %v, want only jane@example.com", got)
+	}
+}
+
+func TestHandlerDecodesURLEncodedKey(t *testing.T) {
+	f := newFakeS3(map[string]string{"bucket/in/my file (1)%.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"})
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	useNotifier(t, &fakeNotifier{})
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "in/my file (1)%.csv")
+	expectLedgerWrite(mock, "bucket", "in/my file (1)%.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "in/my file (1)%.csv")
+
+	// S3 notifications encode a space as "+" and other special characters as %XX
+	payload := `{"Records": [{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "bucket"}, "object": {"key": "in/my+file+%281%29%25.csv"}}}]}`
+	if _, err := handler(context.Background(), json.RawMessage(payload)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want 1 for the decoded key", f.gets)
+	}
+	if got := repo.sources; len(got) != 1 || got[0] != "s3://bucket/in/my file (1)%.csv" {
+		t.Errorf("inserted %v, want s3://bucket/in/my file (1)%%.csv", got)
+	}
+}
+
+func TestObjectKeyPrefersDecodedKey(t *testing.T) {
+	var record events.S3EventRecord
+	record.S3.Object.Key = "in/my+file.csv"
+	if got := objectKey(record); got != "in/my+file.csv" {
+		t.Errorf("objectKey() = %q, want the raw key when no decoded key is set", got)
+	}
+	record.S3.Object.URLDecodedKey = "in/my file.csv"
+	if got := objectKey(record); got != "in/my file.csv" {
+		t.Errorf("objectKey() = %q, want the decoded key", got)
+	}
+}
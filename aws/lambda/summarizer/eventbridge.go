@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// eventBridgeBatchSize is the most entries PutEvents accepts in one call.
+const eventBridgeBatchSize = 10
+
+// eventBridgePutAPI is the subset of the EventBridge client used by eventBridgeNotifier.
+type eventBridgePutAPI interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// eventBridgeNotifier publishes one event per account summary to an event bus,
+// for downstream integrations such as analytics or a CRM.
+type eventBridgeNotifier struct {
+	client     eventBridgePutAPI
+	busName    string
+	source     string
+	detailType string
+}
+
+func (n *eventBridgeNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	summaries, err := payloadSummaries(payload)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(summaries); start += eventBridgeBatchSize {
+		batch := summaries[start:min(start+eventBridgeBatchSize, len(summaries))]
+		entries := make([]ebtypes.PutEventsRequestEntry, 0, len(batch))
+		for _, summary := range batch {
+			detail, err := json.Marshal(summaryForOutput(summary))
+			if err != nil {
+				return classify(ErrFatal, fmt.Errorf("error serializing summary: %w", err))
+			}
+			entries = append(entries, ebtypes.PutEventsRequestEntry{
+				EventBusName: aws.String(n.busName),
+				Source:       aws.String(n.source),
+				DetailType:   aws.String(n.detailType),
+				Detail:       aws.String(string(detail)),
+			})
+		}
+
+		output, err := n.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return classifyAWSError(fmt.Errorf("error putting events to bus %s: %w", n.busName, err))
+		}
+		if output.FailedEntryCount > 0 {
+			for _, entry := range output.Entries {
+				if entry.ErrorCode != nil {
+					log.Printf("EventBridge entry rejected: %s: %s", aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+				}
+			}
+			return classify(ErrTransient, fmt.Errorf("%d of %d events rejected by bus %s", output.FailedEntryCount, len(entries), n.busName))
+		}
+	}
+
+	log.Printf("Published %d summary events to EventBridge bus %s", len(summaries), n.busName)
+	return nil
+}
+
+// payloadSummaries returns the summaries of a payload, decompressing them when the
+// payload is gzip encoded.
+func payloadSummaries(payload NotificationPayload) ([]*AccountSummary, error) {
+	if payload.PayloadEncoding != payloadEncodingGzip {
+		return payload.Summaries, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload.Data))
+	if err != nil {
+		return nil, classify(ErrFatal, fmt.Errorf("error decompressing payload: %w", err))
+	}
+	defer zr.Close()
+	var summaries []*AccountSummary
+	if err := json.NewDecoder(zr).Decode(&summaries); err != nil {
+		return nil, classify(ErrFatal, fmt.Errorf("error decoding payload: %w", err))
+	}
+	return summaries, nil
+}
+
+// multiNotifier delivers the same payload to several notifiers, in order.
+// Every notifier is tried; their errors are joined. Each claims the payload on its
+// own, so a retry does not deliver it again to the notifiers that succeeded.
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	var errs []error
+	for i, n := range m {
+		if err := deliverOnce(ctx, n, fmt.Sprintf("/%d", i), payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+)
+
+// fakeEventBridge is an eventBridgePutAPI that records the entries it is given and
+// rejects the entries whose index in a call is in reject.
+type fakeEventBridge struct {
+	calls  [][]ebtypes.PutEventsRequestEntry
+	reject map[int]bool
+}
+
+func (f *fakeEventBridge) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, _ ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	f.calls = append(f.calls, in.Entries)
+	out := &eventbridge.PutEventsOutput{Entries: make([]ebtypes.PutEventsResultEntry, len(in.Entries))}
+	for i := range in.Entries {
+		if f.reject[i] {
+			out.FailedEntryCount++
+			out.Entries[i] = ebtypes.PutEventsResultEntry{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("try again")}
+		}
+	}
+	return out, nil
+}
+
+func TestEventBridgeNotifierPublishesOneEventPerSummary(t *testing.T) {
+	client := &fakeEventBridge{}
+	n := &eventBridgeNotifier{client: client, busName: "summaries", source: "acme.summarizer", detailType: "AccountSummary"}
+	var summaries []*AccountSummary
+	for i := range 12 {
+		summaries = append(summaries, &AccountSummary{Email: fmt.Sprintf("user%d@example.com", i), TotalBalance: float64(i)})
+	}
+
+	if err := n.Notify(context.Background(), NotificationPayload{Summaries: summaries}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.calls) != 2 || len(client.calls[0]) != eventBridgeBatchSize || len(client.calls[1]) != 2 {
+		t.Fatalf("PutEvents batches = %d, want 10 then 2 entries", len(client.calls))
+	}
+	entry := client.calls[1][1]
+	if aws.ToString(entry.EventBusName) != "summaries" || aws.ToString(entry.Source) != "acme.summarizer" || aws.ToString(entry.DetailType) != "AccountSummary" {
+		t.Errorf("entry bus = %s, source = %s, detail type = %s", aws.ToString(entry.EventBusName), aws.ToString(entry.Source), aws.ToString(entry.DetailType))
+	}
+	var detail AccountSummary
+	if err := json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail); err != nil {
+		t.Fatalf("detail is not a serialized summary: %v", err)
+	}
+	if detail.Email != "user11@example.com" || detail.TotalBalance != 11 {
+		t.Errorf("detail = %+v, want the summary of user11@example.com", detail)
+	}
+}
+
+func TestEventBridgeNotifierReportsRejectedEntriesAsTransient(t *testing.T) {
+	n := &eventBridgeNotifier{client: &fakeEventBridge{reject: map[int]bool{1: true}}, busName: "summaries"}
+	payload := NotificationPayload{Summaries: []*AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}}}
+
+	if err := n.Notify(context.Background(), payload); !errors.Is(err, ErrTransient) {
+		t.Errorf("Notify() = %v, want ErrTransient", err)
+	}
+}
+
+func TestMultiNotifierRetryOnlyRedeliversToFailedNotifier(t *testing.T) {
+	setVar(t, &notifyDedupeTTL, time.Hour)
+	email := &fakeNotifier{}
+	client := &fakeEventBridge{reject: map[int]bool{0: true}}
+	useNotifier(t, multiNotifier{email, &eventBridgeNotifier{client: client, busName: "summaries"}})
+	db, mock := newMockDB(t)
+	// The first attempt delivers the email but not the event, whose claim is released
+	expectClaim(mock, true)
+	expectClaim(mock, true)
+	mock.ExpectExec("DELETE FROM notification_dedupe").WillReturnResult(sqlmock.NewResult(0, 1))
+	// The retry finds the email already claimed
+	expectClaim(mock, false)
+	expectClaim(mock, true)
+
+	summaries := []*AccountSummary{{Email: "jane@example.com"}}
+	if err := notifyOnce(context.Background(), db, summaries); !shouldRetry(err) {
+		t.Fatalf("notifyOnce() = %v, want a retryable error", err)
+	}
+	client.reject = nil
+	if err := notifyOnce(context.Background(), db, summaries); err != nil {
+		t.Fatalf("notifyOnce() retry error = %v", err)
+	}
+	if len(email.payloads) != 1 {
+		t.Errorf("emailed %d times, want once across the retry", len(email.payloads))
+	}
+	if len(client.calls) != 2 {
+		t.Errorf("PutEvents called %d times, want the failed event published again", len(client.calls))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// recentObjects remembers when each s3://bucket/key was last processed by this
+// container, to drop the duplicate notifications S3 occasionally sends in bursts.
+// It is per container, so duplicates delivered to another container are not caught.
+var recentObjects = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: make(map[string]time.Time)}
+
+// seenRecently reports whether bucket/key was seen within S3_EVENT_DEDUPE_WINDOW,
+// and records it as seen now. It always returns false when the window is 0.
+func seenRecently(bucket, key string) bool {
+	if eventDedupeWindow <= 0 {
+		return false
+	}
+
+	now := clock()
+	id := bucket + "/" + key
+
+	recentObjects.Lock()
+	defer recentObjects.Unlock()
+
+	// Expire old entries so the cache stays small in long-lived containers
+	for k, t := range recentObjects.seen {
+		if now.Sub(t) >= eventDedupeWindow {
+			delete(recentObjects.seen, k)
+		}
+	}
+
+	if _, ok := recentObjects.seen[id]; ok {
+		return true
+	}
+	recentObjects.seen[id] = now
+	return false
+}
+
+// forgetObject removes bucket/key from the cache so a retry of it is processed.
+func forgetObject(bucket, key string) {
+	recentObjects.Lock()
+	defer recentObjects.Unlock()
+	delete(recentObjects.seen, bucket+"/"+key)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// resetRecentObjects empties the duplicate-event cache for the duration of the test.
+func resetRecentObjects(t *testing.T) {
+	t.Helper()
+	recentObjects.Lock()
+	recentObjects.seen = make(map[string]time.Time)
+	recentObjects.Unlock()
+	t.Cleanup(func() {
+		recentObjects.Lock()
+		recentObjects.seen = make(map[string]time.Time)
+		recentObjects.Unlock()
+	})
+}
+
+func TestSeenRecently(t *testing.T) {
+	resetRecentObjects(t)
+	setVar(t, &eventDedupeWindow, time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	setVar(t, &clock, func() time.Time { return now })
+
+	if seenRecently("bucket", "file.csv") {
+		t.Fatal("seenRecently() = true for the first notification")
+	}
+	now = now.Add(30 * time.Second)
+	if !seenRecently("bucket", "file.csv") {
+		t.Error("seenRecently() = false within the window, want the duplicate caught")
+	}
+	if seenRecently("bucket", "other.csv") || seenRecently("other", "file.csv") {
+		t.Error("seenRecently() = true for a different object")
+	}
+	now = now.Add(time.Minute)
+	if seenRecently("bucket", "file.csv") {
+		t.Error("seenRecently() = true after the window, want it expired")
+	}
+
+	forgetObject("bucket", "file.csv")
+	if seenRecently("bucket", "file.csv") {
+		t.Error("seenRecently() = true after forgetObject()")
+	}
+}
+
+func TestSeenRecentlyDisabledByDefault(t *testing.T) {
+	resetRecentObjects(t)
+	setVar(t, &eventDedupeWindow, 0)
+	if seenRecently("bucket", "file.csv") || seenRecently("bucket", "file.csv") {
+		t.Error("seenRecently() = true with S3_EVENT_DEDUPE_WINDOW unset")
+	}
+}
+
+func TestHandleS3EventSkipsDuplicateWithinWindow(t *testing.T) {
+	resetRecentObjects(t)
+	setVar(t, &eventDedupeWindow, time.Minute)
+	f := newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"})
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "file.csv")
+	m := captureMetrics(t)
+
+	for i := 0; i < 2; i++ {
+		if err := handleS3Event(context.Background(), s3Event("bucket", "file.csv")); err != nil {
+			t.Fatalf("handleS3Event() #%d error = %v", i+1, err)
+		}
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want 1 with the duplicate skipped", f.gets)
+	}
+	if got := n.emails(); len(got) != 1 || got[0] != "jane@example.com" {
+		t.Errorf("notified %v, want jane@example.com once", got)
+	}
+	if got := len(m.records(t, "DuplicateEventsSkipped")); got != 1 {
+		t.Errorf("emitted %d DuplicateEventsSkipped records, want 1", got)
+	}
+}
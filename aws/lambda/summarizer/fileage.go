@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// manualReprocessKey is the context key set while processing a manual reprocess request.
+type manualReprocessKey struct{}
+
+// withManualReprocess marks ctx as processing a file ops asked to reprocess.
+func withManualReprocess(ctx context.Context) context.Context {
+	return context.WithValue(ctx, manualReprocessKey{}, true)
+}
+
+// isManualReprocess reports whether ctx is processing a manual reprocess request.
+func isManualReprocess(ctx context.Context) bool {
+	manual, _ := ctx.Value(manualReprocessKey{}).(bool)
+	return manual
+}
+
+// isStaleObject reports whether the object was last modified more than maxFileAge
+// ago, so an old file that was accidentally re-uploaded or re-notified is not ingested
+// again. It always returns false when MAX_FILE_AGE is 0 and for manual reprocessing,
+// which is deliberate.
+func isStaleObject(ctx context.Context, bucket, key string) (bool, error) {
+	if maxFileAge <= 0 || isManualReprocess(ctx) {
+		return false, nil
+	}
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return false, classify(ErrFatal, fmt.Errorf("%w: s3://%s/%s: %w", errObjectNotFound, bucket, key, err))
+	}
+	if err != nil {
+		return false, classifyAWSError(fmt.Errorf("error reading S3 object metadata: %w", err))
+	}
+
+	age := clock().Sub(aws.ToTime(head.LastModified))
+	if age <= maxFileAge {
+		return false, nil
+	}
+	log.Printf("Skipping stale file s3://%s/%s: last modified %s ago, more than %s", bucket, key, age.Round(1e9), maxFileAge)
+	emitMetric("StaleFilesSkipped", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+	return true, nil
+}
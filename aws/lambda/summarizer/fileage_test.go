@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// headModifiedAt makes f report every object as last modified at t.
+func headModifiedAt(f *fakeS3, t time.Time) {
+	f.head = func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		return &s3.HeadObjectOutput{ETag: aws.String(`"etag-` + *in.Key + `"`), LastModified: aws.Time(t)}, nil
+	}
+}
+
+func TestProcessFileSkipsStaleObject(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	setVar(t, &clock, func() time.Time { return now })
+	setVar(t, &maxFileAge, 7*24*time.Hour)
+	f := newFakeS3(map[string]string{"bucket/old.csv": "id,date,transaction,email\n1,2024-01-05,+10,jane@example.com\n"})
+	headModifiedAt(f, now.AddDate(0, 0, -30))
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "old.csv")
+	expectLedgerWrite(mock, "bucket", "old.csv", ingestSkipped)
+	m := captureMetrics(t)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "old.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v, want the file skipped", err)
+	}
+	if len(summaries) != 0 || f.gets != 0 || repo.rowCount("transacciones") != 0 {
+		t.Errorf("processFile() = %d summaries, %d reads, %d rows stored, want the file untouched", len(summaries), f.gets, repo.rowCount("transacciones"))
+	}
+	if got := len(m.records(t, "StaleFilesSkipped")); got != 1 {
+		t.Errorf("emitted %d StaleFilesSkipped records, want 1", got)
+	}
+}
+
+func TestIsStaleObject(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	setVar(t, &clock, func() time.Time { return now })
+	f := newFakeS3(nil)
+	useS3(t, f)
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		modified time.Time
+		manual   bool
+		want     bool
+	}{
+		{"disabled", 0, now.AddDate(-1, 0, 0), false, false},
+		{"recent", time.Hour, now.Add(-time.Minute), false, false},
+		{"stale", time.Hour, now.Add(-2 * time.Hour), false, true},
+		{"manual reprocess", time.Hour, now.Add(-2 * time.Hour), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxFileAge, tt.maxAge)
+			headModifiedAt(f, tt.modified)
+			ctx := context.Background()
+			if tt.manual {
+				ctx = withManualReprocess(ctx)
+			}
+			got, err := isStaleObject(ctx, "bucket", "file.csv")
+			if err != nil || got != tt.want {
+				t.Errorf("isStaleObject() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"log"
+)
+
+// fileLockID returns the Postgres advisory lock key of an object: a 64-bit FNV-1a
+// hash of its bucket and key, so every container derives the same key.
+func fileLockID(bucket, key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(bucket + "/" + key))
+	return int64(h.Sum64())
+}
+
+// lockFile takes a session-level advisory lock on an object so that two containers
+// notified about the same upload do not both ingest it. It returns ok=false when
+// another session holds the lock; the caller then skips the file. The returned
+// release function unlocks and returns the connection, and must be called when
+// ok is true. With FILE_LOCK disabled it always succeeds without touching the database.
+//
+// The lock lives on its own connection for the whole file, so each file in flight
+// uses one connection on top of those of its inserts.
+func lockFile(ctx context.Context, db *sql.DB, bucket, key string) (release func(), ok bool, err error) {
+	if !fileLock {
+		return func() {}, true, nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, classifyDBError(fmt.Errorf("error getting connection for file lock: %w", err))
+	}
+
+	id := fileLockID(bucket, key)
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, id).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, classifyDBError(fmt.Errorf("error taking file lock: %w", err))
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	release = func() {
+		// The lock must be released even when the invocation's context is done,
+		// or the pooled connection would keep holding it
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, id); err != nil {
+			log.Printf("Warning: error releasing file lock for s3://%s/%s: %v", bucket, key, err)
+			// Discard the connection so the session, and with it the lock, ends
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return release, true, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFileLockIDIsStablePerObject(t *testing.T) {
+	if fileLockID("bucket", "file.csv") != fileLockID("bucket", "file.csv") {
+		t.Error("fileLockID() differs between calls for the same object")
+	}
+	if fileLockID("bucket", "file.csv") == fileLockID("bucket", "other.csv") {
+		t.Error("fileLockID() is the same for different keys")
+	}
+	if fileLockID("bucket", "file.csv") == fileLockID("other", "file.csv") {
+		t.Error("fileLockID() is the same for different buckets")
+	}
+}
+
+func TestLockFileDisabledSkipsDatabase(t *testing.T) {
+	setVar(t, &fileLock, false)
+	conn, _ := newMockDB(t)
+	release, ok, err := lockFile(context.Background(), conn, "bucket", "file.csv")
+	if err != nil || !ok {
+		t.Fatalf("lockFile() = %v, %v, want the lock without a query", ok, err)
+	}
+	release()
+}
+
+func TestLockFileReleasesLock(t *testing.T) {
+	setVar(t, &fileLock, true)
+	conn, mock := newMockDB(t)
+	id := fileLockID("bucket", "file.csv")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_try_advisory_lock($1)")).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	release, ok, err := lockFile(context.Background(), conn, "bucket", "file.csv")
+	if err != nil || !ok {
+		t.Fatalf("lockFile() = %v, %v, want the lock", ok, err)
+	}
+	release()
+}
+
+// TestProcessFileSkipsLockedFile simulates two containers notified about the same
+// upload: while the first holds the object's lock, the second skips the file.
+func TestProcessFileSkipsLockedFile(t *testing.T) {
+	setVar(t, &fileLock, true)
+	f := newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"})
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	conn, mock := newMockDB(t)
+	id := fileLockID("bucket", "file.csv")
+	lock := regexp.QuoteMeta("SELECT pg_try_advisory_lock($1)")
+	mock.ExpectQuery(lock).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery(lock).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := context.Background()
+	release, ok, err := lockFile(ctx, conn, "bucket", "file.csv")
+	if err != nil || !ok {
+		t.Fatalf("first lockFile() = %v, %v, want the lock", ok, err)
+	}
+	summaries, err := processFile(ctx, conn, "bucket", "file.csv", sql.NullInt64{})
+	release()
+	if err != nil || summaries != nil {
+		t.Fatalf("processFile() = %v, %v, want the locked file skipped", summaries, err)
+	}
+	if f.gets != 0 || len(repo.sources) != 0 {
+		t.Errorf("GetObject calls = %d, inserted %v, want the locked file untouched", f.gets, repo.sources)
+	}
+}
+
+func TestLoadConfigRejectsTooFewConnectionsForFileLock(t *testing.T) {
+	env := map[string]string{"FILE_LOCK": "true", "RECORD_CONCURRENCY": "2", "INSERT_CONCURRENCY": "2", "DB_MAX_OPEN_CONNS": "5"}
+	if out := loadConfigError(t, env); !strings.Contains(out, "FILE_LOCK needs at least 6 connections") {
+		t.Errorf("loadConfig() output = %q, want the minimum connection count", out)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// FileSummary is an operational rollup of one ingested file across all accounts.
+type FileSummary struct {
+	Bucket           string  `json:"bucket"`
+	Key              string  `json:"key"`
+	TotalRows        int     `json:"total_rows"`
+	DistinctAccounts int     `json:"distinct_accounts"`
+	NetChange        float64 `json:"net_change"`
+	TotalCredits     float64 `json:"total_credits"`
+	TotalDebits      float64 `json:"total_debits"`
+}
+
+// computeFileSummary aggregates the ingested rows of a file. Debits are summed as negative amounts.
+func computeFileSummary(bucket, key string, rows []csvRow) FileSummary {
+	fs := FileSummary{Bucket: bucket, Key: key, TotalRows: len(rows)}
+	accounts := make(map[string]struct{})
+
+	for _, row := range rows {
+		if email := strings.TrimSpace(schema.field(row.Fields, "email")); email != "" {
+			accounts[email] = struct{}{}
+		}
+
+		amount, err := strconv.ParseFloat(strings.TrimSpace(schema.field(row.Fields, "transaction")), 64)
+		if err != nil {
+			continue
+		}
+		fs.NetChange += amount
+		if amount >= 0 {
+			fs.TotalCredits += amount
+		} else {
+			fs.TotalDebits += amount
+		}
+	}
+
+	fs.DistinctAccounts = len(accounts)
+	return fs
+}
+
+// logFileSummary writes the rollup as a single JSON log line so it can be queried in CloudWatch.
+func logFileSummary(fs FileSummary) {
+	data, err := json.Marshal(fs)
+	if err != nil {
+		log.Printf("Error serializing file summary: %v", err)
+		return
+	}
+	log.Printf("File summary: %s", data)
+}
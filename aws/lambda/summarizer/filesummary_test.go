@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestComputeFileSummary(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-01-06", "-10.3", "jane@example.com"}},
+		{Line: 4, Fields: []string{"3", "2024-01-07", "+20", "john@example.com"}},
+		{Line: 5, Fields: []string{"4", "2024-02-01", "-20.46", " john@example.com "}},
+		{Line: 6, Fields: []string{"5", "2024-02-02", "+10", ""}},
+	}
+
+	got := computeFileSummary("bucket", "file.csv", rows)
+	want := FileSummary{
+		Bucket:           "bucket",
+		Key:              "file.csv",
+		TotalRows:        5,
+		DistinctAccounts: 2,
+		NetChange:        59.74,
+		TotalCredits:     90.5,
+		TotalDebits:      -30.76,
+	}
+	if got.Bucket != want.Bucket || got.Key != want.Key || got.TotalRows != want.TotalRows || got.DistinctAccounts != want.DistinctAccounts {
+		t.Errorf("computeFileSummary() = %+v, want %+v", got, want)
+	}
+	for name, pair := range map[string][2]float64{
+		"NetChange":    {got.NetChange, want.NetChange},
+		"TotalCredits": {got.TotalCredits, want.TotalCredits},
+		"TotalDebits":  {got.TotalDebits, want.TotalDebits},
+	} {
+		if diff := pair[0] - pair[1]; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+}
+
+func TestComputeFileSummaryEmptyFile(t *testing.T) {
+	got := computeFileSummary("bucket", "empty.csv", nil)
+	if got != (FileSummary{Bucket: "bucket", Key: "empty.csv"}) {
+		t.Errorf("computeFileSummary() = %+v, want an empty rollup", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// flaggedNotifier receives flagged summaries instead of the regular notifier;
+// it is nil unless FLAGGED_NOTIFY_TARGET is configured.
+var flaggedNotifier Notifier
+
+// flagHighVolume marks the summaries whose total or any monthly transaction count
+// exceeds txnCountThreshold and returns them. Nothing is flagged when the threshold is 0.
+func flagHighVolume(summaries []*AccountSummary) []*AccountSummary {
+	if txnCountThreshold <= 0 {
+		return nil
+	}
+
+	var flagged []*AccountSummary
+	for _, s := range summaries {
+		total, busiest := 0, MonthlySummary{}
+		for _, m := range summaryMonths(s) {
+			total += m.TransactionCount
+			if m.TransactionCount > busiest.TransactionCount {
+				busiest = m
+			}
+		}
+
+		switch {
+		case busiest.TransactionCount > txnCountThreshold:
+			s.FlagReason = fmt.Sprintf("%d transactions in %s exceeds threshold %d", busiest.TransactionCount, busiest.Month, txnCountThreshold)
+		case total > txnCountThreshold:
+			s.FlagReason = fmt.Sprintf("%d transactions exceeds threshold %d", total, txnCountThreshold)
+		default:
+			continue
+		}
+		s.Flagged = true
+		log.Printf("Flagged account %s: %s", maskEmail(s.Email), s.FlagReason)
+		emitMetric("FlaggedAccounts", 1, nil, map[string]string{"Reason": s.FlagReason})
+		flagged = append(flagged, s)
+	}
+	return flagged
+}
+
+// summaryMonths returns every monthly summary of an account, across currencies when
+// the summary is broken down by currency.
+func summaryMonths(s *AccountSummary) []MonthlySummary {
+	if len(s.Currencies) == 0 {
+		return s.MonthlySummaries
+	}
+	var months []MonthlySummary
+	for _, c := range s.Currencies {
+		months = append(months, c.MonthlySummaries...)
+	}
+	return months
+}
+
+// withoutFlagged returns summaries minus those that are flagged.
+func withoutFlagged(summaries []*AccountSummary) []*AccountSummary {
+	regular := make([]*AccountSummary, 0, len(summaries))
+	for _, s := range summaries {
+		if !s.Flagged {
+			regular = append(regular, s)
+		}
+	}
+	return regular
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlagHighVolumeFlagsBusyAccounts(t *testing.T) {
+	setVar(t, &txnCountThreshold, 10)
+	m := captureMetrics(t)
+	busyMonth := &AccountSummary{Email: "busy@example.com", MonthlySummaries: []MonthlySummary{
+		{Month: "2024-01", TransactionCount: 3},
+		{Month: "2024-02", TransactionCount: 11},
+	}}
+	busyTotal := &AccountSummary{Email: "steady@example.com", Currencies: []CurrencyBreakdown{
+		{Currency: "USD", MonthlySummaries: []MonthlySummary{{Month: "2024-01", TransactionCount: 6}}},
+		{Currency: "EUR", MonthlySummaries: []MonthlySummary{{Month: "2024-01", TransactionCount: 5}}},
+	}}
+	quiet := &AccountSummary{Email: "quiet@example.com", MonthlySummaries: []MonthlySummary{
+		{Month: "2024-01", TransactionCount: 10},
+	}}
+
+	flagged := flagHighVolume([]*AccountSummary{busyMonth, busyTotal, quiet})
+	if len(flagged) != 2 || flagged[0] != busyMonth || flagged[1] != busyTotal {
+		t.Fatalf("flagHighVolume() = %v, want the two busy accounts", flagged)
+	}
+	if !busyMonth.Flagged || !strings.Contains(busyMonth.FlagReason, "2024-02") {
+		t.Errorf("busy month: Flagged = %v, FlagReason = %q, want a flag naming 2024-02", busyMonth.Flagged, busyMonth.FlagReason)
+	}
+	if !busyTotal.Flagged || !strings.HasPrefix(busyTotal.FlagReason, "11 transactions") {
+		t.Errorf("busy total: Flagged = %v, FlagReason = %q, want a flag for 11 transactions", busyTotal.Flagged, busyTotal.FlagReason)
+	}
+	if quiet.Flagged || quiet.FlagReason != "" {
+		t.Errorf("quiet account flagged at the threshold: %q", quiet.FlagReason)
+	}
+	if got := len(m.records(t, "FlaggedAccounts")); got != 2 {
+		t.Errorf("emitted %d FlaggedAccounts records, want 2", got)
+	}
+
+	regular := withoutFlagged([]*AccountSummary{busyMonth, busyTotal, quiet})
+	if len(regular) != 1 || regular[0] != quiet {
+		t.Errorf("withoutFlagged() = %v, want only the quiet account", regular)
+	}
+}
+
+func TestFlagHighVolumeDisabledByDefault(t *testing.T) {
+	setVar(t, &txnCountThreshold, 0)
+	s := &AccountSummary{Email: "busy@example.com", MonthlySummaries: []MonthlySummary{{Month: "2024-01", TransactionCount: 1000}}}
+	if flagged := flagHighVolume([]*AccountSummary{s}); len(flagged) != 0 || s.Flagged {
+		t.Errorf("flagHighVolume() = %v, want nothing flagged without a threshold", flagged)
+	}
+}
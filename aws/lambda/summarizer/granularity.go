@@ -0,0 +1,32 @@
+package main
+
+// Supported values for SUMMARY_GRANULARITY.
+const (
+	granularityDay   = "day"
+	granularityWeek  = "week"
+	granularityMonth = "month"
+)
+
+// periodLabels is the TO_CHAR format that labels a period of each granularity:
+// the month name as before, the ISO week (e.g. 2024-W07) or the date.
+var periodLabels = map[string]string{
+	granularityDay:   "YYYY-MM-DD",
+	granularityWeek:  `IYYY-"W"IW`,
+	granularityMonth: "FMMonth",
+}
+
+// localDateExpr is the transaction date as wall-clock time in REPORT_TIMEZONE. Grouping
+// on it rather than on date keeps buckets independent of the server's TimeZone setting.
+func localDateExpr() string {
+	return "(date AT TIME ZONE '" + reportTimezone + "')"
+}
+
+// periodExpr truncates the transaction date to the start of its summary period.
+func periodExpr() string {
+	return "DATE_TRUNC('" + summaryGranularity + "', " + localDateExpr() + ")"
+}
+
+// periodLabelExpr renders the label of the transaction's summary period.
+func periodLabelExpr() string {
+	return "TO_CHAR(" + localDateExpr() + ", '" + periodLabels[summaryGranularity] + "')"
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPeriodExpressions(t *testing.T) {
+	tests := []struct {
+		granularity string
+		period      string
+		label       string
+	}{
+		{granularityDay, "DATE_TRUNC('day', (date AT TIME ZONE 'UTC'))", "TO_CHAR((date AT TIME ZONE 'UTC'), 'YYYY-MM-DD')"},
+		{granularityWeek, "DATE_TRUNC('week', (date AT TIME ZONE 'UTC'))", `TO_CHAR((date AT TIME ZONE 'UTC'), 'IYYY-"W"IW')`},
+		{granularityMonth, "DATE_TRUNC('month', (date AT TIME ZONE 'UTC'))", "TO_CHAR((date AT TIME ZONE 'UTC'), 'FMMonth')"},
+	}
+	for _, tt := range tests {
+		setVar(t, &summaryGranularity, tt.granularity)
+		if got := periodExpr(); got != tt.period {
+			t.Errorf("%s: periodExpr() = %q, want %q", tt.granularity, got, tt.period)
+		}
+		if got := periodLabelExpr(); got != tt.label {
+			t.Errorf("%s: periodLabelExpr() = %q, want %q", tt.granularity, got, tt.label)
+		}
+	}
+}
+
+func TestGetTransactionSummaryBucketsByGranularity(t *testing.T) {
+	tests := []struct {
+		granularity string
+		labels      []string
+		periods     []time.Time
+	}{
+		{granularityWeek, []string{"2024-W01", "2024-W02"}, []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)}},
+		{granularityDay, []string{"2024-01-05", "2024-01-06"}, []time.Time{time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity, func(t *testing.T) {
+			setVar(t, &summaryGranularity, tt.granularity)
+			db, mock := newMockDB(t)
+			// Periods are grouped, labeled and compared for adjacency in the granularity's unit
+			query := regexp.QuoteMeta(periodLabelExpr()+" AS month") + "(.|\\n)*" +
+				regexp.QuoteMeta("= "+periodExpr()+" - INTERVAL '1 "+tt.granularity+"' AS prev_adjacent") + "(.|\\n)*" +
+				regexp.QuoteMeta("ORDER BY "+periodExpr())
+			mock.ExpectQuery(query).WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+				monthRow{month: tt.labels[0], credits: []float64{10}, balance: "10", period: tt.periods[0]},
+				monthRow{month: tt.labels[1], debits: []float64{4}, balance: "-4", prevBal: "10", period: tt.periods[1]},
+			))
+
+			summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range summary.MonthlySummaries {
+				got = append(got, m.Month)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.labels, ",") {
+				t.Errorf("periods = %v, want %v", got, tt.labels)
+			}
+			if summary.TotalBalance != 6 {
+				t.Errorf("balance = %v, want 6", summary.TotalBalance)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRejectsUnknownGranularity(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"SUMMARY_GRANULARITY": "quarter"}); !strings.Contains(out, "Invalid value for SUMMARY_GRANULARITY") {
+		t.Errorf("loadConfig() output = %q, want SUMMARY_GRANULARITY rejected", out)
+	}
+}
+
+func TestLocalDateExprUsesReportTimezone(t *testing.T) {
+	for zone, want := range map[string]string{
+		"UTC":              "(date AT TIME ZONE 'UTC')",
+		"America/New_York": "(date AT TIME ZONE 'America/New_York')",
+	} {
+		setVar(t, &reportTimezone, zone)
+		if got := localDateExpr(); got != want {
+			t.Errorf("localDateExpr() in %s = %q, want %q", zone, got, want)
+		}
+	}
+}
+
+// TestReportTimezoneBucketsMonthBoundary shows why dates are converted before DATE_TRUNC:
+// the same instant near midnight falls in a different month in each zone.
+func TestReportTimezoneBucketsMonthBoundary(t *testing.T) {
+	instant := time.Date(2024, 2, 1, 3, 30, 0, 0, time.UTC)
+	tests := []struct {
+		zone string
+		want string
+	}{
+		{"UTC", "February"},
+		{"America/New_York", "January"},
+	}
+	for _, tt := range tests {
+		setVar(t, &reportTimezone, tt.zone)
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta("DATE_TRUNC('month', (date AT TIME ZONE '"+tt.zone+"'))")).
+			WithArgs("jane@example.com", nil, nil).
+			WillReturnRows(summaryRows(monthRow{month: tt.want, credits: []float64{10}, balance: "10", period: bucketStart(t, instant, tt.zone)}))
+
+		summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summary.MonthlySummaries) != 1 || summary.MonthlySummaries[0].Month != tt.want {
+			t.Errorf("%s: months = %+v, want %s", tt.zone, summary.MonthlySummaries, tt.want)
+		}
+		if got := bucketStart(t, instant, tt.zone).Month().String(); got != tt.want {
+			t.Errorf("%s: %s falls in %s, want %s", tt.zone, instant, got, tt.want)
+		}
+	}
+}
+
+// bucketStart returns the start of the month of instant as wall-clock time in zone,
+// which is what DATE_TRUNC('month', date AT TIME ZONE zone) returns.
+func bucketStart(t *testing.T, instant time.Time, zone string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := instant.In(loc)
+	return time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestGetAccountTransactionsDatesInReportTimezone(t *testing.T) {
+	setVar(t, &reportTimezone, "America/New_York")
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT TO_CHAR((date AT TIME ZONE 'America/New_York'), 'YYYY-MM-DD')")).
+		WithArgs("jane@example.com", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "amount", "currency"}).AddRow("2024-01-31", 10.0, ""))
+
+	transactions, err := getAccountTransactions(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 1 || transactions[0].Date != "2024-01-31" {
+		t.Errorf("transactions = %+v, want one dated 2024-01-31", transactions)
+	}
+}
+
+func TestLoadConfigRejectsInvalidReportTimezone(t *testing.T) {
+	for _, zone := range []string{"Mars/Olympus_Mons", "Local", "UTC'; --"} {
+		if out := loadConfigError(t, map[string]string{"REPORT_TIMEZONE": zone}); !strings.Contains(out, "Invalid value for REPORT_TIMEZONE") {
+			t.Errorf("loadConfig() with REPORT_TIMEZONE %q output = %q, want it rejected", zone, out)
+		}
+	}
+}
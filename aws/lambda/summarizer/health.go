@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// healthMode is the value of "mode" in a health check invocation.
+const healthMode = "health"
+
+// Values of HealthCheck.Status and HealthReport.Status.
+const (
+	healthOK       = "ok"
+	healthFailed   = "failed"
+	healthSkipped  = "skipped"
+	healthDegraded = "degraded"
+)
+
+// HealthCheck is the outcome of one dependency check.
+type HealthCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// HealthReport is the result of a health check invocation: ok when no check failed,
+// degraded otherwise.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// s3HeadBucketAPI is the subset of the S3 client used by the health check.
+type s3HeadBucketAPI interface {
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// sesQuotaAPI is the subset of the SES client used by the health check.
+type sesQuotaAPI interface {
+	GetSendQuota(ctx context.Context, params *ses.GetSendQuotaInput, optFns ...func(*ses.Options)) (*ses.GetSendQuotaOutput, error)
+}
+
+var (
+	healthS3  s3HeadBucketAPI
+	healthSES sesQuotaAPI
+)
+
+// isHealthRequest reports whether payload is {"mode": "health"}.
+func isHealthRequest(payload json.RawMessage) bool {
+	var probe struct {
+		Mode string `json:"mode"`
+	}
+	return json.Unmarshal(payload, &probe) == nil && probe.Mode == healthMode
+}
+
+// checkHealth runs the dependency checks concurrently, each bounded by
+// HEALTH_CHECK_TIMEOUT, and aggregates them into a report. A check with nothing
+// configured to probe (no bucket) is skipped.
+func checkHealth(ctx context.Context) *HealthReport {
+	checks := []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"s3", func(ctx context.Context) error {
+			_, err := healthS3.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(healthBucket)})
+			return err
+		}},
+		{"database", func(ctx context.Context) error {
+			db, err := getDBConnection(ctx)
+			if err != nil {
+				return err
+			}
+			return db.PingContext(ctx)
+		}},
+		{"ses", func(ctx context.Context) error {
+			_, err := healthSES.GetSendQuota(ctx, &ses.GetSendQuotaInput{})
+			return err
+		}},
+	}
+
+	report := &HealthReport{Status: healthOK, Checks: make([]HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		report.Checks[i] = HealthCheck{Name: c.name, Status: healthOK}
+		if c.name == "s3" && healthBucket == "" {
+			report.Checks[i].Status = healthSkipped
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.run(checkCtx)
+			report.Checks[i].LatencyMs = time.Since(start).Milliseconds()
+			if err != nil {
+				report.Checks[i].Status = healthFailed
+				report.Checks[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range report.Checks {
+		if c.Status == healthFailed {
+			report.Status = healthDegraded
+		}
+	}
+	return report
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// fakeHealthS3 answers HeadBucket with err.
+type fakeHealthS3 struct {
+	err     error
+	buckets []string
+}
+
+func (f *fakeHealthS3) HeadBucket(ctx context.Context, in *s3.HeadBucketInput, _ ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	f.buckets = append(f.buckets, aws.ToString(in.Bucket))
+	return &s3.HeadBucketOutput{}, f.err
+}
+
+// fakeHealthSES answers GetSendQuota with err, or blocks until ctx is done when hang is set.
+type fakeHealthSES struct {
+	err  error
+	hang bool
+}
+
+func (f *fakeHealthSES) GetSendQuota(ctx context.Context, _ *ses.GetSendQuotaInput, _ ...func(*ses.Options)) (*ses.GetSendQuotaOutput, error) {
+	if f.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &ses.GetSendQuotaOutput{}, f.err
+}
+
+// useHealthClients replaces the clients probed by checkHealth for the test.
+func useHealthClients(t *testing.T, s3 s3HeadBucketAPI, ses sesQuotaAPI) {
+	t.Helper()
+	setVar(t, &healthS3, s3)
+	setVar(t, &healthSES, ses)
+}
+
+// checkStatuses returns the status of each check by name.
+func checkStatuses(report *HealthReport) map[string]string {
+	statuses := make(map[string]string)
+	for _, c := range report.Checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func TestIsHealthRequest(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{`{"mode": "health"}`, true},
+		{`{"mode": "reprocess"}`, false},
+		{`{"bucket": "bucket", "key": "file.csv"}`, false},
+		{`{"Records": []}`, false},
+		{`[]`, false},
+	}
+	for _, tt := range tests {
+		if got := isHealthRequest(json.RawMessage(tt.payload)); got != tt.want {
+			t.Errorf("isHealthRequest(%s) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestCheckHealthAllOK(t *testing.T) {
+	setVar(t, &healthBucket, "ingest-status")
+	s3 := &fakeHealthS3{}
+	useHealthClients(t, s3, &fakeHealthSES{})
+	conn, _ := newMockDB(t)
+	useDB(t, conn)
+
+	report := checkHealth(context.Background())
+	if report.Status != healthOK {
+		t.Errorf("Status = %q, want %q: %+v", report.Status, healthOK, report.Checks)
+	}
+	want := map[string]string{"s3": healthOK, "database": healthOK, "ses": healthOK}
+	if got := checkStatuses(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+	if len(s3.buckets) != 1 || s3.buckets[0] != "ingest-status" {
+		t.Errorf("HeadBucket called for %v, want ingest-status", s3.buckets)
+	}
+}
+
+func TestCheckHealthMixedResults(t *testing.T) {
+	setVar(t, &healthBucket, "ingest-status")
+	setVar(t, &healthCheckTimeout, 20*time.Millisecond)
+	useHealthClients(t, &fakeHealthS3{err: errors.New("access denied")}, &fakeHealthSES{hang: true})
+	conn, _ := newMockDB(t)
+	useDB(t, conn)
+
+	start := time.Now()
+	report := checkHealth(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("checkHealth() took %s, want the hanging SES check cut off", elapsed)
+	}
+	if report.Status != healthDegraded {
+		t.Errorf("Status = %q, want %q", report.Status, healthDegraded)
+	}
+	for _, c := range report.Checks {
+		switch c.Name {
+		case "s3":
+			if c.Status != healthFailed || !strings.Contains(c.Error, "access denied") {
+				t.Errorf("s3 check = %+v, want failed with the S3 error", c)
+			}
+		case "database":
+			if c.Status != healthOK || c.Error != "" {
+				t.Errorf("database check = %+v, want ok", c)
+			}
+		case "ses":
+			if c.Status != healthFailed || !strings.Contains(c.Error, context.DeadlineExceeded.Error()) {
+				t.Errorf("ses check = %+v, want failed by the timeout", c)
+			}
+		}
+	}
+}
+
+func TestCheckHealthReportsDatabaseFailure(t *testing.T) {
+	setVar(t, &healthBucket, "ingest-status")
+	setVar(t, &healthCheckTimeout, 50*time.Millisecond)
+	useHealthClients(t, &fakeHealthS3{}, &fakeHealthSES{})
+	conn, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	useDB(t, conn)
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	report := checkHealth(context.Background())
+	if report.Status != healthDegraded || checkStatuses(report)["database"] != healthFailed {
+		t.Errorf("report = %+v, want degraded by the database check", report)
+	}
+	if got := checkStatuses(report); got["s3"] != healthOK || got["ses"] != healthOK {
+		t.Errorf("checks = %v, want s3 and ses ok", got)
+	}
+}
+
+func TestCheckHealthSkipsS3WithoutBucket(t *testing.T) {
+	setVar(t, &healthBucket, "")
+	s3 := &fakeHealthS3{err: errors.New("never called")}
+	useHealthClients(t, s3, &fakeHealthSES{})
+	conn, _ := newMockDB(t)
+	useDB(t, conn)
+
+	report := checkHealth(context.Background())
+	if got := checkStatuses(report)["s3"]; got != healthSkipped || len(s3.buckets) != 0 {
+		t.Errorf("s3 check = %q after %d calls, want skipped without a call", got, len(s3.buckets))
+	}
+	if report.Status != healthOK {
+		t.Errorf("Status = %q, want a skipped check to leave the report ok", report.Status)
+	}
+}
+
+func TestHandlerReturnsHealthReport(t *testing.T) {
+	setVar(t, &healthBucket, "ingest-status")
+	useHealthClients(t, &fakeHealthS3{}, &fakeHealthSES{err: errors.New("throttled")})
+	conn, _ := newMockDB(t)
+	useDB(t, conn)
+
+	result, err := handler(context.Background(), json.RawMessage(`{"mode": "health"}`))
+	if err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	report, ok := result.(*HealthReport)
+	if !ok {
+		t.Fatalf("handler() = %T, want a *HealthReport", result)
+	}
+	if report.Status != healthDegraded || checkStatuses(report)["ses"] != healthFailed {
+		t.Errorf("report = %+v, want degraded by the SES check", report)
+	}
+}
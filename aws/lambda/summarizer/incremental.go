@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// watermarkLockID is the advisory lock that orders ingest transactions against the
+// recording of a watermark.
+const watermarkLockID = 0x53756d57 // "SumW"
+
+// getLastWatermark returns the watermark of the most recent successful run: the
+// highest transaction id it covered. The result is invalid (NULL) if no run has been
+// recorded yet, meaning "summarize everything".
+func getLastWatermark(ctx context.Context, db *sql.DB) (sql.NullInt64, error) {
+	var watermark sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT watermark_id FROM summarizer_runs ORDER BY id DESC LIMIT 1`).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		return sql.NullInt64{}, nil
+	}
+	if err != nil {
+		return sql.NullInt64{}, classifyDBError(fmt.Errorf("failed reading last run watermark: %w", err))
+	}
+	return watermark, nil
+}
+
+// holdWatermark takes the shared watermark lock for the rest of an ingest transaction
+// in incremental mode, so no watermark is recorded while its rows are uncommitted.
+func holdWatermark(ctx context.Context, tx *sql.Tx) error {
+	if !incremental {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock_shared($1)`, watermarkLockID); err != nil {
+		return classifyDBError(fmt.Errorf("failed taking watermark lock: %w", err))
+	}
+	return nil
+}
+
+// recordRun stores the highest transaction id, across every routed transactions table,
+// as the watermark for the next run. Ids are allocated before their transaction
+// commits, so the exclusive watermark lock first waits for the ingest transactions in
+// flight: every id up to the watermark is then committed, and later ones are higher.
+func recordRun(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyDBError(fmt.Errorf("failed recording summarizer run: %w", err))
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, watermarkLockID); err != nil {
+		return classifyDBError(fmt.Errorf("failed taking watermark lock: %w", err))
+	}
+	maxes := make([]string, 0, len(routeTables()))
+	for _, table := range routeTables() {
+		maxes = append(maxes, "SELECT MAX(id) AS id FROM "+table)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO summarizer_runs (watermark_id)
+		SELECT COALESCE(MAX(id), 0) FROM (`+strings.Join(maxes, " UNION ALL ")+`) AS tables
+	`)
+	if err != nil {
+		return classifyDBError(fmt.Errorf("failed recording summarizer run: %w", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return classifyDBError(fmt.Errorf("failed recording summarizer run: %w", err))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetLastWatermarkWithoutRuns(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT watermark_id FROM summarizer_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"watermark_id"}))
+
+	since, err := getLastWatermark(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since.Valid {
+		t.Errorf("getLastWatermark() = %d, want no watermark", since.Int64)
+	}
+}
+
+func TestRecordRunWaitsForIngestsAndRecordsMaxID(t *testing.T) {
+	setVar(t, &tableRoutes, []tableRoute{{Prefix: "partner/", Table: "partner_transacciones"}})
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).
+		WithArgs(watermarkLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO summarizer_runs \(watermark_id\)\s+SELECT COALESCE\(MAX\(id\), 0\) FROM \(` +
+		`SELECT MAX\(id\) AS id FROM transacciones UNION ALL SELECT MAX\(id\) AS id FROM partner_transacciones\)`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := recordRun(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecondIncrementalRunSummarizesOnlyNewRows(t *testing.T) {
+	setVar(t, &incremental, true)
+	ctx := context.Background()
+	db, mock := newMockDB(t)
+	jan := monthRow{month: "January", credits: []float64{10}, balance: "10", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	feb := monthRow{month: "February", credits: []float64{5}, balance: "5", period: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	// First run: nothing recorded yet, so every row is summarized
+	mock.ExpectQuery("SELECT watermark_id FROM summarizer_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"watermark_id"}))
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).
+		WillReturnRows(summaryRows(jan))
+	first := summarizeRun(t, ctx, db)
+	if len(first.MonthlySummaries) != 1 || first.MonthlySummaries[0].Month != "January" {
+		t.Fatalf("first run months = %+v, want January", first.MonthlySummaries)
+	}
+
+	// Second run: the first recorded id 1, so only rows above it are summarized
+	mock.ExpectQuery("SELECT watermark_id FROM summarizer_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"watermark_id"}).AddRow(1))
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", int64(1), nil).
+		WillReturnRows(summaryRows(feb))
+	second := summarizeRun(t, ctx, db)
+	if len(second.MonthlySummaries) != 1 || second.MonthlySummaries[0].Month != "February" {
+		t.Fatalf("second run months = %+v, want only February", second.MonthlySummaries)
+	}
+	if second.TotalBalance != 5 {
+		t.Errorf("second run balance = %v, want 5", second.TotalBalance)
+	}
+}
+
+// summarizeRun reads the watermark and summarizes jane@example.com since it, as an
+// incremental run does.
+func summarizeRun(t *testing.T, ctx context.Context, db *sql.DB) *AccountSummary {
+	t.Helper()
+	since, err := getLastWatermark(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err := getTransactionSummaryByEmail(ctx, db, "transacciones", "jane@example.com", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
@@ -0,0 +1,46 @@
+package main
+
+import "context"
+
+// Values of IngestStatus.Status.
+const (
+	ingestProcessed = "processed"
+	ingestFailed    = "failed"
+	ingestRetrying  = "retrying"
+	ingestSkipped   = "skipped"
+)
+
+// maxStatusErrors caps the errors kept in an ingest status record.
+const maxStatusErrors = 20
+
+// IngestStatus is the outcome of one processed file. It is stored in the
+// processed-files ledger, which the uploader's /status endpoint reads.
+type IngestStatus struct {
+	Bucket       string   `json:"bucket"`
+	Key          string   `json:"key"`
+	Status       string   `json:"status"`
+	RowsRead     int      `json:"rows_read"`
+	RowsInserted int      `json:"rows_inserted"`
+	RejectedRows int      `json:"rejected_rows"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// fail records err on the status; a retryable error leaves the file retrying.
+func (s *IngestStatus) fail(err error) {
+	s.Status = ingestFailed
+	if shouldRetry(err) {
+		s.Status = ingestRetrying
+	}
+	s.addError(err.Error())
+}
+
+func (s *IngestStatus) addError(msg string) {
+	if len(s.Errors) < maxStatusErrors {
+		s.Errors = append(s.Errors, msg)
+	}
+}
+
+// recordIngestStatus counts the status in the run's statistics.
+func recordIngestStatus(ctx context.Context, status *IngestStatus) {
+	runStatsFrom(ctx).addFile(status)
+}
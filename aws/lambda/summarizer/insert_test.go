@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestInsertInTransactionStoresSourceKey(t *testing.T) {
+	setVar(t, &storeSourceKey, true)
+	db, mock := newMockDB(t)
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-01-09", "-10.3", "john@example.com"}},
+	}
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email, source_key) VALUES ($1, $2, $3, $4, $5)"))
+	prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com", "s3://bucket/file.csv").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	prep.ExpectExec().WithArgs(int64(2), sqlmock.AnyArg(), "-10.3", "john@example.com", "s3://bucket/file.csv").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	emails, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv")
+	if err != nil {
+		t.Fatalf("insertInTransaction() error = %v", err)
+	}
+	if len(emails) != 2 {
+		t.Errorf("emails = %v, want 2", emails)
+	}
+}
+
+func TestInsertInTransactionOmitsSourceKeyByDefault(t *testing.T) {
+	setVar(t, &storeSourceKey, false)
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email) VALUES ($1, $2, $3, $4)")).
+		ExpectExec().WithArgs(int64(1), time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), "+60.5", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatalf("insertInTransaction() error = %v", err)
+	}
+}
+
+func TestProcessFilePassesObjectAsSourceKey(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/in/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "in/file.csv")
+	expectLedgerWrite(mock, "bucket", "in/file.csv", ingestProcessed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "in/file.csv", sql.NullInt64{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.sources; len(got) != 1 || got[0] != "s3://bucket/in/file.csv" {
+		t.Errorf("inserted with sources %v, want s3://bucket/in/file.csv", got)
+	}
+}
+
+func TestInsertTransactionsStoresTimeOfDay(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO transacciones").ExpectExec().
+		WithArgs(int64(1), time.Date(2024, 1, 5, 14, 30, 15, 0, time.UTC), "+60.5", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05 14:30:15", "+60.5", "jane@example.com"}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// partitionRows returns n rows spread over three accounts.
+func partitionRows(n int) []csvRow {
+	rows := make([]csvRow, n)
+	for i := range rows {
+		email := []string{"jane@example.com", "john@example.com", "ann@example.com"}[i%3]
+		rows[i] = csvRow{Line: i + 2, Fields: []string{strconv.Itoa(i + 1), "2024-01-05", "+1", email}}
+	}
+	return rows
+}
+
+// expectInserts expects rows to be inserted in partitions transactions, in any order.
+func expectInserts(mock sqlmock.Sqlmock, rows []csvRow, partitions int) {
+	mock.MatchExpectationsInOrder(false)
+	for range partitions {
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO transacciones")
+		mock.ExpectCommit()
+	}
+	for _, row := range rows {
+		id, _ := strconv.Atoi(row.Fields[0])
+		mock.ExpectExec("INSERT INTO transacciones").WithArgs(int64(id), sqlmock.AnyArg(), "+1", row.Fields[3]).
+			WillReturnResult(sqlmock.NewResult(int64(id), 1))
+	}
+}
+
+func TestInsertPartitionedMatchesSerialInsert(t *testing.T) {
+	rows := partitionRows(10)
+
+	setVar(t, &insertConcurrency, 1)
+	db, mock := newMockDB(t)
+	expectInserts(mock, rows, 1)
+	serial, err := (&sqlTransactionRepository{db: db}).Insert(context.Background(), "transacciones", rows, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setVar(t, &insertConcurrency, 3)
+	db, mock = newMockDB(t)
+	expectInserts(mock, rows, 3)
+	partitioned, err := (&sqlTransactionRepository{db: db}).Insert(context.Background(), "transacciones", rows, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(serial) != 3 || !reflect.DeepEqual(partitioned, serial) {
+		t.Errorf("partitioned emails = %v, serial = %v, want the same 3 accounts", partitioned, serial)
+	}
+}
+
+func TestInsertPartitionedReportsFailedPartition(t *testing.T) {
+	setVar(t, &insertConcurrency, 2)
+	rows := partitionRows(4)
+	db, mock := newMockDB(t)
+	mock.MatchExpectationsInOrder(false)
+	for range 2 {
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO transacciones")
+	}
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+	// The second partition (lines 4 and 5) stops at its first row
+	for _, row := range rows[:3] {
+		exec := mock.ExpectExec("INSERT INTO transacciones").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "+1", row.Fields[3])
+		if row.Line == 4 {
+			exec.WillReturnError(errors.New("disk full"))
+			continue
+		}
+		exec.WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	_, err := insertPartitioned(context.Background(), db, "transacciones", rows, "")
+	if err == nil || !strings.Contains(err.Error(), "partition starting at line 4") {
+		t.Errorf("insertPartitioned() error = %v, want the partition starting at line 4 reported", err)
+	}
+}
+
+func TestInsertTransactionsColumnCountPolicy(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5"}},
+		{Line: 3, Fields: []string{"2", "2024-01-09", "-10.3", "john@example.com"}},
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		setVar(t, &strictColumns, strictColumnsSkip)
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO transacciones")
+		prep.ExpectExec().WithArgs(int64(2), sqlmock.AnyArg(), "-10.3", "john@example.com").WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+
+		emails, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv")
+		if err != nil {
+			t.Fatalf("insertInTransaction() error = %v", err)
+		}
+		if _, ok := emails["john@example.com"]; !ok || len(emails) != 1 {
+			t.Errorf("emails = %v, want only john@example.com", emails)
+		}
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		setVar(t, &strictColumns, strictColumnsFail)
+		db, mock := newMockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectPrepare("INSERT INTO transacciones")
+		mock.ExpectRollback()
+
+		_, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv")
+		if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("insertInTransaction() error = %v, want a column count error for line 2", err)
+		}
+	})
+}
+
+func TestInsertTransactionsStoresTrimmedName(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,name")
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email, name) VALUES ($1, $2, $3, $4, $5)"))
+	prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com", "Jane Doe").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com", "  Jane Doe "}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertTransactionsBlankEmailPolicy(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-01-06", "-4", " "}},
+	}
+	tests := []struct {
+		policy     string
+		storedAs   string
+		wantEmails []string
+	}{
+		{blankEmailExclude, " ", []string{"jane@example.com"}},
+		{blankEmailDefault, "cash@example.com", []string{"cash@example.com", "jane@example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setVar(t, &blankEmailPolicy, tt.policy)
+			setVar(t, &blankEmailAccount, "cash@example.com")
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			prep := mock.ExpectPrepare("INSERT INTO transacciones")
+			prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com").WillReturnResult(sqlmock.NewResult(1, 1))
+			prep.ExpectExec().WithArgs(int64(2), sqlmock.AnyArg(), "-4", tt.storedAs).WillReturnResult(sqlmock.NewResult(2, 1))
+			mock.ExpectCommit()
+
+			emails, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for email := range emails {
+				got = append(got, email)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.wantEmails) {
+				t.Errorf("emails to summarize = %v, want %v and no blank account", got, tt.wantEmails)
+			}
+		})
+	}
+}
+
+func TestInsertTransactionsStoresLowerCaseTier(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,tier")
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email, tier) VALUES ($1, $2, $3, $4, $5)"))
+	prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com", "premium").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com", " Premium "}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+)
+
+// Supported values for KEY_TYPE.
+const (
+	// keyTypeInt stores the "id" field as an integer, the historical external_id.
+	keyTypeInt = "int"
+	// keyTypeText stores the "id" field as trimmed text, e.g. a UUID.
+	keyTypeText = "text"
+)
+
+// keyColumnPattern accepts a plain SQL column name. The key column is interpolated
+// into queries, so nothing else is allowed.
+var keyColumnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseKey converts the "id" field of a row into the value stored in keyColumn,
+// according to KEY_TYPE.
+func parseKey(value string) (interface{}, error) {
+	return csvrules.ParseKey(value, keyType == keyTypeInt)
+}
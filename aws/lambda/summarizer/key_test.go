@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		keyType string
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{keyTypeInt, "42", 42, false},
+		{keyTypeInt, "-7", -7, false},
+		{keyTypeInt, "abc", nil, true},
+		{keyTypeInt, "", nil, true},
+		{keyTypeInt, "8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d", nil, true},
+		{keyTypeText, " 8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d ", "8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d", false},
+		{keyTypeText, "42", "42", false},
+		{keyTypeText, "  ", nil, true},
+	}
+	for _, tt := range tests {
+		setVar(t, &keyType, tt.keyType)
+		got, err := parseKey(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseKey(%q) with KEY_TYPE %s = %v, %v, want %v (error %v)", tt.value, tt.keyType, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestInsertTransactionsStoresIntegerKey(t *testing.T) {
+	setVar(t, &storeSourceKey, false)
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email) VALUES ($1, $2, $3, $4)")).
+		ExpectExec().WithArgs(int64(17), sqlmock.AnyArg(), "+60.5", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"17", "2024-01-05", "+60.5", "jane@example.com"}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertTransactionsStoresUUIDKey(t *testing.T) {
+	setVar(t, &storeSourceKey, false)
+	setVar(t, &keyColumn, "transaction_uuid")
+	setVar(t, &keyType, keyTypeText)
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (transaction_uuid, date, transaction, email) VALUES ($1, $2, $3, $4)")).
+		ExpectExec().WithArgs("8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d", sqlmock.AnyArg(), "+60.5", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rows := []csvRow{{Line: 2, Fields: []string{"8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d", "2024-01-05", "+60.5", "jane@example.com"}}}
+	if _, err := insertInTransaction(context.Background(), db, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateRowsChecksKeyType(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 3, Fields: []string{"8f14e45f-ceea-4e7a-9c3b-1d2f3a4b5c6d", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 4, Fields: []string{" ", "2024-01-05", "+1", "jane@example.com"}},
+	}
+	tests := []struct {
+		keyType   string
+		wantLines []int
+		wantMsg   string
+	}{
+		{keyTypeInt, []int{3, 4}, "not an integer"},
+		{keyTypeText, []int{4}, "empty"},
+	}
+	for _, tt := range tests {
+		setVar(t, &keyType, tt.keyType)
+		_, report := validateRows("bucket", "file.csv", rows)
+		var lines []int
+		for _, fe := range report.Errors {
+			lines = append(lines, fe.Line)
+			if fe.Column != "id" || fe.Message != tt.wantMsg {
+				t.Errorf("KEY_TYPE %s: error = %+v, want an id error %q", tt.keyType, fe, tt.wantMsg)
+			}
+		}
+		if !slices.Equal(lines, tt.wantLines) {
+			t.Errorf("KEY_TYPE %s: rejected lines %v, want %v", tt.keyType, lines, tt.wantLines)
+		}
+	}
+}
+
+func TestLoadConfigRejectsInvalidKeySettings(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"KEY_COLUMN": "id; DROP TABLE transacciones"}, "Invalid value for KEY_COLUMN"},
+		{map[string]string{"KEY_TYPE": "uuid"}, "Invalid value for KEY_TYPE"},
+	}
+	for _, tt := range tests {
+		if out := loadConfigError(t, tt.env); !strings.Contains(out, tt.want) {
+			t.Errorf("loadConfig() with %v output = %q, want %q", tt.env, out, tt.want)
+		}
+	}
+}
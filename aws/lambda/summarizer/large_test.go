@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetTransactionSummaryFlagsLargeTransactions(t *testing.T) {
+	setVar(t, &largeTransactionThreshold, 1000)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`ABS\(CAST\(TRIM\(transaction\) AS NUMERIC\)\) > \$3`).
+		WithArgs("jane@example.com", nil, sql.NullFloat64{Float64: 1000, Valid: true}).
+		WillReturnRows(summaryRows(
+			monthRow{month: "January", credits: []float64{1500}, balance: "1500", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), large: true},
+			monthRow{month: "February", debits: []float64{-20}, balance: "-20", prevBal: "1500", period: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.MonthlySummaries) != 2 {
+		t.Fatalf("months = %+v, want 2", summary.MonthlySummaries)
+	}
+	if !summary.MonthlySummaries[0].HasLargeTransaction || summary.MonthlySummaries[1].HasLargeTransaction {
+		t.Errorf("HasLargeTransaction = %v, %v, want only January flagged",
+			summary.MonthlySummaries[0].HasLargeTransaction, summary.MonthlySummaries[1].HasLargeTransaction)
+	}
+}
+
+func TestGetTransactionSummaryPassesNullThresholdWhenDisabled(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{1500}, balance: "1500", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.MonthlySummaries[0].HasLargeTransaction {
+		t.Error("HasLargeTransaction set with LARGE_TRANSACTION_THRESHOLD disabled")
+	}
+}
+
+func TestGetAccountTransactionsMarksLargeAmounts(t *testing.T) {
+	setVar(t, &largeTransactionThreshold, 1000)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"date", "amount", "currency"}).
+			AddRow("2024-01-05", 1000.0, "").
+			AddRow("2024-01-06", -1000.01, "").
+			AddRow("2024-01-07", 1500.0, ""))
+
+	transactions, err := getAccountTransactions(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var large []bool
+	for _, tx := range transactions {
+		large = append(large, tx.Large)
+	}
+	if len(large) != 3 || large[0] || !large[1] || !large[2] {
+		t.Errorf("Large = %v, want amounts above 1000 in absolute value flagged", large)
+	}
+}
+
+func TestLoadConfigRejectsNegativeLargeTransactionThreshold(t *testing.T) {
+	for _, v := range []string{"-1", "NaN", "big"} {
+		if out := loadConfigError(t, map[string]string{"LARGE_TRANSACTION_THRESHOLD": v}); !strings.Contains(out, "Invalid value for LARGE_TRANSACTION_THRESHOLD") {
+			t.Errorf("loadConfig() with %q output = %q, want LARGE_TRANSACTION_THRESHOLD rejected", v, out)
+		}
+	}
+}
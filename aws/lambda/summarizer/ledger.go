@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/lib/pq"
+)
+
+// ledgerEntryKey is the context key of the file's ledgerEntry.
+type ledgerEntryKey struct{}
+
+// ledgerEntry is a file's row in the processed_files ledger. A successful atomic
+// ingest writes it in the insert transaction, so a file is recorded as processed
+// exactly when its rows are committed; the final outcome is written after the fact.
+type ledgerEntry struct {
+	Bucket, Key, ETag string
+	Status            *IngestStatus
+	// Emails are the accounts to summarize if the file is delivered again before its
+	// summaries were notified; NoSummaries leaves them out.
+	Emails      []string
+	NoSummaries bool
+}
+
+// withLedgerEntry attaches e to ctx; a nil e stops inserts from writing the ledger row.
+func withLedgerEntry(ctx context.Context, e *ledgerEntry) context.Context {
+	return context.WithValue(ctx, ledgerEntryKey{}, e)
+}
+
+// ledgerEntryFrom returns the file's ledger entry, or nil.
+func ledgerEntryFrom(ctx context.Context) *ledgerEntry {
+	e, _ := ctx.Value(ledgerEntryKey{}).(*ledgerEntry)
+	return e
+}
+
+// processedFile is what the ledger knows of an object already ingested.
+type processedFile struct {
+	Emails   []string
+	Notified bool
+}
+
+// findProcessedFile returns the ledger record of the object when this version of it
+// (same ETag) was already ingested, or nil.
+func findProcessedFile(ctx context.Context, db *sql.DB, bucket, key, etag string) (*processedFile, error) {
+	var f processedFile
+	err := db.QueryRowContext(ctx, `
+		SELECT emails, notified_at IS NOT NULL FROM processed_files
+		WHERE bucket = $1 AND object_key = $2 AND etag = $3 AND status = $4`,
+		bucket, key, etag, ingestProcessed).Scan(pq.Array(&f.Emails), &f.Notified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, classifyDBError(fmt.Errorf("processed files lookup failed: %w", err))
+	}
+	return &f, nil
+}
+
+// writeLedgerEntry upserts the file's ledger row with the given status and inserted
+// row count. A new outcome is not notified yet.
+func writeLedgerEntry(ctx context.Context, db sqlExecer, e *ledgerEntry, status string, inserted int) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO processed_files (bucket, object_key, etag, status, rows_read, rows_inserted, rejected_rows, errors, emails)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8::text[], '{}'), COALESCE($9::text[], '{}'))
+		ON CONFLICT (bucket, object_key) DO UPDATE SET
+			etag = EXCLUDED.etag, status = EXCLUDED.status, rows_read = EXCLUDED.rows_read,
+			rows_inserted = EXCLUDED.rows_inserted, rejected_rows = EXCLUDED.rejected_rows,
+			errors = EXCLUDED.errors, emails = EXCLUDED.emails, notified_at = NULL, updated_at = NOW()`,
+		e.Bucket, e.Key, e.ETag, status, e.Status.RowsRead, inserted,
+		e.Status.RejectedRows, pq.Array(e.Status.Errors), pq.Array(e.Emails))
+	if err != nil {
+		return classifyDBError(fmt.Errorf("failed recording processed file: %w", err))
+	}
+	return nil
+}
+
+// setEmails records the sorted emails of an ingested file unless it is not summarized.
+func (e *ledgerEntry) setEmails(emailSet map[string]struct{}) {
+	e.Emails = []string{}
+	if e.NoSummaries {
+		return
+	}
+	for email := range emailSet {
+		e.Emails = append(e.Emails, email)
+	}
+	sort.Strings(e.Emails)
+}
+
+// recordLedgerEntry writes the file's final outcome to the ledger. It is best effort
+// and runs even if the invocation is being cancelled: a file that committed already
+// has its row from the insert transaction.
+func recordLedgerEntry(ctx context.Context, db *sql.DB, e *ledgerEntry) {
+	if err := writeLedgerEntry(context.WithoutCancel(ctx), db, e, e.Status.Status, e.Status.RowsInserted); err != nil {
+		log.Printf("Error recording s3://%s/%s in the processed files ledger: %v", e.Bucket, e.Key, err)
+	}
+}
+
+// markNotified records that the summaries of the event's ingested files were notified,
+// so a redelivered event skips them entirely. It is best effort: a file left
+// unmarked is only summarized and notified again, never ingested again.
+func markNotified(ctx context.Context, db *sql.DB, entries []*ledgerEntry) {
+	for _, r := range entries {
+		if r.ETag == "" {
+			continue
+		}
+		_, err := db.ExecContext(context.WithoutCancel(ctx), `
+			UPDATE processed_files SET notified_at = NOW()
+			WHERE bucket = $1 AND object_key = $2 AND etag = $3 AND status = $4 AND notified_at IS NULL`,
+			r.Bucket, r.Key, r.ETag, ingestProcessed)
+		if err != nil {
+			log.Printf("Error marking s3://%s/%s as notified: %v", r.Bucket, r.Key, err)
+		}
+	}
+}
+
+// objectETag returns the object's ETag, without quotes, from its metadata; event
+// records of manual reprocessing carry none.
+func objectETag(ctx context.Context, bucket, key string) (string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return "", classify(ErrFatal, fmt.Errorf("%w: s3://%s/%s: %w", errObjectNotFound, bucket, key, err))
+	}
+	if err != nil {
+		return "", classifyAWSError(fmt.Errorf("error reading S3 object metadata: %w", err))
+	}
+	return strings.Trim(aws.ToString(head.ETag), `"`), nil
+}
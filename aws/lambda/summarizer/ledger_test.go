@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// expectProcessedFile expects the ledger to report the object as already ingested.
+func expectProcessedFile(mock sqlmock.Sqlmock, bucket, key string, emails []string, notified bool) {
+	mock.ExpectQuery("FROM processed_files").WithArgs(bucket, key, "etag-"+key, ingestProcessed).
+		WillReturnRows(sqlmock.NewRows([]string{"emails", "notified"}).AddRow(pq.StringArray(emails), notified))
+}
+
+func TestProcessFileSummarizesIngestedFileAgainWithoutInserting(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-01,+10,jane@example.com\n"}))
+	repo := newMemRepository()
+	repo.tables["transacciones"] = []csvRow{{Fields: []string{"1", "2024-01-01", "+10", "jane@example.com"}}}
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectProcessedFile(mock, "bucket", "file.csv", []string{"jane@example.com"}, false)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Email != "jane@example.com" {
+		t.Errorf("processFile() = %v, want jane@example.com summarized again", summaries)
+	}
+	if got := repo.rowCount("transacciones"); got != 1 {
+		t.Errorf("stored %d rows, want the file not inserted again", got)
+	}
+}
+
+func TestProcessFileSkipsNotifiedFile(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-01,+10,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectProcessedFile(mock, "bucket", "file.csv", []string{"jane@example.com"}, true)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil || len(summaries) != 0 {
+		t.Fatalf("processFile() = %v, %v, want the file skipped", summaries, err)
+	}
+	if got := repo.rowCount("transacciones"); got != 0 {
+		t.Errorf("stored %d rows, want none", got)
+	}
+}
+
+func TestProcessFileReprocessesIngestedFileOnRequest(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-01,+10,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+	ctx := withManualReprocess(context.Background())
+	summaries, err := processFile(ctx, db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil || len(summaries) != 1 {
+		t.Fatalf("processFile() = %v, %v, want the file ingested again", summaries, err)
+	}
+	if got := repo.rowCount("transacciones"); got != 1 {
+		t.Errorf("stored %d rows, want 1", got)
+	}
+}
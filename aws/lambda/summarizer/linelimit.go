@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// lineLimitReader fails once any physical line of the underlying reader exceeds max
+// bytes, so a malformed file with a huge line is rejected before csv.Reader buffers it.
+type lineLimitReader struct {
+	r       io.Reader
+	max     int
+	line    int // 1-based number of the current line
+	lineLen int // bytes read so far on the current line
+}
+
+func newLineLimitReader(r io.Reader, max int) *lineLimitReader {
+	return &lineLimitReader{r: r, max: max, line: 1}
+}
+
+func (l *lineLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	chunk := p[:n]
+	for len(chunk) > 0 {
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			l.lineLen += len(chunk)
+			break
+		}
+		l.lineLen += i
+		if l.lineLen > l.max {
+			break
+		}
+		l.line++
+		l.lineLen = 0
+		chunk = chunk[i+1:]
+	}
+	if l.lineLen > l.max {
+		return 0, fmt.Errorf("line %d exceeds the maximum length of %d bytes (CSV_MAX_LINE_BYTES)", l.line, l.max)
+	}
+	return n, err
+}
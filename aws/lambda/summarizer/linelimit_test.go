@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLineLimitReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"within limit", "12345678\n1234\n12345678", ""},
+		{"long last line", "1234\n123456789", "line 2 exceeds the maximum length of 8 bytes"},
+		{"long middle line", "1234\n123456789\n12", "line 2 exceeds the maximum length of 8 bytes"},
+	}
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.input)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			data, err := io.ReadAll(newLineLimitReader(r, 8))
+			switch {
+			case tt.wantErr == "" && (err != nil || string(data) != tt.input):
+				t.Errorf("%s (one byte %v): read %q, %v, want the input unchanged", tt.name, oneByte, data, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("%s (one byte %v): error = %v, want %q", tt.name, oneByte, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestProcessCSVFileRejectsOverlongLine(t *testing.T) {
+	setVar(t, &csvMaxLineBytes, 64)
+	body := "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-06,+1," + strings.Repeat("x", 100) + "@example.com\n"
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": body}))
+
+	_, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "line 3 exceeds the maximum length of 64 bytes") {
+		t.Errorf("processCSVFile() error = %v, want the over-long line 3 rejected", err)
+	}
+}
@@ -1,171 +1,644 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/big"
 	"os"
-	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
-	awslambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
 	_ "github.com/lib/pq"
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
 )
 
+// s3API is the subset of the S3 client used by the summarizer, so it can be replaced in tests.
+type s3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+}
+
 var (
-	s3Client     *s3.Client
-	lambdaClient *awslambda.Client
+	s3Client s3API
+	notifier Notifier
 
 	db     *sql.DB
 	dbOnce sync.Once
+
+	// clock returns the current time; tests replace it for deterministic output.
+	clock = time.Now
 )
 
-// initAWSClients initializes AWS SDK clients for S3 and Lambda.
+// initAWSClients initializes AWS SDK clients for S3 and the configured notification channel.
 func initAWSClients() {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		log.Fatalf("Error loading AWS config: %v", err)
 	}
-	s3Client = s3.NewFromConfig(cfg)
-	lambdaClient = awslambda.NewFromConfig(cfg)
+	client := s3.NewFromConfig(cfg)
+	s3Client, healthS3 = client, client
+	healthSES = ses.NewFromConfig(cfg)
+	if s3DownloadManager {
+		downloader = newDownloader(client)
+	}
+	notifier, err = newNotifier(cfg, notifyTarget)
+	if err != nil {
+		log.Fatalf("Error creating notifier: %v", err)
+	}
+	// Summary events can also be published alongside the configured channel
+	if eventBridgeBus != "" {
+		notifier = multiNotifier{notifier, newEventBridgeNotifier(cfg, eventBridgeBus)}
+	}
+	if flaggedNotifyTarget != "" {
+		flaggedNotifier, err = newNotifier(cfg, flaggedNotifyTarget)
+		if err != nil {
+			log.Fatalf("Error creating flagged notifier: %v", err)
+		}
+	}
+	if operatorEmail != "" {
+		operatorMailer = ses.NewFromConfig(cfg)
+	}
 }
 
-// getDBConnection initializes and returns a DB connection pool singleton.
-func getDBConnection() (*sql.DB, error) {
-	var err error
-	dbOnce.Do(func() {
-		host := os.Getenv("DB_HOST")
-		port := os.Getenv("DB_PORT")
-		user := os.Getenv("DB_USER")
-		password := os.Getenv("DB_PASSWORD")
-		dbname := os.Getenv("DB_NAME")
+// connectionString returns DATABASE_URL verbatim when it is set. Otherwise it builds
+// the Postgres connection string from the DB_* environment variables, with the
+// configured SSL mode and, when set, the CA bundle used to verify the server certificate.
+func connectionString() string {
+	if databaseURL != "" {
+		return databaseURL
+	}
+	return dbConnString(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"))
+}
+
+// dbConnString builds a key=value connection string for host and port from the other
+// DB_* settings.
+func dbConnString(host, port string) string {
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		host, port, os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), dbSSLMode)
+	if dbSSLRootCert != "" {
+		connStr += " sslrootcert=" + quoteConnValue(dbSSLRootCert)
+	}
+	return connStr
+}
 
-		connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
-			host, port, user, password, dbname)
+// quoteConnValue single-quotes a connection string value, escaping quotes and
+// backslashes, so paths with spaces are passed through intact.
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
 
-		db, err = sql.Open("postgres", connStr)
+// getDBConnection initializes and returns a DB connection pool singleton,
+// verifying it is reachable with a retried ping.
+func getDBConnection(ctx context.Context) (*sql.DB, error) {
+	var err error
+	dbOnce.Do(func() {
+		db, err = sql.Open("postgres", connectionString())
 		if err != nil {
+			err = classify(ErrFatal, err)
 			return
 		}
-		err = db.Ping()
+		db.SetMaxOpenConns(dbMaxOpenConns)
 	})
-	return db, err
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryDB(ctx, "database ping", func() error {
+		return classifyDBError(db.PingContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
-// insertTransactions inserts multiple transaction records inside a transaction block.
-// Returns a set of unique emails found in the transactions.
-func insertTransactions(tx *sql.Tx, transactions [][]string) (map[string]struct{}, error) {
-	const expectedColumns = 4
-	stmt, err := tx.Prepare(`INSERT INTO transacciones (external_id, date, transaction, email) VALUES ($1, $2, $3, $4)`)
+// insertTransactions inserts multiple transaction records into table inside a transaction block.
+// When STORE_SOURCE_KEY is enabled each row also records sourceKey, the object it came from.
+// Returns a set of unique non-blank emails found in the transactions.
+func insertTransactions(ctx context.Context, tx *sql.Tx, table string, transactions []csvRow, sourceKey string) (map[string]struct{}, error) {
+	columns := []string{keyColumn, "date", "transaction", "email"}
+	if storeSourceKey {
+		columns = append(columns, "source_key")
+	}
+	if schema.has("currency") {
+		columns = append(columns, "currency")
+	}
+	if schema.has("name") {
+		columns = append(columns, "name")
+	}
+	if schema.has("tier") {
+		columns = append(columns, "tier")
+	}
+	stmt, err := tx.PrepareContext(ctx, buildInsertQuery(table, columns))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		return nil, classifyDBError(fmt.Errorf("failed to prepare statement: %w", err))
 	}
 	defer stmt.Close()
 
 	emailSet := make(map[string]struct{})
 
-	for i, row := range transactions {
-		if len(row) != expectedColumns {
-			return nil, fmt.Errorf("invalid column count in row %d: expected %d, got %d", i+1, expectedColumns, len(row))
+	for _, row := range transactions {
+		// The caller rolls back, so a file cut short by the deadline leaves nothing behind
+		if ctx.Err() != nil {
+			return nil, stopped(ctx, fmt.Sprintf("inserting at line %d", row.Line))
+		}
+		if len(row.Fields) != schema.width() {
+			err := fmt.Errorf("invalid column count in line %d: expected %d, got %d", row.Line, schema.width(), len(row.Fields))
+			if strictColumns == strictColumnsFail {
+				return nil, classify(ErrValidation, err)
+			}
+			log.Printf("Warning: skipping row: %v", err)
+			continue
 		}
 
-		externalID, err := strconv.Atoi(row[0])
+		externalID, err := parseKey(schema.field(row.Fields, "id"))
+		if err != nil {
+			return nil, classify(ErrValidation, fmt.Errorf("invalid %s in line %d: %w", keyColumn, row.Line, err))
+		}
+		date, err := csvrules.ParseDate(schema.field(row.Fields, "date"))
 		if err != nil {
-			return nil, fmt.Errorf("invalid externalID in row %d: %w", i+1, err)
+			return nil, classify(ErrValidation, fmt.Errorf("invalid date in line %d: %w", row.Line, err))
+		}
+		transaction := schema.field(row.Fields, "transaction")
+		email := schema.field(row.Fields, "email")
+		// Rows without an email (e.g. cash transactions) never form their own account
+		if strings.TrimSpace(email) == "" && blankEmailPolicy == blankEmailDefault {
+			email = blankEmailAccount
 		}
-		date := row[1]
-		transaction := row[2]
-		email := row[3]
 
-		if _, err := stmt.Exec(externalID, date, transaction, email); err != nil {
-			return nil, fmt.Errorf("insert failed at row %d: %w", i+1, err)
+		args := []interface{}{externalID, date, transaction, email}
+		if storeSourceKey {
+			args = append(args, sourceKey)
+		}
+		if schema.has("currency") {
+			args = append(args, normalizeCurrency(schema.field(row.Fields, "currency")))
+		}
+		if schema.has("name") {
+			args = append(args, strings.TrimSpace(schema.field(row.Fields, "name")))
+		}
+		if schema.has("tier") {
+			args = append(args, strings.ToLower(strings.TrimSpace(schema.field(row.Fields, "tier"))))
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return nil, classifyDBError(fmt.Errorf("insert failed at line %d: %w", row.Line, err))
 		}
 
-		emailSet[email] = struct{}{}
+		if strings.TrimSpace(email) != "" {
+			emailSet[email] = struct{}{}
+		}
+	}
+
+	return emailSet, nil
+}
+
+// insertInTransaction inserts rows in a single transaction and returns the unique emails.
+func insertInTransaction(ctx context.Context, db *sql.DB, table string, rows []csvRow, sourceKey string) (map[string]struct{}, error) {
+	var tx *sql.Tx
+	err := retryDB(ctx, "begin transaction", func() (err error) {
+		tx, err = db.BeginTx(ctx, nil)
+		return classifyDBError(err)
+	})
+	if err != nil {
+		log.Printf("Failed to begin DB transaction: %v", err)
+		return nil, err
+	}
+
+	if err := holdWatermark(ctx, tx); err != nil {
+		tx.Rollback()
+		log.Printf("Transaction rollback due to error: %v", err)
+		return nil, err
+	}
+	emailSet, err := insertTransactions(ctx, tx, table, rows, sourceKey)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Transaction rollback due to error: %v", err)
+		return nil, err
+	}
+
+	// The file is in the ledger exactly when its rows are committed, so a redelivered
+	// event cannot insert them twice
+	if entry := ledgerEntryFrom(ctx); entry != nil {
+		entry.setEmails(emailSet)
+		if err := writeLedgerEntry(ctx, tx, entry, ingestProcessed, len(rows)); err != nil {
+			tx.Rollback()
+			log.Printf("Transaction rollback due to error: %v", err)
+			return nil, err
+		}
+	}
+
+	// The audit row commits or rolls back with the data it describes
+	var auditID int64
+	audit := ingestAuditFrom(ctx)
+	if audit != nil {
+		auditID, err = insertAudit(ctx, tx, audit.Principal, audit.Status, ingestProcessed, len(rows))
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Transaction rollback due to ingest audit error: %v", err)
+			return nil, classifyDBError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to commit DB transaction: %v", err)
+		return nil, classifyDBError(err)
+	}
+	if audit != nil {
+		audit.id = auditID
+	}
+	return emailSet, nil
+}
+
+// insertPartitioned splits rows into insertConcurrency contiguous partitions and
+// inserts them concurrently, each in its own transaction, merging their email sets.
+// Unlike insertInTransaction the file is not atomic: when a partition fails, the
+// partitions that already committed stay in the database.
+func insertPartitioned(ctx context.Context, db *sql.DB, table string, rows []csvRow, sourceKey string) (map[string]struct{}, error) {
+	size := (len(rows) + insertConcurrency - 1) / insertConcurrency
+	// No partition speaks for the whole file, so its ledger and audit rows are written afterwards
+	ctx = withLedgerEntry(withIngestAudit(ctx, nil), nil)
+	var (
+		emailSet = make(map[string]struct{})
+		errs     []error
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+	)
+	for start := 0; start < len(rows); start += size {
+		part := rows[start:min(start+size, len(rows))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partEmails, err := insertInTransaction(ctx, db, table, part, sourceKey)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition starting at line %d: %w", part[0].Line, err))
+				return
+			}
+			for email := range partEmails {
+				emailSet[email] = struct{}{}
+			}
+		}()
 	}
+	wg.Wait()
 
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 	return emailSet, nil
 }
 
-// processCSVFile downloads the CSV from S3, reads and validates it, returns rows as [][]string.
-func processCSVFile(ctx context.Context, bucket, key string) ([][]string, error) {
+// buildInsertQuery returns a parameterized INSERT of the given columns into table.
+func buildInsertQuery(table string, columns []string) string {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+}
+
+// normalizeCurrency upper-cases a currency code, using DEFAULT_CURRENCY when it is blank.
+func normalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return defaultCurrency
+	}
+	return code
+}
+
+// gzipMagic is the header every gzip member starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// utf8BOM is the byte order mark some spreadsheet tools write at the start of a CSV.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// processCSVFile downloads the CSV from S3, reads it and returns the rows with the
+// expected column count, each tagged with its line number.
+// The first line is treated as a header unless CSV_HAS_HEADER is false.
+func processCSVFile(ctx context.Context, bucket, key string) ([]csvRow, error) {
 	log.Printf("Starting to process file s3://%s/%s", bucket, key)
 
-	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	// Parsing is deterministic, so a body cut off mid-stream is read again from the start
+	for attempt := 1; ; attempt++ {
+		rows, err := parseCSVObject(ctx, bucket, key)
+		if !errors.Is(err, errObjectRead) || attempt >= s3ReadAttempts || ctx.Err() != nil {
+			return rows, err
+		}
+		log.Printf("Retrying s3://%s/%s (attempt %d of %d): %v", bucket, key, attempt+1, s3ReadAttempts, err)
+	}
+}
+
+// parseCSVObject reads and parses one GetObject of the file. Failures to read the body
+// itself wrap errObjectRead so that processCSVFile can start over.
+func parseCSVObject(ctx context.Context, bucket, key string) ([]csvRow, error) {
+	object, err := openObject(ctx, bucket, key)
+	if isNotFound(err) {
+		return nil, classify(ErrFatal, fmt.Errorf("%w: s3://%s/%s: %w", errObjectNotFound, bucket, key, err))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting S3 object: %w", err)
+		return nil, classifyAWSError(fmt.Errorf("error getting S3 object: %w", err))
+	}
+	defer object.Close()
+	body := &bodyReader{r: object}
+	// readFailed replaces a parse error with the body's read error, if there was one
+	readFailed := func(err error) error {
+		if body.err == nil {
+			return err
+		}
+		return classify(ErrTransient, fmt.Errorf("%w s3://%s/%s: %w", errObjectRead, bucket, key, body.err))
+	}
+
+	// Gzip-compressed files are detected by their magic bytes rather than the key suffix
+	buffered := bufio.NewReader(body)
+	if prefix, err := buffered.Peek(len(gzipMagic)); err == nil && bytes.Equal(prefix, gzipMagic) {
+		zr, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, readFailed(classify(ErrValidation, fmt.Errorf("invalid gzip file s3://%s/%s: %w", bucket, key, err)))
+		}
+		defer zr.Close()
+		// Concatenated gzip members are read as one stream, so no rows after the first member are lost
+		zr.Multistream(true)
+		buffered = bufio.NewReader(zr)
+		log.Printf("Decompressing gzip file s3://%s/%s", bucket, key)
 	}
-	defer obj.Body.Close()
 
-	reader := csv.NewReader(obj.Body)
+	// Excel exports start with a UTF-8 BOM, which would otherwise end up in the first header cell
+	if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && bytes.Equal(prefix, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+		log.Printf("Stripped UTF-8 byte order mark from s3://%s/%s", bucket, key)
+	}
+
+	var input io.Reader = buffered
+	if csvMaxLineBytes > 0 {
+		input = newLineLimitReader(buffered, csvMaxLineBytes)
+	}
+	tail := &tailReader{r: input}
+
+	reader := csv.NewReader(tail)
 	reader.Comma = ','
 	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1 // column count is checked against the schema below
 
-	// Read and discard header
-	header, err := reader.Read()
-	if err != nil {
-		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	// Read and discard header, unless the file is configured as headerless,
+	// in which case the first row is data and is validated like any other.
+	lineNum := 0
+	if csvHasHeader {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, readFailed(classify(ErrValidation, fmt.Errorf("error reading CSV header: %w", err)))
+		}
+		if err := schema.checkHeader(header); err != nil {
+			return nil, classify(ErrValidation, err)
+		}
+		lineNum++
 	}
-	if len(header) != 4 {
-		return nil, fmt.Errorf("invalid CSV header column count: expected 4, got %d", len(header))
+
+	var rows []csvRow
+	addRecord := func(row csvRow) error {
+		if len(row.Fields) != schema.width() {
+			err := fmt.Errorf("invalid column count in line %d: expected %d, got %d", row.Line, schema.width(), len(row.Fields))
+			if strictColumns == strictColumnsFail {
+				return classify(ErrValidation, err)
+			}
+			log.Printf("Warning: %v", err)
+			return nil
+		}
+		rows = append(rows, row)
+		return nil
 	}
 
-	var rows [][]string
-	lineNum := 1
+	// Blank records are held back until a data record follows them; the ones still
+	// held at the end of the file are trailing and dropped without a warning
+	var blanks []csvRow
 	for {
+		if ctx.Err() != nil {
+			return nil, stopped(ctx, fmt.Sprintf("reading s3://%s/%s at line %d", bucket, key, lineNum+1))
+		}
 		lineNum++
 		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			// Not a malformed line but a broken stream (e.g. a truncated gzip member)
+			return nil, readFailed(classify(ErrValidation, fmt.Errorf("error reading s3://%s/%s at line %d: %w", bucket, key, lineNum, err)))
+		}
+		if err != nil && strictFeed {
+			return nil, classify(ErrValidation, fmt.Errorf("STRICT_FEED: malformed CSV line %d in s3://%s/%s: %w", lineNum, bucket, key, err))
+		}
 		if err != nil {
 			log.Printf("Warning: error reading CSV line %d: %v", lineNum, err)
 			continue
 		}
-		if len(record) != 4 {
-			log.Printf("Warning: invalid column count in line %d: expected 4, got %d", lineNum, len(record))
+		// An interrupted upload leaves a partial last line; it is dropped rather than
+		// ingested or failing the file on its column count
+		if isTruncatedRecord(reader, tail, record) {
+			log.Printf("Warning: skipping truncated final line %d of s3://%s/%s: %d of %d fields and no trailing newline", lineNum, bucket, key, len(record), schema.width())
+			emitMetric("TruncatedFinalLines", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
 			continue
 		}
-		rows = append(rows, record)
+		row := csvRow{Line: lineNum, Fields: record}
+		if csvIgnoreTrailingBlanks && isBlankRecord(record) {
+			blanks = append(blanks, row)
+			continue
+		}
+		for _, blank := range blanks {
+			if err := addRecord(blank); err != nil {
+				return nil, err
+			}
+		}
+		blanks = nil
+		if err := addRecord(row); err != nil {
+			return nil, err
+		}
+	}
+	if len(blanks) > 0 {
+		log.Printf("Ignored %d trailing blank lines in s3://%s/%s", len(blanks), bucket, key)
 	}
 
 	log.Printf("CSV file processing complete: %d valid rows found", len(rows))
 	return rows, nil
 }
 
-// MonthlySummary represents a summary of transactions for a specific month.
+// bodyReader remembers the first error other than io.EOF returned by an S3 object body,
+// which tells a dropped connection apart from a malformed file.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// isBlankRecord reports whether every field of record is empty or whitespace, as in
+// the trailing ",,," or whitespace-only lines some spreadsheet exports add.
+func isBlankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// MonthlySummary represents a summary of transactions for a specific month. With
+// SUMMARY_GRANULARITY set to day or week it covers that period instead, and Month
+// holds the period's label.
+// An average is nil (JSON null) when the month has no transactions of that kind,
+// which keeps "no credits" distinct from an average of zero.
 type MonthlySummary struct {
-	Month            string  `json:"month"`
-	TransactionCount int     `json:"transaction_count"`
-	AverageCredit    float64 `json:"average_credit"`
-	AverageDebit     float64 `json:"average_debit"`
+	Month            string   `json:"month"`
+	TransactionCount int      `json:"transaction_count"`
+	AverageCredit    *float64 `json:"average_credit"`
+	AverageDebit     *float64 `json:"average_debit"`
+	// MaxCredit and MaxDebit are the month's largest credit and largest (most negative)
+	// debit, nil like the averages when the month has none.
+	MaxCredit *float64 `json:"max_credit"`
+	MaxDebit  *float64 `json:"max_debit"`
+	// Net is the sum of the month's transactions. PrevMonthNet is the previous calendar
+	// month's net and ChangePercent the change versus it; both are nil for the first month.
+	Net           *float64 `json:"net"`
+	PrevMonthNet  *float64 `json:"prev_month_net"`
+	ChangePercent *float64 `json:"change_percent"`
+	// HasLargeTransaction is set when a transaction of the month exceeds
+	// LARGE_TRANSACTION_THRESHOLD in absolute value.
+	HasLargeTransaction bool `json:"has_large_transaction,omitempty"`
+
+	// creditCount and debitCount are the transactions behind the averages, and periodKey
+	// the sortable start of the period (YYYY-MM-DD); duplicate summaries are merged with them.
+	creditCount, debitCount int
+	periodKey               string
 }
 
 // AccountSummary represents a summary of transactions for an account.
+// When the CSV schema has a currency column, Currencies holds one breakdown per
+// currency; the top-level balance and months are then only filled in when the account
+// uses a single currency, so amounts in different currencies are never added together.
 type AccountSummary struct {
-	Email            string           `json:"email"`
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+	// Tier is the account's segment from the optional tier column; the emailer picks
+	// the email template from it.
+	Tier             string              `json:"tier,omitempty"`
+	TotalBalance     float64             `json:"total_balance"`
+	MonthlySummaries []MonthlySummary    `json:"monthly_summaries"`
+	Currencies       []CurrencyBreakdown `json:"currencies,omitempty"`
+	// Transactions itemizes the account's transactions, oldest first, when it has fewer
+	// than ITEMIZE_MAX_TRANSACTIONS.
+	Transactions []Transaction `json:"transactions,omitempty"`
+	// Flagged marks an account whose transaction count exceeds TRANSACTION_COUNT_THRESHOLD.
+	Flagged    bool   `json:"flagged,omitempty"`
+	FlagReason string `json:"flag_reason,omitempty"`
+
+	// summaryTable is where PERSIST_SUMMARIES stores the summary, from the file's route.
+	summaryTable string
+}
+
+// Transaction is one itemized transaction of an account. Large is set when its
+// amount exceeds LARGE_TRANSACTION_THRESHOLD in absolute value.
+type Transaction struct {
+	Date     string  `json:"date"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"`
+	Large    bool    `json:"large,omitempty"`
+}
+
+// CurrencyBreakdown is the balance and monthly summary of an account in one currency.
+type CurrencyBreakdown struct {
+	Currency         string           `json:"currency"`
 	TotalBalance     float64          `json:"total_balance"`
 	MonthlySummaries []MonthlySummary `json:"monthly_summaries"`
 }
 
-// Event represents the input event structure for the Lambda function.
-func getTransactionSummaryByEmail(db *sql.DB, email string) (*AccountSummary, error) {
-	query := `
+// getTransactionSummaryByEmail summarizes the transactions of one account by month (or
+// the SUMMARY_GRANULARITY period), and by currency when the CSV schema has a currency column.
+// When since is valid, only transactions ingested after it are included.
+func getTransactionSummaryByEmail(ctx context.Context, db *sql.DB, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	// A NULL threshold never matches, so nothing is flagged when it is disabled
+	threshold := sql.NullFloat64{Float64: largeTransactionThreshold, Valid: largeTransactionThreshold > 0}
+	rows, err := db.QueryContext(ctx, monthlySummaryQuery(table)+`
+		ORDER BY currency, period;
+	`, email, since, threshold)
+	if err != nil {
+		return nil, classifyDBError(fmt.Errorf("query failed: %w", err))
+	}
+	defer rows.Close()
+
+	breakdowns, totals, err := scanMonthlySummaries(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range breakdowns {
+		total, err := numericFloat(totals[i], 1)
+		if err != nil {
+			return nil, err
+		}
+		breakdowns[i].TotalBalance = *total
+	}
+
+	summary := AccountSummary{Email: email}
+	if len(breakdowns) == 1 {
+		summary.TotalBalance = breakdowns[0].TotalBalance
+		summary.MonthlySummaries = breakdowns[0].MonthlySummaries
+	}
+	if schema.has("currency") {
+		summary.Currencies = breakdowns
+	}
+	if schema.has("name") {
+		if summary.Name, err = getAccountField(ctx, db, table, "name", email); err != nil {
+			return nil, err
+		}
+	}
+	if schema.has("tier") {
+		if summary.Tier, err = getAccountField(ctx, db, table, "tier", email); err != nil {
+			return nil, err
+		}
+	}
+	if itemizeMaxTransactions > 0 && summaryTransactionCount(&summary) < itemizeMaxTransactions {
+		if summary.Transactions, err = getAccountTransactions(ctx, db, table, email, since); err != nil {
+			return nil, err
+		}
+	}
+
+	return &summary, nil
+}
+
+// monthlySummaryQuery selects one row per currency and period of an account's
+// transactions in table, with $1 the email, $2 the since watermark and $3 the large
+// transaction threshold. Callers add the ORDER BY.
+func monthlySummaryQuery(table string) string {
+	return `
 		SELECT 
-			TO_CHAR(date, 'FMMonth') AS month,
+			` + currencyExpr() + ` AS currency,
+			` + periodLabelExpr() + ` AS month,
 			COUNT(*) AS num_transactions,
 			AVG(CASE 
 					WHEN TRIM(transaction) LIKE '+%' 
@@ -177,132 +650,658 @@ func getTransactionSummaryByEmail(db *sql.DB, email string) (*AccountSummary, er
 					THEN CAST(REPLACE(TRIM(transaction), '-', '') AS NUMERIC) 
 					ELSE NULL 
 				END) AS avg_debit,
-			SUM(CAST(TRIM(transaction) AS NUMERIC)) AS balance
-		FROM transacciones
+			MAX(CAST(REPLACE(TRIM(transaction), '+', '') AS NUMERIC)) FILTER (WHERE TRIM(transaction) LIKE '+%') AS max_credit,
+			MAX(CAST(REPLACE(TRIM(transaction), '-', '') AS NUMERIC)) FILTER (WHERE TRIM(transaction) LIKE '-%') AS max_debit,
+			SUM(CAST(TRIM(transaction) AS NUMERIC))::text AS balance,
+			(LAG(SUM(CAST(TRIM(transaction) AS NUMERIC))) OVER w)::text AS prev_balance,
+			LAG(` + periodExpr() + `) OVER w = ` + periodExpr() + ` - INTERVAL '1 ` + summaryGranularity + `' AS prev_adjacent,
+			COALESCE(BOOL_OR(ABS(CAST(TRIM(transaction) AS NUMERIC)) > $3), false) AS has_large,
+			COUNT(*) FILTER (WHERE TRIM(transaction) LIKE '+%') AS credit_count,
+			COUNT(*) FILTER (WHERE TRIM(transaction) LIKE '-%') AS debit_count,
+			` + periodExpr() + ` AS period
+		FROM ` + table + `
 		WHERE email = $1
-		GROUP BY DATE_TRUNC('month', date), TO_CHAR(date, 'FMMonth')
-		ORDER BY DATE_TRUNC('month', date);
-	`
+			AND ($2::bigint IS NULL OR id > $2)
+		GROUP BY ` + currencyExpr() + `, ` + periodExpr() + `, ` + periodLabelExpr() + `
+		WINDOW w AS (PARTITION BY ` + currencyExpr() + ` ORDER BY ` + periodExpr() + `)`
+}
 
-	rows, err := db.Query(query, email)
-	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+// currencyExpr is the currency of a transaction, or an empty string when the CSV schema has no
+// currency column.
+func currencyExpr() string {
+	if schema.has("currency") {
+		return "currency"
 	}
-	defer rows.Close()
+	return "''::text" // a bare literal is rejected by GROUP BY
+}
 
-	var summary AccountSummary
-	summary.Email = email
-	var totalBalance float64
+// monthlySummaryColumns are the columns of monthlySummaryQuery, in scan order.
+const monthlySummaryColumns = "currency, month, num_transactions, avg_credit, avg_debit, max_credit, max_debit, balance, prev_balance, prev_adjacent, has_large, credit_count, debit_count, period"
 
+// scanMonthlySummaries reads the rows of monthlySummaryQuery, ordered by currency and
+// period, into one breakdown per currency. Balances are read as text and summed
+// exactly, so large totals are not rounded month by month; totals[i] is the balance
+// of the months of breakdowns[i].
+func scanMonthlySummaries(rows *sql.Rows) (breakdowns []CurrencyBreakdown, totals []*big.Rat, err error) {
 	for rows.Next() {
 		var m MonthlySummary
-		var month string
-		var avgCredit, avgDebit, balance sql.NullFloat64
+		var currency, month string
+		var avgCredit, avgDebit, maxCredit, maxDebit sql.NullFloat64
+		var balanceText, prevBalanceText sql.NullString
+		var prevAdjacent sql.NullBool
+		var period time.Time
 
-		err := rows.Scan(&month, &m.TransactionCount, &avgCredit, &avgDebit, &balance)
+		err := rows.Scan(&currency, &month, &m.TransactionCount, &avgCredit, &avgDebit, &maxCredit, &maxDebit, &balanceText, &prevBalanceText, &prevAdjacent, &m.HasLargeTransaction, &m.creditCount, &m.debitCount, &period)
 		if err != nil {
-			return nil, fmt.Errorf("failed scanning row: %w", err)
+			return nil, nil, classify(ErrFatal, fmt.Errorf("failed scanning row: %w", err))
 		}
 
 		m.Month = month
-		if avgCredit.Valid {
-			m.AverageCredit = avgCredit.Float64
+		m.periodKey = period.Format("2006-01-02")
+		m.AverageCredit = finiteOrNil(avgCredit, 1)
+		m.AverageDebit = finiteOrNil(avgDebit, -1) // debit is negative
+		m.MaxCredit = finiteOrNil(maxCredit, 1)
+		m.MaxDebit = finiteOrNil(maxDebit, -1)
+		balance, err := parseNumeric(balanceText)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.Net, err = numericFloat(balance, 1); err != nil {
+			return nil, nil, err
+		}
+		prevBalance, err := parseNumeric(prevBalanceText)
+		if err != nil {
+			return nil, nil, err
+		}
+		prevNet, err := numericFloat(prevBalance, 1)
+		if err != nil {
+			return nil, nil, err
 		}
-		if avgDebit.Valid {
-			m.AverageDebit = -avgDebit.Float64 // debit is negative
+		setMonthOverMonth(&m, prevNet, prevAdjacent)
+
+		// Rows are ordered by currency, so a new currency starts a new breakdown
+		if len(breakdowns) == 0 || breakdowns[len(breakdowns)-1].Currency != currency {
+			breakdowns = append(breakdowns, CurrencyBreakdown{Currency: currency})
+			totals = append(totals, new(big.Rat))
 		}
-		if balance.Valid {
-			totalBalance += balance.Float64
+		b := &breakdowns[len(breakdowns)-1]
+		if balance != nil {
+			totals[len(totals)-1].Add(totals[len(totals)-1], balance)
 		}
+		b.MonthlySummaries = append(b.MonthlySummaries, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, classifyDBError(fmt.Errorf("failed reading rows: %w", err))
+	}
+	return breakdowns, totals, nil
+}
 
-		summary.MonthlySummaries = append(summary.MonthlySummaries, m)
+// getAccountField returns the most recently ingested non-blank value of an account
+// attribute column (name or tier), or "" when the account has none.
+func getAccountField(ctx context.Context, db *sql.DB, table, column, email string) (string, error) {
+	var value string
+	err := db.QueryRowContext(ctx, `
+		SELECT `+column+` FROM `+table+`
+		WHERE email = $1 AND `+column+` <> ''
+		ORDER BY ingested_at DESC
+		LIMIT 1`, email).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", classifyDBError(fmt.Errorf("%s query failed: %w", column, err))
 	}
-	summary.TotalBalance = totalBalance
+	return value, nil
+}
 
-	return &summary, nil
+// getAccountTransactions returns the account's individual transactions, oldest first,
+// with the same since filter as the summary.
+func getAccountTransactions(ctx context.Context, db *sql.DB, table, email string, since sql.NullInt64) ([]Transaction, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT TO_CHAR(`+localDateExpr()+`, 'YYYY-MM-DD'), CAST(TRIM(transaction) AS NUMERIC), `+currencyExpr()+`
+		FROM `+table+`
+		WHERE email = $1
+			AND ($2::bigint IS NULL OR id > $2)
+		ORDER BY date, `+keyColumn, email, since)
+	if err != nil {
+		return nil, classifyDBError(fmt.Errorf("transaction list query failed: %w", err))
+	}
+	defer rows.Close()
+
+	var transactions []Transaction
+	for rows.Next() {
+		var t Transaction
+		if err := rows.Scan(&t.Date, &t.Amount, &t.Currency); err != nil {
+			return nil, classify(ErrFatal, fmt.Errorf("failed scanning transaction: %w", err))
+		}
+		t.Large = largeTransactionThreshold > 0 && math.Abs(t.Amount) > largeTransactionThreshold
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classifyDBError(fmt.Errorf("failed reading transactions: %w", err))
+	}
+	return transactions, nil
 }
 
-// invokeNotificationLambda asynchronously invokes the notification Lambda function.
-func invokeNotificationLambda(ctx context.Context, summaries []*AccountSummary) error {
-	payload := map[string]interface{}{
-		"summaries": summaries,
+// summaryTransactionCount returns the number of transactions in a summary across all
+// months and currencies.
+func summaryTransactionCount(s *AccountSummary) int {
+	count := 0
+	for _, m := range summaryMonths(s) {
+		count += m.TransactionCount
 	}
-	jsonPayload, err := json.Marshal(payload)
+	return count
+}
+
+// setMonthOverMonth fills PrevMonthNet and ChangePercent from the previous row of the
+// window. The first month has no previous month and keeps both nil; when the previous
+// row is not the preceding calendar month, that month had no activity and its net is 0.
+// ChangePercent stays nil when the previous net is 0, since the change is unbounded.
+func setMonthOverMonth(m *MonthlySummary, prevNet *float64, prevAdjacent sql.NullBool) {
+	if !prevAdjacent.Valid || m.Net == nil {
+		return
+	}
+	prev := 0.0
+	if prevAdjacent.Bool {
+		if prevNet == nil {
+			return
+		}
+		prev = *prevNet
+	}
+	m.PrevMonthNet = &prev
+	if prev != 0 {
+		change := (*m.Net - prev) / math.Abs(prev) * 100
+		m.ChangePercent = &change
+	}
+}
+
+// finiteOrNil returns v multiplied by sign, or nil when v is NULL or not a finite number,
+// so a missing or corrupt average is never reported as a real value.
+func finiteOrNil(v sql.NullFloat64, sign float64) *float64 {
+	if !v.Valid || math.IsNaN(v.Float64) || math.IsInf(v.Float64, 0) {
+		return nil
+	}
+	f := sign * v.Float64
+	return &f
+}
+
+// processFile ingests one CSV object and returns the summaries of the accounts it touched.
+// Only failures worth retrying are returned; validation and fatal failures are logged
+// and the file is skipped, since retrying cannot help. Rows are stored and summarized
+// through a TransactionRepository; db itself serves the file lock and the processed
+// files ledger. A version of the object that is already in the ledger is not ingested
+// again; its accounts are only summarized again until their summaries are notified.
+func processFile(ctx context.Context, db *sql.DB, bucket, key string, since sql.NullInt64) ([]*AccountSummary, error) {
+	// Another container already ingesting this object owns it; its outcome is the one recorded
+	release, locked, err := lockFile(ctx, db, bucket, key)
 	if err != nil {
-		return fmt.Errorf("error serializing payload: %w", err)
+		log.Printf("Error locking file: %v", err)
+		if shouldRetry(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if !locked {
+		log.Printf("Skipping s3://%s/%s: already being processed by another invocation", bucket, key)
+		emitMetric("ConcurrentFilesSkipped", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+		return nil, nil
 	}
+	defer release()
 
-	output, err := lambdaClient.Invoke(ctx, &awslambda.InvokeInput{
-		FunctionName:   aws.String("pongo_mail"),
-		Payload:        jsonPayload,
-		InvocationType: awslambdaTypes.InvocationTypeEvent, // async
-	})
+	// Objects archived by PROCESSED_ACTION=archive were ingested under their original key
+	if isArchivedKey(key) {
+		log.Printf("Skipping s3://%s/%s: archived by PROCESSED_ACTION", bucket, key)
+		return nil, nil
+	}
+
+	// A redelivered event (e.g. retried because another of its files failed) must not
+	// insert a committed file twice; manual reprocessing is deliberate
+	entry := ledgerEntryFrom(ctx)
+	if entry == nil {
+		entry = &ledgerEntry{Bucket: bucket, Key: key}
+		ctx = withLedgerEntry(ctx, entry)
+	}
+	if entry.ETag == "" {
+		etag, err := objectETag(ctx, bucket, key)
+		if errors.Is(err, errObjectNotFound) {
+			log.Printf("Skipping file that no longer exists: %v", err)
+			return nil, nil
+		}
+		if err != nil {
+			log.Printf("Error reading ETag: %v", err)
+			if shouldRetry(err) {
+				return nil, err
+			}
+			return nil, nil
+		}
+		entry.ETag = etag
+	}
+	route := routeFor(key)
+	repo := newTransactionRepository(db)
+	if !isManualReprocess(ctx) {
+		done, err := findProcessedFile(ctx, db, bucket, key, entry.ETag)
+		if err != nil {
+			log.Printf("Error reading processed files ledger: %v", err)
+			if shouldRetry(err) {
+				return nil, err
+			}
+			return nil, nil
+		}
+		if done != nil {
+			emitMetric("AlreadyProcessedSkipped", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+			if done.Notified {
+				log.Printf("Skipping s3://%s/%s: already ingested and notified", bucket, key)
+				return nil, nil
+			}
+			log.Printf("Skipping ingest of s3://%s/%s: already ingested; summarizing its %d accounts again", bucket, key, len(done.Emails))
+			return summarizeEmails(ctx, repo, route, done.Emails, since, &IngestStatus{Bucket: bucket, Key: key}), nil
+		}
+	}
+
+	// The outcome is recorded in the ingest status ledger however the file ends up
+	status := &IngestStatus{Bucket: bucket, Key: key, Status: ingestFailed}
+	defer recordIngestStatus(ctx, status)
+	entry.Status = status
+	defer recordLedgerEntry(ctx, db, entry)
+	if audit := ingestAuditFrom(ctx); audit != nil {
+		audit.Status = status
+	}
+
+	// Old files that were re-uploaded or re-notified by accident are not ingested again
+	stale, err := isStaleObject(ctx, bucket, key)
+	if errors.Is(err, errObjectNotFound) {
+		log.Printf("Skipping file that no longer exists: %v", err)
+		status.Status = ingestSkipped
+		status.addError(err.Error())
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error invoking pongo_mail Lambda: %w", err)
+		log.Printf("Error checking file age: %v", err)
+		status.fail(err)
+		if shouldRetry(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if stale {
+		status.Status = ingestSkipped
+		status.addError("file is older than MAX_FILE_AGE")
+		return nil, nil
 	}
 
-	log.Printf("Lambda pongo_mail invoked, status: %d", output.StatusCode)
-	return nil
+	// Process CSV and get valid rows
+	rows, err := processCSVFile(ctx, bucket, key)
+	if errors.Is(err, errObjectNotFound) {
+		log.Printf("Skipping file that no longer exists: %v", err)
+		status.Status = ingestSkipped
+		status.addError(err.Error())
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error processing CSV file: %v", err)
+		status.fail(err)
+		if shouldRetry(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// Quarantine rows with invalid fields, reporting all of them at once; amounts are
+	// first rewritten from the feed's number format to the canonical one, then any
+	// partner-specific column transforms run
+	normalizeAmounts(rows)
+	applyColumnTransforms(rows)
+	readRows := len(rows)
+	rows, report := validateRows(bucket, key, rows)
+	logValidationReport(report)
+	status.RowsRead = readRows
+	status.RejectedRows = report.RejectedRows
+	for _, fe := range report.Errors {
+		status.addError(fmt.Sprintf("line %d: %s: %s", fe.Line, fe.Column, fe.Message))
+	}
+
+	// A strict feed is all or nothing: nothing has been inserted yet, so the file is rejected whole
+	if strictFeed && report.RejectedRows > 0 {
+		fe := report.Errors[0]
+		err := classify(ErrValidation, fmt.Errorf("STRICT_FEED: rejecting s3://%s/%s at line %d: %s: %s (value %q)", bucket, key, fe.Line, fe.Column, fe.Message, fe.Value))
+		log.Printf("Rejecting file: %v", err)
+		status.fail(err)
+		return nil, nil
+	}
+
+	// A file with implausibly many accounts is rejected, or ingested without fanning out
+	suspicious, err := checkEmailCap(bucket, key, rows)
+	if err != nil {
+		log.Printf("Rejecting file: %v", err)
+		status.fail(err)
+		return nil, nil
+	}
+	entry.NoSummaries = suspicious
+
+	// Insert all rows atomically, or in concurrent partitions when configured, into
+	// the table routed to by the key's prefix
+	source := fmt.Sprintf("s3://%s/%s", bucket, key)
+	emailSet, err := repo.Insert(ctx, route.Table, rows, source)
+	if err != nil {
+		status.fail(err)
+		if shouldRetry(err) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	log.Printf("Successfully inserted %d rows from file s3://%s/%s", len(rows), bucket, key)
+	entry.setEmails(emailSet)
+	finishProcessedObject(ctx, bucket, key)
+	status.Status = ingestProcessed
+	status.RowsInserted = len(rows)
+	logFileSummary(computeFileSummary(bucket, key, rows))
+	if suspicious {
+		status.addError("too many distinct emails: not summarized")
+		return nil, nil
+	}
+
+	summaries := summarizeEmails(ctx, repo, route, entry.Emails, since, status)
+
+	// A non-empty file that yields nothing to send usually means bad data (e.g. a blank
+	// email column); emit a distinct signal so it can be alerted on.
+	if readRows > 0 && len(summaries) == 0 {
+		log.Printf("Warning: file s3://%s/%s had %d rows but produced zero summaries", bucket, key, readRows)
+		emitMetric("ZeroSummaryFiles", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+	}
+	return summaries, nil
 }
 
-// handler is the main Lambda handler triggered by S3 events.
-func handler(ctx context.Context, s3Event events.S3Event) error {
+// summarizeEmails builds the summaries of a file's accounts, recording the accounts
+// that could not be summarized on status. When ctx is done, the summaries already
+// built are returned and the rest skipped.
+func summarizeEmails(ctx context.Context, repo TransactionRepository, route tableRoute, emails []string, since sql.NullInt64, status *IngestStatus) []*AccountSummary {
+	var summaries []*AccountSummary
+	for _, email := range emails {
+		if ctx.Err() != nil {
+			err := stopped(ctx, fmt.Sprintf("summarizing after %d of %d accounts", len(summaries), len(emails)))
+			log.Printf("Error generating summaries: %v", err)
+			status.addError(err.Error())
+			break
+		}
+		summary, err := summarizeAccount(ctx, repo, route.Table, email, since)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			log.Printf("Summary for %s timed out after %s", maskEmail(email), summaryTimeout)
+			status.addError(fmt.Sprintf("summary for %s timed out after %s", maskEmail(email), summaryTimeout))
+			emitMetric("SummaryTimeouts", 1, map[string]string{"Bucket": status.Bucket}, map[string]string{"Key": status.Key})
+			continue
+		}
+		if err != nil {
+			log.Printf("Error generating summary for %s: %v", maskEmail(email), err)
+			status.addError(fmt.Sprintf("summary for %s: %v", maskEmail(email), err))
+			continue
+		}
+
+		summary.summaryTable = route.SummaryTable
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// summarizeAccount builds one account's summary, retrying transient failures within
+// SUMMARY_QUERY_TIMEOUT when it is set. A timeout is returned as context.DeadlineExceeded.
+func summarizeAccount(ctx context.Context, repo TransactionRepository, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	if summaryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, summaryTimeout)
+		defer cancel()
+	}
+
+	summary, err := repo.SummaryByEmail(ctx, table, email, since)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return summary, err
+}
+
+// ReprocessRequest is the payload of a manual invocation that reprocesses one object.
+type ReprocessRequest struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+// handler is the Lambda entry point. A {"mode": "health"} invocation returns a
+// HealthReport of the summarizer's dependencies and an API Gateway request is served
+// by the on-demand summary API; anything else is handled by handleEvent and returns
+// no result.
+func handler(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	if isHealthRequest(payload) {
+		return checkHealth(ctx), nil
+	}
+	if isSummaryAPIRequest(payload) {
+		var req events.APIGatewayV2HTTPRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, classify(ErrValidation, fmt.Errorf("invalid API request: %w", err))
+		}
+		return handleSummaryAPI(ctx, req), nil
+	}
+	return nil, handleEvent(ctx, payload)
+}
+
+// handleEvent accepts an S3 event notification, an S3 event delivered through
+// EventBridge, or a ReprocessRequest, and runs the same ingest, summary and notify
+// flow for all of them.
+func handleEvent(ctx context.Context, payload json.RawMessage) error {
+	var probe struct {
+		Records json.RawMessage `json:"Records"`
+		Source  string          `json:"source"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return classify(ErrValidation, fmt.Errorf("invalid event payload: %w", err))
+	}
+
+	if probe.Records != nil {
+		var s3Event events.S3Event
+		if err := json.Unmarshal(payload, &s3Event); err != nil {
+			return classify(ErrValidation, fmt.Errorf("invalid S3 event: %w", err))
+		}
+		return handleS3Event(ctx, s3Event)
+	}
+
+	if probe.Source == eventBridgeS3Source {
+		var ebEvent events.EventBridgeEvent
+		if err := json.Unmarshal(payload, &ebEvent); err != nil {
+			return classify(ErrValidation, fmt.Errorf("invalid EventBridge event: %w", err))
+		}
+		s3Event, err := s3EventFromEventBridge(ebEvent)
+		if err != nil {
+			return classify(ErrValidation, fmt.Errorf("invalid EventBridge S3 event: %w", err))
+		}
+		return handleS3Event(ctx, s3Event)
+	}
+
+	var req ReprocessRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return classify(ErrValidation, fmt.Errorf("invalid reprocess request: %w", err))
+	}
+	if req.Bucket == "" || req.Key == "" {
+		return classify(ErrValidation, errors.New("reprocess request requires bucket and key"))
+	}
+	log.Printf("Manual reprocess requested for s3://%s/%s", req.Bucket, req.Key)
+	return handleS3Event(ctx, reprocessEvent(req))
+}
+
+// reprocessEventName marks the synthetic record of a manual reprocess.
+const reprocessEventName = "ObjectCreated:Reprocess"
+
+// reprocessEvent wraps a ReprocessRequest in an S3 event with a single created
+// object, so a manual run goes through exactly the same processing as a real upload.
+func reprocessEvent(req ReprocessRequest) events.S3Event {
+	var record events.S3EventRecord
+	record.EventName = reprocessEventName
+	record.S3.Bucket.Name = req.Bucket
+	record.S3.Object.Key = req.Key
+	record.S3.Object.URLDecodedKey = req.Key
+	return events.S3Event{Records: []events.S3EventRecord{record}}
+}
+
+// objectKey returns the real key of a record's object. S3 notifications URL-encode
+// keys (a space arrives as "+"); the decoded key is filled in when the event is
+// unmarshaled and is preferred over the raw one.
+func objectKey(record events.S3EventRecord) string {
+	if record.S3.Object.URLDecodedKey != "" {
+		return record.S3.Object.URLDecodedKey
+	}
+	return record.S3.Object.Key
+}
+
+// handleS3Event ingests and summarizes the objects of an S3 event.
+// Files are processed concurrently; if any fails transiently the error is returned
+// so the event is retried. When OPERATOR_EMAIL is set, the run's statistics are
+// emailed to it at the end, whatever the outcome.
+func handleS3Event(ctx context.Context, s3Event events.S3Event) (err error) {
 	log.Println("Lambda started processing S3 event")
 
-	db, err := getDBConnection()
+	// All retries in this invocation share one budget, so a bad file can't burn the whole timeout
+	ctx = withRetryBudget(ctx)
+	ctx = withRunStats(ctx)
+	defer func() { sendOperatorReport(ctx, err) }()
+
+	db, err := getDBConnection(ctx)
 	if err != nil {
 		log.Printf("Error getting DB connection: %v", err)
 		return err
 	}
 
-	var summaries []*AccountSummary
-	for _, record := range s3Event.Records {
-		bucket := record.S3.Bucket.Name
-		key := record.S3.Object.Key
-
-		// Process CSV and get valid rows
-		rows, err := processCSVFile(ctx, bucket, key)
+	var since sql.NullInt64
+	if incremental {
+		since, err = getLastWatermark(ctx, db)
 		if err != nil {
-			log.Printf("Error processing CSV file: %v", err)
+			log.Printf("Error reading incremental watermark: %v", err)
 			return err
 		}
+		if since.Valid {
+			log.Printf("Incremental mode: summarizing transactions with id above %d", since.Int64)
+		} else {
+			log.Println("Incremental mode: no previous run recorded, summarizing all transactions")
+		}
+	}
 
-		// Begin transaction
-		tx, err := db.Begin()
-		if err != nil {
-			log.Printf("Failed to begin DB transaction: %v", err)
-			return err
+	// Files are audited once the event's outcome is known, so a file whose summaries
+	// were never delivered is not audited as processed
+	var (
+		audits      []*ingestAudit
+		undelivered error
+	)
+	defer func() { writeIngestAudits(ctx, db, audits, errors.Join(err, undelivered)) }()
+
+	// Process files concurrently, each in its own DB transaction, capped by recordConcurrency
+	var (
+		summaries []*AccountSummary
+		entries   []*ledgerEntry
+		errs      []error
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+	)
+	sem := make(chan struct{}, recordConcurrency)
+	for _, record := range s3Event.Records {
+		bucket := record.S3.Bucket.Name
+		key := objectKey(record)
+
+		// Only newly created objects can be ingested; deletes and other events are ignored
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			log.Printf("Skipping %s event for s3://%s/%s", record.EventName, bucket, key)
+			continue
 		}
 
-		// Insert all rows atomically
-		emailSet, err := insertTransactions(tx, rows)
-		if err != nil {
-			tx.Rollback()
-			log.Printf("Transaction rollback due to error: %v", err)
-			return err
+		// Manual reprocessing is deliberate and is never treated as a duplicate
+		if record.EventName != reprocessEventName && seenRecently(bucket, key) {
+			log.Printf("Skipping duplicate event for s3://%s/%s within %s", bucket, key, eventDedupeWindow)
+			emitMetric("DuplicateEventsSkipped", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+			continue
 		}
 
-		if err := tx.Commit(); err != nil {
-			log.Printf("Failed to commit DB transaction: %v", err)
-			return err
+		entry := &ledgerEntry{Bucket: bucket, Key: key, ETag: strings.Trim(record.S3.Object.ETag, `"`)}
+		entries = append(entries, entry)
+		var audit *ingestAudit
+		if ingestAuditEnabled {
+			audit = &ingestAudit{Principal: uploaderPrincipal(record)}
+			audits = append(audits, audit)
 		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-		log.Printf("Successfully inserted %d rows from file s3://%s/%s", len(rows), bucket, key)
+			fileCtx := withLedgerEntry(ctx, entry)
+			if record.EventName == reprocessEventName {
+				fileCtx = withManualReprocess(fileCtx)
+			}
+			if audit != nil {
+				fileCtx = withIngestAudit(fileCtx, audit)
+			}
+			fileSummaries, err := processFile(fileCtx, db, bucket, key, since)
 
-		for email := range emailSet {
-			summary, err := getTransactionSummaryByEmail(db, email)
+			mu.Lock()
+			defer mu.Unlock()
+			summaries = append(summaries, fileSummaries...)
 			if err != nil {
-				log.Printf("Error generating summary for %s: %v", email, err)
-				continue
+				errs = append(errs, err)
 			}
+		}()
+	}
+	wg.Wait()
 
-			summaries = append(summaries, summary)
+	if err := errors.Join(errs...); err != nil {
+		// The event will be redelivered; it must not be mistaken for a duplicate. Its files
+		// that committed are in the processed files ledger and will not be inserted again
+		for _, record := range s3Event.Records {
+			forgetObject(record.S3.Bucket.Name, objectKey(record))
 		}
+		return err
 	}
 
-	if err := invokeNotificationLambda(ctx, summaries); err != nil {
-		log.Printf("Error invoking notification Lambda: %v", err)
-		return err
+	if persistSummaryRows {
+		if err := persistSummaries(ctx, db, summaries); err != nil {
+			log.Printf("Error persisting summaries: %v", err)
+			if shouldRetry(err) {
+				return err
+			}
+		}
+	}
+
+	// The artifact is best effort and must not block or fail the notification
+	if err := writeSummaryArtifact(ctx, summaries); err != nil {
+		log.Printf("Error writing summaries artifact: %v", err)
+	}
+
+	// Unusually busy accounts are flagged, and routed separately when a target is configured
+	if flagged := flagHighVolume(summaries); len(flagged) > 0 && flaggedNotifier != nil {
+		if err := notifyFlagged(ctx, flagged); err != nil {
+			log.Printf("Error sending flagged notification: %v", err)
+			if shouldRetry(err) {
+				return err
+			}
+		}
+		summaries = withoutFlagged(summaries)
+	}
+
+	// An email found in several files of the event is notified once
+	summaries = mergeDuplicateSummaries(summaries)
+	runStatsFrom(ctx).addSummaries(len(summaries))
+	if err := notifyOnce(ctx, db, summaries); err != nil {
+		log.Printf("Error sending notification: %v", err)
+		runStatsFrom(ctx).addError(fmt.Sprintf("notification: %v", err))
+		if shouldRetry(err) {
+			return err
+		}
+		undelivered = err
+		return nil
+	}
+	markNotified(ctx, db, entries)
+
+	if incremental {
+		// Not returned: retrying would re-ingest the files, and a missing run
+		// only makes the next incremental run cover a wider window.
+		if err := recordRun(ctx, db); err != nil {
+			log.Printf("Error recording summarizer run: %v", err)
+		}
 	}
 
 	log.Println("Lambda finished processing S3 event successfully")
@@ -310,6 +1309,7 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 }
 
 func main() {
+	loadConfig()
 	initAWSClients()
 	lambda.Start(handler)
 }
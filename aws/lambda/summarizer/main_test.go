@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestMain(m *testing.M) {
+	for _, key := range []string{"DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"} {
+		os.Setenv(key, "test")
+	}
+	loadConfig()
+	// Tests hand getDBConnection their own pool through useDB
+	dbOnce.Do(func() {})
+	os.Exit(m.Run())
+}
+
+// setVar sets *p to v for the duration of the test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// newMockDB returns a database backed by sqlmock whose expectations must all be met
+// by the end of the test.
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// monthRow is one row of monthlySummaryQuery.
+type monthRow struct {
+	currency, month  string
+	credits, debits  []float64
+	balance, prevBal string
+	period           time.Time
+	large            bool
+}
+
+// summaryRows returns monthlySummaryQuery rows for months, computing the averages,
+// maxima and counts from their credits and debits.
+func summaryRows(months ...monthRow) *sqlmock.Rows {
+	rows := sqlmock.NewRows(strings.Split(monthlySummaryColumns, ", "))
+	for _, m := range months {
+		var avgCredit, avgDebit, maxCredit, maxDebit, prevBal, prevAdjacent any
+		if len(m.credits) > 0 {
+			avgCredit, maxCredit = mean(m.credits), slices.Max(m.credits)
+		}
+		if len(m.debits) > 0 {
+			avgDebit, maxDebit = mean(m.debits), slices.Max(m.debits)
+		}
+		if m.prevBal != "" {
+			prevBal, prevAdjacent = m.prevBal, true
+		}
+		rows.AddRow(m.currency, m.month, len(m.credits)+len(m.debits), avgCredit, avgDebit, maxCredit, maxDebit,
+			m.balance, prevBal, prevAdjacent, m.large, len(m.credits), len(m.debits), m.period)
+	}
+	return rows
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// fakeS3 is an in-memory s3API. Objects are keyed by "bucket/key"; get, head and
+// put, when set, answer GetObject, HeadObject and PutObject instead.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	tags    map[string][]s3types.Tag
+	get     func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	head    func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	put     func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	gets    int
+	deleted []string
+}
+
+func newFakeS3(objects map[string]string) *fakeS3 {
+	f := &fakeS3{objects: make(map[string][]byte), tags: make(map[string][]s3types.Tag)}
+	for k, v := range objects {
+		f.objects[k] = []byte(v)
+	}
+	return f
+}
+
+func (f *fakeS3) object(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[bucket+"/"+key]
+	return data, ok
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	f.gets++
+	f.mu.Unlock()
+	if f.get != nil {
+		return f.get(in)
+	}
+	data, ok := f.object(*in.Bucket, *in.Key)
+	if !ok {
+		return nil, s3NotFound(&s3types.NoSuchKey{})
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data)), ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if f.put != nil {
+		return f.put(in)
+	}
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Bucket+"/"+*in.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if f.head != nil {
+		return f.head(in)
+	}
+	data, ok := f.object(*in.Bucket, *in.Key)
+	if !ok {
+		return nil, s3NotFound(&s3types.NotFound{})
+	}
+	return &s3.HeadObjectOutput{
+		ETag:          aws.String(`"etag-` + *in.Key + `"`),
+		ContentLength: aws.Int64(int64(len(data))),
+		LastModified:  aws.Time(clock()),
+	}, nil
+}
+
+func (f *fakeS3) DeleteObject(ctx context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, *in.Bucket+"/"+*in.Key)
+	f.deleted = append(f.deleted, *in.Bucket+"/"+*in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObjectTagging(ctx context.Context, in *s3.GetObjectTaggingInput, _ ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &s3.GetObjectTaggingOutput{TagSet: f.tags[*in.Bucket+"/"+*in.Key]}, nil
+}
+
+func (f *fakeS3) PutObjectTagging(ctx context.Context, in *s3.PutObjectTaggingInput, _ ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tags[*in.Bucket+"/"+*in.Key] = in.Tagging.TagSet
+	return &s3.PutObjectTaggingOutput{}, nil
+}
+
+// s3NotFound wraps err in a 404 response error, as the SDK returns it.
+func s3NotFound(err error) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusNotFound}},
+		Err:      err,
+	}}
+}
+
+// useS3 replaces the S3 client for the duration of the test.
+func useS3(t *testing.T, f *fakeS3) {
+	t.Helper()
+	setVar[s3API](t, &s3Client, f)
+}
+
+// fields returns the Fields of rows.
+func fields(rows []csvRow) [][]string {
+	out := make([][]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.Fields
+	}
+	return out
+}
+
+// memRepository is an in-memory TransactionRepository. Summaries hold the account's
+// balance and one month per "2006-01" of its transaction dates. Inserts of the
+// sources in fail return their error; sources lists the sources inserted.
+type memRepository struct {
+	mu      sync.Mutex
+	tables  map[string][]csvRow
+	fail    map[string]error
+	sources []string
+}
+
+func newMemRepository() *memRepository {
+	return &memRepository{tables: make(map[string][]csvRow)}
+}
+
+func (r *memRepository) Insert(ctx context.Context, table string, rows []csvRow, sourceKey string) (map[string]struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.fail[sourceKey]; err != nil {
+		return nil, err
+	}
+	emails := make(map[string]struct{})
+	for _, row := range rows {
+		if email := strings.TrimSpace(schema.field(row.Fields, "email")); email != "" {
+			emails[email] = struct{}{}
+		}
+	}
+	r.tables[table] = append(r.tables[table], rows...)
+	r.sources = append(r.sources, sourceKey)
+	return emails, nil
+}
+
+func (r *memRepository) SummaryByEmail(ctx context.Context, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := &AccountSummary{Email: email}
+	for _, row := range r.tables[table] {
+		if strings.TrimSpace(schema.field(row.Fields, "email")) != email {
+			continue
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(schema.field(row.Fields, "transaction")), 64)
+		if err != nil {
+			return nil, err
+		}
+		summary.TotalBalance += amount
+		month := schema.field(row.Fields, "date")[:7]
+		if n := len(summary.MonthlySummaries); n == 0 || summary.MonthlySummaries[n-1].Month != month {
+			summary.MonthlySummaries = append(summary.MonthlySummaries, MonthlySummary{Month: month})
+		}
+		summary.MonthlySummaries[len(summary.MonthlySummaries)-1].TransactionCount++
+	}
+	return summary, nil
+}
+
+// rowCount returns the number of rows stored in table.
+func (r *memRepository) rowCount(table string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.tables[table])
+}
+
+// useRepository makes processFile store and summarize through repo for the test.
+func useRepository(t *testing.T, repo TransactionRepository) {
+	t.Helper()
+	setVar(t, &newTransactionRepository, func(*sql.DB) TransactionRepository { return repo })
+}
+
+// expectNewFile expects processFile to find no earlier ingest of the object in the
+// processed files ledger.
+func expectNewFile(mock sqlmock.Sqlmock, bucket, key string) {
+	mock.ExpectQuery("FROM processed_files").WithArgs(bucket, key, sqlmock.AnyArg(), ingestProcessed).
+		WillReturnRows(sqlmock.NewRows([]string{"emails", "notified"}))
+}
+
+// expectLedgerWrite expects the file's outcome to be written to the ledger with status.
+func expectLedgerWrite(mock sqlmock.Sqlmock, bucket, key, status string) {
+	mock.ExpectExec("INSERT INTO processed_files").
+		WithArgs(bucket, key, sqlmock.AnyArg(), status, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// useDB makes getDBConnection return conn for the duration of the test.
+func useDB(t *testing.T, conn *sql.DB) {
+	t.Helper()
+	setVar(t, &db, conn)
+}
+
+// expectNotified expects the file to be marked as notified in the ledger.
+func expectNotified(mock sqlmock.Sqlmock, bucket, key string) {
+	mock.ExpectExec("UPDATE processed_files SET notified_at").WithArgs(bucket, key, sqlmock.AnyArg(), ingestProcessed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// fakeNotifier is a Notifier that records the payloads it delivers and fails with err.
+type fakeNotifier struct {
+	mu       sync.Mutex
+	err      error
+	payloads []NotificationPayload
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.payloads = append(n.payloads, payload)
+	return n.err
+}
+
+// emails returns the sorted emails of all the summaries delivered.
+func (n *fakeNotifier) emails() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var emails []string
+	for _, p := range n.payloads {
+		for _, s := range p.Summaries {
+			emails = append(emails, s.Email)
+		}
+	}
+	slices.Sort(emails)
+	return emails
+}
+
+// useNotifier replaces the notifier for the duration of the test.
+func useNotifier(t *testing.T, n Notifier) {
+	t.Helper()
+	setVar(t, &notifier, n)
+}
+
+// s3Event returns an ObjectCreated event for keys in bucket.
+func s3Event(bucket string, keys ...string) events.S3Event {
+	var event events.S3Event
+	for _, key := range keys {
+		var record events.S3EventRecord
+		record.EventName = "ObjectCreated:Put"
+		record.S3.Bucket.Name = bucket
+		record.S3.Object.Key = key
+		record.S3.Object.ETag = "etag-" + key
+		event.Records = append(event.Records, record)
+	}
+	return event
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// metricsOut receives the metric records; tests replace it to capture them.
+var metricsOut io.Writer = os.Stdout
+
+// emitMetric writes a single CloudWatch Embedded Metric Format record to stdout, which
+// CloudWatch turns into a metric without any API call. Dimensions become metric
+// dimensions; properties are attached to the log event for investigation only.
+func emitMetric(name string, value float64, dimensions, properties map[string]string) {
+	dimNames := make([]string, 0, len(dimensions))
+	record := map[string]interface{}{}
+	for k, v := range properties {
+		record[k] = v
+	}
+	for k, v := range dimensions {
+		dimNames = append(dimNames, k)
+		record[k] = v
+	}
+	record[name] = value
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": clock().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  metricsNamespace,
+			"Dimensions": [][]string{dimNames},
+			"Metrics":    []map[string]string{{"Name": name, "Unit": "Count"}},
+		}},
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error serializing metric %s: %v", name, err)
+		return
+	}
+	fmt.Fprintln(metricsOut, string(data))
+}
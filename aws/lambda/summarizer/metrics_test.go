@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+// metricRecorder captures the metric records emitted during a test.
+type metricRecorder struct {
+	buf bytes.Buffer
+}
+
+// captureMetrics sends the metric records emitted by the test to the returned recorder.
+func captureMetrics(t *testing.T) *metricRecorder {
+	t.Helper()
+	r := &metricRecorder{}
+	setVar[io.Writer](t, &metricsOut, &r.buf)
+	return r
+}
+
+// records returns the metric records emitted with name.
+func (r *metricRecorder) records(t *testing.T, name string) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(r.buf.Bytes()))
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("metric record %q is not JSON: %v", scanner.Text(), err)
+		}
+		if _, ok := record[name]; ok {
+			out = append(out, record)
+		}
+	}
+	return out
+}
+
+func TestEmitMetricWritesEmbeddedMetricFormat(t *testing.T) {
+	m := captureMetrics(t)
+	emitMetric("FilesProcessed", 2, map[string]string{"Bucket": "bucket"}, map[string]string{"Key": "file.csv"})
+
+	records := m.records(t, "FilesProcessed")
+	if len(records) != 1 {
+		t.Fatalf("emitted %d FilesProcessed records, want 1", len(records))
+	}
+	r := records[0]
+	if r["FilesProcessed"] != 2.0 || r["Bucket"] != "bucket" || r["Key"] != "file.csv" {
+		t.Errorf("record = %v", r)
+	}
+	aws, _ := r["_aws"].(map[string]any)
+	metrics, _ := aws["CloudWatchMetrics"].([]any)
+	if len(metrics) != 1 || metrics[0].(map[string]any)["Namespace"] != metricsNamespace {
+		t.Errorf("_aws = %v, want one metric in namespace %s", aws, metricsNamespace)
+	}
+}
+
+func TestProcessFileSignalsZeroSummaryFile(t *testing.T) {
+	m := captureMetrics(t)
+	useS3(t, newFakeS3(map[string]string{"bucket/blank.csv": "id,date,transaction,email\n1,2024-01-01,+10,\n2,2024-01-02,-5, \n"}))
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "blank.csv")
+	expectLedgerWrite(mock, "bucket", "blank.csv", ingestProcessed)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "blank.csv", sql.NullInt64{})
+	if err != nil || len(summaries) != 0 {
+		t.Fatalf("processFile() = %v, %v, want no summaries", summaries, err)
+	}
+	records := m.records(t, "ZeroSummaryFiles")
+	if len(records) != 1 || records[0]["Key"] != "blank.csv" {
+		t.Errorf("ZeroSummaryFiles records = %v, want one for blank.csv", records)
+	}
+}
+
+func TestProcessFileDoesNotSignalEmptyFile(t *testing.T) {
+	m := captureMetrics(t)
+	useS3(t, newFakeS3(map[string]string{"bucket/empty.csv": "id,date,transaction,email\n"}))
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "empty.csv")
+	expectLedgerWrite(mock, "bucket", "empty.csv", ingestProcessed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "empty.csv", sql.NullInt64{}); err != nil {
+		t.Fatal(err)
+	}
+	if records := m.records(t, "ZeroSummaryFiles"); len(records) != 0 {
+		t.Errorf("ZeroSummaryFiles records = %v, want none for a file without rows", records)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func TestProcessFileSkipsObjectDeletedBeforeProcessing(t *testing.T) {
+	useS3(t, newFakeS3(nil))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, _ := newMockDB(t)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "gone.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v, want the file skipped", err)
+	}
+	if len(summaries) != 0 || repo.rowCount("transacciones") != 0 {
+		t.Errorf("processFile() = %d summaries, %d rows stored, want nothing", len(summaries), repo.rowCount("transacciones"))
+	}
+}
+
+func TestProcessFileSkipsObjectDeletedWhileReading(t *testing.T) {
+	f := newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n"})
+	f.get = func(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+		return nil, s3NotFound(&s3types.NoSuchKey{})
+	}
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestSkipped)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v, want the file skipped", err)
+	}
+	if len(summaries) != 0 {
+		t.Errorf("processFile() = %d summaries, want none", len(summaries))
+	}
+	if f.gets != 1 {
+		t.Errorf("GetObject called %d times, want 1 (not retried)", f.gets)
+	}
+}
+
+func TestProcessCSVFileReportsNotFoundAsNonRetryable(t *testing.T) {
+	useS3(t, newFakeS3(nil))
+	_, err := processCSVFile(context.Background(), "bucket", "gone.csv")
+	if !errors.Is(err, errObjectNotFound) || shouldRetry(err) {
+		t.Errorf("processCSVFile() = %v, want a non-retryable errObjectNotFound", err)
+	}
+}
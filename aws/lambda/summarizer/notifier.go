@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	awslambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// Supported values for NOTIFY_CHANNEL.
+const (
+	notifyChannelLambda = "lambda"
+	notifyChannelSNS    = "sns"
+	notifyChannelSQS    = "sqs"
+	// notifyChannelEventBridge publishes one event per summary instead of emailing.
+	notifyChannelEventBridge = "eventbridge"
+)
+
+// Supported values for NOTIFY_PAYLOAD_ENCODING.
+const (
+	payloadEncodingJSON = "json"
+	payloadEncodingGzip = "gzip"
+)
+
+// NotificationPayload is the event sent to the notification channel.
+// SchemaVersion lets the receiver reject payloads it does not understand. Summaries
+// are always serialized in the v1 schema, whatever SUMMARY_SCHEMA says.
+// With the gzip encoding, Summaries is empty and Data holds the gzipped JSON
+// summaries array (base64 encoded on the wire).
+type NotificationPayload struct {
+	SchemaVersion   int               `json:"schema_version"`
+	PayloadEncoding string            `json:"payload_encoding,omitempty"`
+	Summaries       []*AccountSummary `json:"summaries"`
+	Data            []byte            `json:"data,omitempty"`
+	// Period is the month (YYYY-MM) the summaries are for: the latest period in their
+	// data, in REPORT_TIMEZONE. The emailer keys its send markers by it.
+	Period string `json:"period"`
+}
+
+// Notifier delivers generated summaries to whatever sends the emails.
+type Notifier interface {
+	Notify(ctx context.Context, payload NotificationPayload) error
+}
+
+// lambdaInvokeAPI is the subset of the Lambda client used by lambdaNotifier.
+type lambdaInvokeAPI interface {
+	Invoke(ctx context.Context, params *awslambda.InvokeInput, optFns ...func(*awslambda.Options)) (*awslambda.InvokeOutput, error)
+}
+
+// snsPublishAPI is the subset of the SNS client used by snsNotifier.
+type snsPublishAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// sqsSendAPI is the subset of the SQS client used by sqsNotifier.
+type sqsSendAPI interface {
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+}
+
+// newNotifier builds the Notifier selected by NOTIFY_CHANNEL for target from an AWS config.
+func newNotifier(cfg aws.Config, target string) (Notifier, error) {
+	switch notifyChannel {
+	case notifyChannelLambda:
+		return &lambdaNotifier{client: awslambda.NewFromConfig(cfg), functionName: target, sync: notifySync}, nil
+	case notifyChannelSNS:
+		return &snsNotifier{client: sns.NewFromConfig(cfg), topicARN: target}, nil
+	case notifyChannelSQS:
+		return &sqsNotifier{client: sqs.NewFromConfig(cfg), queueURL: target}, nil
+	case notifyChannelEventBridge:
+		return newEventBridgeNotifier(cfg, target), nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFY_CHANNEL %q", notifyChannel)
+	}
+}
+
+// newEventBridgeNotifier builds an eventBridgeNotifier for busName with the configured
+// source and detail type.
+func newEventBridgeNotifier(cfg aws.Config, busName string) *eventBridgeNotifier {
+	return &eventBridgeNotifier{
+		client:     eventbridge.NewFromConfig(cfg),
+		busName:    busName,
+		source:     eventBridgeSource,
+		detailType: eventBridgeDetailType,
+	}
+}
+
+// lambdaNotifier invokes the notification Lambda function, asynchronously unless
+// sync is set, in which case it waits for and reports the per-recipient result.
+type lambdaNotifier struct {
+	client       lambdaInvokeAPI
+	functionName string
+	sync         bool
+}
+
+// EmailSendResult is the per-recipient result returned by the emailer.
+type EmailSendResult struct {
+	Sent   []string           `json:"sent"`
+	Failed []EmailSendFailure `json:"failed,omitempty"`
+	Queued int                `json:"queued,omitempty"`
+	// Deferred counts emails over the emailer's per-invocation cap, put in its outbox unsent.
+	Deferred int `json:"deferred,omitempty"`
+	// Skipped lists recipients outside the emailer's domain allowlist.
+	Skipped []string `json:"skipped,omitempty"`
+	// AlreadySent lists recipients whose send marker for the period already existed.
+	AlreadySent []string `json:"already_sent,omitempty"`
+}
+
+// EmailSendFailure describes an email the emailer could not send.
+type EmailSendFailure struct {
+	Email     string `json:"email"`
+	Error     string `json:"error"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (n *lambdaNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	jsonPayload, err := marshalPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	invocationType := awslambdaTypes.InvocationTypeEvent // async
+	if n.sync {
+		invocationType = awslambdaTypes.InvocationTypeRequestResponse
+	}
+	output, err := n.client.Invoke(ctx, &awslambda.InvokeInput{
+		FunctionName:   aws.String(n.functionName),
+		Payload:        jsonPayload,
+		InvocationType: invocationType,
+	})
+	if err != nil {
+		return classifyAWSError(fmt.Errorf("error invoking %s Lambda: %w", n.functionName, err))
+	}
+
+	log.Printf("Lambda %s invoked, status: %d", n.functionName, output.StatusCode)
+	if !n.sync {
+		return nil
+	}
+
+	// The emailer rejected the whole payload; retrying would send the same payload again
+	if output.FunctionError != nil {
+		return classify(ErrFatal, fmt.Errorf("%s Lambda failed (%s): %s", n.functionName, aws.ToString(output.FunctionError), output.Payload))
+	}
+
+	var result EmailSendResult
+	if err := json.Unmarshal(output.Payload, &result); err != nil {
+		return classify(ErrFatal, fmt.Errorf("error decoding %s Lambda result: %w", n.functionName, err))
+	}
+	reportSendResult(ctx, result)
+	return nil
+}
+
+// reportSendResult logs and emits metrics for the emailer's per-recipient outcome.
+// Failed emails are not retried here: the emailer owns retries through its outbox.
+func reportSendResult(ctx context.Context, result EmailSendResult) {
+	runStatsFrom(ctx).addSendResult(result)
+	log.Printf("Emailer result: %d sent, %d failed, %d queued for retry, %d deferred, %d skipped, %d already sent",
+		len(result.Sent), len(result.Failed), result.Queued, result.Deferred, len(result.Skipped), len(result.AlreadySent))
+	for _, f := range result.Failed {
+		log.Printf("Email to %s failed (retryable: %t): %s", maskEmail(f.Email), f.Retryable, f.Error)
+	}
+
+	dims := map[string]string{"Channel": notifyChannelLambda}
+	emitMetric("EmailsSent", float64(len(result.Sent)), dims, nil)
+	emitMetric("EmailsFailed", float64(len(result.Failed)), dims, nil)
+	emitMetric("EmailsQueued", float64(result.Queued), dims, nil)
+	emitMetric("EmailsDeferred", float64(result.Deferred), dims, nil)
+	emitMetric("EmailsAlreadySent", float64(len(result.AlreadySent)), dims, nil)
+}
+
+// snsNotifier publishes the payload to an SNS topic.
+type snsNotifier struct {
+	client   snsPublishAPI
+	topicARN string
+}
+
+func (n *snsNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	jsonPayload, err := marshalPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	output, err := n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicARN),
+		Message:  aws.String(string(jsonPayload)),
+	})
+	if err != nil {
+		return classifyAWSError(fmt.Errorf("error publishing to SNS topic %s: %w", n.topicARN, err))
+	}
+
+	log.Printf("Summaries published to SNS topic %s, message ID: %s", n.topicARN, aws.ToString(output.MessageId))
+	return nil
+}
+
+// sqsNotifier sends the payload as a message to an SQS queue.
+type sqsNotifier struct {
+	client   sqsSendAPI
+	queueURL string
+}
+
+func (n *sqsNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	jsonPayload, err := marshalPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	output, err := n.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(n.queueURL),
+		MessageBody: aws.String(string(jsonPayload)),
+	})
+	if err != nil {
+		return classifyAWSError(fmt.Errorf("error sending to SQS queue %s: %w", n.queueURL, err))
+	}
+
+	log.Printf("Summaries sent to SQS queue %s, message ID: %s", n.queueURL, aws.ToString(output.MessageId))
+	return nil
+}
+
+// marshalPayload serializes a payload; a failure here is a programming error and is fatal.
+func marshalPayload(payload NotificationPayload) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, classify(ErrFatal, fmt.Errorf("error serializing payload: %w", err))
+	}
+	return data, nil
+}
+
+// notifySummaries hands the summaries to the notifier.
+func notifySummaries(ctx context.Context, summaries []*AccountSummary) error {
+	return notifyBatches(ctx, notifier, summaries)
+}
+
+// notifyFlagged hands flagged summaries to the flagged notifier.
+func notifyFlagged(ctx context.Context, summaries []*AccountSummary) error {
+	return notifyBatches(ctx, flaggedNotifier, summaries)
+}
+
+// notifyBatches sends the summaries to n in one payload, or in payloads of at most
+// NOTIFY_BATCH_SIZE summaries each when it is set. At most NOTIFY_MAX_IN_FLIGHT batches
+// are sent at a time (one by one, in order, by default); once a batch fails no further
+// batches are started and the failures are returned. Batches already sent are not
+// undone; with NOTIFY_DEDUPE_TTL a retried run skips them, otherwise it relies on the
+// emailer's send markers.
+func notifyBatches(ctx context.Context, n Notifier, summaries []*AccountSummary) error {
+	if notifyBatchSize <= 0 || len(summaries) <= notifyBatchSize {
+		payload, err := buildPayload(summaries)
+		if err != nil {
+			return err
+		}
+		return deliverOnce(withBatchToken(ctx, summaries), n, "", payload)
+	}
+
+	var (
+		errs []error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}
+	sem := make(chan struct{}, notifyMaxInFlight)
+	for start := 0; start < len(summaries); start += notifyBatchSize {
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			batch := summaries[start:min(start+notifyBatchSize, len(summaries))]
+			payload, err := buildPayload(batch)
+			if err == nil {
+				err = deliverOnce(withBatchToken(ctx, batch), n, "", payload)
+			}
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, fmt.Errorf("batch starting at summary %d of %d: %w", start+1, len(summaries), err))
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// buildPayload wraps the summaries in a versioned payload, compressing them when
+// NOTIFY_PAYLOAD_ENCODING is gzip.
+func buildPayload(summaries []*AccountSummary) (NotificationPayload, error) {
+	payload := NotificationPayload{
+		SchemaVersion: notifySchemaVersion,
+		Period:        summaryPeriod(summaries),
+		Summaries:     summaries,
+	}
+	if notifyPayloadEncoding == payloadEncodingGzip {
+		data, err := gzipSummaries(summaries)
+		if err != nil {
+			return payload, classify(ErrFatal, fmt.Errorf("error compressing payload: %w", err))
+		}
+		payload.PayloadEncoding = payloadEncodingGzip
+		payload.Summaries = nil
+		payload.Data = data
+	}
+	return payload, nil
+}
+
+// summaryPeriod returns the month (YYYY-MM) of the latest period in summaries. Periods
+// are bucketed in REPORT_TIMEZONE, so a run just after midnight UTC still labels a
+// local month correctly; without any period it falls back to the current month there.
+func summaryPeriod(summaries []*AccountSummary) string {
+	latest := ""
+	for _, s := range summaries {
+		months := s.MonthlySummaries
+		for _, c := range s.Currencies {
+			months = append(months[:len(months):len(months)], c.MonthlySummaries...)
+		}
+		for _, m := range months {
+			if m.periodKey > latest {
+				latest = m.periodKey
+			}
+		}
+	}
+	if len(latest) >= len("2006-01") {
+		return latest[:len("2006-01")]
+	}
+	loc, err := time.LoadLocation(reportTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return clock().In(loc).Format("2006-01")
+}
+
+// gzipSummaries returns the gzipped JSON encoding of summaries.
+func gzipSummaries(summaries []*AccountSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(summaries); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	awslambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go"
+)
+
+// fakeLambda is a lambdaInvokeAPI that records the payloads it is invoked with and
+// answers with invoke, or succeeds when invoke is nil.
+type fakeLambda struct {
+	mu       sync.Mutex
+	invoke   func(*awslambda.InvokeInput) (*awslambda.InvokeOutput, error)
+	payloads [][]byte
+}
+
+func (f *fakeLambda) Invoke(ctx context.Context, in *awslambda.InvokeInput, _ ...func(*awslambda.Options)) (*awslambda.InvokeOutput, error) {
+	f.mu.Lock()
+	f.payloads = append(f.payloads, in.Payload)
+	f.mu.Unlock()
+	if f.invoke == nil {
+		return &awslambda.InvokeOutput{StatusCode: 202}, nil
+	}
+	return f.invoke(in)
+}
+
+func TestNotifyStampsSchemaVersion(t *testing.T) {
+	setVar(t, &notifySchemaVersion, 3)
+	client := &fakeLambda{}
+	n := &lambdaNotifier{client: client, functionName: "emailer"}
+
+	payload, err := buildPayload([]*AccountSummary{{Email: "jane@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), payload); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(client.payloads) != 1 {
+		t.Fatalf("Invoke called %d times, want 1", len(client.payloads))
+	}
+	var got struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(client.payloads[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SchemaVersion != 3 {
+		t.Errorf("schema_version = %d, want 3", got.SchemaVersion)
+	}
+}
+
+// fakeSNS is an snsPublishAPI that records what it publishes.
+type fakeSNS struct {
+	inputs []*sns.PublishInput
+	err    error
+}
+
+func (f *fakeSNS) Publish(ctx context.Context, in *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.inputs = append(f.inputs, in)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sns.PublishOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+// fakeSQS is an sqsSendAPI that records the messages it sends.
+type fakeSQS struct {
+	inputs []*sqs.SendMessageInput
+}
+
+func (f *fakeSQS) SendMessage(ctx context.Context, in *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	f.inputs = append(f.inputs, in)
+	return &sqs.SendMessageOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+// notifiedEmails decodes a serialized payload and returns the emails of its summaries.
+func notifiedEmails(t *testing.T, data []byte) []string {
+	t.Helper()
+	var payload NotificationPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	var emails []string
+	for _, s := range payload.Summaries {
+		emails = append(emails, s.Email)
+	}
+	return emails
+}
+
+// testPayload returns a payload with the summaries of two accounts.
+func testPayload(t *testing.T) NotificationPayload {
+	t.Helper()
+	payload, err := buildPayload([]*AccountSummary{{Email: "jane@example.com"}, {Email: "john@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestLambdaNotifierInvokesAsynchronously(t *testing.T) {
+	client := &fakeLambda{}
+	var got *awslambda.InvokeInput
+	client.invoke = func(in *awslambda.InvokeInput) (*awslambda.InvokeOutput, error) {
+		got = in
+		return &awslambda.InvokeOutput{StatusCode: 202}, nil
+	}
+	n := &lambdaNotifier{client: client, functionName: "emailer"}
+
+	if err := n.Notify(context.Background(), testPayload(t)); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if aws.ToString(got.FunctionName) != "emailer" || got.InvocationType != awslambdaTypes.InvocationTypeEvent {
+		t.Errorf("invoked %s (%s), want emailer asynchronously", aws.ToString(got.FunctionName), got.InvocationType)
+	}
+	if emails := notifiedEmails(t, got.Payload); !slices.Equal(emails, []string{"jane@example.com", "john@example.com"}) {
+		t.Errorf("payload summaries = %v", emails)
+	}
+}
+
+func TestSNSNotifierPublishesPayload(t *testing.T) {
+	client := &fakeSNS{}
+	n := &snsNotifier{client: client, topicARN: "arn:aws:sns:us-east-1:123456789012:summaries"}
+
+	if err := n.Notify(context.Background(), testPayload(t)); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(client.inputs) != 1 {
+		t.Fatalf("Publish called %d times, want 1", len(client.inputs))
+	}
+	in := client.inputs[0]
+	if aws.ToString(in.TopicArn) != n.topicARN {
+		t.Errorf("TopicArn = %s, want %s", aws.ToString(in.TopicArn), n.topicARN)
+	}
+	if emails := notifiedEmails(t, []byte(aws.ToString(in.Message))); !slices.Equal(emails, []string{"jane@example.com", "john@example.com"}) {
+		t.Errorf("message summaries = %v", emails)
+	}
+}
+
+func TestSNSNotifierClassifiesErrors(t *testing.T) {
+	n := &snsNotifier{client: &fakeSNS{err: &smithy.GenericAPIError{Code: "Throttling"}}, topicARN: "topic"}
+	if err := n.Notify(context.Background(), testPayload(t)); !shouldRetry(err) {
+		t.Errorf("Notify() error = %v, want a retryable error", err)
+	}
+}
+
+func TestSQSNotifierSendsPayload(t *testing.T) {
+	client := &fakeSQS{}
+	n := &sqsNotifier{client: client, queueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/summaries"}
+
+	if err := n.Notify(context.Background(), testPayload(t)); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(client.inputs) != 1 {
+		t.Fatalf("SendMessage called %d times, want 1", len(client.inputs))
+	}
+	in := client.inputs[0]
+	if aws.ToString(in.QueueUrl) != n.queueURL {
+		t.Errorf("QueueUrl = %s, want %s", aws.ToString(in.QueueUrl), n.queueURL)
+	}
+	if emails := notifiedEmails(t, []byte(aws.ToString(in.MessageBody))); !slices.Equal(emails, []string{"jane@example.com", "john@example.com"}) {
+		t.Errorf("message summaries = %v", emails)
+	}
+}
+
+func TestNewNotifierSelectsChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    Notifier
+	}{
+		{notifyChannelLambda, &lambdaNotifier{}},
+		{notifyChannelSNS, &snsNotifier{}},
+		{notifyChannelSQS, &sqsNotifier{}},
+		{notifyChannelEventBridge, &eventBridgeNotifier{}},
+	}
+	for _, tt := range tests {
+		setVar(t, &notifyChannel, tt.channel)
+		n, err := newNotifier(aws.Config{Region: "us-east-1"}, "target")
+		if err != nil {
+			t.Fatalf("newNotifier(%s) error = %v", tt.channel, err)
+		}
+		if reflect.TypeOf(n) != reflect.TypeOf(tt.want) {
+			t.Errorf("newNotifier(%s) = %T, want %T", tt.channel, n, tt.want)
+		}
+	}
+
+	setVar(t, &notifyChannel, "pigeon")
+	if _, err := newNotifier(aws.Config{}, "target"); err == nil {
+		t.Error("newNotifier(pigeon) succeeded, want an error")
+	}
+}
+
+func TestBuildPayloadStampsPeriodOfTheData(t *testing.T) {
+	// The run is in April UTC, but the data only goes up to March
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 4, 1, 2, 0, 0, 0, time.UTC) })
+	summaries := []*AccountSummary{
+		{Email: "jane@example.com", MonthlySummaries: []MonthlySummary{{Month: "February", periodKey: "2024-02-01"}, {Month: "March", periodKey: "2024-03-01"}}},
+		{Email: "john@example.com", MonthlySummaries: []MonthlySummary{{Month: "January", periodKey: "2024-01-01"}}},
+	}
+	payload, err := buildPayload(summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Period != "2024-03" {
+		t.Errorf("Period = %q, want 2024-03", payload.Period)
+	}
+}
+
+func TestBuildPayloadStampsCurrentMonthInReportTimezone(t *testing.T) {
+	setVar(t, &reportTimezone, "America/Mexico_City")
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 4, 1, 2, 0, 0, 0, time.UTC) })
+	payload, err := buildPayload([]*AccountSummary{{Email: "jane@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Period != "2024-03" {
+		t.Errorf("Period = %q, want 2024-03, the month in REPORT_TIMEZONE", payload.Period)
+	}
+}
+
+func TestBuildPayloadCompressesSummaries(t *testing.T) {
+	setVar(t, &notifyPayloadEncoding, payloadEncodingGzip)
+	net := 42.5
+	summaries := []*AccountSummary{
+		{Email: "jane@example.com", TotalBalance: 42.5, MonthlySummaries: []MonthlySummary{{Month: "January", TransactionCount: 2, Net: &net}}},
+		{Email: "john@example.com", TotalBalance: -3},
+	}
+
+	payload, err := buildPayload(summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.PayloadEncoding != payloadEncodingGzip || payload.Summaries != nil || len(payload.Data) == 0 {
+		t.Fatalf("payload = %+v, want only gzipped data", payload)
+	}
+
+	// The emailer reads the data back as it is sent: base64 in the JSON payload
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent NotificationPayload
+	if err := json.Unmarshal(data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(sent.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*AccountSummary
+	if err := json.NewDecoder(zr).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(summaries)
+	if gotJSON, _ := json.Marshal(got); string(gotJSON) != string(want) {
+		t.Errorf("decompressed summaries = %s, want %s", gotJSON, want)
+	}
+}
+
+func TestLambdaNotifierSurfacesSyncSendResult(t *testing.T) {
+	m := captureMetrics(t)
+	var got *awslambda.InvokeInput
+	client := &fakeLambda{invoke: func(in *awslambda.InvokeInput) (*awslambda.InvokeOutput, error) {
+		got = in
+		return &awslambda.InvokeOutput{StatusCode: 200, Payload: []byte(`{
+			"sent": ["jane@example.com"],
+			"failed": [{"email": "john@example.com", "error": "mailbox full", "retryable": false}],
+			"deferred": 2,
+			"already_sent": ["ana@example.com"]
+		}`)}, nil
+	}}
+	n := &lambdaNotifier{client: client, functionName: "emailer", sync: true}
+	stats := &runStats{}
+	ctx := context.WithValue(context.Background(), runStatsKey{}, stats)
+
+	if err := n.Notify(ctx, testPayload(t)); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if got.InvocationType != awslambdaTypes.InvocationTypeRequestResponse {
+		t.Errorf("InvocationType = %s, want RequestResponse", got.InvocationType)
+	}
+	if !stats.emailsKnown || stats.emailsSent != 1 || stats.emailsFailed != 1 {
+		t.Errorf("run stats = %d sent, %d failed, want 1 and 1", stats.emailsSent, stats.emailsFailed)
+	}
+	if records := m.records(t, "EmailsFailed"); len(records) != 1 || records[0]["EmailsFailed"] != 1.0 {
+		t.Errorf("EmailsFailed records = %v, want one of 1", records)
+	}
+	if records := m.records(t, "EmailsDeferred"); len(records) != 1 || records[0]["EmailsDeferred"] != 2.0 {
+		t.Errorf("EmailsDeferred records = %v, want one of 2", records)
+	}
+	if records := m.records(t, "EmailsAlreadySent"); len(records) != 1 || records[0]["EmailsAlreadySent"] != 1.0 {
+		t.Errorf("EmailsAlreadySent records = %v, want one of 1", records)
+	}
+}
+
+func TestLambdaNotifierReportsEmailerFunctionError(t *testing.T) {
+	client := &fakeLambda{invoke: func(*awslambda.InvokeInput) (*awslambda.InvokeOutput, error) {
+		return &awslambda.InvokeOutput{StatusCode: 200, FunctionError: aws.String("Unhandled"), Payload: []byte(`{"errorMessage": "invalid period"}`)}, nil
+	}}
+	n := &lambdaNotifier{client: client, functionName: "emailer", sync: true}
+
+	err := n.Notify(context.Background(), testPayload(t))
+	if !errors.Is(err, ErrFatal) || !strings.Contains(err.Error(), "invalid period") {
+		t.Errorf("Notify() error = %v, want a fatal error with the emailer's message", err)
+	}
+}
+
+// notifierFunc is a Notifier calling itself.
+type notifierFunc func(ctx context.Context, payload NotificationPayload) error
+
+func (f notifierFunc) Notify(ctx context.Context, payload NotificationPayload) error {
+	return f(ctx, payload)
+}
+
+// accountSummaries returns summaries for n distinct accounts, in order.
+func accountSummaries(n int) []*AccountSummary {
+	summaries := make([]*AccountSummary, n)
+	for i := range summaries {
+		summaries[i] = &AccountSummary{Email: fmt.Sprintf("user%d@example.com", i+1)}
+	}
+	return summaries
+}
+
+func TestNotifyBatchesInvokesOncePerBatch(t *testing.T) {
+	tests := []struct {
+		summaries, batchSize int
+		want                 []int
+	}{
+		{7, 3, []int{3, 3, 1}},
+		{6, 3, []int{3, 3}},
+		{2, 5, []int{2}},
+		{5, 0, []int{5}},
+		{1, 1, []int{1}},
+	}
+	for _, tt := range tests {
+		setVar(t, &notifyBatchSize, tt.batchSize)
+		n := &fakeNotifier{}
+		summaries := accountSummaries(tt.summaries)
+		if err := notifyBatches(context.Background(), n, summaries); err != nil {
+			t.Fatalf("notifyBatches(%d, size %d) error = %v", tt.summaries, tt.batchSize, err)
+		}
+		var sizes []int
+		var emails []string
+		for _, p := range n.payloads {
+			sizes = append(sizes, len(p.Summaries))
+			for _, s := range p.Summaries {
+				emails = append(emails, s.Email)
+			}
+		}
+		if !reflect.DeepEqual(sizes, tt.want) {
+			t.Errorf("notifyBatches(%d, size %d) sent batches of %v, want %v", tt.summaries, tt.batchSize, sizes, tt.want)
+		}
+		if len(emails) != tt.summaries || emails[0] != "user1@example.com" || emails[len(emails)-1] != summaries[len(summaries)-1].Email {
+			t.Errorf("notifyBatches(%d, size %d) sent %v, want every summary once in order", tt.summaries, tt.batchSize, emails)
+		}
+	}
+}
+
+func TestNotifyBatchesStopsAfterFailedBatch(t *testing.T) {
+	setVar(t, &notifyBatchSize, 3)
+	calls := 0
+	n := notifierFunc(func(ctx context.Context, payload NotificationPayload) error {
+		calls++
+		if calls == 2 {
+			return classify(ErrTransient, errors.New("throttled"))
+		}
+		return nil
+	})
+
+	err := notifyBatches(context.Background(), n, accountSummaries(9))
+	if !shouldRetry(err) || !strings.Contains(err.Error(), "batch starting at summary 4 of 9") {
+		t.Errorf("notifyBatches() error = %v, want the retryable failure of the second batch", err)
+	}
+	if calls != 2 {
+		t.Errorf("notifier called %d times, want no batch started after the failure", calls)
+	}
+}
+
+func TestNotifyBatchesCapsInFlightInvocations(t *testing.T) {
+	setVar(t, &notifyBatchSize, 1)
+	setVar(t, &notifyMaxInFlight, 3)
+	var (
+		mu        sync.Mutex
+		inFlight  int
+		peak      int
+		delivered []string
+	)
+	// Each call holds its slot until the cap is reached once, so the test proves the
+	// cap is both used and never exceeded
+	full := make(chan struct{})
+	var fullOnce sync.Once
+	n := notifierFunc(func(ctx context.Context, payload NotificationPayload) error {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		if inFlight == notifyMaxInFlight {
+			fullOnce.Do(func() { close(full) })
+		}
+		mu.Unlock()
+
+		select {
+		case <-full:
+		case <-time.After(time.Second):
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		inFlight--
+		delivered = append(delivered, payload.Summaries[0].Email)
+		return nil
+	})
+
+	if err := notifyBatches(context.Background(), n, accountSummaries(10)); err != nil {
+		t.Fatalf("notifyBatches() error = %v", err)
+	}
+	if peak != 3 {
+		t.Errorf("peak in-flight invocations = %d, want the cap of 3", peak)
+	}
+	slices.Sort(delivered)
+	want := make([]string, 0, 10)
+	for _, s := range accountSummaries(10) {
+		want = append(want, s.Email)
+	}
+	slices.Sort(want)
+	if !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %v, want every batch sent once", delivered)
+	}
+}
+
+func TestLoadConfigRejectsInvalidNotifyMaxInFlight(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"NOTIFY_MAX_IN_FLIGHT": "0"}); !strings.Contains(out, "Invalid value for NOTIFY_MAX_IN_FLIGHT") {
+		t.Errorf("loadConfig() output = %q, want NOTIFY_MAX_IN_FLIGHT rejected", out)
+	}
+}
+
+func TestLoadConfigRejectsNegativeNotifyBatchSize(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"NOTIFY_BATCH_SIZE": "-1"}); !strings.Contains(out, "Invalid value for NOTIFY_BATCH_SIZE") {
+		t.Errorf("loadConfig() output = %q, want NOTIFY_BATCH_SIZE rejected", out)
+	}
+}
+
+func TestNotifyOnceRetrySkipsDeliveredBatches(t *testing.T) {
+	setVar(t, &notifyBatchSize, 2)
+	setVar(t, &notifyDedupeTTL, time.Hour)
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC) })
+	var delivered []string
+	failSecond := true
+	useNotifier(t, notifierFunc(func(ctx context.Context, payload NotificationPayload) error {
+		if failSecond && payload.Summaries[0].Email == "user3@example.com" {
+			failSecond = false
+			return classify(ErrTransient, errors.New("throttled"))
+		}
+		for _, s := range payload.Summaries {
+			delivered = append(delivered, s.Email)
+		}
+		return nil
+	}))
+	db, mock := newMockDB(t)
+	// First run: the first batch is delivered, the second fails and releases its claim
+	expectClaim(mock, true)
+	expectClaim(mock, true)
+	mock.ExpectExec("DELETE FROM notification_dedupe").WillReturnResult(sqlmock.NewResult(0, 1))
+	// Retry: the first batch is already claimed, only the second is sent
+	expectClaim(mock, false)
+	expectClaim(mock, true)
+
+	summaries := accountSummaries(4)
+	if err := notifyOnce(context.Background(), db, summaries); !shouldRetry(err) {
+		t.Fatalf("first run error = %v, want the retryable batch failure", err)
+	}
+	if err := notifyOnce(context.Background(), db, summaries); err != nil {
+		t.Fatalf("retry error = %v", err)
+	}
+	want := []string{"user1@example.com", "user2@example.com", "user3@example.com", "user4@example.com"}
+	if !reflect.DeepEqual(delivered, want) {
+		t.Errorf("delivered %v, want each summary exactly once", delivered)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Supported values for NUMERIC_PRECISION.
+const (
+	numericPrecisionRound = "round"
+	numericPrecisionFail  = "fail"
+)
+
+// parseNumeric parses a Postgres NUMERIC scanned as text into an exact rational,
+// returning nil for NULL and for the special value NaN.
+func parseNumeric(v sql.NullString) (*big.Rat, error) {
+	if !v.Valid || v.String == "NaN" {
+		return nil, nil
+	}
+	r, ok := new(big.Rat).SetString(v.String)
+	if !ok {
+		return nil, classify(ErrFatal, fmt.Errorf("invalid numeric value %q", v.String))
+	}
+	return r, nil
+}
+
+// numericFloat converts an exact amount to the float64 used in summaries. An amount
+// that does not survive the conversion (beyond float64 range, or with more significant
+// digits than float64 holds) is rejected as a validation error, so a summary never
+// carries a silently rounded balance, unless NUMERIC_PRECISION=round allows rounding
+// with a warning. A nil amount stays nil.
+func numericFloat(r *big.Rat, sign float64) (*float64, error) {
+	if r == nil {
+		return nil, nil
+	}
+	f, _ := r.Float64()
+	if math.IsInf(f, 0) || !roundTrips(r, f) {
+		err := fmt.Errorf("amount %s cannot be represented without losing precision", r.FloatString(2))
+		if numericPrecision == numericPrecisionFail || math.IsInf(f, 0) {
+			return nil, classify(ErrValidation, err)
+		}
+		log.Printf("Warning: %v; rounding to %s", err, strconv.FormatFloat(f, 'f', -1, 64))
+		emitMetric("NumericPrecisionLoss", 1, nil, nil)
+	}
+	f *= sign
+	return &f, nil
+}
+
+// roundTrips reports whether the shortest decimal form of f is exactly r, i.e. whether
+// a reader of the summary gets back the amount Postgres computed.
+func roundTrips(r *big.Rat, f float64) bool {
+	back, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return ok && back.Cmp(r) == 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestGetTransactionSummarySumsBalancesExactly(t *testing.T) {
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{0.1}, balance: "0.1", period: jan},
+		monthRow{month: "February", credits: []float64{0.2}, balance: "0.2", prevBal: "0.1", period: jan.AddDate(0, 1, 0)},
+		monthRow{month: "March", credits: []float64{4503599627370496}, balance: "4503599627370496.00", prevBal: "0.2", period: jan.AddDate(0, 2, 0)},
+		monthRow{month: "April", debits: []float64{4503599627370496}, balance: "-4503599627370496.00", prevBal: "4503599627370496.00", period: jan.AddDate(0, 3, 0)},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Summed as floats, 0.1 + 0.2 is 0.30000000000000004 and the large amounts absorb it
+	if summary.TotalBalance != 0.3 {
+		t.Errorf("TotalBalance = %v, want exactly 0.3", summary.TotalBalance)
+	}
+}
+
+func TestGetTransactionSummaryRejectsBalanceBeyondFloatPrecision(t *testing.T) {
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := func() []monthRow {
+		return []monthRow{{month: "January", credits: []float64{1}, balance: "12345678901234567.89", period: jan}}
+	}
+
+	setVar(t, &numericPrecision, numericPrecisionFail)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(rows()...))
+	if _, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{}); !errors.Is(err, ErrValidation) {
+		t.Errorf("getTransactionSummaryByEmail() error = %v, want ErrValidation by default", err)
+	}
+
+	setVar(t, &numericPrecision, numericPrecisionRound)
+	m := captureMetrics(t)
+	db, mock = newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(rows()...))
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("getTransactionSummaryByEmail() error = %v, want the balance rounded", err)
+	}
+	if summary.TotalBalance != 12345678901234568 {
+		t.Errorf("TotalBalance = %v, want the nearest float64", summary.TotalBalance)
+	}
+	if len(m.records(t, "NumericPrecisionLoss")) == 0 {
+		t.Error("no NumericPrecisionLoss metric emitted")
+	}
+}
+
+func TestNumericFloat(t *testing.T) {
+	setVar(t, &numericPrecision, numericPrecisionRound)
+	huge, _ := new(big.Rat).SetString("1e400")
+	if _, err := numericFloat(huge, 1); !errors.Is(err, ErrValidation) {
+		t.Errorf("numericFloat(1e400) error = %v, want ErrValidation even when rounding is allowed", err)
+	}
+	debit, _ := new(big.Rat).SetString("99.25")
+	if f, err := numericFloat(debit, -1); err != nil || *f != -99.25 {
+		t.Errorf("numericFloat(99.25, -1) = %v, %v, want -99.25", f, err)
+	}
+	if f, err := numericFloat(nil, 1); f != nil || err != nil {
+		t.Errorf("numericFloat(nil) = %v, %v, want nil", f, err)
+	}
+}
+
+func TestParseNumeric(t *testing.T) {
+	for _, v := range []sql.NullString{{}, {String: "NaN", Valid: true}} {
+		if r, err := parseNumeric(v); r != nil || err != nil {
+			t.Errorf("parseNumeric(%+v) = %v, %v, want nil", v, r, err)
+		}
+	}
+	if _, err := parseNumeric(sql.NullString{String: "12,5", Valid: true}); !errors.Is(err, ErrFatal) {
+		t.Errorf("parseNumeric(12,5) error = %v, want ErrFatal", err)
+	}
+	r, err := parseNumeric(sql.NullString{String: "123456789012345678901234567890.123", Valid: true})
+	if err != nil || r.FloatString(3) != "123456789012345678901234567890.123" {
+		t.Errorf("parseNumeric() = %v, %v, want the exact value", r, err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// sesSendAPI is the subset of the SES client used to send the operator report.
+type sesSendAPI interface {
+	SendEmail(ctx context.Context, params *ses.SendEmailInput, optFns ...func(*ses.Options)) (*ses.SendEmailOutput, error)
+}
+
+// operatorMailer is nil unless OPERATOR_EMAIL is configured.
+var operatorMailer sesSendAPI
+
+// maxReportErrors caps the errors listed in the operator report.
+const maxReportErrors = 20
+
+// runStats aggregates the outcome of one invocation for the operator report.
+// Its methods are safe for concurrent use and do nothing on a nil receiver.
+type runStats struct {
+	mu           sync.Mutex
+	files        int
+	filesFailed  int
+	filesSkipped int
+	rowsIngested int
+	rowsRejected int
+	summaries    int
+	// emailsKnown is set when the emailer reported its result (NOTIFY_SYNC).
+	emailsKnown  bool
+	emailsSent   int
+	emailsFailed int
+	errors       []string
+}
+
+// runStatsKey is the context key of the invocation's runStats.
+type runStatsKey struct{}
+
+// withRunStats returns a context that collects the invocation's statistics when the
+// operator report is enabled.
+func withRunStats(ctx context.Context) context.Context {
+	if operatorMailer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, runStatsKey{}, &runStats{})
+}
+
+// runStatsFrom returns the statistics collected on ctx, or nil.
+func runStatsFrom(ctx context.Context) *runStats {
+	stats, _ := ctx.Value(runStatsKey{}).(*runStats)
+	return stats
+}
+
+// addFile counts the outcome of one file.
+func (s *runStats) addFile(status *IngestStatus) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files++
+	switch status.Status {
+	case ingestFailed, ingestRetrying:
+		s.filesFailed++
+		for _, msg := range status.Errors {
+			s.addErrorLocked(fmt.Sprintf("s3://%s/%s: %s", status.Bucket, status.Key, msg))
+		}
+	case ingestSkipped:
+		s.filesSkipped++
+	}
+	s.rowsIngested += status.RowsInserted
+	s.rowsRejected += status.RejectedRows
+}
+
+// addSummaries counts the summaries handed to the notifier.
+func (s *runStats) addSummaries(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summaries += n
+}
+
+// addSendResult counts the emailer's outcome.
+func (s *runStats) addSendResult(result EmailSendResult) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emailsKnown = true
+	s.emailsSent += len(result.Sent)
+	s.emailsFailed += len(result.Failed)
+}
+
+// addError records a run-level error, such as a failed notification.
+func (s *runStats) addError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addErrorLocked(msg)
+}
+
+func (s *runStats) addErrorLocked(msg string) {
+	if len(s.errors) < maxReportErrors {
+		s.errors = append(s.errors, msg)
+	}
+}
+
+// reportText renders the statistics as the plain-text body of the operator email.
+func (s *runStats) reportText(runErr error) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	outcome := "succeeded"
+	if runErr != nil {
+		outcome = "failed and will be retried: " + runErr.Error()
+	}
+	fmt.Fprintf(&b, "Summarizer run %s.\n\n", outcome)
+	fmt.Fprintf(&b, "Files processed: %d (%d failed, %d skipped)\n", s.files, s.filesFailed, s.filesSkipped)
+	fmt.Fprintf(&b, "Rows ingested: %d\n", s.rowsIngested)
+	fmt.Fprintf(&b, "Rows rejected: %d\n", s.rowsRejected)
+	fmt.Fprintf(&b, "Summaries: %d\n", s.summaries)
+	if s.emailsKnown {
+		fmt.Fprintf(&b, "Emails sent: %d (%d failed)\n", s.emailsSent, s.emailsFailed)
+	} else {
+		b.WriteString("Emails sent: unknown (enable NOTIFY_SYNC to report them)\n")
+	}
+	if len(s.errors) > 0 {
+		fmt.Fprintf(&b, "\nErrors:\n")
+		for _, e := range s.errors {
+			fmt.Fprintf(&b, "- %s\n", e)
+		}
+	}
+	return b.String()
+}
+
+// sendOperatorReport emails the run's statistics to OPERATOR_EMAIL. It is best effort:
+// a failure is logged and never fails the run.
+func sendOperatorReport(ctx context.Context, runErr error) {
+	stats := runStatsFrom(ctx)
+	if stats == nil {
+		return
+	}
+
+	subject := "Summarizer run report"
+	if runErr != nil {
+		subject += " (failed)"
+	}
+	_, err := operatorMailer.SendEmail(context.WithoutCancel(ctx), &ses.SendEmailInput{
+		Source:      aws.String(operatorEmailFrom),
+		Destination: &sestypes.Destination{ToAddresses: []string{operatorEmail}},
+		Message: &sestypes.Message{
+			Subject: &sestypes.Content{Data: aws.String(subject)},
+			Body:    &sestypes.Body{Text: &sestypes.Content{Data: aws.String(stats.reportText(runErr))}},
+		},
+	})
+	if err != nil {
+		log.Printf("Error sending operator report: %v", err)
+		return
+	}
+	log.Printf("Operator report sent to %s", maskEmail(operatorEmail))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// fakeMailer is an SES client that records the emails it sends and fails with err.
+type fakeMailer struct {
+	mu   sync.Mutex
+	err  error
+	sent []*ses.SendEmailInput
+}
+
+func (m *fakeMailer) SendEmail(ctx context.Context, params *ses.SendEmailInput, optFns ...func(*ses.Options)) (*ses.SendEmailOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, params)
+	return &ses.SendEmailOutput{}, m.err
+}
+
+// useOperatorMailer enables the operator report to OPERATOR_EMAIL through m.
+func useOperatorMailer(t *testing.T, m sesSendAPI) {
+	t.Helper()
+	setVar(t, &operatorMailer, m)
+	setVar(t, &operatorEmail, "ops@example.com")
+	setVar(t, &operatorEmailFrom, "reports@example.com")
+}
+
+// resultNotifier is a Notifier that reports every summary as sent, as the emailer
+// does under NOTIFY_SYNC.
+type resultNotifier struct{}
+
+func (resultNotifier) Notify(ctx context.Context, payload NotificationPayload) error {
+	var result EmailSendResult
+	for _, s := range payload.Summaries {
+		result.Sent = append(result.Sent, s.Email)
+	}
+	reportSendResult(ctx, result)
+	return nil
+}
+
+func TestWithRunStatsDisabledWithoutMailer(t *testing.T) {
+	setVar(t, &operatorMailer, nil)
+	ctx := withRunStats(context.Background())
+	if runStatsFrom(ctx) != nil {
+		t.Fatal("withRunStats() collected statistics with the operator report disabled")
+	}
+	// A nil runStats ignores every update
+	runStatsFrom(ctx).addFile(&IngestStatus{Status: ingestFailed})
+	runStatsFrom(ctx).addSummaries(1)
+	runStatsFrom(ctx).addError("ignored")
+	sendOperatorReport(ctx, nil)
+}
+
+func TestRunStatsReportText(t *testing.T) {
+	stats := &runStats{}
+	stats.addFile(&IngestStatus{Bucket: "bucket", Key: "a.csv", Status: ingestProcessed, RowsInserted: 10, RejectedRows: 2})
+	stats.addFile(&IngestStatus{Bucket: "bucket", Key: "b.csv", Status: ingestFailed, Errors: []string{"line 3: date: invalid"}})
+	stats.addFile(&IngestStatus{Bucket: "bucket", Key: "c.csv", Status: ingestSkipped})
+	stats.addSummaries(4)
+	stats.addSendResult(EmailSendResult{Sent: []string{"a@example.com", "b@example.com", "c@example.com"}, Failed: []EmailSendFailure{{Email: "d@example.com"}}})
+	stats.addError("notification: throttled")
+
+	want := "Summarizer run failed and will be retried: boom.\n\n" +
+		"Files processed: 3 (1 failed, 1 skipped)\n" +
+		"Rows ingested: 10\n" +
+		"Rows rejected: 2\n" +
+		"Summaries: 4\n" +
+		"Emails sent: 3 (1 failed)\n" +
+		"\nErrors:\n" +
+		"- s3://bucket/b.csv: line 3: date: invalid\n" +
+		"- notification: throttled\n"
+	if got := stats.reportText(errors.New("boom")); got != want {
+		t.Errorf("reportText() = %q, want %q", got, want)
+	}
+}
+
+func TestRunStatsReportTextWithoutSendResult(t *testing.T) {
+	stats := &runStats{}
+	for i := 0; i < maxReportErrors+5; i++ {
+		stats.addError("error")
+	}
+	got := stats.reportText(nil)
+	if !strings.HasPrefix(got, "Summarizer run succeeded.") || !strings.Contains(got, "Emails sent: unknown (enable NOTIFY_SYNC to report them)") {
+		t.Errorf("reportText() = %q, want a successful run with unknown emails", got)
+	}
+	if n := strings.Count(got, "- error\n"); n != maxReportErrors {
+		t.Errorf("reportText() lists %d errors, want %d", n, maxReportErrors)
+	}
+}
+
+func TestHandleS3EventSendsOperatorReport(t *testing.T) {
+	m := &fakeMailer{}
+	useOperatorMailer(t, m)
+	useS3(t, newFakeS3(map[string]string{
+		"bucket/a.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n2,2024-01-06,-10,jane@example.com\n",
+		"bucket/b.csv": "id,date,transaction,email\n1,2024-01-05,+5,john@example.com\n2,not-a-date,+1,john@example.com\n",
+	}))
+	useRepository(t, newMemRepository())
+	useNotifier(t, resultNotifier{})
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	mock.MatchExpectationsInOrder(false)
+	for _, key := range []string{"a.csv", "b.csv"} {
+		expectNewFile(mock, "bucket", key)
+		expectLedgerWrite(mock, "bucket", key, ingestProcessed)
+		expectNotified(mock, "bucket", key)
+	}
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", "a.csv", "b.csv")); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if len(m.sent) != 1 {
+		t.Fatalf("sent %d operator emails, want 1", len(m.sent))
+	}
+	in := m.sent[0]
+	if aws.ToString(in.Source) != "reports@example.com" || len(in.Destination.ToAddresses) != 1 || in.Destination.ToAddresses[0] != "ops@example.com" {
+		t.Errorf("operator email from %s to %v, want reports@example.com to ops@example.com", aws.ToString(in.Source), in.Destination.ToAddresses)
+	}
+	if got := aws.ToString(in.Message.Subject.Data); got != "Summarizer run report" {
+		t.Errorf("subject = %q, want Summarizer run report", got)
+	}
+	body := aws.ToString(in.Message.Body.Text.Data)
+	for _, want := range []string{"Files processed: 2 (0 failed, 0 skipped)", "Rows ingested: 3", "Rows rejected: 1", "Summaries: 2", "Emails sent: 2 (0 failed)"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report body = %q, want %q", body, want)
+		}
+	}
+}
+
+func TestHandleS3EventReportsFailedRun(t *testing.T) {
+	// A failing report is only logged, and never changes the run's outcome
+	m := &fakeMailer{err: errors.New("ses unavailable")}
+	useOperatorMailer(t, m)
+	useS3(t, newFakeS3(map[string]string{"bucket/a.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"}))
+	repo := newMemRepository()
+	repo.fail = map[string]error{"s3://bucket/a.csv": classify(ErrTransient, errors.New("connection reset"))}
+	useRepository(t, repo)
+	useNotifier(t, &fakeNotifier{})
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "a.csv")
+	expectLedgerWrite(mock, "bucket", "a.csv", ingestRetrying)
+
+	err := handleS3Event(context.Background(), s3Event("bucket", "a.csv"))
+	if !errors.Is(err, ErrTransient) {
+		t.Fatalf("handleS3Event() error = %v, want the transient insert error", err)
+	}
+	if len(m.sent) != 1 {
+		t.Fatalf("sent %d operator emails, want 1", len(m.sent))
+	}
+	if got := aws.ToString(m.sent[0].Message.Subject.Data); got != "Summarizer run report (failed)" {
+		t.Errorf("subject = %q, want Summarizer run report (failed)", got)
+	}
+	body := aws.ToString(m.sent[0].Message.Body.Text.Data)
+	for _, want := range []string{"Summarizer run failed and will be retried", "Files processed: 1 (1 failed, 0 skipped)", "- s3://bucket/a.csv: "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("report body = %q, want %q", body, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Supported values for PROCESSED_ACTION.
+const (
+	// processedNone leaves ingested objects where they are.
+	processedNone = "none"
+	// processedArchive moves ingested objects under PROCESSED_PREFIX, gzip-compressed.
+	processedArchive = "archive"
+	// processedTagged tags ingested objects with PROCESSED_TAG, for a lifecycle rule to act on.
+	processedTagged = "tag"
+)
+
+// isArchivedKey reports whether key is an object this summarizer archived, which must
+// not be ingested again when the bucket notifies its creation.
+func isArchivedKey(key string) bool {
+	return processedAction == processedArchive && strings.HasPrefix(key, processedPrefix)
+}
+
+// finishProcessedObject applies PROCESSED_ACTION to an object whose rows are committed.
+// It is best effort: the rows are already stored, so a failure is logged and counted
+// rather than returned, which would retry the file and insert them again.
+func finishProcessedObject(ctx context.Context, bucket, key string) {
+	var err error
+	switch processedAction {
+	case processedArchive:
+		err = archiveObject(ctx, bucket, key)
+	case processedTagged:
+		err = tagObject(ctx, bucket, key)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Warning: PROCESSED_ACTION %s failed for s3://%s/%s: %v", processedAction, bucket, key, err)
+		emitMetric("ProcessedActionFailures", 1, map[string]string{"Bucket": bucket}, map[string]string{"Key": key})
+	}
+}
+
+// archiveObject copies the object to PROCESSED_PREFIX + key, gzip-compressing it
+// (with a .gz suffix) unless it already is, then deletes the original. The original
+// is only deleted once the archived copy is stored.
+func archiveObject(ctx context.Context, bucket, key string) error {
+	body, err := openObject(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("error reading object: %w", err)
+	}
+	defer body.Close()
+
+	// The compressed copy is spooled to /tmp so PutObject gets a seekable body
+	f, err := os.CreateTemp("", "summarizer-archive-*.gz")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	archive := &tempFile{File: f}
+	defer archive.Close()
+
+	archiveKey := processedPrefix + key
+	buffered := bufio.NewReader(body)
+	if prefix, err := buffered.Peek(len(gzipMagic)); err == nil && bytes.Equal(prefix, gzipMagic) {
+		_, err = io.Copy(f, buffered)
+		if err != nil {
+			return fmt.Errorf("error copying object: %w", err)
+		}
+	} else {
+		archiveKey += ".gz"
+		zw := gzip.NewWriter(f)
+		if _, err := io.Copy(zw, buffered); err != nil {
+			return fmt.Errorf("error compressing object: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing object: %w", err)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding temp file: %w", err)
+	}
+
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(archiveKey),
+		Body:        f,
+		ContentType: aws.String("application/gzip"),
+	})
+	if err != nil {
+		return fmt.Errorf("error writing s3://%s/%s: %w", bucket, archiveKey, err)
+	}
+	_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("archived to s3://%s/%s but deleting the original failed: %w", bucket, archiveKey, err)
+	}
+	log.Printf("Archived s3://%s/%s to s3://%s/%s", bucket, key, bucket, archiveKey)
+	return nil
+}
+
+// tagObject adds PROCESSED_TAG to the object's tag set, keeping its other tags.
+func tagObject(ctx context.Context, bucket, key string) error {
+	name, value, _ := strings.Cut(processedTag, "=")
+	out, err := s3Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("error reading tags: %w", err)
+	}
+	tags := []types.Tag{{Key: aws.String(name), Value: aws.String(value)}}
+	for _, t := range out.TagSet {
+		if aws.ToString(t.Key) != name {
+			tags = append(tags, t)
+		}
+	}
+	_, err = s3Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: tags},
+	})
+	if err != nil {
+		return fmt.Errorf("error writing tags: %w", err)
+	}
+	return nil
+}
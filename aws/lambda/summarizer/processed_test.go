@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const processedCSV = "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"
+
+// gunzip decompresses data, failing the test on error.
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestProcessFileArchivesObjectAfterCommit(t *testing.T) {
+	setVar(t, &processedAction, processedArchive)
+	f := newFakeS3(map[string]string{"bucket/file.csv": processedCSV})
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{}); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	archived, ok := f.object("bucket", "processed/file.csv.gz")
+	if !ok {
+		t.Fatalf("objects = %v, want processed/file.csv.gz", f.objects)
+	}
+	if got := gunzip(t, archived); got != processedCSV {
+		t.Errorf("archived object = %q, want the original CSV", got)
+	}
+	if !reflect.DeepEqual(f.deleted, []string{"bucket/file.csv"}) {
+		t.Errorf("deleted = %v, want the original removed", f.deleted)
+	}
+}
+
+func TestProcessFileArchivesGzipObjectAsIs(t *testing.T) {
+	setVar(t, &processedAction, processedArchive)
+	compressed := gzipMembers(t, processedCSV)
+	f := newFakeS3(map[string]string{"bucket/file.csv.gz": compressed})
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv.gz")
+	expectLedgerWrite(mock, "bucket", "file.csv.gz", ingestProcessed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "file.csv.gz", sql.NullInt64{}); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	archived, ok := f.object("bucket", "processed/file.csv.gz")
+	if !ok || string(archived) != compressed {
+		t.Errorf("archived object = %q, %v, want the gzip copied without recompressing", archived, ok)
+	}
+}
+
+func TestProcessFileLeavesObjectWhenInsertFails(t *testing.T) {
+	setVar(t, &processedAction, processedArchive)
+	f := newFakeS3(map[string]string{"bucket/file.csv": processedCSV})
+	useS3(t, f)
+	repo := newMemRepository()
+	repo.fail = map[string]error{"s3://bucket/file.csv": classify(ErrTransient, errors.New("connection reset"))}
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestRetrying)
+
+	if _, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{}); !errors.Is(err, ErrTransient) {
+		t.Fatalf("processFile() error = %v, want ErrTransient", err)
+	}
+	if _, ok := f.object("bucket", "processed/file.csv.gz"); ok {
+		t.Error("archived an object whose rows were not committed")
+	}
+	if len(f.deleted) != 0 {
+		t.Errorf("deleted = %v, want the original kept for the retry", f.deleted)
+	}
+}
+
+func TestProcessFileTagsObjectAfterCommit(t *testing.T) {
+	setVar(t, &processedAction, processedTagged)
+	setVar(t, &processedTag, "stage=processed")
+	f := newFakeS3(map[string]string{"bucket/file.csv": processedCSV})
+	f.tags["bucket/file.csv"] = []s3types.Tag{
+		{Key: aws.String("owner"), Value: aws.String("ops")},
+		{Key: aws.String("stage"), Value: aws.String("incoming")},
+	}
+	useS3(t, f)
+	useRepository(t, newMemRepository())
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{}); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	tags := make(map[string]string)
+	for _, tag := range f.tags["bucket/file.csv"] {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if want := map[string]string{"owner": "ops", "stage": "processed"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+	if _, ok := f.object("bucket", "file.csv"); !ok || len(f.deleted) != 0 {
+		t.Error("tagging moved the object, want it left in place")
+	}
+}
+
+func TestProcessFileSkipsArchivedKeys(t *testing.T) {
+	setVar(t, &processedAction, processedArchive)
+	f := newFakeS3(map[string]string{"bucket/processed/file.csv.gz": gzipMembers(t, processedCSV)})
+	useS3(t, f)
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, _ := newMockDB(t)
+
+	if _, err := processFile(context.Background(), db, "bucket", "processed/file.csv.gz", sql.NullInt64{}); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if len(repo.sources) != 0 {
+		t.Errorf("ingested %v, want archived objects skipped", repo.sources)
+	}
+}
+
+func TestLoadConfigRejectsInvalidProcessedSettings(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"PROCESSED_ACTION": "move"},
+		{"PROCESSED_TAG": "=true"},
+	} {
+		for name := range env {
+			if out := loadConfigError(t, env); !strings.Contains(out, "Invalid value for "+name) {
+				t.Errorf("loadConfig() with %v output = %q, want %s rejected", env, out, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// replicaPollInterval is how often the replica's replay position is checked while
+// waiting for it to catch up.
+const replicaPollInterval = 100 * time.Millisecond
+
+var (
+	replicaDB   *sql.DB
+	replicaOnce sync.Once
+)
+
+// replicaConfigured reports whether summary queries should go to a read replica.
+func replicaConfigured() bool {
+	return databaseReplicaURL != "" || dbReplicaHost != ""
+}
+
+// replicaConnectionString returns DATABASE_REPLICA_URL verbatim when it is set, and
+// otherwise the primary's DB_* connection string pointed at DB_REPLICA_HOST and
+// DB_REPLICA_PORT (default DB_PORT, or 5432 when that is unset too).
+func replicaConnectionString() string {
+	if databaseReplicaURL != "" {
+		return databaseReplicaURL
+	}
+	return dbConnString(dbReplicaHost, envString("DB_REPLICA_PORT", envString("DB_PORT", "5432")))
+}
+
+// getReplicaConnection returns the read replica connection pool singleton, verifying
+// it is reachable with a retried ping.
+func getReplicaConnection(ctx context.Context) (*sql.DB, error) {
+	var err error
+	replicaOnce.Do(func() {
+		replicaDB, err = sql.Open("postgres", replicaConnectionString())
+		if err != nil {
+			err = classify(ErrFatal, err)
+			return
+		}
+		replicaDB.SetMaxOpenConns(dbMaxOpenConns)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = retryDB(ctx, "replica ping", func() error {
+		return classifyDBError(replicaDB.PingContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replicaDB, nil
+}
+
+// summaryReader returns the database the read-only summary queries should use: the
+// replica when one is configured and has replayed everything the primary has written
+// so far (so the rows just inserted are visible), otherwise the primary. The replica
+// is given up to REPLICA_MAX_WAIT to catch up; any problem with it falls back to the
+// primary rather than failing the file.
+func summaryReader(ctx context.Context, primary *sql.DB) *sql.DB {
+	if !replicaConfigured() {
+		return primary
+	}
+	replica, err := getReplicaConnection(ctx)
+	if err != nil {
+		log.Printf("Warning: read replica unavailable, summarizing on the primary: %v", err)
+		emitMetric("ReplicaFallbacks", 1, map[string]string{"Reason": "unavailable"}, nil)
+		return primary
+	}
+	if replicaMaxWait <= 0 {
+		return replica
+	}
+
+	var lsn string
+	if err := primary.QueryRowContext(ctx, `SELECT pg_current_wal_lsn()::text`).Scan(&lsn); err != nil {
+		log.Printf("Warning: could not read the primary's WAL position, summarizing on the primary: %v", err)
+		emitMetric("ReplicaFallbacks", 1, map[string]string{"Reason": "error"}, nil)
+		return primary
+	}
+
+	deadline := clock().Add(replicaMaxWait)
+	for {
+		// A server that is not replaying WAL (NULL position) is not lagging
+		var caughtUp bool
+		err := replica.QueryRowContext(ctx, `SELECT COALESCE(pg_last_wal_replay_lsn() >= $1::pg_lsn, true)`, lsn).Scan(&caughtUp)
+		if err != nil {
+			log.Printf("Warning: could not read the replica's replay position, summarizing on the primary: %v", err)
+			emitMetric("ReplicaFallbacks", 1, map[string]string{"Reason": "error"}, nil)
+			return primary
+		}
+		if caughtUp {
+			return replica
+		}
+		if clock().After(deadline) || sleepWithContext(ctx, replicaPollInterval) != nil {
+			log.Printf("Warning: read replica did not catch up within %s, summarizing on the primary", replicaMaxWait)
+			emitMetric("ReplicaFallbacks", 1, map[string]string{"Reason": "lag"}, nil)
+			return primary
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useReplica configures a read replica and makes getReplicaConnection return conn.
+func useReplica(t *testing.T, conn *sql.DB) {
+	t.Helper()
+	setVar(t, &databaseReplicaURL, "postgres://replica/app")
+	replicaOnce.Do(func() {})
+	setVar(t, &replicaDB, conn)
+}
+
+func TestReplicaConnectionString(t *testing.T) {
+	setVar(t, &databaseReplicaURL, "postgres://replica/app")
+	if got := replicaConnectionString(); got != "postgres://replica/app" {
+		t.Errorf("replicaConnectionString() = %q, want DATABASE_REPLICA_URL", got)
+	}
+
+	setVar(t, &databaseReplicaURL, "")
+	setVar(t, &dbReplicaHost, "replica")
+	setVar(t, &dbSSLMode, "require")
+	setVar(t, &dbSSLRootCert, "")
+	for k, v := range map[string]string{"DB_PORT": "6432", "DB_USER": "app", "DB_PASSWORD": "secret", "DB_NAME": "ledger", "DB_REPLICA_PORT": ""} {
+		t.Setenv(k, v)
+	}
+	if got, want := replicaConnectionString(), "host=replica port=6432 user=app password=secret dbname=ledger sslmode=require"; got != want {
+		t.Errorf("replicaConnectionString() = %q, want %q", got, want)
+	}
+	t.Setenv("DB_REPLICA_PORT", "5433")
+	if got, want := replicaConnectionString(), "host=replica port=5433 user=app password=secret dbname=ledger sslmode=require"; got != want {
+		t.Errorf("replicaConnectionString() = %q, want %q", got, want)
+	}
+}
+
+func TestSummaryReaderWithoutReplicaUsesPrimary(t *testing.T) {
+	setVar(t, &databaseReplicaURL, "")
+	setVar(t, &dbReplicaHost, "")
+	primary, _ := newMockDB(t)
+	if got := summaryReader(context.Background(), primary); got != primary {
+		t.Error("summaryReader() = replica, want the primary")
+	}
+}
+
+func TestSummaryReaderUsesReplicaOnceCaughtUp(t *testing.T) {
+	setVar(t, &replicaMaxWait, time.Second)
+	primary, primaryMock := newMockDB(t)
+	replica, replicaMock := newMockDB(t)
+	useReplica(t, replica)
+	primaryMock.ExpectQuery(regexp.QuoteMeta("SELECT pg_current_wal_lsn()::text")).
+		WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/3000060"))
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WithArgs("0/3000060").
+		WillReturnRows(sqlmock.NewRows([]string{"caught_up"}).AddRow(true))
+
+	if got := summaryReader(context.Background(), primary); got != replica {
+		t.Error("summaryReader() = primary, want the replica")
+	}
+}
+
+func TestSummaryReaderFallsBackWhenReplicaLags(t *testing.T) {
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+	now := start
+	setVar(t, &clock, func() time.Time {
+		current := now
+		now = now.Add(time.Second)
+		return current
+	})
+	setVar(t, &replicaMaxWait, time.Second)
+	primary, primaryMock := newMockDB(t)
+	replica, replicaMock := newMockDB(t)
+	useReplica(t, replica)
+	m := captureMetrics(t)
+	primaryMock.ExpectQuery(regexp.QuoteMeta("SELECT pg_current_wal_lsn()::text")).
+		WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/3000060"))
+	for i := 0; i < 2; i++ {
+		replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WithArgs("0/3000060").
+			WillReturnRows(sqlmock.NewRows([]string{"caught_up"}).AddRow(false))
+	}
+
+	if got := summaryReader(context.Background(), primary); got != primary {
+		t.Error("summaryReader() = replica, want the primary once the wait is over")
+	}
+	if got := len(m.records(t, "ReplicaFallbacks")); got != 1 {
+		t.Errorf("emitted %d ReplicaFallbacks records, want 1", got)
+	}
+}
+
+func TestSummaryReaderFallsBackOnReplicaError(t *testing.T) {
+	setVar(t, &replicaMaxWait, time.Second)
+	primary, primaryMock := newMockDB(t)
+	replica, replicaMock := newMockDB(t)
+	useReplica(t, replica)
+	primaryMock.ExpectQuery(regexp.QuoteMeta("SELECT pg_current_wal_lsn()::text")).
+		WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/3000060"))
+	replicaMock.ExpectQuery("pg_last_wal_replay_lsn").WillReturnError(errors.New("recovery is not in progress"))
+
+	if got := summaryReader(context.Background(), primary); got != primary {
+		t.Error("summaryReader() = replica, want the primary")
+	}
+}
+
+func TestSQLRepositoryWritesPrimaryAndSummarizesOnReplica(t *testing.T) {
+	setVar(t, &storeSourceKey, false)
+	setVar(t, &replicaMaxWait, 0)
+	primary, primaryMock := newMockDB(t)
+	replica, replicaMock := newMockDB(t)
+	useReplica(t, replica)
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email) VALUES ($1, $2, $3, $4)")).
+		ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectCommit()
+	replicaMock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{60.5}, balance: "60.5", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	))
+
+	ctx := context.Background()
+	repo := newTransactionRepository(primary)
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}}}
+	if _, err := repo.Insert(ctx, "transacciones", rows, "s3://bucket/file.csv"); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	summary, err := repo.SummaryByEmail(ctx, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("SummaryByEmail() error = %v", err)
+	}
+	if summary.TotalBalance != 60.5 {
+		t.Errorf("balance = %v, want 60.5 from the replica", summary.TotalBalance)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TransactionRepository stores a file's transactions and summarizes accounts from them.
+// processFile depends on it rather than on *sql.DB so the ingest path can run against
+// an in-memory implementation.
+type TransactionRepository interface {
+	// Insert stores rows in table, recording sourceKey when STORE_SOURCE_KEY is enabled,
+	// and returns the set of distinct non-blank emails among them.
+	Insert(ctx context.Context, table string, rows []csvRow, sourceKey string) (map[string]struct{}, error)
+	// SummaryByEmail summarizes the account's transactions in table, only those
+	// ingested after since when it is valid.
+	SummaryByEmail(ctx context.Context, table, email string, since sql.NullInt64) (*AccountSummary, error)
+}
+
+// newTransactionRepository returns the repository processFile uses for one file;
+// tests replace it with an in-memory implementation.
+var newTransactionRepository = func(db *sql.DB) TransactionRepository {
+	return &sqlTransactionRepository{db: db}
+}
+
+// sqlTransactionRepository is the Postgres TransactionRepository. It serves one file
+// and is not safe for concurrent use: the database its summaries are read from (the
+// replica, once it has caught up) is chosen once, after the file's rows are inserted.
+type sqlTransactionRepository struct {
+	db     *sql.DB
+	reader *sql.DB
+}
+
+// Insert stores rows atomically, or in concurrent partitions when INSERT_CONCURRENCY > 1.
+func (r *sqlTransactionRepository) Insert(ctx context.Context, table string, rows []csvRow, sourceKey string) (map[string]struct{}, error) {
+	r.reader = nil
+	if insertConcurrency > 1 {
+		return insertPartitioned(ctx, r.db, table, rows, sourceKey)
+	}
+	return insertInTransaction(ctx, r.db, table, rows, sourceKey)
+}
+
+// SummaryByEmail runs the summary queries, retrying transient failures.
+func (r *sqlTransactionRepository) SummaryByEmail(ctx context.Context, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	// Summaries are read-only and can run on a replica, away from ingest writes
+	if r.reader == nil {
+		r.reader = summaryReader(ctx, r.db)
+	}
+	var summary *AccountSummary
+	err := retryDB(ctx, "summary query", func() (err error) {
+		summary, err = getTransactionSummaryByEmail(ctx, r.reader, table, email, since)
+		return err
+	})
+	return summary, err
+}
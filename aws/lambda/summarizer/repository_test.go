@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHandlerSummarizesThroughRepository(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{
+		"bucket/a.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n2,2024-01-06,-10,john@example.com\n",
+		"bucket/b.csv": "id,date,transaction,email\n3,2024-02-01,-20.5,jane@example.com\n",
+	}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	mock.MatchExpectationsInOrder(false)
+	for _, key := range []string{"a.csv", "b.csv"} {
+		expectNewFile(mock, "bucket", key)
+		expectLedgerWrite(mock, "bucket", key, ingestProcessed)
+		expectNotified(mock, "bucket", key)
+	}
+
+	if err := handleS3Event(context.Background(), s3Event("bucket", "a.csv", "b.csv")); err != nil {
+		t.Fatalf("handleS3Event() error = %v", err)
+	}
+	if got := repo.rowCount("transacciones"); got != 3 {
+		t.Errorf("stored %d rows, want 3", got)
+	}
+	sources := append([]string(nil), repo.sources...)
+	sort.Strings(sources)
+	if want := []string{"s3://bucket/a.csv", "s3://bucket/b.csv"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources = %v, want %v", sources, want)
+	}
+
+	balances := make(map[string]float64)
+	for _, p := range n.payloads {
+		for _, s := range p.Summaries {
+			balances[s.Email] = s.TotalBalance
+		}
+	}
+	if want := map[string]float64{"jane@example.com": 40, "john@example.com": -10}; !reflect.DeepEqual(balances, want) {
+		t.Errorf("balances = %v, want %v, summarized from every stored row", balances, want)
+	}
+}
+
+func TestSQLTransactionRepository(t *testing.T) {
+	db, mock := newMockDB(t)
+	repo := newTransactionRepository(db)
+
+	mock.ExpectBegin()
+	prep := mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO transacciones (external_id, date, transaction, email) VALUES ($1, $2, $3, $4)"))
+	prep.ExpectExec().WithArgs(int64(1), sqlmock.AnyArg(), "+60.5", "jane@example.com").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}}}
+	emails, err := repo.Insert(context.Background(), "transacciones", rows, "")
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	if _, ok := emails["jane@example.com"]; !ok || len(emails) != 1 {
+		t.Errorf("Insert() emails = %v, want jane@example.com", emails)
+	}
+
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{60.5}, balance: "60.5", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	))
+	summary, err := repo.SummaryByEmail(context.Background(), "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("SummaryByEmail() error = %v", err)
+	}
+	if summary.TotalBalance != 60.5 || len(summary.MonthlySummaries) != 1 {
+		t.Errorf("SummaryByEmail() = %+v, want a balance of 60.5 over 1 month", summary)
+	}
+}
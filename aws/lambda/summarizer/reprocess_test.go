@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestHandlerReprocessesRequestedObject(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/in/file 1.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	// A manual reprocess skips the ledger lookup: it is deliberate, never a duplicate
+	expectLedgerWrite(mock, "bucket", "in/file 1.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "in/file 1.csv")
+
+	if _, err := handler(context.Background(), json.RawMessage(`{"bucket": "bucket", "key": "in/file 1.csv"}`)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if got := repo.sources; len(got) != 1 || got[0] != "s3://bucket/in/file 1.csv" {
+		t.Errorf("inserted %v, want the requested object", got)
+	}
+	if got := n.emails(); len(got) != 1 || got[0] != "jane@example.com" {
+		t.Errorf("notified %v, want jane@example.com", got)
+	}
+}
+
+func TestHandlerRejectsIncompleteReprocessRequest(t *testing.T) {
+	for _, payload := range []string{`{"bucket": "bucket"}`, `{"key": "file.csv"}`, `[]`} {
+		if _, err := handler(context.Background(), json.RawMessage(payload)); !errors.Is(err, ErrValidation) {
+			t.Errorf("handler(%s) error = %v, want ErrValidation", payload, err)
+		}
+	}
+}
+
+func TestReprocessEventMatchesUpload(t *testing.T) {
+	event := reprocessEvent(ReprocessRequest{Bucket: "bucket", Key: "in/file 1.csv"})
+	if len(event.Records) != 1 {
+		t.Fatalf("records = %d, want 1", len(event.Records))
+	}
+	record := event.Records[0]
+	if record.S3.Bucket.Name != "bucket" || objectKey(record) != "in/file 1.csv" || record.EventName != reprocessEventName {
+		t.Errorf("record = %+v, want a created s3://bucket/in/file 1.csv", record)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// sqlStateTooManyConnections is the Postgres SQLSTATE for too_many_connections.
+const sqlStateTooManyConnections = "53300"
+
+// isTooManyConnections reports whether err is Postgres refusing a connection because it is saturated.
+func isTooManyConnections(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == sqlStateTooManyConnections
+}
+
+// retryDB runs fn up to dbRetryAttempts times (at least once), retrying only transient errors.
+// When Postgres reports too_many_connections the backoff starts from the much
+// longer dbSaturatedBackoff, so retries give the server room instead of piling on.
+func retryDB(ctx context.Context, op string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errors.Is(err, ErrTransient) || attempt >= dbRetryAttempts {
+			return err
+		}
+
+		base := dbRetryBackoff
+		if isTooManyConnections(err) {
+			base = dbSaturatedBackoff
+		}
+		backoff := base << (attempt - 1)
+		backoff += rand.N(backoff / 2)
+
+		log.Printf("%s failed (attempt %d/%d), retrying in %s: %v", op, attempt, dbRetryAttempts, backoff, err)
+		if err := sleepWithContext(ctx, backoff); err != nil {
+			return classify(ErrTransient, fmt.Errorf("%s retry aborted: %w", op, err))
+		}
+	}
+}
+
+// retryBudgetKey is the context key of the invocation's retry deadline.
+type retryBudgetKey struct{}
+
+// withRetryBudget returns a context whose retries must finish retryBudgetReserve before
+// the invocation deadline, leaving that time for notifying and recording the run.
+// Without a deadline on ctx there is no budget beyond the per-operation attempt limits.
+func withRetryBudget(ctx context.Context) context.Context {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, retryBudgetKey{}, deadline.Add(-retryBudgetReserve))
+}
+
+// retryDeadline returns the time by which retries must be done: the retry budget
+// when one is set, otherwise the context deadline.
+func retryDeadline(ctx context.Context) (time.Time, bool) {
+	if budget, ok := ctx.Value(retryBudgetKey{}).(time.Time); ok {
+		return budget, true
+	}
+	return ctx.Deadline()
+}
+
+// sleepWithContext waits for d, returning early with an error if ctx is done
+// or if the retry deadline would pass before d elapses.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := retryDeadline(ctx); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
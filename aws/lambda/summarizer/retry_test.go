@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRetryDBBacksOffLongerWhenPostgresIsSaturated(t *testing.T) {
+	setVar(t, &dbRetryAttempts, 3)
+	setVar(t, &dbRetryBackoff, time.Millisecond)
+	setVar(t, &dbSaturatedBackoff, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	calls := 0
+	err := retryDB(ctx, "summary query", func() error {
+		calls++
+		return classifyDBError(&pq.Error{Code: sqlStateTooManyConnections})
+	})
+	// The saturated backoff does not fit in the deadline, so no retry is attempted
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTransient) {
+		t.Errorf("retryDB() = %v, want a transient deadline error", err)
+	}
+}
+
+func TestRetryDBRetriesOtherTransientErrorsWithShortBackoff(t *testing.T) {
+	setVar(t, &dbRetryAttempts, 3)
+	setVar(t, &dbRetryBackoff, time.Millisecond)
+	setVar(t, &dbSaturatedBackoff, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	calls := 0
+	err := retryDB(ctx, "summary query", func() error {
+		calls++
+		return classifyDBError(&pq.Error{Code: "08006"})
+	})
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("retryDB() = %v, want a transient error", err)
+	}
+}
+
+func TestRetryDBDoesNotRetryFatalErrors(t *testing.T) {
+	setVar(t, &dbRetryAttempts, 3)
+	calls := 0
+	err := retryDB(context.Background(), "summary query", func() error {
+		calls++
+		return classifyDBError(&pq.Error{Code: "42P01"})
+	})
+	if calls != 1 || !errors.Is(err, ErrFatal) {
+		t.Errorf("retryDB() = %v after %d calls, want a fatal error after 1", err, calls)
+	}
+}
+
+func TestRetryDBCallsFnAtLeastOnce(t *testing.T) {
+	setVar(t, &dbRetryAttempts, 0)
+	calls := 0
+	err := retryDB(context.Background(), "summary query", func() error {
+		calls++
+		return nil
+	})
+	if calls != 1 || err != nil {
+		t.Errorf("retryDB() = %v after %d calls, want success after 1", err, calls)
+	}
+}
+
+func TestIsTooManyConnections(t *testing.T) {
+	if !isTooManyConnections(classify(ErrTransient, &pq.Error{Code: "53300"})) {
+		t.Error("isTooManyConnections(53300) = false, want true")
+	}
+	if isTooManyConnections(&pq.Error{Code: "53200"}) {
+		t.Error("isTooManyConnections(53200) = true, want false")
+	}
+}
+
+func TestRetryDBStopsWhenRetryBudgetIsSpent(t *testing.T) {
+	setVar(t, &dbRetryAttempts, 5)
+	setVar(t, &dbRetryBackoff, 50*time.Millisecond)
+	setVar(t, &retryBudgetReserve, 5*time.Second)
+	// The invocation has time left, but all of it is reserved for notifying and recording.
+	parent, cancel := context.WithTimeout(context.Background(), 5*time.Second+20*time.Millisecond)
+	defer cancel()
+	ctx := withRetryBudget(parent)
+
+	calls := 0
+	start := time.Now()
+	err := retryDB(ctx, "ledger write", func() error {
+		calls++
+		return classify(ErrTransient, errors.New("connection reset"))
+	})
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTransient) {
+		t.Errorf("retryDB() = %v, want a transient deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retryDB() took %s, want it to return promptly", elapsed)
+	}
+	if parent.Err() != nil {
+		t.Error("invocation context expired, want the reserve left for the rest of the run")
+	}
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	setVar(t, &retryBudgetReserve, time.Minute)
+	if ctx := withRetryBudget(context.Background()); ctx.Value(retryBudgetKey{}) != nil {
+		t.Error("withRetryBudget() set a budget on a context without a deadline")
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	got, ok := retryDeadline(withRetryBudget(ctx))
+	if want := deadline.Add(-time.Minute); !ok || !got.Equal(want) {
+		t.Errorf("retryDeadline() = %v, %v, want %v", got, ok, want)
+	}
+	if got, ok := retryDeadline(ctx); !ok || !got.Equal(deadline) {
+		t.Errorf("retryDeadline() without a budget = %v, %v, want the context deadline %v", got, ok, deadline)
+	}
+}
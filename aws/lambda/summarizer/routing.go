@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Default tables, used for keys that match no TABLE_ROUTES prefix.
+const (
+	defaultTransactionsTable = "transacciones"
+	defaultSummaryTable      = "account_summaries"
+)
+
+// tableRoute sends the objects under Prefix to their own transactions table and
+// persisted summaries table.
+type tableRoute struct {
+	Prefix       string
+	Table        string
+	SummaryTable string
+}
+
+// defaultRoute is the route of keys outside every configured prefix.
+var defaultRoute = tableRoute{Table: defaultTransactionsTable, SummaryTable: defaultSummaryTable}
+
+// tableNamePattern accepts a plain or schema-qualified SQL identifier. Table names
+// are interpolated into queries, so nothing else is allowed.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// parseTableRoutes parses TABLE_ROUTES: comma-separated prefix=table entries, where
+// table may be followed by :summary_table (default account_summaries). Routes are
+// returned longest prefix first, so the most specific one wins.
+func parseTableRoutes(value string) ([]tableRoute, error) {
+	var routes []tableRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, tables, ok := strings.Cut(entry, "=")
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("entry %q is not prefix=table", entry)
+		}
+		table, summaryTable, _ := strings.Cut(tables, ":")
+		if summaryTable == "" {
+			summaryTable = defaultSummaryTable
+		}
+		for _, name := range []string{table, summaryTable} {
+			if !tableNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("entry %q: %q is not a valid table name", entry, name)
+			}
+		}
+		routes = append(routes, tableRoute{Prefix: prefix, Table: table, SummaryTable: summaryTable})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	return routes, nil
+}
+
+// routeFor returns the route of an object key.
+func routeFor(key string) tableRoute {
+	for _, r := range tableRoutes {
+		if strings.HasPrefix(key, r.Prefix) {
+			return r
+		}
+	}
+	return defaultRoute
+}
+
+// routeTables returns every transactions table a run may have written to.
+func routeTables() []string {
+	tables := []string{defaultRoute.Table}
+	seen := map[string]bool{defaultRoute.Table: true}
+	for _, r := range tableRoutes {
+		if !seen[r.Table] {
+			seen[r.Table] = true
+			tables = append(tables, r.Table)
+		}
+	}
+	return tables
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseTableRoutes(t *testing.T) {
+	routes, err := parseTableRoutes(" cards/=card_transactions , cards/premium/=premium.transactions:premium.summaries,loans/=loan_transactions ")
+	if err != nil {
+		t.Fatalf("parseTableRoutes() error = %v", err)
+	}
+	want := []tableRoute{
+		{Prefix: "cards/premium/", Table: "premium.transactions", SummaryTable: "premium.summaries"},
+		{Prefix: "cards/", Table: "card_transactions", SummaryTable: defaultSummaryTable},
+		{Prefix: "loans/", Table: "loan_transactions", SummaryTable: defaultSummaryTable},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("parseTableRoutes() = %+v, want %+v", routes, want)
+	}
+
+	if routes, err := parseTableRoutes(""); err != nil || routes != nil {
+		t.Errorf("parseTableRoutes(\"\") = %v, %v, want no routes", routes, err)
+	}
+}
+
+func TestParseTableRoutesRejectsInvalidEntries(t *testing.T) {
+	for _, value := range []string{
+		"cards/",
+		"=card_transactions",
+		"cards/=",
+		"cards/=card-transactions",
+		"cards/=cards; DROP TABLE transacciones",
+		"cards/=card_transactions:a.b.c",
+	} {
+		if _, err := parseTableRoutes(value); err == nil {
+			t.Errorf("parseTableRoutes(%q) error = nil, want an error", value)
+		}
+	}
+}
+
+func TestRouteFor(t *testing.T) {
+	routes, err := parseTableRoutes("cards/=card_transactions,cards/premium/=premium_transactions:premium_summaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &tableRoutes, routes)
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"cards/jan.csv", "card_transactions"},
+		{"cards/premium/jan.csv", "premium_transactions"},
+		{"loans/jan.csv", defaultTransactionsTable},
+		{"jan.csv", defaultTransactionsTable},
+	}
+	for _, tt := range tests {
+		if got := routeFor(tt.key).Table; got != tt.want {
+			t.Errorf("routeFor(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	if got := routeFor("loans/jan.csv").SummaryTable; got != defaultSummaryTable {
+		t.Errorf("default route summary table = %q, want %q", got, defaultSummaryTable)
+	}
+	if got := routeTables(); !reflect.DeepEqual(got, []string{defaultTransactionsTable, "premium_transactions", "card_transactions"}) {
+		t.Errorf("routeTables() = %v, want the default table then each routed table", got)
+	}
+}
+
+func TestProcessFileRoutesKeysByPrefix(t *testing.T) {
+	setVar(t, &tableRoutes, []tableRoute{{Prefix: "cards/", Table: "card_transactions", SummaryTable: "card_summaries"}})
+	useS3(t, newFakeS3(map[string]string{
+		"bucket/cards/jan.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n",
+		"bucket/loans/jan.csv": "id,date,transaction,email\n1,2024-01-05,-10,jane@example.com\n2,2024-01-06,-5,jane@example.com\n",
+	}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		key          string
+		table        string
+		summaryTable string
+		rows         int
+	}{
+		{"cards/jan.csv", "card_transactions", "card_summaries", 1},
+		{"loans/jan.csv", defaultTransactionsTable, defaultSummaryTable, 2},
+	}
+	for _, tt := range tests {
+		expectNewFile(mock, "bucket", tt.key)
+		expectLedgerWrite(mock, "bucket", tt.key, ingestProcessed)
+		summaries, err := processFile(ctx, db, "bucket", tt.key, sql.NullInt64{})
+		if err != nil {
+			t.Fatalf("processFile(%s) error = %v", tt.key, err)
+		}
+		if got := repo.rowCount(tt.table); got != tt.rows {
+			t.Errorf("%s: %s has %d rows, want %d", tt.key, tt.table, got, tt.rows)
+		}
+		if len(summaries) != 1 || summaries[0].summaryTable != tt.summaryTable {
+			t.Errorf("%s: summaries = %+v, want one for %s", tt.key, summaries, tt.summaryTable)
+		}
+	}
+}
+
+func TestPersistSummariesUsesRouteSummaryTable(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectExec("INSERT INTO card_summaries").WithArgs("jane@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO account_summaries").WithArgs("john@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	summaries := []*AccountSummary{
+		{Email: "jane@example.com", summaryTable: "card_summaries"},
+		{Email: "john@example.com"},
+	}
+	if err := persistSummaries(context.Background(), db, summaries); err != nil {
+		t.Fatalf("persistSummaries() error = %v", err)
+	}
+}
+
+func TestLoadConfigRejectsInvalidTableRoutes(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"TABLE_ROUTES": "cards/=card-transactions"}); !strings.Contains(out, "Invalid value for TABLE_ROUTES") {
+		t.Errorf("loadConfig() output = %q, want TABLE_ROUTES rejected", out)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// eventBridgeS3Source is the source of the events S3 delivers through EventBridge.
+const eventBridgeS3Source = "aws.s3"
+
+// eventBridgeObjectCreated is the detail-type of an EventBridge S3 upload event.
+const eventBridgeObjectCreated = "Object Created"
+
+// s3EventBridgeDetail is the detail of an EventBridge S3 event. Only the fields used
+// to locate the object are decoded.
+type s3EventBridgeDetail struct {
+	Bucket struct {
+		Name string `json:"name"`
+	} `json:"bucket"`
+	Object struct {
+		Key       string `json:"key"`
+		Size      int64  `json:"size"`
+		ETag      string `json:"etag"`
+		VersionID string `json:"version-id"`
+		Sequencer string `json:"sequencer"`
+	} `json:"object"`
+	// Reason is the API call that created the object, e.g. PutObject.
+	Reason string `json:"reason"`
+}
+
+// s3EventFromEventBridge converts an EventBridge S3 event into the classic S3 event
+// shape, so both are processed by handleS3Event. Object Created becomes an
+// "ObjectCreated:<reason>" record; any other detail-type gets an event name that
+// handleS3Event skips.
+func s3EventFromEventBridge(event events.EventBridgeEvent) (events.S3Event, error) {
+	var detail s3EventBridgeDetail
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return events.S3Event{}, fmt.Errorf("invalid detail: %w", err)
+	}
+	if detail.Bucket.Name == "" || detail.Object.Key == "" {
+		return events.S3Event{}, fmt.Errorf("detail has no bucket name or object key")
+	}
+
+	var record events.S3EventRecord
+	record.EventSource = event.Source
+	record.EventTime = event.Time
+	record.AWSRegion = event.Region
+	record.EventName = strings.ReplaceAll(event.DetailType, " ", "")
+	if event.DetailType == eventBridgeObjectCreated {
+		record.EventName = "ObjectCreated:" + detail.Reason
+	}
+	record.S3.Bucket.Name = detail.Bucket.Name
+	record.S3.Object.Key = detail.Object.Key
+	record.S3.Object.URLDecodedKey = detail.Object.Key
+	record.S3.Object.Size = detail.Object.Size
+	record.S3.Object.ETag = detail.Object.ETag
+	record.S3.Object.VersionID = detail.Object.VersionID
+	record.S3.Object.Sequencer = detail.Object.Sequencer
+	return events.S3Event{Records: []events.S3EventRecord{record}}, nil
+}
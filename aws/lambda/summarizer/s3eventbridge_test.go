@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// eventBridgeS3Event is an S3 event as EventBridge delivers it.
+const eventBridgeS3Event = `{
+	"version": "0",
+	"id": "17793124-05d4-b198-2fde-7ededc63b103",
+	"detail-type": "Object Created",
+	"source": "aws.s3",
+	"account": "123456789012",
+	"time": "2024-01-05T12:00:00Z",
+	"region": "us-east-1",
+	"resources": ["arn:aws:s3:::bucket"],
+	"detail": {
+		"version": "0",
+		"bucket": {"name": "bucket"},
+		"object": {"key": "in/file.csv", "size": 70, "etag": "b1946ac92492d2347c6235b4d2611184", "sequencer": "00617F08299329D189"},
+		"request-id": "N4N7GDK58NMKJ12R",
+		"requester": "123456789012",
+		"reason": "PutObject"
+	}
+}`
+
+func TestS3EventFromEventBridge(t *testing.T) {
+	var ebEvent events.EventBridgeEvent
+	if err := json.Unmarshal([]byte(eventBridgeS3Event), &ebEvent); err != nil {
+		t.Fatal(err)
+	}
+	event, err := s3EventFromEventBridge(ebEvent)
+	if err != nil {
+		t.Fatalf("s3EventFromEventBridge() error = %v", err)
+	}
+	if len(event.Records) != 1 {
+		t.Fatalf("records = %d, want 1", len(event.Records))
+	}
+	record := event.Records[0]
+	if record.S3.Bucket.Name != "bucket" || objectKey(record) != "in/file.csv" {
+		t.Errorf("object = s3://%s/%s, want s3://bucket/in/file.csv", record.S3.Bucket.Name, objectKey(record))
+	}
+	if record.EventName != "ObjectCreated:PutObject" {
+		t.Errorf("EventName = %q, want ObjectCreated:PutObject", record.EventName)
+	}
+	if record.S3.Object.Size != 70 || record.S3.Object.Sequencer != "00617F08299329D189" {
+		t.Errorf("object = %+v, want size and sequencer from the detail", record.S3.Object)
+	}
+}
+
+func TestS3EventFromEventBridgeOtherDetailType(t *testing.T) {
+	var ebEvent events.EventBridgeEvent
+	if err := json.Unmarshal([]byte(eventBridgeS3Event), &ebEvent); err != nil {
+		t.Fatal(err)
+	}
+	ebEvent.DetailType = "Object Deleted"
+	event, err := s3EventFromEventBridge(ebEvent)
+	if err != nil {
+		t.Fatalf("s3EventFromEventBridge() error = %v", err)
+	}
+	if got := event.Records[0].EventName; got != "ObjectDeleted" {
+		t.Errorf("EventName = %q, want ObjectDeleted, which handleS3Event skips", got)
+	}
+}
+
+func TestS3EventFromEventBridgeRejectsMissingObject(t *testing.T) {
+	for _, detail := range []string{`{"bucket": {"name": "bucket"}}`, `{"object": {"key": "file.csv"}}`, `"text"`} {
+		ebEvent := events.EventBridgeEvent{DetailType: eventBridgeObjectCreated, Source: eventBridgeS3Source, Detail: json.RawMessage(detail)}
+		if _, err := s3EventFromEventBridge(ebEvent); err == nil {
+			t.Errorf("s3EventFromEventBridge(%s) error = nil, want an error", detail)
+		}
+	}
+}
+
+func TestHandlerProcessesEventBridgeEvent(t *testing.T) {
+	useS3(t, newFakeS3(map[string]string{"bucket/in/file.csv": "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	n := &fakeNotifier{}
+	useNotifier(t, n)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectNewFile(mock, "bucket", "in/file.csv")
+	expectLedgerWrite(mock, "bucket", "in/file.csv", ingestProcessed)
+	expectNotified(mock, "bucket", "in/file.csv")
+
+	if _, err := handler(context.Background(), json.RawMessage(eventBridgeS3Event)); err != nil {
+		t.Fatalf("handler() error = %v", err)
+	}
+	if got := repo.sources; len(got) != 1 || got[0] != "s3://bucket/in/file.csv" {
+		t.Errorf("inserted %v, want s3://bucket/in/file.csv", got)
+	}
+	if got := n.emails(); len(got) != 1 || got[0] != "jane@example.com" {
+		t.Errorf("notified %v, want jane@example.com", got)
+	}
+}
+
+func TestHandlerRejectsInvalidEventBridgeEvent(t *testing.T) {
+	payload := `{"source": "aws.s3", "detail-type": "Object Created", "detail": {"bucket": {"name": "bucket"}}}`
+	if _, err := handler(context.Background(), json.RawMessage(payload)); !errors.Is(err, ErrValidation) {
+		t.Errorf("handler() error = %v, want ErrValidation", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// requiredColumns must appear in every CSV schema; other configured columns are
+// accepted (and count towards the expected column count) but are not stored,
+// except for the optional currency, name and tier columns.
+var requiredColumns = []string{"id", "date", "transaction", "email"}
+
+// csvSchema maps column names to their position in each CSV record.
+type csvSchema struct {
+	columns []string
+	index   map[string]int
+}
+
+// newCSVSchema builds a schema from an ordered list of column names,
+// checking that names are unique and that every required column is present.
+func newCSVSchema(columns []string) (*csvSchema, error) {
+	s := &csvSchema{index: make(map[string]int, len(columns))}
+	for i, col := range columns {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if col == "" {
+			return nil, fmt.Errorf("column %d has an empty name", i+1)
+		}
+		if _, dup := s.index[col]; dup {
+			return nil, fmt.Errorf("column %q is listed more than once", col)
+		}
+		s.columns = append(s.columns, col)
+		s.index[col] = i
+	}
+	for _, col := range requiredColumns {
+		if _, ok := s.index[col]; !ok {
+			return nil, fmt.Errorf("required column %q is missing", col)
+		}
+	}
+	return s, nil
+}
+
+// width is the number of columns every record must have.
+func (s *csvSchema) width() int {
+	return len(s.columns)
+}
+
+// has reports whether the schema includes the named column.
+func (s *csvSchema) has(name string) bool {
+	_, ok := s.index[name]
+	return ok
+}
+
+// field returns the value of the named column in fields, or "" if the schema lacks it.
+func (s *csvSchema) field(fields []string, name string) string {
+	i, ok := s.index[name]
+	if !ok || i >= len(fields) {
+		return ""
+	}
+	return fields[i]
+}
+
+// checkHeader verifies that a header row names the configured columns in order,
+// ignoring case and surrounding whitespace.
+func (s *csvSchema) checkHeader(header []string) error {
+	if len(header) != s.width() {
+		return fmt.Errorf("invalid CSV header column count: expected %d, got %d", s.width(), len(header))
+	}
+	for i, name := range header {
+		if strings.ToLower(strings.TrimSpace(name)) != s.columns[i] {
+			return fmt.Errorf("unexpected CSV header column %d: expected %q, got %q", i+1, s.columns[i], name)
+		}
+	}
+	return nil
+}
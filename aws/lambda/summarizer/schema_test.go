@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useSchema configures the CSV columns for the duration of the test.
+func useSchema(t *testing.T, columns string) {
+	t.Helper()
+	s, err := newCSVSchema(strings.Split(columns, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &schema, s)
+}
+
+func TestNewCSVSchema(t *testing.T) {
+	s, err := newCSVSchema([]string{" ID", "Date", "transaction", "email", "category"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.width() != 5 || !s.has("id") || !s.has("category") || s.has("currency") {
+		t.Errorf("schema = %+v, want 5 normalized columns", s)
+	}
+	if got := s.field([]string{"1", "2024-01-05", "+1", "jane@example.com", "food"}, "category"); got != "food" {
+		t.Errorf("field(category) = %q, want food", got)
+	}
+
+	for _, columns := range [][]string{
+		{"id", "date", "transaction"},
+		{"id", "date", "transaction", "email", "ID"},
+		{"id", "date", "", "transaction", "email"},
+	} {
+		if _, err := newCSVSchema(columns); err == nil {
+			t.Errorf("newCSVSchema(%v) succeeded, want an error", columns)
+		}
+	}
+}
+
+func TestProcessCSVFileUsesSchemaColumnCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+		body    string
+		want    [][]string
+	}{
+		{
+			name:    "4 columns",
+			columns: "id,date,transaction,email",
+			body:    "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-06,-3,jane@example.com,food\n",
+			want:    [][]string{{"1", "2024-01-05", "+10.5", "jane@example.com"}},
+		},
+		{
+			name:    "5 columns",
+			columns: "id,date,transaction,email,category",
+			body:    "id,date,transaction,email,category\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-06,-3,jane@example.com,food\n",
+			want:    [][]string{{"2", "2024-01-06", "-3", "jane@example.com", "food"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSchema(t, tt.columns)
+			useS3(t, newFakeS3(map[string]string{"bucket/file.csv": tt.body}))
+
+			rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fields(rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessCSVFileRejectsHeaderOfOtherSchema(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,category")
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n"}))
+
+	_, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "expected 5, got 4") {
+		t.Errorf("processCSVFile() error = %v, want a header column count error", err)
+	}
+}
+
+func TestProcessCSVFileFailsWrongColumnCountWhenStrict(t *testing.T) {
+	setVar(t, &strictColumns, strictColumnsFail)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5\n"}))
+
+	_, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "expected 4, got 3") {
+		t.Errorf("processCSVFile() error = %v, want a column count error", err)
+	}
+}
+
+func TestProcessCSVFileSkipsWrongColumnCountByDefault(t *testing.T) {
+	setVar(t, &strictColumns, strictColumnsSkip)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5\n2,2024-01-06,-3,jane@example.com\n"}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Line != 3 {
+		t.Errorf("rows = %v, want only the full row on line 3", fields(rows))
+	}
+}
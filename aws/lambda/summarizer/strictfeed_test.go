@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// containsArg matches a query argument whose text contains substr.
+type containsArg string
+
+func (a containsArg) Match(v driver.Value) bool {
+	return strings.Contains(fmt.Sprint(v), string(a))
+}
+
+func TestProcessFileStrictFeedRejectsWholeFileAtFirstInvalidRow(t *testing.T) {
+	setVar(t, &strictFeed, true)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n" +
+		"1,2024-01-05,+10,jane@example.com\n" +
+		"2,not-a-date,+20,jane@example.com\n" +
+		"3,2024-01-07,+30,jane@example.com\n" +
+		"x,2024-01-08,+40,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	mock.ExpectExec("INSERT INTO processed_files").
+		WithArgs("bucket", "file.csv", sqlmock.AnyArg(), ingestFailed, sqlmock.AnyArg(), 0, sqlmock.AnyArg(),
+			containsArg("STRICT_FEED: rejecting s3://bucket/file.csv at line 3: date"), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v, want the rejection recorded rather than retried", err)
+	}
+	if len(summaries) != 0 {
+		t.Errorf("summaries = %+v, want none", summaries)
+	}
+	if len(repo.sources) != 0 {
+		t.Errorf("inserted %v, want nothing from a rejected strict feed", repo.sources)
+	}
+}
+
+func TestProcessFileStrictFeedRejectsMalformedCSV(t *testing.T) {
+	// loadConfig forces STRICT_COLUMNS=fail for a strict feed
+	setVar(t, &strictFeed, true)
+	setVar(t, &strictColumns, strictColumnsFail)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10,jane@example.com,extra\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestFailed)
+
+	if _, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{}); err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if len(repo.sources) != 0 {
+		t.Errorf("inserted %v, want nothing", repo.sources)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetTransactionSummaryMarksDebitOnlyMonthCreditsAbsent(t *testing.T) {
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", debits: []float64{10, 20}, balance: "-30", period: jan},
+		monthRow{month: "February", credits: []float64{0}, debits: []float64{5}, balance: "-5", prevBal: "-30", period: feb},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	debitOnly, zeroCredit := summary.MonthlySummaries[0], summary.MonthlySummaries[1]
+	if debitOnly.AverageCredit != nil || debitOnly.MaxCredit != nil {
+		t.Errorf("debit-only month credit average = %v, max = %v, want both absent", debitOnly.AverageCredit, debitOnly.MaxCredit)
+	}
+	if debitOnly.AverageDebit == nil || *debitOnly.AverageDebit != -15 {
+		t.Errorf("debit-only month debit average = %v, want -15", debitOnly.AverageDebit)
+	}
+	if zeroCredit.AverageCredit == nil || *zeroCredit.AverageCredit != 0 {
+		t.Errorf("zero-credit month credit average = %v, want 0", zeroCredit.AverageCredit)
+	}
+
+	data, err := json.Marshal(debitOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"average_credit":null`) {
+		t.Errorf("debit-only month = %s, want average_credit null", data)
+	}
+}
+
+func TestGetTransactionSummaryComparesEachMonthWithThePrevious(t *testing.T) {
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{100}, balance: "100", period: jan},
+		monthRow{month: "February", credits: []float64{150}, balance: "150", prevBal: "100", period: jan.AddDate(0, 1, 0)},
+		monthRow{month: "March", debits: []float64{20}, balance: "-20", prevBal: "150", period: jan.AddDate(0, 2, 0)},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	months := summary.MonthlySummaries
+	if len(months) != 3 {
+		t.Fatalf("got %d months, want 3", len(months))
+	}
+	if m := months[0]; m.Net == nil || *m.Net != 100 || m.PrevMonthNet != nil || m.ChangePercent != nil {
+		t.Errorf("January = net %v, previous %v, change %v, want net 100 and no comparison", m.Net, m.PrevMonthNet, m.ChangePercent)
+	}
+	wants := []struct{ net, prev, change float64 }{{150, 100, 50}, {-20, 150, -170.0 / 150 * 100}}
+	for i, want := range wants {
+		m := months[i+1]
+		if m.Net == nil || m.PrevMonthNet == nil || m.ChangePercent == nil {
+			t.Fatalf("%s = net %v, previous %v, change %v, want all set", m.Month, m.Net, m.PrevMonthNet, m.ChangePercent)
+		}
+		if *m.Net != want.net || *m.PrevMonthNet != want.prev || math.Abs(*m.ChangePercent-want.change) > 1e-9 {
+			t.Errorf("%s = net %v, previous %v, change %v, want %v", m.Month, *m.Net, *m.PrevMonthNet, *m.ChangePercent, want)
+		}
+	}
+}
+
+func TestSetMonthOverMonthAfterInactiveMonth(t *testing.T) {
+	net, prev := 40.0, 100.0
+	m := MonthlySummary{Net: &net}
+	// The previous row is two months back, so the month before had no transactions
+	setMonthOverMonth(&m, &prev, sql.NullBool{Bool: false, Valid: true})
+	if m.PrevMonthNet == nil || *m.PrevMonthNet != 0 {
+		t.Errorf("PrevMonthNet = %v, want 0 for an inactive previous month", m.PrevMonthNet)
+	}
+	if m.ChangePercent != nil {
+		t.Errorf("ChangePercent = %v, want nil versus a zero net", *m.ChangePercent)
+	}
+}
+
+func TestGetTransactionSummaryCarriesAccountName(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,name")
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{10}, balance: "10", period: jan},
+	))
+	mock.ExpectQuery("SELECT name FROM transacciones").WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Jane Doe"))
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{5}, balance: "5", period: jan},
+	))
+	mock.ExpectQuery("SELECT name FROM transacciones").WithArgs("john@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	named, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unnamed, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "john@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if named.Name != "Jane Doe" || unnamed.Name != "" {
+		t.Errorf("names = %q, %q, want %q and none", named.Name, unnamed.Name, "Jane Doe")
+	}
+}
+
+func TestGetTransactionSummaryReportsMonthlyExtremes(t *testing.T) {
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{10, 250.5, 40}, debits: []float64{3, 99.25}, balance: "198.25", period: jan},
+		monthRow{month: "February", credits: []float64{12}, balance: "12", prevBal: "198.25", period: jan.AddDate(0, 1, 0)},
+	))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jan1, feb := summary.MonthlySummaries[0], summary.MonthlySummaries[1]
+	if jan1.MaxCredit == nil || *jan1.MaxCredit != 250.5 || jan1.MaxDebit == nil || *jan1.MaxDebit != -99.25 {
+		t.Errorf("January largest credit = %v, debit = %v, want 250.5 and -99.25", jan1.MaxCredit, jan1.MaxDebit)
+	}
+	if feb.MaxCredit == nil || *feb.MaxCredit != 12 || feb.MaxDebit != nil {
+		t.Errorf("February largest credit = %v, debit = %v, want 12 and none", feb.MaxCredit, feb.MaxDebit)
+	}
+}
+
+// hangingRepository is a TransactionRepository whose summary query for the email
+// hang blocks until its context is done, like a stuck database query.
+type hangingRepository struct {
+	TransactionRepository
+	hang string
+}
+
+func (r hangingRepository) SummaryByEmail(ctx context.Context, table, email string, since sql.NullInt64) (*AccountSummary, error) {
+	if email == r.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &AccountSummary{Email: email}, nil
+}
+
+func TestSummarizeEmailsRecordsTimedOutSummary(t *testing.T) {
+	setVar(t, &summaryTimeout, 20*time.Millisecond)
+	m := captureMetrics(t)
+	repo := hangingRepository{hang: "stuck@example.com"}
+	status := &IngestStatus{Bucket: "bucket", Key: "file.csv"}
+	emails := []string{"jane@example.com", "stuck@example.com", "john@example.com"}
+
+	start := time.Now()
+	summaries := summarizeEmails(context.Background(), repo, tableRoute{Table: "transacciones"}, emails, sql.NullInt64{}, status)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("summarizeEmails() took %s, want the stuck query abandoned", elapsed)
+	}
+	var got []string
+	for _, s := range summaries {
+		got = append(got, s.Email)
+	}
+	if want := []string{"jane@example.com", "john@example.com"}; !slices.Equal(got, want) {
+		t.Errorf("summaries = %v, want %v", got, want)
+	}
+	if len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "timed out") {
+		t.Errorf("status errors = %v, want one timeout", status.Errors)
+	}
+	if n := len(m.records(t, "SummaryTimeouts")); n != 1 {
+		t.Errorf("emitted %d SummaryTimeouts records, want 1", n)
+	}
+}
+
+func TestGetTransactionSummaryItemizesSmallAccounts(t *testing.T) {
+	setVar(t, &itemizeMaxTransactions, 5)
+	db, mock := newMockDB(t)
+	jan := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM transacciones").WithArgs("jane@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{10, 20}, debits: []float64{5}, balance: "25", period: jan},
+	))
+	mock.ExpectQuery("SELECT TO_CHAR").WithArgs("jane@example.com", nil).WillReturnRows(
+		sqlmock.NewRows([]string{"date", "amount", "currency"}).
+			AddRow("2024-01-05", "10", "").AddRow("2024-01-09", "-5", "").AddRow("2024-01-20", "20", ""))
+	mock.ExpectQuery("FROM transacciones").WithArgs("john@example.com", nil, nil).WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{1, 2, 3}, debits: []float64{1, 2, 3}, balance: "0", period: jan},
+	))
+
+	small, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transaction{{Date: "2024-01-05", Amount: 10}, {Date: "2024-01-09", Amount: -5}, {Date: "2024-01-20", Amount: 20}}
+	if !reflect.DeepEqual(small.Transactions, want) {
+		t.Errorf("transactions = %+v, want %+v", small.Transactions, want)
+	}
+
+	large, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "john@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large.Transactions != nil {
+		t.Errorf("transactions = %+v, want none over the threshold", large.Transactions)
+	}
+}
+
+func TestGetTransactionSummaryCarriesTier(t *testing.T) {
+	useSchema(t, "id,date,transaction,email,tier")
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("FROM transacciones").WillReturnRows(summaryRows(
+		monthRow{month: "January", credits: []float64{10}, balance: "10", period: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	))
+	mock.ExpectQuery("SELECT tier FROM transacciones").WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow("premium"))
+
+	summary, err := getTransactionSummaryByEmail(context.Background(), db, "transacciones", "jane@example.com", sql.NullInt64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Tier != "premium" {
+		t.Errorf("Tier = %q, want premium", summary.Tier)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SummaryPage is the response of GET /summary: an account's balance and one page of
+// its monthly summaries. Next is the offset of the following page, empty on the last.
+type SummaryPage struct {
+	Email            string              `json:"email"`
+	Name             string              `json:"name,omitempty"`
+	TotalBalance     float64             `json:"total_balance"`
+	MonthlySummaries []MonthlySummary    `json:"monthly_summaries"`
+	Currencies       []CurrencyBreakdown `json:"currencies,omitempty"`
+	// TotalMonths is the number of months available, in the longest currency breakdown
+	// when the account has several.
+	TotalMonths int    `json:"total_months"`
+	Limit       int    `json:"limit"`
+	Offset      int    `json:"offset"`
+	Next        string `json:"next,omitempty"`
+}
+
+// isSummaryAPIRequest reports whether payload is an API Gateway HTTP API (v2) request.
+func isSummaryAPIRequest(payload json.RawMessage) bool {
+	var probe struct {
+		Version string `json:"version"`
+		RawPath string `json:"rawPath"`
+	}
+	return json.Unmarshal(payload, &probe) == nil && probe.Version == "2.0" && probe.RawPath != ""
+}
+
+// handleSummaryAPI serves GET /summary?email=...&limit=...&offset=..., summarizing the
+// account on demand from the default transactions table. Callers may only read their
+// own account: email must match the SUMMARY_API_EMAIL_CLAIM claim of the authorizer.
+// offset takes the previous page's next.
+func handleSummaryAPI(ctx context.Context, req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	if !summaryAPIEnabled || !strings.HasSuffix(req.RawPath, "/summary") {
+		return apiResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if req.RequestContext.HTTP.Method != http.MethodGet {
+		return apiResponse(http.StatusMethodNotAllowed, map[string]string{"error": "only GET is allowed"})
+	}
+
+	email := strings.TrimSpace(req.QueryStringParameters["email"])
+	if email == "" {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": "missing email parameter"})
+	}
+	caller := callerEmail(req)
+	if caller == "" {
+		return apiResponse(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	if !strings.EqualFold(caller, email) {
+		log.Printf("Summary API: caller %s denied access to %s", maskEmail(caller), maskEmail(email))
+		return apiResponse(http.StatusForbidden, map[string]string{"error": "forbidden"})
+	}
+	limit, err := queryInt(req, "limit", summaryAPIDefaultLimit)
+	if err != nil || limit < 1 || limit > summaryAPIMaxLimit {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("limit must be between 1 and %d", summaryAPIMaxLimit)})
+	}
+	offset, err := queryInt(req, "offset", 0)
+	if err != nil || offset < 0 {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": "offset must be a non-negative integer"})
+	}
+
+	db, err := getDBConnection(ctx)
+	if err != nil {
+		log.Printf("Error getting DB connection: %v", err)
+		return apiResponse(http.StatusServiceUnavailable, map[string]string{"error": "database unavailable"})
+	}
+	if summaryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, summaryTimeout)
+		defer cancel()
+	}
+	reader := summaryReader(ctx, db)
+	var page *SummaryPage
+	err = retryDB(ctx, "summary page query", func() (err error) {
+		page, err = getSummaryPage(ctx, reader, defaultRoute.Table, email, limit, offset)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error generating summary for %s: %v", maskEmail(email), err)
+		status := http.StatusInternalServerError
+		if shouldRetry(err) || errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusServiceUnavailable
+		}
+		return apiResponse(status, map[string]string{"error": "summary failed"})
+	}
+	if page.TotalMonths == 0 {
+		return apiResponse(http.StatusNotFound, map[string]string{"error": "no transactions for this account"})
+	}
+	if offset > page.TotalMonths {
+		return apiResponse(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("offset must not exceed %d", page.TotalMonths)})
+	}
+	return apiResponse(http.StatusOK, page)
+}
+
+// callerEmail returns the email claim of the request's JWT or Lambda authorizer, or ""
+// when the request was not authorized with one.
+func callerEmail(req events.APIGatewayV2HTTPRequest) string {
+	auth := req.RequestContext.Authorizer
+	if auth == nil {
+		return ""
+	}
+	if auth.JWT != nil {
+		if v := strings.TrimSpace(auth.JWT.Claims[summaryAPIEmailClaim]); v != "" {
+			return v
+		}
+	}
+	v, _ := auth.Lambda[summaryAPIEmailClaim].(string)
+	return strings.TrimSpace(v)
+}
+
+// getSummaryPage reads months [offset, offset+limit) of each currency of the account
+// in table, paging in the query, and the balances and month counts of the whole
+// account. The month-over-month figures of the first month on a page still compare
+// against the month before it.
+func getSummaryPage(ctx context.Context, db *sql.DB, table, email string, limit, offset int) (*SummaryPage, error) {
+	page := &SummaryPage{Email: email, Limit: limit, Offset: offset}
+
+	totalRows, err := db.QueryContext(ctx, `
+		SELECT `+currencyExpr()+` AS currency,
+			SUM(CAST(TRIM(transaction) AS NUMERIC))::text,
+			COUNT(DISTINCT `+periodExpr()+`)
+		FROM `+table+`
+		WHERE email = $1
+		GROUP BY 1
+		ORDER BY 1`, email)
+	if err != nil {
+		return nil, classifyDBError(fmt.Errorf("balance query failed: %w", err))
+	}
+	defer totalRows.Close()
+	var breakdowns []CurrencyBreakdown
+	for totalRows.Next() {
+		var b CurrencyBreakdown
+		var totalText sql.NullString
+		var months int
+		if err := totalRows.Scan(&b.Currency, &totalText, &months); err != nil {
+			return nil, classify(ErrFatal, fmt.Errorf("failed scanning balance: %w", err))
+		}
+		total, err := parseNumeric(totalText)
+		if err != nil {
+			return nil, err
+		}
+		if total != nil {
+			t, err := numericFloat(total, 1)
+			if err != nil {
+				return nil, err
+			}
+			b.TotalBalance = *t
+		}
+		b.MonthlySummaries = []MonthlySummary{}
+		breakdowns = append(breakdowns, b)
+		page.TotalMonths = max(page.TotalMonths, months)
+	}
+	if err := totalRows.Err(); err != nil {
+		return nil, classifyDBError(fmt.Errorf("failed reading balances: %w", err))
+	}
+	if len(breakdowns) == 0 {
+		return page, nil
+	}
+
+	// The window runs over every month before the page is cut, so LAG still sees the
+	// month preceding the page. page_row - offset is compared rather than offset + limit
+	// added, which cannot overflow for any offset.
+	threshold := sql.NullFloat64{Float64: largeTransactionThreshold, Valid: largeTransactionThreshold > 0}
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+monthlySummaryColumns+`
+		FROM (
+			SELECT m.*, ROW_NUMBER() OVER (PARTITION BY currency ORDER BY period) AS page_row
+			FROM (`+monthlySummaryQuery(table)+`) m
+		) p
+		WHERE page_row - $4 BETWEEN 1 AND $5
+		ORDER BY currency, period`, email, sql.NullInt64{}, threshold, int64(offset), int64(limit))
+	if err != nil {
+		return nil, classifyDBError(fmt.Errorf("query failed: %w", err))
+	}
+	defer rows.Close()
+	paged, _, err := scanMonthlySummaries(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paged {
+		for i := range breakdowns {
+			if breakdowns[i].Currency == p.Currency {
+				breakdowns[i].MonthlySummaries = p.MonthlySummaries
+			}
+		}
+	}
+
+	page.MonthlySummaries = []MonthlySummary{}
+	if len(breakdowns) == 1 {
+		page.TotalBalance = breakdowns[0].TotalBalance
+		page.MonthlySummaries = breakdowns[0].MonthlySummaries
+	}
+	if schema.has("currency") {
+		page.Currencies = breakdowns
+	}
+	if schema.has("name") {
+		if page.Name, err = getAccountField(ctx, db, table, "name", email); err != nil {
+			return nil, err
+		}
+	}
+	if offset < page.TotalMonths-limit {
+		page.Next = strconv.Itoa(offset + limit)
+	}
+	return page, nil
+}
+
+// queryInt parses the integer query parameter name, or returns def when it is absent.
+func queryInt(req events.APIGatewayV2HTTPRequest, name string, def int) (int, error) {
+	v := req.QueryStringParameters[name]
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// apiResponse returns v as a JSON HTTP API response with the given status.
+func apiResponse(status int, v interface{}) events.APIGatewayV2HTTPResponse {
+	body, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body = []byte(`{"error":"error encoding response"}`)
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
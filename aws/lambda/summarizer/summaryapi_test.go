@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// summaryRequest returns GET /summary for email from a caller authorized as caller,
+// with the query parameters in params.
+func summaryRequest(email, caller string, params map[string]string) events.APIGatewayV2HTTPRequest {
+	req := events.APIGatewayV2HTTPRequest{Version: "2.0", RawPath: "/summary", QueryStringParameters: map[string]string{"email": email}}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	for k, v := range params {
+		req.QueryStringParameters[k] = v
+	}
+	if caller != "" {
+		req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+			JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: map[string]string{"email": caller}},
+		}
+	}
+	return req
+}
+
+// expectSummaryPage expects the balance query of an account with months months, then
+// the page query for [offset, offset+limit) answered with rows.
+func expectSummaryPage(mock sqlmock.Sqlmock, months, offset, limit int, rows *sqlmock.Rows) {
+	mock.ExpectQuery("COUNT\\(DISTINCT").WithArgs("jane@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "sum", "count"}).AddRow("", "150", months))
+	mock.ExpectQuery("page_row - \\$4 BETWEEN 1 AND \\$5").
+		WithArgs("jane@example.com", nil, nil, int64(offset), int64(limit)).
+		WillReturnRows(rows)
+}
+
+// monthsFrom returns summaryRows for n consecutive months of 2024 starting at month first.
+func monthsFrom(first, n int) *sqlmock.Rows {
+	var months []monthRow
+	for i := range n {
+		period := time.Date(2024, time.Month(first+i), 1, 0, 0, 0, 0, time.UTC)
+		months = append(months, monthRow{month: period.Format("January"), credits: []float64{30}, balance: "30", period: period})
+	}
+	return summaryRows(months...)
+}
+
+func decodePage(t *testing.T, resp events.APIGatewayV2HTTPResponse) SummaryPage {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", resp.StatusCode, resp.Body)
+	}
+	var page SummaryPage
+	if err := json.Unmarshal([]byte(resp.Body), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestSummaryAPIPagesMonthsWithNextCursor(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectSummaryPage(mock, 5, 0, 2, monthsFrom(1, 2))
+	expectSummaryPage(mock, 5, 2, 2, monthsFrom(3, 2))
+	expectSummaryPage(mock, 5, 4, 2, monthsFrom(5, 1))
+
+	var got []string
+	offset := ""
+	for range 3 {
+		params := map[string]string{"limit": "2"}
+		if offset != "" {
+			params["offset"] = offset
+		}
+		page := decodePage(t, handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", params)))
+		if page.TotalMonths != 5 || page.Limit != 2 || page.TotalBalance != 150 {
+			t.Errorf("page = %+v, want 5 months in total, a limit of 2 and a balance of 150", page)
+		}
+		for _, m := range page.MonthlySummaries {
+			got = append(got, m.Month)
+		}
+		offset = page.Next
+	}
+	if want := []string{"January", "February", "March", "April", "May"}; !slices.Equal(got, want) {
+		t.Errorf("paged months = %v, want %v", got, want)
+	}
+	if offset != "" {
+		t.Errorf("last page next = %q, want none", offset)
+	}
+}
+
+func TestSummaryAPINextCursorOnExactLastPage(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectSummaryPage(mock, 4, 2, 2, monthsFrom(3, 2))
+
+	page := decodePage(t, handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", map[string]string{"limit": "2", "offset": "2"})))
+	if page.Next != "" || page.Offset != 2 || len(page.MonthlySummaries) != 2 {
+		t.Errorf("page = %+v, want the last 2 months and no next cursor", page)
+	}
+}
+
+func TestSummaryAPIUsesDefaultLimit(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	setVar(t, &summaryAPIDefaultLimit, 3)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectSummaryPage(mock, 4, 0, 3, monthsFrom(1, 3))
+
+	page := decodePage(t, handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", nil)))
+	if page.Limit != 3 || page.Next != "3" {
+		t.Errorf("page = %+v, want a limit of 3 and next 3", page)
+	}
+}
+
+func TestSummaryAPIRejectsOffsetPastLastMonth(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectSummaryPage(mock, 5, 6, 2, monthsFrom(1, 0))
+
+	resp := handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", map[string]string{"limit": "2", "offset": "6"}))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestSummaryAPIAuthorizesCaller(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	tests := []struct {
+		name   string
+		req    events.APIGatewayV2HTTPRequest
+		status int
+	}{
+		{"no authorizer", summaryRequest("jane@example.com", "", nil), http.StatusUnauthorized},
+		{"other account", summaryRequest("jane@example.com", "mallory@example.com", nil), http.StatusForbidden},
+		{"missing email", summaryRequest("", "jane@example.com", nil), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if resp := handleSummaryAPI(context.Background(), tt.req); resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, resp.StatusCode, tt.status, resp.Body)
+		}
+	}
+}
+
+func TestSummaryAPIAcceptsLambdaAuthorizerEmailInAnyCase(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	conn, mock := newMockDB(t)
+	useDB(t, conn)
+	expectSummaryPage(mock, 1, 0, 12, monthsFrom(1, 1))
+
+	req := summaryRequest("jane@example.com", "", nil)
+	req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+		Lambda: map[string]interface{}{"email": "Jane@Example.com"},
+	}
+	if resp := handleSummaryAPI(context.Background(), req); resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestSummaryAPIRejectsInvalidPaging(t *testing.T) {
+	setVar(t, &summaryAPIEnabled, true)
+	for _, params := range []map[string]string{
+		{"limit": "0"},
+		{"limit": strconv.Itoa(summaryAPIMaxLimit + 1)},
+		{"limit": "ten"},
+		{"offset": "-1"},
+		{"offset": "99999999999999999999"},
+	} {
+		resp := handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", params))
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: status = %d, want 400", params, resp.StatusCode)
+		}
+	}
+}
+
+func TestSummaryAPIDisabled(t *testing.T) {
+	resp := handleSummaryAPI(context.Background(), summaryRequest("jane@example.com", "jane@example.com", nil))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 with SUMMARY_API_ENABLED off", resp.StatusCode)
+	}
+}
+
+func TestIsSummaryAPIRequest(t *testing.T) {
+	for payload, want := range map[string]bool{
+		`{"version": "2.0", "rawPath": "/summary"}`: true,
+		`{"version": "1.0", "rawPath": "/summary"}`: false,
+		`{"Records": []}`:    false,
+		`{"mode": "health"}`: false,
+	} {
+		if got := isSummaryAPIRequest(json.RawMessage(payload)); got != want {
+			t.Errorf("isSummaryAPIRequest(%s) = %v, want %v", payload, got, want)
+		}
+	}
+}
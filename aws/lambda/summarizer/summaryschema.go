@@ -0,0 +1,114 @@
+package main
+
+// Supported values for SUMMARY_SCHEMA.
+const (
+	summarySchemaV1 = "v1"
+	summarySchemaV2 = "v2"
+)
+
+// summaryV2 is the v2 serialization of an AccountSummary: camelCase field names, the
+// account's transaction count, and an explicit currency. Currency is empty when the
+// account has transactions in several currencies; each is then listed in Currencies.
+type summaryV2 struct {
+	Email            string        `json:"email"`
+	Name             string        `json:"name,omitempty"`
+	Tier             string        `json:"tier,omitempty"`
+	Currency         string        `json:"currency"`
+	TotalBalance     float64       `json:"totalBalance"`
+	TransactionCount int           `json:"transactionCount"`
+	MonthlySummaries []monthV2     `json:"monthlySummaries"`
+	Currencies       []currencyV2  `json:"currencies,omitempty"`
+	Transactions     []Transaction `json:"transactions,omitempty"`
+	Flagged          bool          `json:"flagged"`
+	FlagReason       string        `json:"flagReason,omitempty"`
+}
+
+// currencyV2 is the v2 serialization of a CurrencyBreakdown.
+type currencyV2 struct {
+	Currency         string    `json:"currency"`
+	TotalBalance     float64   `json:"totalBalance"`
+	TransactionCount int       `json:"transactionCount"`
+	MonthlySummaries []monthV2 `json:"monthlySummaries"`
+}
+
+// monthV2 is the v2 serialization of a MonthlySummary.
+type monthV2 struct {
+	Month               string   `json:"month"`
+	TransactionCount    int      `json:"transactionCount"`
+	AverageCredit       *float64 `json:"averageCredit"`
+	AverageDebit        *float64 `json:"averageDebit"`
+	MaxCredit           *float64 `json:"maxCredit"`
+	MaxDebit            *float64 `json:"maxDebit"`
+	Net                 *float64 `json:"net"`
+	PrevMonthNet        *float64 `json:"prevMonthNet"`
+	ChangePercent       *float64 `json:"changePercent"`
+	HasLargeTransaction bool     `json:"hasLargeTransaction,omitempty"`
+}
+
+// summaryForOutput returns the value to serialize for a summary under SUMMARY_SCHEMA.
+// v1 is the AccountSummary itself, so its JSON is unchanged. Only the S3 artifact and
+// EventBridge events go through it: notifier payloads, persisted summaries and the
+// summary API are read by the emailer or by clients of v1 and always stay v1.
+func summaryForOutput(s *AccountSummary) interface{} {
+	if summarySchema != summarySchemaV2 {
+		return s
+	}
+
+	v2 := summaryV2{
+		Email:            s.Email,
+		Name:             s.Name,
+		Tier:             s.Tier,
+		TotalBalance:     s.TotalBalance,
+		MonthlySummaries: monthsV2(s.MonthlySummaries),
+		Transactions:     s.Transactions,
+		Flagged:          s.Flagged,
+		FlagReason:       s.FlagReason,
+	}
+	v2.TransactionCount = summaryTransactionCount(s)
+	switch len(s.Currencies) {
+	case 0:
+		v2.Currency = defaultCurrency
+	case 1:
+		v2.Currency = s.Currencies[0].Currency
+	}
+	for _, c := range s.Currencies {
+		cv := currencyV2{
+			Currency:         c.Currency,
+			TotalBalance:     c.TotalBalance,
+			MonthlySummaries: monthsV2(c.MonthlySummaries),
+		}
+		for _, m := range c.MonthlySummaries {
+			cv.TransactionCount += m.TransactionCount
+		}
+		v2.Currencies = append(v2.Currencies, cv)
+	}
+	return v2
+}
+
+// summariesForOutput applies summaryForOutput to every summary.
+func summariesForOutput(summaries []*AccountSummary) []interface{} {
+	out := make([]interface{}, len(summaries))
+	for i, s := range summaries {
+		out[i] = summaryForOutput(s)
+	}
+	return out
+}
+
+func monthsV2(months []MonthlySummary) []monthV2 {
+	out := make([]monthV2, len(months))
+	for i, m := range months {
+		out[i] = monthV2{
+			Month:               m.Month,
+			TransactionCount:    m.TransactionCount,
+			AverageCredit:       m.AverageCredit,
+			AverageDebit:        m.AverageDebit,
+			MaxCredit:           m.MaxCredit,
+			MaxDebit:            m.MaxDebit,
+			Net:                 m.Net,
+			PrevMonthNet:        m.PrevMonthNet,
+			ChangePercent:       m.ChangePercent,
+			HasLargeTransaction: m.HasLargeTransaction,
+		}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// schemaTestSummary returns a summary with one month and every optional field set.
+func schemaTestSummary() *AccountSummary {
+	net, prev, change, avg := 30.0, 20.0, 50.0, 15.0
+	return &AccountSummary{
+		Email:        "jane@example.com",
+		Name:         "Jane",
+		TotalBalance: 30,
+		MonthlySummaries: []MonthlySummary{{
+			Month: "March", TransactionCount: 2, AverageCredit: &avg,
+			Net: &net, PrevMonthNet: &prev, ChangePercent: &change,
+		}},
+	}
+}
+
+func TestSummaryForOutputV1IsUnchanged(t *testing.T) {
+	setVar(t, &summarySchema, summarySchemaV1)
+	s := schemaTestSummary()
+	want, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(summaryForOutput(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("v1 JSON = %s, want the AccountSummary JSON %s", got, want)
+	}
+}
+
+func TestSummaryForOutputV2Shape(t *testing.T) {
+	setVar(t, &summarySchema, summarySchemaV2)
+	setVar(t, &defaultCurrency, "USD")
+	got, err := json.Marshal(summaryForOutput(schemaTestSummary()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"email":"jane@example.com","name":"Jane","currency":"USD","totalBalance":30,"transactionCount":2,` +
+		`"monthlySummaries":[{"month":"March","transactionCount":2,"averageCredit":15,"averageDebit":null,"maxCredit":null,` +
+		`"maxDebit":null,"net":30,"prevMonthNet":20,"changePercent":50}],"flagged":false}`
+	if string(got) != want {
+		t.Errorf("v2 JSON =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSummaryForOutputV2Currencies(t *testing.T) {
+	setVar(t, &summarySchema, summarySchemaV2)
+	s := &AccountSummary{Email: "jane@example.com", Currencies: []CurrencyBreakdown{
+		{Currency: "EUR", TotalBalance: 5, MonthlySummaries: []MonthlySummary{{Month: "March", TransactionCount: 1}}},
+		{Currency: "USD", TotalBalance: 7, MonthlySummaries: []MonthlySummary{{Month: "March", TransactionCount: 3}}},
+	}}
+
+	v2 := summaryForOutput(s).(summaryV2)
+	if v2.Currency != "" || len(v2.Currencies) != 2 {
+		t.Fatalf("currency = %q with %d breakdowns, want none and 2 breakdowns", v2.Currency, len(v2.Currencies))
+	}
+	if v2.TransactionCount != 4 || v2.Currencies[1].TransactionCount != 3 {
+		t.Errorf("transaction counts = %d total, %d in USD, want 4 and 3", v2.TransactionCount, v2.Currencies[1].TransactionCount)
+	}
+
+	s.Currencies = s.Currencies[:1]
+	if v2 := summaryForOutput(s).(summaryV2); v2.Currency != "EUR" {
+		t.Errorf("currency = %q, want EUR for a single-currency account", v2.Currency)
+	}
+}
+
+func TestSummarySchemaV2OnlyAppliesToArtifactAndEvents(t *testing.T) {
+	setVar(t, &summarySchema, summarySchemaV2)
+	setVar(t, &summaryArtifactBucket, "artifacts")
+	f := newFakeS3(nil)
+	useS3(t, f)
+	summaries := []*AccountSummary{schemaTestSummary()}
+
+	if err := writeSummaryArtifact(context.Background(), summaries); err != nil {
+		t.Fatal(err)
+	}
+	for key, data := range f.objects {
+		if !strings.Contains(string(data), `"totalBalance"`) {
+			t.Errorf("artifact %s = %s, want the v2 shape", key, data)
+		}
+	}
+
+	client := &fakeEventBridge{}
+	n := &eventBridgeNotifier{client: client, busName: "summaries"}
+	if err := n.Notify(context.Background(), NotificationPayload{Summaries: summaries}); err != nil {
+		t.Fatal(err)
+	}
+	if detail := aws.ToString(client.calls[0][0].Detail); !strings.Contains(detail, `"totalBalance"`) {
+		t.Errorf("event detail = %s, want the v2 shape", detail)
+	}
+
+	payload, err := buildPayload(summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"total_balance"`) || strings.Contains(string(data), `"totalBalance"`) {
+		t.Errorf("notifier payload = %s, want v1 summaries for the emailer", data)
+	}
+}
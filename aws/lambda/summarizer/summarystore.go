@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// persistSummaries upserts each summary into its route's summary table (by default
+// account_summaries) for the current month, where the emailer's bulk mode picks them up. A summary already stored for the month
+// is replaced but keeps its status, so an account that was already emailed is not
+// emailed again.
+func persistSummaries(ctx context.Context, db *sql.DB, summaries []*AccountSummary) error {
+	period := clock().Format("2006-01")
+	for _, summary := range summaries {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return classify(ErrFatal, fmt.Errorf("error serializing summary: %w", err))
+		}
+		table := summary.summaryTable
+		if table == "" {
+			table = defaultSummaryTable
+		}
+		err = retryDB(ctx, "persist summary", func() error {
+			_, err := db.ExecContext(ctx, `
+				INSERT INTO `+table+` (email, period, summary)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (email, period) DO UPDATE
+				SET summary = EXCLUDED.summary, updated_at = NOW()`,
+				summary.Email, period, data)
+			return classifyDBError(err)
+		})
+		if err != nil {
+			return fmt.Errorf("error persisting summary for %s: %w", maskEmail(summary.Email), err)
+		}
+	}
+	return nil
+}
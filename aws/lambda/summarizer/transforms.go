@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+)
+
+// columnTransform rewrites one column of every row before validation.
+type columnTransform struct {
+	column string
+	name   string
+	apply  func(string) string
+}
+
+// parseColumnTransforms parses COLUMN_TRANSFORMS: semicolon-separated column:rule
+// entries applied in order, where a rule is one of
+//
+//	trim, lower, upper      whitespace and case normalization
+//	append_domain=<domain>  complete a value without "@" as value@domain
+//	scale=<factor>          multiply a decimal amount exactly by factor (e.g. 0.01 for cents)
+//
+// Only these fixed rules exist, so a configuration cannot run arbitrary code.
+func parseColumnTransforms(value string, s *csvSchema) ([]columnTransform, error) {
+	var transforms []columnTransform
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		column, rule, ok := strings.Cut(entry, ":")
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !ok || column == "" {
+			return nil, fmt.Errorf("entry %q is not column:rule", entry)
+		}
+		if !s.has(column) {
+			return nil, fmt.Errorf("entry %q: column %q is not in CSV_COLUMNS", entry, column)
+		}
+
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		t := columnTransform{column: column, name: name}
+		switch name {
+		case "trim":
+			t.apply = strings.TrimSpace
+		case "lower":
+			t.apply = strings.ToLower
+		case "upper":
+			t.apply = strings.ToUpper
+		case "append_domain":
+			domain := strings.TrimPrefix(arg, "@")
+			if domain == "" || strings.ContainsAny(domain, "@ ") {
+				return nil, fmt.Errorf("entry %q: append_domain needs a domain", entry)
+			}
+			t.apply = func(v string) string { return appendDomain(v, domain) }
+		case "scale":
+			factor, ok := new(big.Rat).SetString(arg)
+			if !csvrules.StrictAmount.MatchString(arg) || !ok || factor.Sign() == 0 {
+				return nil, fmt.Errorf("entry %q: scale needs a non-zero decimal factor", entry)
+			}
+			t.apply = func(v string) string { return scaleAmount(v, factor) }
+		default:
+			return nil, fmt.Errorf("entry %q: unknown rule %q", entry, name)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+// applyColumnTransforms runs the configured transforms over every row, in order.
+func applyColumnTransforms(rows []csvRow) {
+	for _, t := range columnTransforms {
+		i := schema.index[t.column]
+		for _, row := range rows {
+			row.Fields[i] = t.apply(row.Fields[i])
+		}
+	}
+}
+
+// appendDomain completes a bare account name as name@domain. Blank values and values
+// that already contain "@" are left alone.
+func appendDomain(value, domain string) string {
+	v := strings.TrimSpace(value)
+	if v == "" || strings.Contains(v, "@") {
+		return value
+	}
+	return v + "@" + domain
+}
+
+// scaleAmount multiplies a decimal amount by factor without floating point rounding,
+// keeping an explicit "+" sign since credits are recognized by it. A value that is not
+// a plain decimal is returned unchanged for validation to reject.
+func scaleAmount(value string, factor *big.Rat) string {
+	v := strings.TrimSpace(value)
+	if !csvrules.StrictAmount.MatchString(v) {
+		return value
+	}
+	amount, ok := new(big.Rat).SetString(v)
+	if !ok {
+		return value
+	}
+	amount.Mul(amount, factor)
+
+	// A product of decimals needs at most the sum of their fractional digits
+	digits := fractionDigits(v) + fractionDigits(factor.FloatString(fractionDigitsLimit))
+	s := amount.FloatString(digits)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if amount.Sign() >= 0 && strings.HasPrefix(v, "+") {
+		s = "+" + s
+	}
+	return s
+}
+
+// fractionDigitsLimit bounds the fractional digits of a scale factor; factors are
+// decimals as written in COLUMN_TRANSFORMS, far shorter than this.
+const fractionDigitsLimit = 40
+
+// fractionDigits returns the number of significant digits after the decimal point of
+// a decimal string.
+func fractionDigits(s string) int {
+	_, frac, ok := strings.Cut(s, ".")
+	if !ok {
+		return 0
+	}
+	return len(strings.TrimRight(frac, "0"))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTransforms configures COLUMN_TRANSFORMS for the test.
+func useTransforms(t *testing.T, value string) {
+	t.Helper()
+	transforms, err := parseColumnTransforms(value, schema)
+	if err != nil {
+		t.Fatalf("parseColumnTransforms(%q) error = %v", value, err)
+	}
+	setVar(t, &columnTransforms, transforms)
+}
+
+func TestParseColumnTransforms(t *testing.T) {
+	transforms, err := parseColumnTransforms(" Email:trim; email:lower ;email:append_domain=@example.com;transaction:scale=0.01;", schema)
+	if err != nil {
+		t.Fatalf("parseColumnTransforms() error = %v", err)
+	}
+	var got []string
+	for _, tr := range transforms {
+		got = append(got, tr.column+":"+tr.name)
+	}
+	want := []string{"email:trim", "email:lower", "email:append_domain", "transaction:scale"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transforms = %v, want %v in order", got, want)
+	}
+}
+
+func TestParseColumnTransformsRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"email", "is not column:rule"},
+		{":trim", "is not column:rule"},
+		{"name:trim", `column "name" is not in CSV_COLUMNS`},
+		{"email:exec=rm", `unknown rule "exec"`},
+		{"email:append_domain", "append_domain needs a domain"},
+		{"email:append_domain=a@b.com", "append_domain needs a domain"},
+		{"transaction:scale=0", "scale needs a non-zero decimal factor"},
+		{"transaction:scale=1e3", "scale needs a non-zero decimal factor"},
+		{"transaction:scale=x", "scale needs a non-zero decimal factor"},
+	}
+	for _, tt := range tests {
+		if _, err := parseColumnTransforms(tt.value, schema); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseColumnTransforms(%q) error = %v, want %q", tt.value, err, tt.want)
+		}
+	}
+}
+
+func TestAppendDomain(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"jane", "jane@example.com"},
+		{" jane ", "jane@example.com"},
+		{"jane@other.com", "jane@other.com"},
+		{"", ""},
+		{"  ", "  "},
+	}
+	for _, tt := range tests {
+		if got := appendDomain(tt.value, "example.com"); got != tt.want {
+			t.Errorf("appendDomain(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestScaleAmount(t *testing.T) {
+	tests := []struct {
+		value, factor, want string
+	}{
+		{"+6050", "0.01", "+60.5"},
+		{"-1999", "0.01", "-19.99"},
+		{"123", "0.01", "1.23"},
+		{"+0.1", "3", "+0.3"},
+		{"+12.5", "-1", "-12.5"},
+		{"-12.5", "-1", "12.5"},
+		{"+100", "0.001", "+0.1"},
+		{"1,000", "0.01", "1,000"},
+		{"1e3", "0.01", "1e3"},
+	}
+	for _, tt := range tests {
+		factor, _ := new(big.Rat).SetString(tt.factor)
+		if got := scaleAmount(tt.value, factor); got != tt.want {
+			t.Errorf("scaleAmount(%q, %s) = %q, want %q", tt.value, tt.factor, got, tt.want)
+		}
+	}
+}
+
+func TestProcessFileAppliesColumnTransforms(t *testing.T) {
+	useTransforms(t, "email:lower;email:append_domain=example.com;transaction:scale=0.01")
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+6050,JANE\n2,2024-01-06,-1000,jane@example.com\n"}))
+	repo := newMemRepository()
+	useRepository(t, repo)
+	db, mock := newMockDB(t)
+	expectNewFile(mock, "bucket", "file.csv")
+	expectLedgerWrite(mock, "bucket", "file.csv", ingestProcessed)
+
+	summaries, err := processFile(context.Background(), db, "bucket", "file.csv", sql.NullInt64{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if len(summaries) != 1 || summaries[0].Email != "jane@example.com" || summaries[0].TotalBalance != 50.5 {
+		t.Errorf("summaries = %+v, want one for jane@example.com with a balance of 50.5", summaries)
+	}
+}
+
+func TestLoadConfigRejectsInvalidColumnTransforms(t *testing.T) {
+	if out := loadConfigError(t, map[string]string{"COLUMN_TRANSFORMS": "email:exec=rm"}); !strings.Contains(out, "Invalid value for COLUMN_TRANSFORMS") {
+		t.Errorf("loadConfig() output = %q, want COLUMN_TRANSFORMS rejected", out)
+	}
+}
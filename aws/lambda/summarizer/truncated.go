@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// tailReader tracks how much of the CSV input has been read and how it ends, so that a
+// final line cut off by an interrupted upload can be told apart from a short record.
+type tailReader struct {
+	r    io.Reader
+	n    int64
+	last byte
+	eof  bool
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.n += int64(n)
+		t.last = p[n-1]
+	}
+	if err == io.EOF {
+		t.eof = true
+	}
+	return n, err
+}
+
+// isTruncatedRecord reports whether record, just returned by reader, is a partial final
+// line: the input ended without a trailing newline right after it, and it has fewer
+// fields than the schema. Such a record is what a truncated upload leaves behind.
+func isTruncatedRecord(reader *csv.Reader, tail *tailReader, record []string) bool {
+	return len(record) < schema.width() &&
+		tail.eof && tail.last != '\n' && reader.InputOffset() == tail.n
+}
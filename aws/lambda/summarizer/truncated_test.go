@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestProcessCSVFileSkipsTruncatedFinalLine(t *testing.T) {
+	metrics := captureMetrics(t)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-0"}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if err != nil {
+		t.Fatalf("processCSVFile() error = %v, want the partial line skipped", err)
+	}
+	if got := fields(rows); !reflect.DeepEqual(got, [][]string{{"1", "2024-01-05", "+10.5", "jane@example.com"}}) {
+		t.Errorf("rows = %v, want only the complete line", got)
+	}
+	if got := metrics.records(t, "TruncatedFinalLines"); len(got) != 1 {
+		t.Errorf("TruncatedFinalLines records = %v, want one", got)
+	}
+}
+
+func TestProcessCSVFileKeepsCompleteFinalLineWithoutNewline(t *testing.T) {
+	metrics := captureMetrics(t)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com"}))
+
+	rows, err := processCSVFile(context.Background(), "bucket", "file.csv")
+	if err != nil {
+		t.Fatalf("processCSVFile() error = %v", err)
+	}
+	if got := fields(rows); !reflect.DeepEqual(got, [][]string{{"1", "2024-01-05", "+10.5", "jane@example.com"}}) {
+		t.Errorf("rows = %v, want the final line kept", got)
+	}
+	if got := metrics.records(t, "TruncatedFinalLines"); len(got) != 0 {
+		t.Errorf("TruncatedFinalLines records = %v, want none", got)
+	}
+}
+
+func TestProcessCSVFileTreatsShortLineWithNewlineAsInvalid(t *testing.T) {
+	metrics := captureMetrics(t)
+	useS3(t, newFakeS3(map[string]string{"bucket/file.csv": "id,date,transaction,email\n1,2024-01-05,+10.5,jane@example.com\n2,2024-01-0\n"}))
+
+	if _, err := processCSVFile(context.Background(), "bucket", "file.csv"); err != nil {
+		t.Fatalf("processCSVFile() error = %v", err)
+	}
+	if got := metrics.records(t, "TruncatedFinalLines"); len(got) != 0 {
+		t.Errorf("TruncatedFinalLines records = %v, want a complete short line handled as an invalid row", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+)
+
+// csvRow is a data record read from the CSV together with the line it came from.
+type csvRow struct {
+	Line   int
+	Fields []string
+}
+
+// FieldError describes one invalid value in the CSV. Value is masked for PII columns.
+type FieldError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+// ValidationReport collects every field error found in a file. Rows with errors are
+// quarantined: they are left out of the insert while the rest of the file is ingested.
+type ValidationReport struct {
+	Bucket       string       `json:"bucket"`
+	Key          string       `json:"key"`
+	RejectedRows int          `json:"rejected_rows"`
+	Errors       []FieldError `json:"errors"`
+}
+
+// validateRows checks every field of every row and returns the rows that passed along
+// with a report of all errors found, rather than stopping at the first one. With
+// STRICT_FEED it stops at the first invalid row, which fails the whole file.
+func validateRows(bucket, key string, rows []csvRow) ([]csvRow, ValidationReport) {
+	report := ValidationReport{Bucket: bucket, Key: key}
+	valid := make([]csvRow, 0, len(rows))
+
+	for _, row := range rows {
+		errs := validateRow(row)
+		if len(errs) > 0 {
+			report.RejectedRows++
+			report.Errors = append(report.Errors, errs...)
+			if strictFeed {
+				break
+			}
+			continue
+		}
+		valid = append(valid, row)
+	}
+	return valid, report
+}
+
+// validateRow returns a FieldError for each invalid column of row.
+func validateRow(row csvRow) []FieldError {
+	rules := csvrules.Rules{
+		IntKey:            keyType == keyTypeInt,
+		StrictAmounts:     amountFormat == amountFormatStrict,
+		RejectFutureDates: rejectFutureDates,
+		Now:               clock,
+	}
+	problems := rules.Check(func(col string) (string, bool) {
+		return schema.field(row.Fields, col), schema.has(col)
+	})
+
+	var errs []FieldError
+	for _, p := range problems {
+		value := schema.field(row.Fields, p.Column)
+		if p.Column == "email" {
+			value = maskEmail(value)
+		}
+		errs = append(errs, FieldError{
+			Line:    row.Line,
+			Column:  p.Column,
+			Value:   value,
+			Message: p.Message,
+		})
+	}
+	return errs
+}
+
+// logValidationReport writes the report as a single JSON log line when it has errors.
+func logValidationReport(report ValidationReport) {
+	if len(report.Errors) == 0 {
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Error serializing validation report: %v", err)
+		return
+	}
+	log.Printf("Validation report: %s", data)
+}
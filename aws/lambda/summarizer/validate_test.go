@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+)
+
+func TestValidateRowsReportsEveryFieldError(t *testing.T) {
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-01-05", "+60.5", "jane@example.com"}},
+		{Line: 3, Fields: []string{"x", "2024-13-01", "+1", "jane@example.com"}},
+		{Line: 4, Fields: []string{"3", "2024-01-07", "ten", "jane.example.com"}},
+		{Line: 5, Fields: []string{"4", "2024-01-08", "-2", "john@example.com"}},
+	}
+
+	valid, report := validateRows("bucket", "file.csv", rows)
+	if got := fields(valid); !reflect.DeepEqual(got, [][]string{rows[0].Fields, rows[3].Fields}) {
+		t.Errorf("valid rows = %v, want lines 2 and 5", got)
+	}
+	if report.RejectedRows != 2 {
+		t.Errorf("RejectedRows = %d, want 2", report.RejectedRows)
+	}
+	want := []FieldError{
+		{Line: 3, Column: "id", Value: "x", Message: "not an integer"},
+		{Line: 3, Column: "date", Value: "2024-13-01", Message: "not a date in YYYY-MM-DD format, optionally with a time of day"},
+		{Line: 4, Column: "transaction", Value: "ten", Message: "not a signed decimal amount"},
+		{Line: 4, Column: "email", Value: "***", Message: "not an email address"},
+	}
+	if !reflect.DeepEqual(report.Errors, want) {
+		t.Errorf("errors = %+v\nwant %+v", report.Errors, want)
+	}
+}
+
+func TestValidateRowsStopsAtFirstInvalidRowOfStrictFeed(t *testing.T) {
+	setVar(t, &strictFeed, true)
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"x", "2024-01-05", "+1", "jane@example.com"}},
+		{Line: 3, Fields: []string{"y", "2024-01-06", "+1", "jane@example.com"}},
+	}
+
+	_, report := validateRows("bucket", "file.csv", rows)
+	if report.RejectedRows != 1 || len(report.Errors) != 1 || report.Errors[0].Line != 2 {
+		t.Errorf("report = %+v, want only line 2 reported", report)
+	}
+}
+
+func TestValidateRowsQuarantinesFutureDates(t *testing.T) {
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC) })
+	rows := []csvRow{
+		{Line: 2, Fields: []string{"1", "2024-03-10", "+1", "jane@example.com"}},
+		{Line: 3, Fields: []string{"2", "2024-03-11", "+1", "jane@example.com"}},
+	}
+
+	valid, report := validateRows("bucket", "file.csv", rows)
+	if len(valid) != 2 || report.RejectedRows != 0 {
+		t.Errorf("valid rows = %v, want both kept by default", fields(valid))
+	}
+
+	setVar(t, &rejectFutureDates, true)
+	valid, report = validateRows("bucket", "file.csv", rows)
+	if got := fields(valid); !reflect.DeepEqual(got, [][]string{rows[0].Fields}) {
+		t.Errorf("valid rows = %v, want only line 2", got)
+	}
+	want := []FieldError{{Line: 3, Column: "date", Value: "2024-03-11", Message: "in the future"}}
+	if report.RejectedRows != 1 || !reflect.DeepEqual(report.Errors, want) {
+		t.Errorf("report = %+v, want line 3 quarantined as in the future", report)
+	}
+}
+
+func TestValidateRowsStrictAmountFormat(t *testing.T) {
+	const strictMessage = "not a plain signed decimal amount (digits with an optional sign and decimal point)"
+	tests := []struct {
+		amount  string
+		lenient bool // accepted under the default lenient format
+	}{
+		{"+60.5", true},
+		{"-3", true},
+		{"42", true},
+		{" +1.25 ", true},
+		{"+-5", false},
+		{"1e3", true},
+		{"1.5E-2", true},
+		{"1 000", false},
+		{"- 5", false},
+		{"0x1p-2", true},
+		{"1,000.50", false},
+		{".5", true},
+		{"5.", true},
+	}
+	for _, tt := range tests {
+		rows := []csvRow{{Line: 2, Fields: []string{"1", "2024-01-05", tt.amount, "jane@example.com"}}}
+		wantStrict := csvrules.StrictAmount.MatchString(strings.TrimSpace(tt.amount))
+
+		setVar(t, &amountFormat, amountFormatLenient)
+		if _, report := validateRows("bucket", "file.csv", rows); (report.RejectedRows == 0) != tt.lenient {
+			t.Errorf("lenient %q: rejected = %v, want %v", tt.amount, report.RejectedRows == 1, !tt.lenient)
+		}
+
+		setVar(t, &amountFormat, amountFormatStrict)
+		_, report := validateRows("bucket", "file.csv", rows)
+		if (report.RejectedRows == 0) != wantStrict {
+			t.Errorf("strict %q: rejected = %v, want %v", tt.amount, report.RejectedRows == 1, !wantStrict)
+		}
+		if !wantStrict && (len(report.Errors) != 1 || report.Errors[0].Message != strictMessage || report.Errors[0].Line != 2) {
+			t.Errorf("strict %q: errors = %+v, want one amount error on line 2", tt.amount, report.Errors)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// setContentDisposition makes uploaded objects download as an attachment named after their key.
+	setContentDisposition bool
+	// csvHasHeader mirrors the summarizer setting so /validate checks files the same way.
+	csvHasHeader bool
+	// csvColumns mirrors the summarizer's CSV_COLUMNS.
+	csvColumns []string
+	// keyType mirrors the summarizer's KEY_TYPE: "int" ids must be integers, "text" ids non-blank.
+	keyType string
+	// amountFormat, amountDecimalSeparator, amountThousandsSeparator and rejectFutureDates
+	// mirror the summarizer's row rules, so /validate rejects the rows it would.
+	amountFormat             string
+	amountDecimalSeparator   string
+	amountThousandsSeparator string
+	rejectFutureDates        bool
+	// strictColumns and strictFeed mirror the summarizer settings that fail a whole file
+	// on its first bad row.
+	strictColumns string
+	strictFeed    bool
+
+	// uploadStatusEnabled serves GET /status from the summarizer's processed-files ledger.
+	uploadStatusEnabled bool
+	// uploadCallerClaim is the authorizer claim identifying the caller; uploads record
+	// it so that only the same caller can read a file's status.
+	uploadCallerClaim string
+
+	// databaseURL, when set, replaces the DB_* variables with a full connection string.
+	databaseURL string
+	// dbSSLMode and dbSSLRootCert configure TLS for the ledger database.
+	dbSSLMode     string
+	dbSSLRootCert string
+
+	// quotaLimit caps uploads per client (source IP) in any rolling quotaWindow; 0 disables it.
+	quotaLimit  int
+	quotaWindow time.Duration
+
+	// zipMaxDecompressedBytes caps the total size of the CSVs extracted from one ZIP upload.
+	zipMaxDecompressedBytes int64
+)
+
+// requiredEnv lists the environment variables the uploader cannot start without
+// under the current configuration.
+func requiredEnv() []string {
+	keys := []string{"S3_BUCKET"}
+	status, _ := strconv.ParseBool(os.Getenv("UPLOAD_STATUS_ENABLED"))
+	if status && os.Getenv("DATABASE_URL") == "" {
+		keys = append(keys, "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME")
+	}
+	return keys
+}
+
+// loadConfig validates required settings and reads optional ones from the environment,
+// applying defaults. It terminates execution if anything is missing or malformed.
+func loadConfig() {
+	if err := checkRequiredEnv(requiredEnv()...); err != nil {
+		log.Fatal(err)
+	}
+	uploadStatusEnabled = envBool("UPLOAD_STATUS_ENABLED", false)
+	uploadCallerClaim = envString("UPLOAD_CALLER_CLAIM", "sub")
+	databaseURL = os.Getenv("DATABASE_URL")
+	if databaseURL != "" {
+		// Parses the URL without connecting
+		if _, err := pq.NewConnector(databaseURL); err != nil {
+			log.Fatalf("Invalid value for DATABASE_URL: %v", err)
+		}
+	}
+	dbSSLMode = envString("DB_SSLMODE", "require")
+	switch dbSSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		log.Fatalf("Invalid value for DB_SSLMODE: %q", dbSSLMode)
+	}
+	dbSSLRootCert = os.Getenv("DB_SSLROOTCERT")
+	quotaLimit = envInt("UPLOAD_QUOTA", 0)
+	quotaWindow = envDuration("UPLOAD_QUOTA_WINDOW", time.Minute)
+	if quotaLimit > 0 && quotaWindow <= 0 {
+		log.Fatalf("Invalid value for UPLOAD_QUOTA_WINDOW: must be positive, got %s", quotaWindow)
+	}
+	zipMaxDecompressedBytes = int64(envInt("ZIP_MAX_DECOMPRESSED_BYTES", 100*1024*1024))
+	if zipMaxDecompressedBytes < 1 {
+		log.Fatalf("Invalid value for ZIP_MAX_DECOMPRESSED_BYTES: must be at least 1, got %d", zipMaxDecompressedBytes)
+	}
+	setContentDisposition = envBool("S3_CONTENT_DISPOSITION", false)
+	csvHasHeader = envBool("CSV_HAS_HEADER", true)
+	csvColumns = strings.Split(envString("CSV_COLUMNS", "id,date,transaction,email"), ",")
+	if _, ok := columnIndex(csvColumns)["id"]; !ok {
+		log.Fatal(`Invalid value for CSV_COLUMNS: required column "id" is missing`)
+	}
+	keyType = envString("KEY_TYPE", "int")
+	if keyType != "int" && keyType != "text" {
+		log.Fatalf("Invalid value for KEY_TYPE: %q", keyType)
+	}
+	amountFormat = envString("AMOUNT_FORMAT", "lenient")
+	if amountFormat != "lenient" && amountFormat != "strict" {
+		log.Fatalf("Invalid value for AMOUNT_FORMAT: %q", amountFormat)
+	}
+	amountDecimalSeparator = envString("AMOUNT_DECIMAL_SEPARATOR", ".")
+	amountThousandsSeparator = os.Getenv("AMOUNT_THOUSANDS_SEPARATOR")
+	if amountDecimalSeparator != "." && amountDecimalSeparator != "," {
+		log.Fatalf("Invalid value for AMOUNT_DECIMAL_SEPARATOR: %q", amountDecimalSeparator)
+	}
+	switch amountThousandsSeparator {
+	case "", ".", ",", " ", "'":
+	default:
+		log.Fatalf("Invalid value for AMOUNT_THOUSANDS_SEPARATOR: %q", amountThousandsSeparator)
+	}
+	if amountThousandsSeparator == amountDecimalSeparator {
+		log.Fatalf("Invalid value for AMOUNT_THOUSANDS_SEPARATOR: same as AMOUNT_DECIMAL_SEPARATOR (%q)", amountDecimalSeparator)
+	}
+	rejectFutureDates = envBool("REJECT_FUTURE_DATES", false)
+	strictColumns = envString("STRICT_COLUMNS", "skip")
+	if strictColumns != "skip" && strictColumns != "fail" {
+		log.Fatalf("Invalid value for STRICT_COLUMNS: %q", strictColumns)
+	}
+	strictFeed = envBool("STRICT_FEED", false)
+	if strictFeed {
+		strictColumns = "fail"
+	}
+}
+
+// envBool returns the boolean value of the environment variable key, or def if it is unset.
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return b
+}
+
+// envInt returns the integer value of the environment variable key, or def if it is unset.
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return n
+}
+
+// envDuration returns the duration value (e.g. "5s") of the environment variable key, or def if it is unset.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", key, err)
+	}
+	return d
+}
+
+// checkRequiredEnv returns an error naming every variable in keys that is unset or empty,
+// so a misconfigured deployment reports all of its problems at once.
+func checkRequiredEnv(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// envString returns the value of the environment variable key, or def if it is unset or empty.
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckRequiredEnvListsEveryMissingVariable(t *testing.T) {
+	t.Setenv("REQ_A", "")
+	t.Setenv("REQ_B", "set")
+	err := checkRequiredEnv("REQ_A", "REQ_B", "REQ_C")
+	if err == nil {
+		t.Fatal("checkRequiredEnv() = nil, want an error")
+	}
+	if want := "missing required environment variables: REQ_A, REQ_C"; err.Error() != want {
+		t.Errorf("checkRequiredEnv() = %q, want %q", err, want)
+	}
+}
+
+func TestRequiredEnv(t *testing.T) {
+	t.Setenv("UPLOAD_STATUS_ENABLED", "")
+	t.Setenv("DATABASE_URL", "")
+	if got := requiredEnv(); !reflect.DeepEqual(got, []string{"S3_BUCKET"}) {
+		t.Errorf("requiredEnv() = %v, want only S3_BUCKET", got)
+	}
+
+	t.Setenv("UPLOAD_STATUS_ENABLED", "true")
+	want := []string{"S3_BUCKET", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"}
+	if got := requiredEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requiredEnv() with status = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "errors"
+
+var (
+	// ErrValidation marks failures caused by bad client input, such as an undecodable body.
+	ErrValidation = errors.New("validation error")
+	// ErrTransient marks infrastructure failures that may succeed if retried.
+	ErrTransient = errors.New("transient error")
+	// ErrFatal marks infrastructure failures that will not succeed if retried.
+	ErrFatal = errors.New("fatal error")
+)
+
+// classifiedError attaches one of the sentinel kinds to an underlying error
+// without altering its message.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// classify tags err with kind so that errors.Is(err, kind) reports true.
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{kind: kind, err: err}
+}
+
+// classifyAWSError tags an AWS SDK error as transient when it is retryable and fatal otherwise.
+func classifyAWSError(err error) error {
+	if isRetryable(err) {
+		return classify(ErrTransient, err)
+	}
+	return classify(ErrFatal, err)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func TestClassifyKeepsMessageAndKind(t *testing.T) {
+	base := errors.New("boom")
+	err := classify(ErrTransient, base)
+	if !errors.Is(err, ErrTransient) || !errors.Is(err, base) {
+		t.Fatalf("classify() = %v, want it to match both ErrTransient and the cause", err)
+	}
+	if err.Error() != "boom" {
+		t.Errorf("Error() = %q, want %q", err.Error(), "boom")
+	}
+	if classify(ErrFatal, nil) != nil {
+		t.Error("classify(kind, nil) != nil")
+	}
+}
+
+func TestClassifyAWSError(t *testing.T) {
+	if err := classifyAWSError(errRetryable); !errors.Is(err, ErrTransient) {
+		t.Errorf("classifyAWSError(retryable) = %v, want ErrTransient", err)
+	}
+	if err := classifyAWSError(&smithy.GenericAPIError{Code: "AccessDenied"}); !errors.Is(err, ErrFatal) {
+		t.Errorf("classifyAWSError(AccessDenied) = %v, want ErrFatal", err)
+	}
+}
+
+func TestErrorResponseStatus(t *testing.T) {
+	tests := []struct {
+		kind error
+		want int
+	}{
+		{ErrValidation, http.StatusBadRequest},
+		{ErrTransient, http.StatusServiceUnavailable},
+		{ErrFatal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		resp := errorResponse("failed", classify(tt.kind, fmt.Errorf("cause")))
+		if resp.StatusCode != tt.want {
+			t.Errorf("errorResponse(%v) status = %d, want %d", tt.kind, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestHandlerRejectsUndecodableBody(t *testing.T) {
+	useS3(t, &fakeS3{})
+	req := events.APIGatewayV2HTTPRequest{Body: "not base64!", IsBase64Encoded: true}
+	req.RequestContext.HTTP.Method = http.MethodPost
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerReportsPermanentS3FailureAsServerError(t *testing.T) {
+	useS3(t, &fakeS3{put: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied"}
+	}})
+	req := events.APIGatewayV2HTTPRequest{Body: "id,date,transaction,email\n"}
+	req.RequestContext.HTTP.Method = http.MethodPost
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
@@ -4,35 +4,50 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
-var (
-	s3Client *s3.Client
-	bucket   string
+const (
+	// maxUploadAttempts bounds how many times a PutObject is tried before giving up.
+	maxUploadAttempts = 3
+	// uploadBaseBackoff is the delay before the first retry; it doubles on each attempt.
+	uploadBaseBackoff = 200 * time.Millisecond
 )
 
-func init() {
-	initS3Client()
+// s3API is the subset of the S3 client used by the uploader, so it can be replaced in tests.
+type s3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
 }
 
+var (
+	s3Client s3API
+	bucket   string
+
+	// clock returns the current time; tests replace it to get deterministic keys.
+	clock = time.Now
+)
+
 // initS3Client initializes the S3 client and loads the target bucket name from environment variables.
-// It terminates execution if configuration is missing or AWS setup fails.
+// It terminates execution if AWS setup fails.
 func initS3Client() {
 	bucket = os.Getenv("S3_BUCKET")
-	if bucket == "" {
-		log.Fatal("S3_BUCKET is not defined in the environment")
-	}
 
 	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
 	if err != nil {
@@ -40,59 +55,183 @@ func initS3Client() {
 	}
 
 	s3Client = s3.NewFromConfig(cfg)
+
+	if uploadStatusEnabled {
+		statusDB, err = openDB()
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+	}
 }
 
 // handler is the main Lambda handler.
 // It accepts only POST requests, decodes the CSV file from the request,
-// uploads it to S3, and returns an appropriate HTTP response.
+// uploads it to S3, and returns an appropriate HTTP response. A ZIP archive
+// is unpacked and each CSV in it is uploaded as a separate file.
+// Requests to /validate only check the CSV and store nothing; GET /status reports
+// whether an uploaded file was ingested.
 func handler(ctx context.Context, req events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	if req.RequestContext.HTTP.Method == http.MethodGet && strings.HasSuffix(req.RequestContext.HTTP.Path, "/status") {
+		return statusHandler(ctx, req), nil
+	}
 	if req.RequestContext.HTTP.Method != http.MethodPost {
 		return methodNotAllowedResponse(), nil
 	}
 
+	if strings.HasSuffix(req.RequestContext.HTTP.Path, "/validate") {
+		return validateHandler(req), nil
+	}
+
+	// Throttle clients uploading more than UPLOAD_QUOTA files per window
+	if quotaLimit > 0 {
+		if ok, wait := quota.allow(req.RequestContext.HTTP.SourceIP, clock()); !ok {
+			log.Printf("Upload quota exceeded for %s, retry after %s", req.RequestContext.HTTP.SourceIP, wait)
+			return tooManyRequestsResponse(wait), nil
+		}
+	}
+
 	body, err := decodeRequestBody(req)
 	if err != nil {
-		return badRequestResponse("Failed to decode request body"), nil
+		return errorResponse("Failed to decode request body", err), nil
+	}
+
+	// The uploader is recorded on the object so only they can read its status
+	owner := callerIdentity(req)
+	if isZip(body) {
+		return uploadZip(ctx, body, owner), nil
 	}
 
-	filename := generateFilename()
-	if err := uploadToS3(ctx, filename, body); err != nil {
-		return internalServerErrorResponse(fmt.Sprintf("Failed to upload to S3: %v", err)), nil
+	filename := generateFilename(clock)
+	if err := uploadToS3(ctx, filename, body, owner); err != nil {
+		return errorResponse("Failed to upload to S3", err), nil
 	}
 
 	log.Printf("File %s uploaded successfully to bucket %s", filename, bucket)
 	return successResponse(fmt.Sprintf("File successfully uploaded as %s", filename)), nil
 }
 
+// uploadZip extracts the CSVs of a ZIP upload and stores each as its own object, so the
+// summarizer ingests them individually. Nothing is stored unless the whole archive
+// extracts; an upload failure partway leaves the CSVs already stored in place.
+func uploadZip(ctx context.Context, body []byte, owner string) events.APIGatewayV2HTTPResponse {
+	entries, err := extractCSVs(body)
+	if err != nil {
+		return errorResponse("Failed to extract ZIP archive", err)
+	}
+
+	base := strings.TrimSuffix(generateFilename(clock), ".csv")
+	filenames := make([]string, 0, len(entries))
+	for i, entry := range entries {
+		filename := fmt.Sprintf("%s-%d.csv", base, i+1)
+		if err := uploadToS3(ctx, filename, entry.Data, owner); err != nil {
+			return errorResponse(fmt.Sprintf("Failed to upload %s (from %s) to S3", filename, entry.Name), err)
+		}
+		log.Printf("ZIP entry %s uploaded successfully to bucket %s as %s", entry.Name, bucket, filename)
+		filenames = append(filenames, filename)
+	}
+	return successResponse(fmt.Sprintf("%d files successfully uploaded as %s", len(filenames), strings.Join(filenames, ", ")))
+}
+
 // decodeRequestBody decodes the HTTP request body.
 // If it's base64 encoded, it decodes it. Otherwise, it returns the raw body.
 func decodeRequestBody(req events.APIGatewayV2HTTPRequest) ([]byte, error) {
 	if req.IsBase64Encoded {
-		return base64.StdEncoding.DecodeString(req.Body)
+		body, err := base64.StdEncoding.DecodeString(req.Body)
+		return body, classify(ErrValidation, err)
 	}
 	return []byte(req.Body), nil
 }
 
-// generateFilename returns a unique filename using the current Unix timestamp.
-func generateFilename() string {
-	return fmt.Sprintf("upload-%d.csv", time.Now().Unix())
+// generateFilename returns a unique filename using the Unix timestamp reported by now.
+func generateFilename(now func() time.Time) string {
+	return fmt.Sprintf("upload-%d.csv", now().Unix())
 }
 
-// uploadToS3 uploads the provided byte content to S3 with the specified key.
-func uploadToS3(ctx context.Context, key string, body []byte) error {
-	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+// uploadToS3 uploads the provided byte content to S3 with the specified key,
+// recording owner (when known) as the caller that uploaded it. Retryable failures
+// are retried with exponential backoff up to maxUploadAttempts, stopping early if
+// the context is cancelled or its deadline would be exceeded.
+func uploadToS3(ctx context.Context, key string, body []byte, owner string) error {
+	backoff := uploadBaseBackoff
+	var err error
+	for attempt := 1; attempt <= maxUploadAttempts; attempt++ {
+		_, err = s3Client.PutObject(ctx, newPutObjectInput(key, body, owner))
+		if err == nil || !isRetryable(err) || attempt == maxUploadAttempts {
+			break
+		}
+
+		log.Printf("Upload attempt %d for %s failed, retrying in %s: %v", attempt, key, backoff, err)
+		if err := sleepWithContext(ctx, backoff); err != nil {
+			return classify(ErrTransient, fmt.Errorf("upload retry aborted: %w", err))
+		}
+		backoff *= 2
+	}
+	if err != nil {
+		return classifyAWSError(err)
+	}
+	return nil
+}
+
+// newPutObjectInput builds the PutObject request for an upload, adding a
+// Content-Disposition header when enabled so downloads get a sensible filename.
+func newPutObjectInput(key string, body []byte, owner string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 		Body:   bytes.NewReader(body),
-	})
-	return err
+	}
+	if owner != "" {
+		input.Metadata = map[string]string{uploadedByMetadata: owner}
+	}
+	if setContentDisposition {
+		input.ContentDisposition = aws.String(mime.FormatMediaType("attachment", map[string]string{
+			"filename": path.Base(key),
+		}))
+	}
+	return input
+}
+
+// isRetryable reports whether err is a transient AWS error worth retrying.
+func isRetryable(err error) bool {
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// sleepWithContext waits for d, returning early with an error if ctx is done
+// or if its deadline would pass before d elapses.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// errorResponse maps a classified error to an HTTP response: validation errors become 400,
+// transient errors 503 and anything else 500.
+func errorResponse(msg string, err error) events.APIGatewayV2HTTPResponse {
+	log.Printf("%s: %v", msg, err)
+	switch {
+	case errors.Is(err, ErrValidation):
+		return badRequestResponse(msg)
+	case errors.Is(err, ErrTransient):
+		return serviceUnavailableResponse(fmt.Sprintf("%s: %v", msg, err))
+	default:
+		return internalServerErrorResponse(fmt.Sprintf("%s: %v", msg, err))
+	}
 }
 
-// methodNotAllowedResponse returns a 405 HTTP response when the method is not POST.
+// methodNotAllowedResponse returns a 405 HTTP response for a method the route does
+// not accept: GET is only allowed on /status, POST everywhere else.
 func methodNotAllowedResponse() events.APIGatewayV2HTTPResponse {
 	return events.APIGatewayV2HTTPResponse{
 		StatusCode: http.StatusMethodNotAllowed,
-		Body:       "Only POST method is allowed",
+		Body:       "Only POST is allowed, or GET on /status",
 	}
 }
 
@@ -112,6 +251,14 @@ func internalServerErrorResponse(msg string) events.APIGatewayV2HTTPResponse {
 	}
 }
 
+// serviceUnavailableResponse returns a 503 HTTP response for failures that may succeed on retry.
+func serviceUnavailableResponse(msg string) events.APIGatewayV2HTTPResponse {
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       msg,
+	}
+}
+
 // successResponse returns a 200 HTTP response with a success message.
 func successResponse(msg string) events.APIGatewayV2HTTPResponse {
 	return events.APIGatewayV2HTTPResponse{
@@ -120,7 +267,9 @@ func successResponse(msg string) events.APIGatewayV2HTTPResponse {
 	}
 }
 
-// main starts the Lambda function.
+// main loads the configuration and the S3 client, then starts the Lambda function.
 func main() {
+	loadConfig()
+	initS3Client()
 	lambda.Start(handler)
 }
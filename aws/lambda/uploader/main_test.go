@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("S3_BUCKET", "test-bucket")
+	loadConfig()
+	bucket = "test-bucket"
+	os.Exit(m.Run())
+}
+
+// setVar sets *p to v for the duration of the test.
+func setVar[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	prev := *p
+	*p = v
+	t.Cleanup(func() { *p = prev })
+}
+
+// fakeS3 is an s3API whose calls are answered by the function fields; PutObject
+// records the keys it was called with.
+type fakeS3 struct {
+	put  func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	get  func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	head func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	puts []string
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.puts = append(f.puts, *in.Key)
+	if f.put == nil {
+		return &s3.PutObjectOutput{}, nil
+	}
+	return f.put(in)
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return f.get(in)
+}
+
+func (f *fakeS3) HeadObject(ctx context.Context, in *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return f.head(in)
+}
+
+// useS3 replaces the S3 client for the duration of the test.
+func useS3(t *testing.T, f *fakeS3) {
+	t.Helper()
+	setVar[s3API](t, &s3Client, f)
+}
+
+// errRetryable is an S3 error the SDK's default retryer treats as transient.
+var errRetryable = &smithy.GenericAPIError{Code: "RequestTimeout", Message: "request timed out"}
+
+func TestUploadToS3RetriesTransientFailures(t *testing.T) {
+	calls := 0
+	f := &fakeS3{put: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		calls++
+		if calls <= 2 {
+			return nil, errRetryable
+		}
+		return &s3.PutObjectOutput{}, nil
+	}}
+	useS3(t, f)
+
+	if err := uploadToS3(context.Background(), "upload-1.csv", []byte("data"), ""); err != nil {
+		t.Fatalf("uploadToS3() error = %v, want success", err)
+	}
+	if calls != 3 {
+		t.Errorf("PutObject called %d times, want 3", calls)
+	}
+}
+
+func TestUploadToS3GivesUpAfterMaxAttempts(t *testing.T) {
+	f := &fakeS3{put: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		return nil, errRetryable
+	}}
+	useS3(t, f)
+
+	err := uploadToS3(context.Background(), "upload-1.csv", []byte("data"), "")
+	if err == nil {
+		t.Fatal("uploadToS3() succeeded, want an error")
+	}
+	if len(f.puts) != maxUploadAttempts {
+		t.Errorf("PutObject called %d times, want %d", len(f.puts), maxUploadAttempts)
+	}
+}
+
+func TestUploadToS3DoesNotRetryPermanentFailures(t *testing.T) {
+	f := &fakeS3{put: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		return nil, &smithy.GenericAPIError{Code: "AccessDenied"}
+	}}
+	useS3(t, f)
+
+	if err := uploadToS3(context.Background(), "upload-1.csv", []byte("data"), ""); err == nil {
+		t.Fatal("uploadToS3() succeeded, want an error")
+	}
+	if len(f.puts) != 1 {
+		t.Errorf("PutObject called %d times, want 1", len(f.puts))
+	}
+}
+
+func TestUploadToS3StopsWhenDeadlineIsTooClose(t *testing.T) {
+	f := &fakeS3{put: func(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		return nil, errRetryable
+	}}
+	useS3(t, f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadBaseBackoff/2)
+	defer cancel()
+	err := uploadToS3(ctx, "upload-1.csv", []byte("data"), "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("uploadToS3() error = %v, want context.DeadlineExceeded", err)
+	}
+	if len(f.puts) != 1 {
+		t.Errorf("PutObject called %d times, want 1", len(f.puts))
+	}
+}
+
+func TestPutObjectInputContentDisposition(t *testing.T) {
+	setVar(t, &setContentDisposition, true)
+	in := newPutObjectInput("upload-1700000000.csv", []byte("data"), "")
+	if in.ContentDisposition == nil || *in.ContentDisposition != "attachment; filename=upload-1700000000.csv" {
+		t.Errorf("ContentDisposition = %v, want an attachment named after the key", in.ContentDisposition)
+	}
+
+	setContentDisposition = false
+	if in := newPutObjectInput("upload-1700000000.csv", []byte("data"), ""); in.ContentDisposition != nil {
+		t.Errorf("ContentDisposition = %q, want none when disabled", *in.ContentDisposition)
+	}
+}
+
+// fixedClock returns a clock that always reports t.
+func fixedClock(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func TestGenerateFilenameUsesClock(t *testing.T) {
+	if got := generateFilename(fixedClock(time.Unix(1700000000, 0))); got != "upload-1700000000.csv" {
+		t.Errorf("generateFilename() = %q, want upload-1700000000.csv", got)
+	}
+}
+
+func TestHandlerStoresUploadUnderClockTime(t *testing.T) {
+	setVar(t, &clock, fixedClock(time.Unix(1700000000, 0)))
+	f := &fakeS3{}
+	useS3(t, f)
+
+	var req events.APIGatewayV2HTTPRequest
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.Body = "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, resp.Body)
+	}
+	if len(f.puts) != 1 || f.puts[0] != "upload-1700000000.csv" {
+		t.Errorf("stored %v, want upload-1700000000.csv", f.puts)
+	}
+}
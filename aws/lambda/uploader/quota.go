@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// uploadQuota limits each client to quotaLimit uploads in any rolling quotaWindow.
+// Counts are kept per container, so with several warm containers a client can get up
+// to quotaLimit per container; API Gateway throttling remains the global limit.
+type uploadQuota struct {
+	mu sync.Mutex
+	// uploads holds each client's upload times within the window, oldest first.
+	uploads map[string][]time.Time
+}
+
+var quota = &uploadQuota{uploads: make(map[string][]time.Time)}
+
+// allow records an upload by client at now when it is within the quota. Otherwise it
+// returns false and how long until the oldest upload in the window expires, which
+// is when the client may upload again.
+func (q *uploadQuota) allow(client string, now time.Time) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Drop uploads that have left the window
+	cutoff := now.Add(-quotaWindow)
+	times := q.uploads[client]
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	times = times[i:]
+
+	if len(times) >= quotaLimit {
+		q.uploads[client] = times
+		return false, times[0].Add(quotaWindow).Sub(now)
+	}
+	q.uploads[client] = append(times, now)
+	return true, 0
+}
+
+// retryAfterSeconds renders wait as a Retry-After value: whole seconds, rounded up so a
+// client that honours it is never early, and at least 1.
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1))
+}
+
+// tooManyRequestsResponse returns a 429 HTTP response telling the client when its quota resets.
+func tooManyRequestsResponse(wait time.Duration) events.APIGatewayV2HTTPResponse {
+	seconds := retryAfterSeconds(wait)
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusTooManyRequests,
+		Headers:    map[string]string{"Retry-After": seconds},
+		Body:       "Upload quota exceeded, retry after " + seconds + " seconds",
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// useQuota enables a fresh quota of limit uploads per window for the test.
+func useQuota(t *testing.T, limit int, window time.Duration) {
+	t.Helper()
+	setVar(t, &quotaLimit, limit)
+	setVar(t, &quotaWindow, window)
+	setVar(t, &quota, &uploadQuota{uploads: make(map[string][]time.Time)})
+}
+
+// uploadRequest returns a CSV upload from the client at sourceIP.
+func uploadRequest(sourceIP string) events.APIGatewayV2HTTPRequest {
+	var req events.APIGatewayV2HTTPRequest
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.RequestContext.HTTP.SourceIP = sourceIP
+	req.Body = "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"
+	return req
+}
+
+func TestUploadQuotaAllow(t *testing.T) {
+	useQuota(t, 2, time.Minute)
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	if ok, _ := quota.allow("10.0.0.1", start); !ok {
+		t.Fatal("first upload refused")
+	}
+	if ok, _ := quota.allow("10.0.0.1", start.Add(20*time.Second)); !ok {
+		t.Fatal("second upload refused")
+	}
+	ok, wait := quota.allow("10.0.0.1", start.Add(45*time.Second))
+	if ok || wait != 15*time.Second {
+		t.Errorf("third upload = %v, %s, want refused until the first leaves the window in 15s", ok, wait)
+	}
+	if ok, _ := quota.allow("10.0.0.2", start.Add(45*time.Second)); !ok {
+		t.Error("another client's upload refused")
+	}
+	// A refused upload does not count against the quota
+	if ok, _ := quota.allow("10.0.0.1", start.Add(time.Minute)); !ok {
+		t.Error("upload after the first left the window refused")
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{15 * time.Second, "15"},
+		{14*time.Second + time.Millisecond, "15"},
+		{time.Millisecond, "1"},
+		{0, "1"},
+	}
+	for _, tt := range tests {
+		if got := retryAfterSeconds(tt.wait); got != tt.want {
+			t.Errorf("retryAfterSeconds(%s) = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerReturnsRetryAfterWhenOverQuota(t *testing.T) {
+	useQuota(t, 1, time.Minute)
+	useS3(t, &fakeS3{})
+	start := time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC)
+
+	setVar(t, &clock, fixedClock(start))
+	resp, err := handler(context.Background(), uploadRequest("10.0.0.1"))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("first upload = %d, %v, want %d", resp.StatusCode, err, http.StatusOK)
+	}
+
+	setVar(t, &clock, fixedClock(start.Add(17500*time.Millisecond)))
+	resp, err = handler(context.Background(), uploadRequest("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	// The window resets 42.5s later, rounded up to whole seconds
+	if got := resp.Headers["Retry-After"]; got != "43" {
+		t.Errorf("Retry-After = %q, want 43", got)
+	}
+	if want := "Upload quota exceeded, retry after 43 seconds"; resp.Body != want {
+		t.Errorf("body = %q, want %q", resp.Body, want)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/lib/pq"
+)
+
+// pendingStatus is returned for a key the summarizer has not recorded yet.
+const pendingStatus = "pending"
+
+// uploadedByMetadata is the object metadata key holding the caller that uploaded the
+// file, so GET /status can tell whether the caller asking about it may see it.
+const uploadedByMetadata = "uploaded-by"
+
+// statusDB is the summarizer's database, read by GET /status; nil unless
+// UPLOAD_STATUS_ENABLED is set.
+var statusDB *sql.DB
+
+// IngestStatus is a file's row in the summarizer's processed-files ledger.
+type IngestStatus struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Status       string    `json:"status"`
+	RowsRead     int       `json:"rows_read,omitempty"`
+	RowsInserted int       `json:"rows_inserted,omitempty"`
+	RejectedRows int       `json:"rejected_rows,omitempty"`
+	Errors       []string  `json:"errors,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at,omitzero"`
+}
+
+// statusHandler serves GET /status?key=<upload key>. It returns the file's row in the
+// summarizer's processed-files ledger (status, row counts and errors) as JSON, or a
+// pending status when the file has not been processed yet. Only the caller that
+// uploaded the file may read its status; other keys are reported as unknown.
+func statusHandler(ctx context.Context, req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	if statusDB == nil {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       "Ingest status is not enabled",
+		}
+	}
+
+	key := req.QueryStringParameters["key"]
+	if key == "" || strings.Contains(key, "..") {
+		return badRequestResponse("Query parameter key must be an upload key")
+	}
+	caller := callerIdentity(req)
+	if caller == "" {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: http.StatusUnauthorized,
+			Body:       fmt.Sprintf("Request is not authorized with a %q claim", uploadCallerClaim),
+		}
+	}
+
+	owner, err := uploadOwner(ctx, key)
+	if err != nil {
+		return errorResponse("Failed to read upload", err)
+	}
+	if owner != caller {
+		return events.APIGatewayV2HTTPResponse{
+			StatusCode: http.StatusNotFound,
+			Body:       "Unknown upload key",
+		}
+	}
+
+	status, err := readIngestStatus(ctx, statusDB, bucket, key)
+	if err != nil {
+		return errorResponse("Failed to read ingest status", err)
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return errorResponse("Failed to encode ingest status", classify(ErrFatal, err))
+	}
+	return jsonResponse(string(data))
+}
+
+// callerIdentity returns the UPLOAD_CALLER_CLAIM claim of the request's JWT or Lambda
+// authorizer, or "" when the request was not authorized with one.
+func callerIdentity(req events.APIGatewayV2HTTPRequest) string {
+	auth := req.RequestContext.Authorizer
+	if auth == nil {
+		return ""
+	}
+	if auth.JWT != nil {
+		if v := strings.TrimSpace(auth.JWT.Claims[uploadCallerClaim]); v != "" {
+			return v
+		}
+	}
+	v, _ := auth.Lambda[uploadCallerClaim].(string)
+	return strings.TrimSpace(v)
+}
+
+// uploadOwner returns the caller recorded on the uploaded object, or "" when the
+// object does not exist or was uploaded without one.
+func uploadOwner(ctx context.Context, key string) (string, error) {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", classifyAWSError(err)
+	}
+	return head.Metadata[uploadedByMetadata], nil
+}
+
+// readIngestStatus reads the file's row from the processed_files table, returning a
+// pending status when there is none.
+func readIngestStatus(ctx context.Context, db *sql.DB, bucket, key string) (*IngestStatus, error) {
+	status := &IngestStatus{Bucket: bucket, Key: key}
+	err := db.QueryRowContext(ctx, `
+		SELECT status, rows_read, rows_inserted, rejected_rows, errors, updated_at
+		FROM processed_files
+		WHERE bucket = $1 AND object_key = $2`, bucket, key).Scan(
+		&status.Status, &status.RowsRead, &status.RowsInserted, &status.RejectedRows,
+		pq.Array(&status.Errors), &status.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &IngestStatus{Bucket: bucket, Key: key, Status: pendingStatus}, nil
+	}
+	if err != nil {
+		return nil, classify(ErrTransient, fmt.Errorf("error reading processed files ledger: %w", err))
+	}
+	return status, nil
+}
+
+// openDB opens the Postgres pool described by DATABASE_URL, or else by the DB_*
+// environment variables. sql.Open does not connect, so an unreachable database
+// surfaces on first use.
+func openDB() (*sql.DB, error) {
+	if databaseURL != "" {
+		return sql.Open("postgres", databaseURL)
+	}
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), dbSSLMode)
+	if dbSSLRootCert != "" {
+		connStr += " sslrootcert=" + quoteConnValue(dbSSLRootCert)
+	}
+	return sql.Open("postgres", connStr)
+}
+
+// quoteConnValue single-quotes a connection string value, escaping quotes and
+// backslashes, so paths with spaces are passed through intact.
+func quoteConnValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+// jsonResponse returns a 200 HTTP response with a JSON body.
+func jsonResponse(body string) events.APIGatewayV2HTTPResponse {
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       body,
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// useStatusDB enables GET /status against a mock database for the duration of the test.
+func useStatusDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVar(t, &statusDB, db)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return mock
+}
+
+// useUploads answers HeadObject with the owners of the uploaded keys; other keys do not exist.
+func useUploads(t *testing.T, owners map[string]string) {
+	t.Helper()
+	useS3(t, &fakeS3{head: func(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+		owner, ok := owners[*in.Key]
+		if !ok {
+			return nil, &s3types.NotFound{}
+		}
+		return &s3.HeadObjectOutput{Metadata: map[string]string{uploadedByMetadata: owner}}, nil
+	}})
+}
+
+// statusRequest returns a GET /status request for key authorized as caller.
+func statusRequest(key, caller string) events.APIGatewayV2HTTPRequest {
+	req := events.APIGatewayV2HTTPRequest{QueryStringParameters: map[string]string{"key": key}}
+	req.RequestContext.HTTP.Method = http.MethodGet
+	req.RequestContext.HTTP.Path = "/status"
+	if caller != "" {
+		req.RequestContext.Authorizer = &events.APIGatewayV2HTTPRequestContextAuthorizerDescription{
+			JWT: &events.APIGatewayV2HTTPRequestContextAuthorizerJWTDescription{Claims: map[string]string{"sub": caller}},
+		}
+	}
+	return req
+}
+
+var ledgerColumns = []string{"status", "rows_read", "rows_inserted", "rejected_rows", "errors", "updated_at"}
+
+func TestStatusHandlerReportsLedgerRow(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		row  *sqlmock.Rows
+		want IngestStatus
+	}{
+		{
+			"processed",
+			sqlmock.NewRows(ledgerColumns).AddRow("processed", 10, 9, 1, "{\"line 3: date: not a date\"}", updated),
+			IngestStatus{Status: "processed", RowsRead: 10, RowsInserted: 9, RejectedRows: 1, Errors: []string{"line 3: date: not a date"}, UpdatedAt: updated},
+		},
+		{
+			"failed",
+			sqlmock.NewRows(ledgerColumns).AddRow("failed", 4, 0, 0, "{\"insert failed at line 2\"}", updated),
+			IngestStatus{Status: "failed", RowsRead: 4, Errors: []string{"insert failed at line 2"}, UpdatedAt: updated},
+		},
+		{
+			"unprocessed",
+			sqlmock.NewRows(ledgerColumns),
+			IngestStatus{Status: pendingStatus},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := useStatusDB(t)
+			useUploads(t, map[string]string{"upload-1.csv": "user-1"})
+			mock.ExpectQuery("FROM processed_files").WithArgs(bucket, "upload-1.csv").WillReturnRows(tt.row)
+
+			resp, err := handler(context.Background(), statusRequest("upload-1.csv", "user-1"))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("handler() = %d %s, %v, want 200", resp.StatusCode, resp.Body, err)
+			}
+			var got IngestStatus
+			if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Bucket, tt.want.Key = bucket, "upload-1.csv"
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("status = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusHandlerOnlyServesTheUploadingCaller(t *testing.T) {
+	useStatusDB(t)
+	useUploads(t, map[string]string{"upload-1.csv": "user-1"})
+
+	tests := []struct {
+		name, key, caller string
+		want              int
+	}{
+		{"unauthenticated", "upload-1.csv", "", http.StatusUnauthorized},
+		{"other caller", "upload-1.csv", "user-2", http.StatusNotFound},
+		{"unknown key", "upload-2.csv", "user-1", http.StatusNotFound},
+		{"path traversal", "../secret.csv", "user-1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, _ := handler(context.Background(), statusRequest(tt.key, tt.caller))
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: status code = %d (%s), want %d", tt.name, resp.StatusCode, resp.Body, tt.want)
+		}
+	}
+}
+
+func TestStatusHandlerDisabled(t *testing.T) {
+	setVar[*sql.DB](t, &statusDB, nil)
+	resp, _ := handler(context.Background(), statusRequest("upload-1.csv", "user-1"))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status code = %d, want 404 when UPLOAD_STATUS_ENABLED is off", resp.StatusCode)
+	}
+}
+
+func TestHandlerRejectsOtherMethods(t *testing.T) {
+	req := events.APIGatewayV2HTTPRequest{}
+	req.RequestContext.HTTP.Method = http.MethodPut
+	req.RequestContext.HTTP.Path = "/status"
+	resp, _ := handler(context.Background(), req)
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Body != "Only POST is allowed, or GET on /status" {
+		t.Errorf("handler() = %d %q, want 405 naming GET on /status", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestUploadRecordsCaller(t *testing.T) {
+	var metadata map[string]string
+	useS3(t, &fakeS3{put: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		metadata = in.Metadata
+		return &s3.PutObjectOutput{}, nil
+	}})
+	req := statusRequest("", "user-1")
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.RequestContext.HTTP.Path = "/upload"
+	req.Body = "id,date,transaction,email\n1,2024-01-05,+10,jane@example.com\n"
+
+	resp, err := handler(context.Background(), req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("handler() = %d %s, %v, want 200", resp.StatusCode, resp.Body, err)
+	}
+	if metadata[uploadedByMetadata] != "user-1" {
+		t.Errorf("metadata = %v, want %s recorded as user-1", metadata, uploadedByMetadata)
+	}
+}
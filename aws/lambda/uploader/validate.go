@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/luis/challenge-go-s-dev/aws/lambda/internal/csvrules"
+)
+
+// ValidationReport describes the result of checking a CSV without ingesting it.
+type ValidationReport struct {
+	Valid     bool       `json:"valid"`
+	TotalRows int        `json:"total_rows"`
+	ValidRows int        `json:"valid_rows"`
+	Errors    []RowError `json:"errors,omitempty"`
+}
+
+// RowError describes a malformed line of the CSV.
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// validateCSV applies the checks the summarizer applies at ingest (header and column
+// count, then the id, date, amount and email rules of csvrules, after normalizing the
+// amount separators) and reports every malformed row instead of stopping. Like the
+// summarizer, it stops at the row that fails the whole file under STRICT_COLUMNS=fail
+// or STRICT_FEED.
+func validateCSV(body []byte) ValidationReport {
+	var report ValidationReport
+	// A leading UTF-8 BOM (common in Excel exports) is stripped, as the summarizer does
+	body = bytes.TrimPrefix(body, []byte{0xEF, 0xBB, 0xBF})
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.Comma = ','
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	lineNum := 0
+	if csvHasHeader {
+		lineNum++
+		header, err := reader.Read()
+		if err != nil {
+			report.Errors = append(report.Errors, RowError{Line: lineNum, Error: fmt.Sprintf("error reading CSV header: %v", err)})
+			return report
+		}
+		if len(header) != len(csvColumns) {
+			report.Errors = append(report.Errors, RowError{Line: lineNum, Error: fmt.Sprintf("invalid CSV header column count: expected %d, got %d", len(csvColumns), len(header))})
+			return report
+		}
+	}
+
+	index := columnIndex(csvColumns)
+	rules := csvrules.Rules{
+		IntKey:            keyType == "int",
+		StrictAmounts:     amountFormat == "strict",
+		RejectFutureDates: rejectFutureDates,
+		Now:               clock,
+	}
+	for {
+		lineNum++
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		report.TotalRows++
+		if err != nil {
+			report.Errors = append(report.Errors, RowError{Line: lineNum, Error: err.Error()})
+			if strictFeed {
+				break
+			}
+			continue
+		}
+		if len(record) != len(csvColumns) {
+			report.Errors = append(report.Errors, RowError{Line: lineNum, Error: fmt.Sprintf("invalid column count: expected %d, got %d", len(csvColumns), len(record))})
+			if strictColumns == "fail" {
+				break
+			}
+			continue
+		}
+
+		problems := rules.Check(func(col string) (string, bool) {
+			i, ok := index[col]
+			if !ok {
+				return "", false
+			}
+			if col == "transaction" {
+				return csvrules.NormalizeAmount(record[i], amountDecimalSeparator, amountThousandsSeparator), true
+			}
+			return record[i], true
+		})
+		for _, p := range problems {
+			report.Errors = append(report.Errors, RowError{Line: lineNum, Error: p.Column + ": " + p.Message})
+		}
+		if len(problems) > 0 {
+			if strictFeed {
+				break
+			}
+			continue
+		}
+		report.ValidRows++
+	}
+
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+// columnIndex maps each name of columns, trimmed and lower-cased, to its position.
+func columnIndex(columns []string) map[string]int {
+	index := make(map[string]int, len(columns))
+	for i, col := range columns {
+		index[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	return index
+}
+
+// validateHandler serves POST /validate: it checks the posted CSV and returns a JSON
+// report without storing anything in S3.
+func validateHandler(req events.APIGatewayV2HTTPRequest) events.APIGatewayV2HTTPResponse {
+	body, err := decodeRequestBody(req)
+	if err != nil {
+		return errorResponse("Failed to decode request body", err)
+	}
+
+	data, err := json.Marshal(validateCSV(body))
+	if err != nil {
+		return errorResponse("Failed to encode validation report", err)
+	}
+	return jsonResponse(string(data))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// validateRequest returns a POST /validate request carrying body.
+func validateRequest(body string) events.APIGatewayV2HTTPRequest {
+	var req events.APIGatewayV2HTTPRequest
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.RequestContext.HTTP.Path = "/validate"
+	req.Body = body
+	return req
+}
+
+func TestValidateHandlerReportsCleanFile(t *testing.T) {
+	f := &fakeS3{}
+	useS3(t, f)
+	body := "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n2,2024-01-09,-10.3,jane@example.com\n"
+
+	resp, err := handler(context.Background(), validateRequest(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, resp.Body)
+	}
+	var got ValidationReport
+	if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := ValidationReport{Valid: true, TotalRows: 2, ValidRows: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+	if len(f.puts) != 0 {
+		t.Errorf("stored %v, want nothing stored", f.puts)
+	}
+}
+
+func TestValidateHandlerReportsMalformedRows(t *testing.T) {
+	f := &fakeS3{}
+	useS3(t, f)
+	body := "id,date,transaction,email\n" +
+		"1,2024-01-05,+60.5,jane@example.com\n" +
+		"x,2024-01-06,+1,jane@example.com\n" +
+		"3,2024-01-07,+1\n" +
+		"4,2024-01-08,-2,jane@example.com\n"
+	req := validateRequest(base64.StdEncoding.EncodeToString([]byte(body)))
+	req.IsBase64Encoded = true
+
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ValidationReport
+	if err := json.Unmarshal([]byte(resp.Body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Valid || got.TotalRows != 4 || got.ValidRows != 2 {
+		t.Errorf("report = %+v, want 4 rows of which 2 valid", got)
+	}
+	if len(got.Errors) != 2 || got.Errors[0].Line != 3 || got.Errors[1].Line != 4 {
+		t.Fatalf("errors = %+v, want lines 3 and 4", got.Errors)
+	}
+	if want := "invalid column count: expected 4, got 3"; got.Errors[1].Error != want {
+		t.Errorf("line 4 error = %q, want %q", got.Errors[1].Error, want)
+	}
+	if len(f.puts) != 0 {
+		t.Errorf("stored %v, want nothing stored", f.puts)
+	}
+}
+
+func TestValidateCSVRejectsBadHeader(t *testing.T) {
+	got := validateCSV([]byte("id,date\n1,2024-01-05\n"))
+	if got.Valid || len(got.Errors) != 1 || got.Errors[0].Line != 1 {
+		t.Errorf("report = %+v, want the header reported on line 1", got)
+	}
+}
+
+func TestValidateCSVUsesConfiguredColumns(t *testing.T) {
+	setVar(t, &csvColumns, []string{"date", "id", "transaction", "email", "category"})
+
+	got := validateCSV([]byte("date,id,transaction,email,category\n2024-01-05,1,+60.5,jane@example.com,food\n2024-01-06,x,+1,jane@example.com,food\n2024-01-07,3,+1,jane@example.com\n"))
+	if got.Valid || got.TotalRows != 3 || got.ValidRows != 1 {
+		t.Errorf("report = %+v, want 3 rows of which 1 valid", got)
+	}
+	if len(got.Errors) != 2 || got.Errors[1].Error != "invalid column count: expected 5, got 4" {
+		t.Errorf("errors = %+v, want the id of line 3 and the column count of line 4", got.Errors)
+	}
+}
+
+func TestValidateCSVChecksTextKeys(t *testing.T) {
+	setVar(t, &keyType, "text")
+	got := validateCSV([]byte("id,date,transaction,email\nabc,2024-01-05,+1,jane@example.com\n ,2024-01-06,+1,jane@example.com\n"))
+	if got.ValidRows != 1 || len(got.Errors) != 1 || got.Errors[0].Error != "id: empty" {
+		t.Errorf("report = %+v, want the blank key rejected", got)
+	}
+}
+
+func TestValidateCSVStripsBOM(t *testing.T) {
+	got := validateCSV([]byte("\xEF\xBB\xBFid,date,transaction,email\n1,2024-01-05,+1,jane@example.com\n"))
+	if !got.Valid || got.ValidRows != 1 {
+		t.Errorf("report = %+v, want the BOM-prefixed file valid", got)
+	}
+}
+
+func TestValidateCSVAppliesSummarizerRowRules(t *testing.T) {
+	setVar(t, &amountFormat, "strict")
+	setVar(t, &rejectFutureDates, true)
+	setVar(t, &clock, func() time.Time { return time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC) })
+	got := validateCSV([]byte("id,date,transaction,email\n" +
+		"1,2024-03-10,+60.5,jane@example.com\n" +
+		"2,2024-13-01,+1,jane@example.com\n" +
+		"3,2024-03-11,+1,jane@example.com\n" +
+		"4,2024-03-09,1e3,jane@example.com\n" +
+		"5,2024-03-09,+1,jane.example.com\n"))
+	want := []RowError{
+		{Line: 3, Error: "date: not a date in YYYY-MM-DD format, optionally with a time of day"},
+		{Line: 4, Error: "date: in the future"},
+		{Line: 5, Error: "transaction: not a plain signed decimal amount (digits with an optional sign and decimal point)"},
+		{Line: 6, Error: "email: not an email address"},
+	}
+	if got.ValidRows != 1 || !reflect.DeepEqual(got.Errors, want) {
+		t.Errorf("report = %+v, want errors %+v", got, want)
+	}
+}
+
+func TestValidateCSVNormalizesAmountSeparators(t *testing.T) {
+	setVar(t, &amountDecimalSeparator, ",")
+	setVar(t, &amountThousandsSeparator, ".")
+	got := validateCSV([]byte("id,date,transaction,email\n1,2024-01-05,\"+1.234,56\",jane@example.com\n2,2024-01-06,\"+12.34,5\",jane@example.com\n"))
+	if got.ValidRows != 1 || len(got.Errors) != 1 || got.Errors[0].Line != 3 {
+		t.Errorf("report = %+v, want only the malformed group on line 3 rejected", got)
+	}
+}
+
+func TestValidateCSVStopsWhereTheSummarizerFailsTheFile(t *testing.T) {
+	body := []byte("id,date,transaction,email\n1,2024-01-05,+1\nx,2024-01-06,+1,jane@example.com\n3,2024-01-07,+1,jane@example.com\n")
+
+	if got := validateCSV(body); got.TotalRows != 3 || len(got.Errors) != 2 {
+		t.Errorf("report = %+v, want every row checked when rows are skipped", got)
+	}
+
+	setVar(t, &strictColumns, "fail")
+	if got := validateCSV(body); got.TotalRows != 1 || len(got.Errors) != 1 {
+		t.Errorf("STRICT_COLUMNS=fail report = %+v, want it stopped at the column count of line 2", got)
+	}
+
+	setVar(t, &strictColumns, "skip")
+	setVar(t, &strictFeed, true)
+	body = []byte("id,date,transaction,email\nx,2024-01-05,+1,jane@example.com\ny,2024-01-06,+1,jane@example.com\n")
+	if got := validateCSV(body); got.TotalRows != 1 || len(got.Errors) != 1 || got.Errors[0].Line != 2 {
+		t.Errorf("STRICT_FEED report = %+v, want it stopped at line 2", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// zipMagic is the signature at the start of a ZIP archive's first local file header.
+var zipMagic = []byte("PK\x03\x04")
+
+// isZip reports whether body is a ZIP archive, judged by its magic bytes.
+func isZip(body []byte) bool {
+	return bytes.HasPrefix(body, zipMagic)
+}
+
+// zipEntry is one CSV extracted from an uploaded ZIP archive.
+type zipEntry struct {
+	Name string
+	Data []byte
+}
+
+// extractCSVs returns the .csv entries of a ZIP archive, in archive order. Directories,
+// other files and macOS resource forks are skipped. To guard against zip bombs the
+// decompressed bytes actually read, not the sizes the archive claims, are capped at
+// ZIP_MAX_DECOMPRESSED_BYTES across all entries.
+func extractCSVs(body []byte) ([]zipEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, classify(ErrValidation, fmt.Errorf("invalid ZIP archive: %w", err))
+	}
+
+	var entries []zipEntry
+	remaining := zipMaxDecompressedBytes
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || !strings.EqualFold(path.Ext(f.Name), ".csv") {
+			log.Printf("Skipping ZIP entry %q: not a CSV file", f.Name)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, classify(ErrValidation, fmt.Errorf("error opening ZIP entry %q: %w", f.Name, err))
+		}
+		// One byte past the budget is enough to tell that it was exceeded
+		data, err := io.ReadAll(io.LimitReader(rc, remaining+1))
+		rc.Close()
+		if err != nil {
+			return nil, classify(ErrValidation, fmt.Errorf("error reading ZIP entry %q: %w", f.Name, err))
+		}
+		if int64(len(data)) > remaining {
+			return nil, classify(ErrValidation, fmt.Errorf("ZIP archive exceeds the maximum decompressed size of %d bytes", zipMaxDecompressedBytes))
+		}
+		remaining -= int64(len(data))
+		entries = append(entries, zipEntry{Name: f.Name, Data: data})
+	}
+	if len(entries) == 0 {
+		return nil, classify(ErrValidation, fmt.Errorf("ZIP archive contains no CSV files"))
+	}
+	return entries, nil
+}
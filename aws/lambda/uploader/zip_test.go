@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// zipOf returns a ZIP archive holding files, given as alternating names and contents.
+func zipOf(t *testing.T, files ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, files[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const (
+	januaryCSV  = "id,date,transaction,email\n1,2024-01-05,+60.5,jane@example.com\n"
+	februaryCSV = "id,date,transaction,email\n2,2024-02-05,-10,john@example.com\n"
+)
+
+func TestHandlerUploadsEachCSVOfZip(t *testing.T) {
+	setVar(t, &clock, fixedClock(time.Unix(1700000000, 0)))
+	stored := make(map[string]string)
+	f := &fakeS3{put: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+		data, err := io.ReadAll(in.Body)
+		if err != nil {
+			return nil, err
+		}
+		stored[*in.Key] = string(data)
+		return &s3.PutObjectOutput{}, nil
+	}}
+	useS3(t, f)
+
+	var req events.APIGatewayV2HTTPRequest
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.IsBase64Encoded = true
+	req.Body = base64.StdEncoding.EncodeToString(zipOf(t, "january.csv", januaryCSV, "february.csv", februaryCSV))
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, resp.Body)
+	}
+	want := map[string]string{
+		"upload-1700000000-1.csv": januaryCSV,
+		"upload-1700000000-2.csv": februaryCSV,
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored %v, want %v", stored, want)
+	}
+	if !strings.Contains(resp.Body, "2 files successfully uploaded") {
+		t.Errorf("body = %s, want both files reported", resp.Body)
+	}
+}
+
+func TestExtractCSVsSkipsOtherEntries(t *testing.T) {
+	body := zipOf(t, "readme.txt", "hello", "__MACOSX/._data.csv", "fork", "reports/", "", "reports/DATA.CSV", januaryCSV)
+	entries, err := extractCSVs(body)
+	if err != nil {
+		t.Fatalf("extractCSVs() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "reports/DATA.CSV" || string(entries[0].Data) != januaryCSV {
+		t.Errorf("entries = %+v, want only reports/DATA.CSV", entries)
+	}
+}
+
+func TestExtractCSVsCapsDecompressedSize(t *testing.T) {
+	setVar(t, &zipMaxDecompressedBytes, int64(len(januaryCSV)+len(februaryCSV)-1))
+	_, err := extractCSVs(zipOf(t, "january.csv", januaryCSV, "february.csv", februaryCSV))
+	if !errors.Is(err, ErrValidation) || !strings.Contains(err.Error(), "maximum decompressed size") {
+		t.Errorf("extractCSVs() error = %v, want the decompressed size cap enforced across entries", err)
+	}
+
+	zipMaxDecompressedBytes = int64(len(januaryCSV) + len(februaryCSV))
+	if entries, err := extractCSVs(zipOf(t, "january.csv", januaryCSV, "february.csv", februaryCSV)); err != nil || len(entries) != 2 {
+		t.Errorf("extractCSVs() = %d entries, %v, want both within the cap", len(entries), err)
+	}
+}
+
+func TestExtractCSVsRejectsArchiveWithoutCSVs(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"no CSVs": zipOf(t, "readme.txt", "hello"),
+		"corrupt": append(append([]byte{}, zipMagic...), "not really a zip"...),
+	} {
+		if _, err := extractCSVs(body); !errors.Is(err, ErrValidation) {
+			t.Errorf("extractCSVs(%s) error = %v, want ErrValidation", name, err)
+		}
+	}
+}
+
+func TestHandlerStoresNothingFromInvalidZip(t *testing.T) {
+	f := &fakeS3{}
+	useS3(t, f)
+
+	var req events.APIGatewayV2HTTPRequest
+	req.RequestContext.HTTP.Method = http.MethodPost
+	req.IsBase64Encoded = true
+	req.Body = base64.StdEncoding.EncodeToString(zipOf(t, "readme.txt", "hello"))
+	resp, err := handler(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest || len(f.puts) != 0 {
+		t.Errorf("status = %d, stored %v, want 400 and nothing stored", resp.StatusCode, f.puts)
+	}
+}
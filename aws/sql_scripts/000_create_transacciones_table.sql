@@ -0,0 +1,9 @@
+-- Create the transacciones table the summarizer ingests into; the later scripts
+-- add its remaining columns
+CREATE TABLE IF NOT EXISTS transacciones (
+    id BIGSERIAL PRIMARY KEY,
+    external_id INTEGER NOT NULL,
+    date DATE NOT NULL,
+    transaction TEXT NOT NULL,
+    email TEXT NOT NULL
+);
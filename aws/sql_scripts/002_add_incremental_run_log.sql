@@ -0,0 +1,13 @@
+-- Track when each transaction was ingested so the summarizer can work incrementally
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS ingested_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS transacciones_email_ingested_at_idx
+    ON transacciones (email, ingested_at);
+
+-- One row per successful summarizer run; watermark is the newest ingested_at it covered
+CREATE TABLE IF NOT EXISTS summarizer_runs (
+    id SERIAL PRIMARY KEY,
+    finished_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    watermark TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Record which S3 object each transaction was ingested from (s3://bucket/key)
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS source_key TEXT;
+
+CREATE INDEX IF NOT EXISTS transacciones_source_key_idx
+    ON transacciones (source_key);
@@ -0,0 +1,8 @@
+-- Short-lived tokens that suppress duplicate notifications when the summarizer is retried
+CREATE TABLE IF NOT EXISTS notification_dedupe (
+    token TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS notification_dedupe_expires_at_idx
+    ON notification_dedupe (expires_at);
@@ -0,0 +1,3 @@
+-- ISO 4217 currency of each transaction; existing rows are assumed to be USD
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'USD';
@@ -0,0 +1,3 @@
+-- Keep the time of day of each transaction; existing dates become midnight UTC
+ALTER TABLE transacciones
+    ALTER COLUMN date TYPE TIMESTAMPTZ USING date::timestamp AT TIME ZONE 'UTC';
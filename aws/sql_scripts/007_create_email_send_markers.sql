@@ -0,0 +1,9 @@
+-- Per-recipient, per-period record of emails being sent, so a retry after an
+-- ambiguous SES timeout cannot send the same summary twice
+CREATE TABLE IF NOT EXISTS email_send_markers (
+    email      TEXT        NOT NULL,
+    period     TEXT        NOT NULL,
+    status     TEXT        NOT NULL DEFAULT 'pending',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (email, period)
+);
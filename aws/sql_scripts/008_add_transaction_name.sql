@@ -0,0 +1,3 @@
+-- Optional account holder display name, used to greet the recipient in the email
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT '';
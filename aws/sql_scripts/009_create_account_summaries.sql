@@ -0,0 +1,13 @@
+-- Summaries persisted by the summarizer, one per account and month, so the emailer's
+-- bulk mode can page through them and resume a crashed run without re-sending
+CREATE TABLE IF NOT EXISTS account_summaries (
+    email      TEXT        NOT NULL,
+    period     TEXT        NOT NULL,
+    summary    JSONB       NOT NULL,
+    status     TEXT        NOT NULL DEFAULT 'pending',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (email, period)
+);
+
+CREATE INDEX IF NOT EXISTS account_summaries_pending_idx
+    ON account_summaries (period, email) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+-- Optional account tier or segment (e.g. premium), used to choose the email template
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT '';
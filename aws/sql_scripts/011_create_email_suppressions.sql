@@ -0,0 +1,10 @@
+-- Addresses the emailer must never send to (bounces, complaints, opt-outs). With
+-- EMAIL_VERIFY_ACCOUNTS enabled a summary for a suppressed email is skipped.
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email      TEXT        NOT NULL,
+    reason     TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS email_suppressions_email_idx
+    ON email_suppressions (LOWER(email));
@@ -0,0 +1,19 @@
+-- One row per file the summarizer processed (INGEST_AUDIT): who uploaded it, when,
+-- the row counts and the outcome. A successful atomic ingest writes it in the same
+-- transaction as the data
+CREATE TABLE IF NOT EXISTS ingest_audit (
+    id            BIGSERIAL   PRIMARY KEY,
+    bucket        TEXT        NOT NULL,
+    object_key    TEXT        NOT NULL,
+    principal     TEXT        NOT NULL DEFAULT '',
+    request_id    TEXT        NOT NULL DEFAULT '',
+    outcome       TEXT        NOT NULL,
+    rows_read     INTEGER     NOT NULL DEFAULT 0,
+    rows_rejected INTEGER     NOT NULL DEFAULT 0,
+    rows_inserted INTEGER     NOT NULL DEFAULT 0,
+    errors        TEXT[]      NOT NULL DEFAULT '{}',
+    recorded_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ingest_audit_object_idx
+    ON ingest_audit (bucket, object_key, recorded_at);
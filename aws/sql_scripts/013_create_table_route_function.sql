@@ -0,0 +1,12 @@
+-- Creates the tables of a TABLE_ROUTES entry with the same columns, defaults and
+-- indexes as transacciones and account_summaries, e.g.
+--   SELECT create_table_route('card_transactions', 'card_summaries');
+-- Run it again after adding columns to the default tables only for new routes;
+-- existing routed tables must be altered like the default ones.
+CREATE OR REPLACE FUNCTION create_table_route(transactions_table TEXT, summary_table TEXT DEFAULT 'account_summaries')
+RETURNS VOID AS $$
+BEGIN
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I (LIKE transacciones INCLUDING ALL)', transactions_table);
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I (LIKE account_summaries INCLUDING ALL)', summary_table);
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,18 @@
+-- The processed-files ledger: the outcome of the latest version (ETag) of each object
+-- the summarizer ingested. A successful ingest writes its row in the same transaction
+-- as the data, so a redelivered event never inserts a committed file twice; emails
+-- and notified_at let it still notify a file whose summaries were not sent.
+CREATE TABLE IF NOT EXISTS processed_files (
+    bucket        TEXT        NOT NULL,
+    object_key    TEXT        NOT NULL,
+    etag          TEXT        NOT NULL DEFAULT '',
+    status        TEXT        NOT NULL,
+    rows_read     INTEGER     NOT NULL DEFAULT 0,
+    rows_inserted INTEGER     NOT NULL DEFAULT 0,
+    rejected_rows INTEGER     NOT NULL DEFAULT 0,
+    errors        TEXT[]      NOT NULL DEFAULT '{}',
+    emails        TEXT[]      NOT NULL DEFAULT '{}',
+    notified_at   TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (bucket, object_key)
+);
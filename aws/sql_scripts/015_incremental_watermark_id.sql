@@ -0,0 +1,18 @@
+-- Incremental runs record the highest transaction id they covered instead of the
+-- newest ingested_at: ingested_at is the start of the inserting transaction, so a
+-- longer transaction could commit rows older than a recorded watermark. Routed
+-- tables must draw their ids from the same sequence (create_table_route does).
+ALTER TABLE transacciones
+    ADD COLUMN IF NOT EXISTS id BIGSERIAL;
+
+CREATE INDEX IF NOT EXISTS transacciones_email_id_idx
+    ON transacciones (email, id);
+
+ALTER TABLE summarizer_runs
+    ADD COLUMN IF NOT EXISTS watermark_id BIGINT,
+    ALTER COLUMN watermark DROP NOT NULL;
+
+-- Existing runs continue from the rows ingested up to their timestamp watermark
+UPDATE summarizer_runs
+SET watermark_id = (SELECT COALESCE(MAX(id), 0) FROM transacciones WHERE ingested_at <= summarizer_runs.watermark)
+WHERE watermark_id IS NULL;
@@ -0,0 +1,4 @@
+-- Lets EMAIL_VERIFY_ACCOUNTS match emails case-insensitively, as it does against
+-- email_suppressions, without scanning the table
+CREATE INDEX IF NOT EXISTS transacciones_lower_email_idx
+    ON transacciones (LOWER(email));